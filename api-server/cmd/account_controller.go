@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	tracelog "github.com/opentracing/opentracing-go/log"
+)
+
+func (e *env) getAccount(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "account_controller_get_account")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	account, err := e.accountService.GetAccount(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+func (e *env) updateAccountPolicy(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "account_controller_update_account_policy")
+	defer span.Finish()
+
+	req, err := parseAccountPolicyRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	account, err := e.accountService.UpdatePolicy(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+func parseAccountPolicyRequest(c *gin.Context) (model.AccountPolicyRequest, error) {
+	var body model.AccountPolicyRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.AccountPolicyRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.AccountPolicyRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.AccountID = c.Param("id")
+	return body, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateAccountPolicy(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	account, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	route := "/v1/accounts/" + account.ID
+	req := createTestRequest(route, http.MethodGet, user.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var fetched model.Account
+	err := json.NewDecoder(res.Result().Body).Decode(&fetched)
+	assert.NoError(err)
+	assert.Equal(account.ID, fetched.ID)
+	assert.Equal(model.ValidityPolicyClamp, fetched.ValidityPolicy)
+
+	body := model.AccountPolicyRequest{ValidityPolicy: model.ValidityPolicyReject}
+	req = createTestRequest(route+"/policy", http.MethodPut, otherAdmin.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(route+"/policy", http.MethodPut, admin.JWTUser(), model.AccountPolicyRequest{ValidityPolicy: "IGNORE"})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(route+"/policy", http.MethodPut, admin.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var updated model.Account
+	err = json.NewDecoder(res.Result().Body).Decode(&updated)
+	assert.NoError(err)
+	assert.Equal(model.ValidityPolicyReject, updated.ValidityPolicy)
+
+	req = createTestRequest(route, http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	err = json.NewDecoder(res.Result().Body).Decode(&fetched)
+	assert.NoError(err)
+	assert.Equal(model.ValidityPolicyReject, fetched.ValidityPolicy)
+
+	req = createTestRequest(route, http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+}
+
+func TestUpdateAccountPolicy_InvitationLifetime(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	assert.Equal(model.DefaultInvitationLifetimeHours, account.InvitationLifetimeHours)
+
+	route := "/v1/accounts/" + account.ID + "/policy"
+	body := model.AccountPolicyRequest{
+		ValidityPolicy:          model.ValidityPolicyClamp,
+		InvitationLifetimeHours: 72,
+	}
+	req := createTestRequest(route, http.MethodPut, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var updated model.Account
+	err := json.NewDecoder(res.Result().Body).Decode(&updated)
+	assert.NoError(err)
+	assert.Equal(72, updated.InvitationLifetimeHours)
+
+	body = model.AccountPolicyRequest{ValidityPolicy: model.ValidityPolicyReject}
+	req = createTestRequest(route, http.MethodPut, admin.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	err = json.NewDecoder(res.Result().Body).Decode(&updated)
+	assert.NoError(err)
+	assert.Equal(72, updated.InvitationLifetimeHours)
+	assert.Equal(model.ValidityPolicyReject, updated.ValidityPolicy)
+
+	body.InvitationLifetimeHours = model.MaxInvitationLifetimeHours + 1
+	req = createTestRequest(route, http.MethodPut, admin.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+}
+
+func TestUpdateAccountPolicy_BadContentType(t *testing.T) {
+	testBadContentType(t, "/v1/accounts/some-id/policy", http.MethodPut, model.AdminRole)
+}
+
+func TestAccounts_UnauthorizedAndForbidden(t *testing.T) {
+	testUnauthorized(t, "/v1/accounts/some-id", http.MethodGet)
+	testForbidden(t, "/v1/accounts/some-id", http.MethodGet, []string{
+		jwt.AnonymousRole,
+	})
+
+	testUnauthorized(t, "/v1/accounts/some-id/policy", http.MethodPut)
+	testForbidden(t, "/v1/accounts/some-id/policy", http.MethodPut, []string{
+		jwt.AnonymousRole,
+		model.UserRole,
+	})
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	tracelog "github.com/opentracing/opentracing-go/log"
+	"go.uber.org/zap"
+)
+
+const (
+	joseType            = "application/jose+json"
+	problemType         = "application/problem+json"
+	pemCertificateChain = "application/pem-certificate-chain"
+	maxACMERequest      = 64 * 1024
+)
+
+func (e *env) enableUnattendedIssuance(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_enable_unattended_issuance")
+	defer span.Finish()
+
+	req, err := parseUnattendedIssuanceRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	cert, err := e.certificateService.EnableUnattendedIssuance(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
+func (e *env) disableUnattendedIssuance(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_disable_unattended_issuance")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	err = e.certificateService.DisableUnattendedIssuance(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) enableACME(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_enable_acme")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req, err := parseACMEEnrollmentRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	enrollment, err := e.acmeService.Enable(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (e *env) disableACME(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_disable_acme")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	err = e.acmeService.Disable(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func parseUnattendedIssuanceRequest(c *gin.Context) (model.UnattendedIssuanceRequest, error) {
+	var body model.UnattendedIssuanceRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.UnattendedIssuanceRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.UnattendedIssuanceRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.CertificateID = c.Param("id")
+	return body, nil
+}
+
+func parseACMEEnrollmentRequest(c *gin.Context) (model.ACMEEnrollmentRequest, error) {
+	var body model.ACMEEnrollmentRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.ACMEEnrollmentRequest{}, err
+	}
+
+	body, err = body.Normalize()
+	if err != nil {
+		return model.ACMEEnrollmentRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.CertificateID = c.Param("id")
+	return body, nil
+}
+
+func (e *env) getACMEDirectory(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_get_acme_directory")
+	defer span.Finish()
+
+	directory, err := e.acmeService.Directory(ctx, c.Param("id"))
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, directory)
+}
+
+func (e *env) getACMENonce(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_get_acme_nonce")
+	defer span.Finish()
+
+	_, err := e.acmeService.Directory(ctx, c.Param("id"))
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	e.setReplayNonce(c)
+	c.Header("Cache-Control", "no-store")
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (e *env) newACMEAccount(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_new_acme_account")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	account, created, err := e.acmeService.NewAccount(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	c.Header("Location", e.acmeService.URL(req.CertificateID, "account", account.ID))
+	e.writeACMEResponse(c, status, account)
+}
+
+func (e *env) updateACMEAccount(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_update_acme_account")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	account, err := e.acmeService.UpdateAccount(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	e.writeACMEResponse(c, http.StatusOK, account)
+}
+
+func (e *env) newACMEOrder(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_new_acme_order")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	order, err := e.acmeService.NewOrder(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	c.Header("Location", e.acmeService.URL(req.CertificateID, "order", order.ID))
+	e.writeACMEResponse(c, http.StatusCreated, order)
+}
+
+func (e *env) getACMEOrder(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_get_acme_order")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	order, err := e.acmeService.GetOrder(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	// Some clients take the order url from the Location header of every order response.
+	c.Header("Location", e.acmeService.URL(req.CertificateID, "order", order.ID))
+	e.writeACMEResponse(c, http.StatusOK, order)
+}
+
+func (e *env) finalizeACMEOrder(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_finalize_acme_order")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	order, err := e.acmeService.FinalizeOrder(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	c.Header("Location", e.acmeService.URL(req.CertificateID, "order", order.ID))
+	e.writeACMEResponse(c, http.StatusOK, order)
+}
+
+func (e *env) getACMEAuthorization(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_get_acme_authorization")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	authz, err := e.acmeService.GetAuthorization(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	e.writeACMEResponse(c, http.StatusOK, authz)
+}
+
+func (e *env) respondACMEChallenge(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_respond_acme_challenge")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	chal, authzURL, err := e.acmeService.RespondChallenge(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	c.Header("Link", fmt.Sprintf(`<%s>;rel="up"`, authzURL))
+	e.writeACMEResponse(c, http.StatusOK, chal)
+}
+
+func (e *env) getACMECertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "acme_controller_get_acme_certificate")
+	defer span.Finish()
+
+	req, err := parseACMERequest(c, e.acmeService.ExternalBaseURL)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	chain, err := e.acmeService.GetCertificate(ctx, req)
+	if err != nil {
+		e.writeACMEProblem(c, span, err)
+		return
+	}
+
+	e.setReplayNonce(c)
+	c.Data(http.StatusOK, pemCertificateChain, []byte(chain))
+}
+
+// writeACMEResponse writes a JSON response with a fresh nonce and a link to the directory of the certificate authority.
+func (e *env) writeACMEResponse(c *gin.Context, status int, body interface{}) {
+	e.setReplayNonce(c)
+	c.Writer.Header().Add("Link", fmt.Sprintf(`<%s>;rel="index"`, e.acmeService.URL(c.Param("id"), "directory")))
+	c.JSON(status, body)
+}
+
+// writeACMEProblem writes an error as a problem document (RFC 7807), which is what ACME clients expect
+// instead of the error format of the rest of the api. Errors that are not ACMEProblems are mapped by status.
+func (e *env) writeACMEProblem(c *gin.Context, span opentracing.Span, err error) {
+	span.LogFields(tracelog.Error(err))
+
+	var problem *model.ACMEProblem
+	var httpErr *httputil.Error
+	switch {
+	case errors.As(err, &problem):
+	case errors.As(err, &httpErr) && httpErr.Status < http.StatusInternalServerError:
+		problem = model.NewACMEProblem(model.ACMEMalformed, "%s", httpErr.Message)
+		if httpErr.Err != nil {
+			problem.Detail = httpErr.Err.Error()
+		}
+		problem.Status = httpErr.Status
+	default:
+		log.Error("acme request failed", zap.String("path", c.Request.URL.Path), zap.Error(err))
+		problem = model.NewACMEProblem(model.ACMEServerInternal, "internal server error")
+	}
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	e.setReplayNonce(c)
+	c.Data(problem.Status, problemType, body)
+}
+
+// setReplayNonce adds a new nonce to the response, as required for all responses to ACME POST requests.
+// Failing to create a nonce does not fail the request since clients can request a nonce separately.
+func (e *env) setReplayNonce(c *gin.Context) {
+	nonce, err := e.acmeService.NewNonce(c.Request.Context())
+	if err != nil {
+		log.Error("failed to create acme nonce", zap.Error(err))
+		return
+	}
+
+	c.Header("Replay-Nonce", nonce)
+}
+
+func parseACMERequest(c *gin.Context, externalBaseURL string) (model.ACMERequest, error) {
+	contentType := c.ContentType()
+	if contentType != joseType {
+		err := model.NewACMEProblem(model.ACMEMalformed, "unsupported content type: %s", contentType)
+		err.Status = http.StatusUnsupportedMediaType
+		return model.ACMERequest{}, err
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxACMERequest))
+	if err != nil {
+		return model.ACMERequest{}, model.NewACMEProblem(model.ACMEMalformed, "failed to read request: %v", err)
+	}
+
+	return model.ACMERequest{
+		CertificateID: c.Param("id"),
+		ResourceID:    c.Param("resourceId"),
+		URL:           strings.TrimSuffix(externalBaseURL, "/") + c.Request.URL.Path,
+		Body:          body,
+	}, nil
+}
@@ -0,0 +1,1012 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/jwsutil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/ratelimit"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+)
+
+func TestACMEIssuance_HTTP01(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+
+	challenges := newTestChallengeServer(t, e)
+	defer challenges.Close()
+
+	_, admin, _ := createTestAccount(t, e)
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", "1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e")
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, "1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e")
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, "1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e")
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, testLoopbackEnrollment)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+
+	client := createTestACMEClient(t, ts, rootCA.ID)
+	account, err := client.Register(ctx, &acme.Account{Contact: []string{"mailto:ops@webca.io"}}, acme.AcceptTOS)
+	assert.NoError(err)
+	assert.Equal(acme.StatusValid, account.Status)
+	assert.Equal([]string{"mailto:ops@webca.io"}, account.Contact)
+	assert.True(strings.HasPrefix(account.URI, fmt.Sprintf("%s/v1/acme/%s/account/", ts.URL, rootCA.ID)))
+
+	_, err = client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.Equal(acme.ErrAccountAlreadyExists, err)
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+	assert.NoError(err)
+	assert.Equal(acme.StatusPending, order.Status)
+	assert.Len(order.AuthzURLs, 1)
+
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	assert.NoError(err)
+	assert.Equal(acme.StatusPending, authz.Status)
+	assert.Equal("localhost", authz.Identifier.Value)
+	assert.Len(authz.Challenges, 2)
+
+	chal := findTestChallenge(t, authz, model.HTTP01Challenge)
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	assert.NoError(err)
+	challenges.set(chal.Token, keyAuth)
+
+	chal, err = client.Accept(ctx, chal)
+	assert.NoError(err)
+	assert.Equal(acme.StatusValid, chal.Status)
+
+	authz, err = client.WaitAuthorization(ctx, order.AuthzURLs[0])
+	assert.NoError(err)
+	assert.Equal(acme.StatusValid, authz.Status)
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	assert.NoError(err)
+	assert.Equal(acme.StatusReady, order.Status)
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	csr := createTestACMECSR(t, certKey, "localhost")
+
+	chain, certURL, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	assert.NoError(err)
+	assert.NotEmpty(certURL)
+	// The root certificate is not included in the chain.
+	assert.Len(chain, 1)
+
+	cert, err := x509.ParseCertificate(chain[0])
+	assert.NoError(err)
+	assert.NoError(cert.CheckSignatureFrom(rootCert))
+	assert.Equal("localhost", cert.Subject.CommonName)
+	assert.Equal([]string{"localhost"}, cert.DNSNames)
+	assert.Equal(certKey.Public(), cert.PublicKey)
+	assert.Equal([]string{fmt.Sprintf("https://webca.io/api/v1/ca/%s/crl", rootCA.ID)}, cert.CRLDistributionPoints)
+
+	order, err = client.GetOrder(ctx, order.URI)
+	assert.NoError(err)
+	assert.Equal(acme.StatusValid, order.Status)
+	assert.Equal(certURL, order.CertURL)
+
+	// The certificate is named after the order, since the requested names may exceed the length of a certificate name.
+	orderID := strings.TrimPrefix(order.URI, fmt.Sprintf("%s/v1/acme/%s/order/", ts.URL, rootCA.ID))
+	storedOrder, found, err := repository.NewACMERepository(e.db).FindOrder(ctx, orderID)
+	assert.NoError(err)
+	assert.True(found)
+	issued, found, err := repository.NewCertificateRepository(e.db).Find(ctx, storedOrder.CertificateID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal("acme-"+orderID, issued.Name)
+
+	accountID := strings.TrimPrefix(account.URI, fmt.Sprintf("%s/v1/acme/%s/account/", ts.URL, rootCA.ID))
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:acme-account:%s", accountID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("CREATE", events[0].Activity)
+	assert.Equal(accountID, events[0].UserID)
+}
+
+func TestACMEIssuance_DNS01Wildcard(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+
+	resolver := &testResolver{records: make(map[string][]string)}
+	e.acmeService.Resolver = resolver
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	intermediateCA := createTestIntermediateCertificate(t, server, admin.JWTUser(), "intermediate-ca", "5b4a3f2e1d0c9b8a7f6e5d4c3b2a1f0e", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), intermediateCA.ID, "5b4a3f2e1d0c9b8a7f6e5d4c3b2a1f0e")
+	enableTestACME(t, server, admin.JWTUser(), intermediateCA.ID, model.ACMEEnrollmentRequest{})
+	intermediateCert := parseTestCertificate(t, intermediateCA.Body)
+
+	client := createTestACMEClient(t, ts, intermediateCA.ID)
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("*.webca.io", "webca.io"))
+	assert.NoError(err)
+	assert.Len(order.AuthzURLs, 2)
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		assert.NoError(err)
+		assert.Equal("webca.io", authz.Identifier.Value)
+		if authz.Wildcard {
+			// Wildcard names can only be validated through DNS.
+			assert.Len(authz.Challenges, 1)
+		}
+
+		chal := findTestChallenge(t, authz, model.DNS01Challenge)
+		record, err := client.DNS01ChallengeRecord(chal.Token)
+		assert.NoError(err)
+		resolver.add("_acme-challenge.webca.io", record)
+
+		_, err = client.Accept(ctx, chal)
+		assert.NoError(err)
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	assert.NoError(err)
+	assert.Equal(acme.StatusReady, order.Status)
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	csr := createTestACMECSR(t, certKey, "*.webca.io", "webca.io")
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	assert.NoError(err)
+	assert.Len(chain, 2)
+
+	cert, err := x509.ParseCertificate(chain[0])
+	assert.NoError(err)
+	assert.NoError(cert.CheckSignatureFrom(intermediateCert))
+	assert.ElementsMatch([]string{"*.webca.io", "webca.io"}, cert.DNSNames)
+	assert.Equal(intermediateCert.Raw, chain[1])
+}
+
+func TestACMEIssuance_FailedChallenge(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+
+	challenges := newTestChallengeServer(t, e)
+	defer challenges.Close()
+
+	_, admin, _ := createTestAccount(t, e)
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", "9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a")
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, "9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a")
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, testLoopbackEnrollment)
+
+	client := createTestACMEClient(t, ts, rootCA.ID)
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+	assert.NoError(err)
+
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	assert.NoError(err)
+
+	chal := findTestChallenge(t, authz, model.HTTP01Challenge)
+	challenges.set(chal.Token, chal.Token+".wrong-thumbprint")
+
+	chal, err = client.Accept(ctx, chal)
+	assert.NoError(err)
+	assert.Equal(acme.StatusInvalid, chal.Status)
+	assert.Equal(model.ACMEIncorrectResponse, chal.Error.(*acme.Error).ProblemType)
+
+	_, err = client.WaitAuthorization(ctx, order.AuthzURLs[0])
+	assert.Error(err)
+
+	order, err = client.GetOrder(ctx, order.URI)
+	assert.NoError(err)
+	assert.Equal(acme.StatusInvalid, order.Status)
+
+	// Invalid challenges cannot be retried.
+	chal, err = client.Accept(ctx, chal)
+	assert.NoError(err)
+	assert.Equal(acme.StatusInvalid, chal.Status)
+}
+
+func TestACMEIssuance_NonPublicTargets(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+
+	challenges := newTestChallengeServer(t, e)
+	defer challenges.Close()
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, model.ACMEEnrollmentRequest{})
+
+	client := createTestACMEClient(t, ts, rootCA.ID)
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	for _, name := range []string{"localhost", "metadata.google.internal", "127.0.0.1", "169.254.169.254"} {
+		_, err = client.AuthorizeOrder(ctx, acme.DomainIDs(name))
+		assertTestACMEError(t, err, http.StatusBadRequest, model.ACMERejectedIdentifier)
+	}
+
+	// Allowing a private domain does not allow validation to connect to private addresses.
+	domainPassword := "7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2c"
+	domainCA := createTestRootCertificate(t, server, admin.JWTUser(), "domain-ca", domainPassword)
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), domainCA.ID, domainPassword)
+	enableTestACME(t, server, admin.JWTUser(), domainCA.ID, model.ACMEEnrollmentRequest{
+		AllowedDomains: []string{"localhost"},
+	})
+
+	domainClient := createTestACMEClient(t, ts, domainCA.ID)
+	_, err = domainClient.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	for _, name := range []string{"127.0.0.1", "metadata.google.internal"} {
+		_, err = domainClient.AuthorizeOrder(ctx, acme.DomainIDs(name))
+		assertTestACMEError(t, err, http.StatusBadRequest, model.ACMERejectedIdentifier)
+	}
+
+	order, err := domainClient.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+	assert.NoError(err)
+	authz, err := domainClient.GetAuthorization(ctx, order.AuthzURLs[0])
+	assert.NoError(err)
+
+	chal := findTestChallenge(t, authz, model.HTTP01Challenge)
+	keyAuth, err := domainClient.HTTP01ChallengeResponse(chal.Token)
+	assert.NoError(err)
+	challenges.set(chal.Token, keyAuth)
+
+	chal, err = domainClient.Accept(ctx, chal)
+	assert.NoError(err)
+	assert.Equal(acme.StatusInvalid, chal.Status)
+	assert.Equal(model.ACMEConnection, chal.Error.(*acme.Error).ProblemType)
+	assert.Contains(chal.Error.Error(), "non public address")
+
+	// The key authorization is served at the redirect target, but redirects are not followed.
+	loopbackPassword := "8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3d"
+	loopbackCA := createTestRootCertificate(t, server, admin.JWTUser(), "loopback-ca", loopbackPassword)
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), loopbackCA.ID, loopbackPassword)
+	enableTestACME(t, server, admin.JWTUser(), loopbackCA.ID, testLoopbackEnrollment)
+
+	loopbackClient := createTestACMEClient(t, ts, loopbackCA.ID)
+	_, err = loopbackClient.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	order, err = loopbackClient.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+	assert.NoError(err)
+	authz, err = loopbackClient.GetAuthorization(ctx, order.AuthzURLs[0])
+	assert.NoError(err)
+
+	chal = findTestChallenge(t, authz, model.HTTP01Challenge)
+	keyAuth, err = loopbackClient.HTTP01ChallengeResponse(chal.Token)
+	assert.NoError(err)
+	challenges.set("redirected", keyAuth)
+	challenges.redirect(chal.Token, challenges.URL+"/.well-known/acme-challenge/redirected")
+
+	chal, err = loopbackClient.Accept(ctx, chal)
+	assert.NoError(err)
+	assert.Equal(acme.StatusInvalid, chal.Status)
+	assert.Equal(model.ACMEIncorrectResponse, chal.Error.(*acme.Error).ProblemType)
+}
+
+func TestACMENewOrder_NameConstraints(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4e"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	intermediatePassword := "0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5f"
+	intermediateCA := createTestCertificate(t, server, admin.JWTUser(), model.CertificateRequest{
+		Name:      "corp-ca",
+		Subject:   model.CertificateSubject{CommonName: "Corp CA"},
+		Type:      model.IntermediateCAType,
+		Algorithm: "RSA",
+		Password:  intermediatePassword,
+		Options: map[string]interface{}{
+			"keySize": 1024,
+		},
+		Signatory: model.Signatory{
+			ID:       rootCA.ID,
+			Password: rootPassword,
+		},
+		NameConstraints: model.NameConstraints{
+			Permitted: model.NameSubtrees{
+				DNSDomains: []string{"webca.internal"},
+			},
+			Excluded: model.NameSubtrees{
+				DNSDomains: []string{"secret.webca.internal"},
+			},
+		},
+	})
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), intermediateCA.ID, intermediatePassword)
+	enableTestACME(t, server, admin.JWTUser(), intermediateCA.ID, model.ACMEEnrollmentRequest{
+		AllowedDomains: []string{"localhost"},
+	})
+
+	client := createTestACMEClient(t, ts, intermediateCA.ID)
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	// Names within a domain permitted by the certificate authority need not be public.
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("ci.webca.internal", "*.apps.webca.internal"))
+	assert.NoError(err)
+	assert.Len(order.AuthzURLs, 2)
+
+	// Names outside of the name constraints are rejected, even if allowed by the enrollment.
+	for _, names := range [][]string{
+		{"app.webca.io"},
+		{"localhost"},
+		{"vault.secret.webca.internal"},
+		{"*.webca.internal"},
+		{"ci.webca.internal", "app.webca.io"},
+	} {
+		_, err = client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+		assertTestACMEError(t, err, http.StatusBadRequest, model.ACMERejectedIdentifier)
+	}
+}
+
+func TestACMEFinalize_Rejected(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+
+	resolver := &testResolver{records: make(map[string][]string)}
+	e.acmeService.Resolver = resolver
+
+	_, admin, _ := createTestAccount(t, e)
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", "4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f")
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, "4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f")
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, model.ACMEEnrollmentRequest{})
+
+	client := createTestACMEClient(t, ts, rootCA.ID)
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("app.webca.io"))
+	assert.NoError(err)
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createTestACMECSR(t, certKey, "app.webca.io"), true)
+	assertTestACMEError(t, err, http.StatusForbidden, model.ACMEOrderNotReady)
+
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	assert.NoError(err)
+	chal := findTestChallenge(t, authz, model.DNS01Challenge)
+	record, err := client.DNS01ChallengeRecord(chal.Token)
+	assert.NoError(err)
+	resolver.add("_acme-challenge.app.webca.io", record)
+	_, err = client.Accept(ctx, chal)
+	assert.NoError(err)
+
+	_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createTestACMECSR(t, certKey, "app.webca.io", "other.webca.io"), true)
+	assertTestACMEError(t, err, http.StatusBadRequest, model.ACMEBadCSR)
+
+	_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createTestACMECSR(t, certKey, "other.webca.io"), true)
+	assertTestACMEError(t, err, http.StatusBadRequest, model.ACMEBadCSR)
+
+	// Orders of other accounts cannot be accessed.
+	otherClient := createTestACMEClient(t, ts, rootCA.ID)
+	_, err = otherClient.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+	_, err = otherClient.GetOrder(ctx, order.URI)
+	assertTestACMEError(t, err, http.StatusNotFound, model.ACMEMalformed)
+	_, err = otherClient.GetAuthorization(ctx, order.AuthzURLs[0])
+	assertTestACMEError(t, err, http.StatusNotFound, model.ACMEMalformed)
+
+	order, err = client.GetOrder(ctx, order.URI)
+	assert.NoError(err)
+	assert.Equal(acme.StatusReady, order.Status)
+
+	_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createTestACMECSR(t, certKey, "app.webca.io"), true)
+	assert.NoError(err)
+}
+
+func TestACMEDirectory(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	rootPassword := "6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, model.ACMEEnrollmentRequest{})
+	userCert := createTestUserCertificate(t, server, user.JWTUser(), "user-cert", "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+
+	req := createUnauthenticatedTestRequest(fmt.Sprintf("/v1/acme/%s/directory", rootCA.ID), http.MethodGet, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var directory model.ACMEDirectory
+	err := json.NewDecoder(res.Body).Decode(&directory)
+	assert.NoError(err)
+	baseURL := fmt.Sprintf("https://webca.io/api/v1/acme/%s", rootCA.ID)
+	assert.Equal(baseURL+"/new-nonce", directory.NewNonce)
+	assert.Equal(baseURL+"/new-account", directory.NewAccount)
+	assert.Equal(baseURL+"/new-order", directory.NewOrder)
+
+	req = createUnauthenticatedTestRequest(fmt.Sprintf("/v1/acme/%s/new-nonce", rootCA.ID), http.MethodHead, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.NotEmpty(res.Header().Get("Replay-Nonce"))
+	assert.Equal("no-store", res.Header().Get("Cache-Control"))
+
+	for _, caID := range []string{"missing-ca-id", userCert.ID} {
+		req = createUnauthenticatedTestRequest(fmt.Sprintf("/v1/acme/%s/directory", caID), http.MethodGet, nil)
+		res = performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusNotFound, res.Code)
+		assert.Equal(problemType, res.Header().Get("Content-Type"))
+	}
+
+	e.acmeService.ExternalBaseURL = ""
+	req = createUnauthenticatedTestRequest(fmt.Sprintf("/v1/acme/%s/directory", rootCA.ID), http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestEnableUnattendedIssuance(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+	rootPassword := "7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	path := fmt.Sprintf("/v1/certificates/%s/unattended-issuance", rootCA.ID)
+
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.UnattendedIssuanceRequest{Password: "wrong-password"})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.UnattendedIssuanceRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, user.JWTUser(), model.UnattendedIssuanceRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, otherAdmin.JWTUser(), model.UnattendedIssuanceRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	// Enrollment for revocation lists does not store an issuing key.
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	issuingKeyRepo := repository.NewIssuingKeyRepository(e.db)
+	_, found, err := issuingKeyRepo.Find(ctx, rootCA.ID)
+	assert.NoError(err)
+	assert.False(found)
+
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	issuingKey, found, err := issuingKeyRepo.Find(ctx, rootCA.ID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(admin.ID, issuingKey.CreatedBy)
+	assert.NotContains(issuingKey.PrivateKey, "PRIVATE KEY")
+
+	auditEvents, err := repository.NewAuditEventRepository(e.db).FindByResource(ctx, fmt.Sprintf("webca:api-server:certificate:%s:issuing-key", rootCA.ID))
+	assert.NoError(err)
+	assert.Len(auditEvents, 1)
+	assert.Equal("CREATE", auditEvents[0].Activity)
+	assert.Equal(admin.ID, auditEvents[0].UserID)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.UnattendedIssuanceRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	userCert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	req = createTestRequest(fmt.Sprintf("/v1/certificates/%s/unattended-issuance", userCert.ID), http.MethodPost, admin.JWTUser(), model.UnattendedIssuanceRequest{Password: "8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c"})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodDelete, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(path, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	auditEvents, err = repository.NewAuditEventRepository(e.db).FindByResource(ctx, fmt.Sprintf("webca:api-server:certificate:%s:issuing-key", rootCA.ID))
+	assert.NoError(err)
+	assert.Len(auditEvents, 2)
+
+	// The revocation list signing key is kept and cannot be used to issue certificates.
+	_, err = e.certificateService.SignUnattended(ctx, model.CertificateRequest{
+		Name:          "unattended-cert",
+		Subject:       model.CertificateSubject{CommonName: "app.webca.io"},
+		Type:          model.UserCertificateType,
+		Signatory:     model.Signatory{ID: rootCA.ID},
+		ExpiresInDays: 10,
+	}, parseTestCertificate(t, userCert.Body).PublicKey, admin.ID)
+	assert.Error(err)
+	assert.Equal(http.StatusPreconditionRequired, err.(*httputil.Error).Status)
+
+	enrolled, err := e.crlService.Enrolled(ctx, rootCA.ID)
+	assert.NoError(err)
+	assert.True(enrolled)
+}
+
+func TestEnableUnattendedIssuance_NoKeyWrappingKey(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	e.certificateService.KeyWrappingKey = nil
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+
+	path := fmt.Sprintf("/v1/certificates/%s/unattended-issuance", rootCA.ID)
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.UnattendedIssuanceRequest{Password: rootPassword})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusPreconditionRequired, res.Code)
+}
+
+func TestEnableACME(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+	rootPassword := "2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	path := fmt.Sprintf("/v1/certificates/%s/acme", rootCA.ID)
+	directoryPath := fmt.Sprintf("/v1/acme/%s/directory", rootCA.ID)
+
+	req := createUnauthenticatedTestRequest(path, http.MethodPost, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, user.JWTUser(), model.ACMEEnrollmentRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, otherAdmin.JWTUser(), model.ACMEEnrollmentRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	// The certificate authority must be enrolled for unattended issuance first,
+	// enrollment for revocation lists does not let the server issue certificates.
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.ACMEEnrollmentRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusPreconditionRequired, res.Code)
+
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.ACMEEnrollmentRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusPreconditionRequired, res.Code)
+
+	// Enrollment for unattended issuance does not open an ACME directory.
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	req = createUnauthenticatedTestRequest(directoryPath, http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	for _, invalid := range []model.ACMEEnrollmentRequest{
+		{AllowedDomains: []string{"*.corp.webca.io"}},
+		{AllowedDomains: []string{"10.0.0.1"}},
+		{AllowedNetworks: []string{"10.0.0.1"}},
+		{AllowedNetworks: []string{"corp.webca.io"}},
+	} {
+		req = createTestRequest(path, http.MethodPost, admin.JWTUser(), invalid)
+		res = performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusBadRequest, res.Code)
+	}
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.ACMEEnrollmentRequest{
+		AllowedDomains:  []string{"Corp.WebCA.io."},
+		AllowedNetworks: []string{"10.1.2.3/16"},
+	})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var enrollment model.ACMEEnrollment
+	err := json.NewDecoder(res.Body).Decode(&enrollment)
+	assert.NoError(err)
+	assert.Equal(rootCA.ID, enrollment.CertificateID)
+	assert.Equal([]string{"corp.webca.io"}, enrollment.AllowedDomains)
+	assert.Equal([]string{"10.1.0.0/16"}, enrollment.AllowedNetworks)
+	assert.Equal(admin.ID, enrollment.CreatedBy)
+
+	storedEnrollment, found, err := repository.NewACMERepository(e.db).FindEnrollment(ctx, rootCA.ID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(enrollment.AllowedDomains, storedEnrollment.AllowedDomains)
+	assert.Equal(enrollment.AllowedNetworks, storedEnrollment.AllowedNetworks)
+	assert.Equal("https://webca.io/api"+directoryPath, enrollment.DirectoryURL)
+
+	auditEvents, err := repository.NewAuditEventRepository(e.db).FindByResource(ctx, fmt.Sprintf("webca:api-server:certificate:%s:acme", rootCA.ID))
+	assert.NoError(err)
+	assert.Len(auditEvents, 1)
+	assert.Equal("CREATE", auditEvents[0].Activity)
+	assert.Equal(admin.ID, auditEvents[0].UserID)
+
+	req = createUnauthenticatedTestRequest(directoryPath, http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.ACMEEnrollmentRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	userCert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	req = createTestRequest(fmt.Sprintf("/v1/certificates/%s/acme", userCert.ID), http.MethodPost, admin.JWTUser(), model.ACMEEnrollmentRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodDelete, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(path, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	req = createUnauthenticatedTestRequest(directoryPath, http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestACMERateLimit(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+	e.acmeService.ExternalBaseURL = ts.URL
+	e.acmeService.AccountLimiter = ratelimit.NewLimiter(1, time.Hour)
+	e.acmeService.OrderLimiter = ratelimit.NewLimiter(1, time.Hour)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9a"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, model.ACMEEnrollmentRequest{})
+	otherPassword := "5d6e7f8a9b0c1d2e3f4a5b6c7d8e9a0b"
+	otherCA := createTestRootCertificate(t, server, admin.JWTUser(), "other-ca", otherPassword)
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), otherCA.ID, otherPassword)
+	enableTestACME(t, server, admin.JWTUser(), otherCA.ID, model.ACMEEnrollmentRequest{})
+
+	// The client retries rate limited requests unless told otherwise.
+	noRetry := func(n int, r *http.Request, res *http.Response) time.Duration { return 0 }
+	client := createTestACMEClient(t, ts, rootCA.ID)
+	client.RetryBackoff = noRetry
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+
+	// Looking up an existing account is not limited.
+	_, err = client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.Equal(acme.ErrAccountAlreadyExists, err)
+
+	limitedClient := createTestACMEClient(t, ts, rootCA.ID)
+	limitedClient.RetryBackoff = noRetry
+	_, err = limitedClient.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assertTestACMEError(t, err, http.StatusTooManyRequests, model.ACMERateLimited)
+
+	_, err = client.AuthorizeOrder(ctx, acme.DomainIDs("app.webca.io"))
+	assert.NoError(err)
+
+	_, err = client.AuthorizeOrder(ctx, acme.DomainIDs("app.webca.io"))
+	assertTestACMEError(t, err, http.StatusTooManyRequests, model.ACMERateLimited)
+
+	// Limits are kept per certificate authority.
+	otherClient := createTestACMEClient(t, ts, otherCA.ID)
+	_, err = otherClient.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(err)
+	_, err = otherClient.AuthorizeOrder(ctx, acme.DomainIDs("app.webca.io"))
+	assert.NoError(err)
+}
+
+func TestACMERequest_BadRequests(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", "f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5")
+	enableTestUnattendedIssuance(t, server, admin.JWTUser(), rootCA.ID, "f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5")
+	enableTestACME(t, server, admin.JWTUser(), rootCA.ID, model.ACMEEnrollmentRequest{})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	path := fmt.Sprintf("/v1/acme/%s/new-account", rootCA.ID)
+	accountURL := "https://webca.io/api" + path
+
+	// Valid request
+	nonce := getTestACMENonce(t, server, rootCA.ID)
+	res := postTestJWS(t, server, path, createTestJWS(t, key, accountURL, nonce, `{}`))
+	assert.Equal(http.StatusCreated, res.Code)
+	assert.NotEmpty(res.Header().Get("Location"))
+	assert.NotEmpty(res.Header().Get("Replay-Nonce"))
+
+	// Reused nonce
+	res = postTestJWS(t, server, path, createTestJWS(t, key, accountURL, nonce, `{}`))
+	assertTestACMEProblem(t, res, http.StatusBadRequest, model.ACMEBadNonce)
+	assert.NotEmpty(res.Header().Get("Replay-Nonce"))
+
+	// Unknown nonce
+	res = postTestJWS(t, server, path, createTestJWS(t, key, accountURL, "made-up-nonce", `{}`))
+	assertTestACMEProblem(t, res, http.StatusBadRequest, model.ACMEBadNonce)
+
+	// Url mismatch
+	nonce = getTestACMENonce(t, server, rootCA.ID)
+	res = postTestJWS(t, server, path, createTestJWS(t, key, "https://webca.io/api/v1/acme/other-ca/new-account", nonce, `{}`))
+	assertTestACMEProblem(t, res, http.StatusUnauthorized, model.ACMEUnauthorized)
+
+	// Unsupported contact
+	nonce = getTestACMENonce(t, server, rootCA.ID)
+	res = postTestJWS(t, server, path, createTestJWS(t, key, accountURL, nonce, `{"contact": ["tel:+46701234567"]}`))
+	assertTestACMEProblem(t, res, http.StatusBadRequest, model.ACMEUnsupportedContact)
+
+	// Wrong content type
+	req := createUnauthenticatedTestRequest(path, http.MethodPost, nil)
+	res = performTestRequest(server.Handler, req)
+	assertTestACMEProblem(t, res, http.StatusUnsupportedMediaType, model.ACMEMalformed)
+
+	// Not a JWS
+	res = postTestJWS(t, server, path, []byte(`{"hello": "world"}`))
+	assertTestACMEProblem(t, res, http.StatusBadRequest, model.ACMEMalformed)
+}
+
+// ---- Test utils ----
+
+type testChallengeServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses map[string]string
+	redirects map[string]string
+}
+
+// testLoopbackEnrollment allows validation of localhost, where the test challenge server listens.
+var testLoopbackEnrollment = model.ACMEEnrollmentRequest{
+	AllowedDomains:  []string{"localhost"},
+	AllowedNetworks: []string{"127.0.0.0/8", "::1/128"},
+}
+
+// newTestChallengeServer starts a server on the loopback interface that serves http-01 key authorizations
+// and points the http-01 validation of the ACME service to its port. Validation is performed by the
+// production client, so only names and addresses allowed by the enrollment reach the server.
+func newTestChallengeServer(t *testing.T, e *env) *testChallengeServer {
+	s := &testChallengeServer{
+		responses: make(map[string]string),
+		redirects: make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		token := strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+		if location, ok := s.redirects[token]; ok {
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+
+		keyAuth, ok := s.responses[token]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(keyAuth))
+	}))
+
+	_, port, err := net.SplitHostPort(s.Listener.Addr().String())
+	assert.NoError(t, err)
+	e.acmeService.HTTPPort = port
+
+	return s
+}
+
+func (s *testChallengeServer) set(token, keyAuth string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[token] = keyAuth
+}
+
+func (s *testChallengeServer) redirect(token, location string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redirects[token] = location
+}
+
+type testResolver struct {
+	mu      sync.Mutex
+	records map[string][]string
+}
+
+func (r *testResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records, ok := r.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return records, nil
+}
+
+func (r *testResolver) add(name, record string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[name] = append(r.records[name], record)
+}
+
+func enableTestUnattendedIssuance(t *testing.T, server *http.Server, jwtUser jwt.User, caID, password string) {
+	path := fmt.Sprintf("/v1/certificates/%s/unattended-issuance", caID)
+	req := createTestRequest(path, http.MethodPost, jwtUser, model.UnattendedIssuanceRequest{Password: password})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func enableTestACME(t *testing.T, server *http.Server, jwtUser jwt.User, caID string, enrollment model.ACMEEnrollmentRequest) {
+	path := fmt.Sprintf("/v1/certificates/%s/acme", caID)
+	req := createTestRequest(path, http.MethodPost, jwtUser, enrollment)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func createTestACMEClient(t *testing.T, ts *httptest.Server, caID string) *acme.Client {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	return &acme.Client{
+		Key:          key,
+		DirectoryURL: fmt.Sprintf("%s/v1/acme/%s/directory", ts.URL, caID),
+		HTTPClient:   ts.Client(),
+	}
+}
+
+func findTestChallenge(t *testing.T, authz *acme.Authorization, challengeType string) *acme.Challenge {
+	for _, chal := range authz.Challenges {
+		if chal.Type == challengeType {
+			return chal
+		}
+	}
+
+	t.Fatalf("no %s challenge found in %s", challengeType, authz.URI)
+	return nil
+}
+
+func createTestACMECSR(t *testing.T, key *ecdsa.PrivateKey, names ...string) []byte {
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	assert.NoError(t, err)
+
+	return csr
+}
+
+func getTestACMENonce(t *testing.T, server *http.Server, caID string) string {
+	req := createUnauthenticatedTestRequest(fmt.Sprintf("/v1/acme/%s/new-nonce", caID), http.MethodHead, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	return res.Header().Get("Replay-Nonce")
+}
+
+func createTestJWS(t *testing.T, key *ecdsa.PrivateKey, url, nonce, payload string) []byte {
+	assert := assert.New(t)
+
+	jwk, err := jwsutil.EncodeJWK(key.Public())
+	assert.NoError(err)
+
+	header := fmt.Sprintf(`{"alg":"ES256","jwk":%s,"nonce":%q,"url":%q}`, jwk, nonce, url)
+	protected := base64.RawURLEncoding.EncodeToString([]byte(header))
+	encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	digest := sha256.Sum256([]byte(protected + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.NoError(err)
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	body, err := json.Marshal(jwsutil.Message{
+		Protected: protected,
+		Payload:   encodedPayload,
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	})
+	assert.NoError(err)
+
+	return body
+}
+
+func postTestJWS(t *testing.T, server *http.Server, path string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", joseType)
+
+	return performTestRequest(server.Handler, req)
+}
+
+func assertTestACMEProblem(t *testing.T, res *httptest.ResponseRecorder, status int, problemType string) {
+	assert := assert.New(t)
+	assert.Equal(status, res.Code)
+
+	var problem model.ACMEProblem
+	err := json.NewDecoder(res.Body).Decode(&problem)
+	assert.NoError(err)
+	assert.Equal(problemType, problem.Type)
+}
+
+func assertTestACMEError(t *testing.T, err error, status int, problemType string) {
+	assert := assert.New(t)
+
+	acmeErr, ok := err.(*acme.Error)
+	if !assert.True(ok, "expected *acme.Error got %v", err) {
+		return
+	}
+
+	assert.Equal(status, acmeErr.StatusCode)
+	assert.Equal(problemType, acmeErr.ProblemType)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/pem"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/CzarSimon/httputil"
 	"github.com/CzarSimon/webca/api-server/internal/model"
@@ -13,6 +16,11 @@ import (
 
 const (
 	privKeyPwdHeader         = "X-Private-Key-Password"
+	exportPwdHeader          = "X-Export-Password"
+	jsonType                 = "application/json"
+	pkixCertType             = "application/pkix-cert"
+	pkcs8Type                = "application/pkcs8"
+	pkcs12Type               = "application/pkcs12"
 	defaultCertificateExpiry = 365
 )
 
@@ -45,6 +53,35 @@ func (e *env) createCertificate(c *gin.Context) {
 	c.JSON(http.StatusOK, cert)
 }
 
+func (e *env) signCertificateRequest(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_sign_certificate_request")
+	defer span.Finish()
+
+	req, err := parseSigningRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	cert, err := e.certificateService.Sign(ctx, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
 func (e *env) getCertificate(c *gin.Context) {
 	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_get_certificate")
 	defer span.Finish()
@@ -106,16 +143,28 @@ func (e *env) getCertificateBody(c *gin.Context) {
 		return
 	}
 
+	format := parseFormatParameter(c, pkixCertType)
+	responseType, err := negotiateAttachmentType(c, jsonType, downloadType(format, pkixCertType))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
 	certID := c.Param("id")
 	fullchain := parseBooleanParameter(c, "fullchain", false)
-	attachment, err := e.certificateService.GetCertificateBody(ctx, principal, certID, fullchain)
+	attachment, err := e.certificateService.GetCertificateBody(ctx, principal, certID, fullchain, format)
 	if err != nil {
 		span.LogFields(tracelog.Error(err))
 		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, attachment)
+	err = writeAttachment(c, attachment, responseType)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+	}
 }
 
 func (e *env) getCertificatePrivateKey(c *gin.Context) {
@@ -137,22 +186,151 @@ func (e *env) getCertificatePrivateKey(c *gin.Context) {
 		return
 	}
 
+	format := parseFormatParameter(c, pkcs8Type)
+	responseType, err := negotiateAttachmentType(c, append([]string{jsonType}, keyDownloadTypes(format)...)...)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
 	certID := c.Param("id")
-	attachment, err := e.certificateService.GetCertificatePrivateKey(ctx, principal, certID, password)
+	exportPassword := c.GetHeader(exportPwdHeader)
+	attachment, err := e.certificateService.GetCertificatePrivateKey(ctx, principal, certID, format, password, exportPassword)
 	if err != nil {
 		span.LogFields(tracelog.Error(err))
 		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, attachment)
+	if responseType == pkcs8Type && format != model.FormatDER {
+		attachment, err = decodePEMAttachment(attachment, pkcs8Type)
+		if err != nil {
+			span.LogFields(tracelog.Error(err))
+			c.Error(err)
+			return
+		}
+	}
+
+	err = writeAttachment(c, attachment, responseType)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+	}
+}
+
+func (e *env) getCertificatePKCS12(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_get_certificate_pkcs12")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	password := c.GetHeader(privKeyPwdHeader)
+	exportPassword := c.GetHeader(exportPwdHeader)
+	if password == "" || exportPassword == "" {
+		err := httputil.BadRequestError(fmt.Errorf("missing required headers. %s and %s", privKeyPwdHeader, exportPwdHeader))
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	responseType, err := negotiateAttachmentType(c, pkcs12Type, jsonType)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	certID := c.Param("id")
+	attachment, err := e.certificateService.GetCertificatePKCS12(ctx, principal, certID, password, exportPassword)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	err = writeAttachment(c, attachment, responseType)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+	}
+}
+
+func (e *env) revokeCertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_revoke_certificate")
+	defer span.Finish()
+
+	req, err := parseRevocationRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	cert, err := e.certificateService.Revoke(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
+func (e *env) renewCertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_renew_certificate")
+	defer span.Finish()
+
+	req, err := parseRenewalRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	cert, err := e.certificateService.Renew(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
 }
 
 func (e *env) getCertificateOptions(c *gin.Context) {
 	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_get_certificate_options")
 	defer span.Finish()
 
-	opts, err := e.certificateService.GetOptions(ctx)
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	opts, err := e.certificateService.GetOptions(ctx, principal)
 	if err != nil {
 		span.LogFields(tracelog.Error(err))
 		c.Error(err)
@@ -162,6 +340,35 @@ func (e *env) getCertificateOptions(c *gin.Context) {
 	c.JSON(http.StatusOK, opts)
 }
 
+func (e *env) importCertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_controller_import_certificate")
+	defer span.Finish()
+
+	req, err := parseImportRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	cert, err := e.certificateService.Import(ctx, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
 func parseCertificateRequest(c *gin.Context) (model.CertificateRequest, error) {
 	var body model.CertificateRequest
 	err := c.BindJSON(&body)
@@ -170,7 +377,7 @@ func parseCertificateRequest(c *gin.Context) (model.CertificateRequest, error) {
 		return model.CertificateRequest{}, err
 	}
 
-	if body.ExpiresInDays <= 0 {
+	if body.ExpiresInDays <= 0 && body.Profile == "" {
 		body.ExpiresInDays = defaultCertificateExpiry
 	}
 
@@ -179,6 +386,75 @@ func parseCertificateRequest(c *gin.Context) (model.CertificateRequest, error) {
 		return model.CertificateRequest{}, httputil.BadRequestError(err)
 	}
 
+	body.SubjectAlternativeNames, err = body.SubjectAlternativeNames.Normalize()
+	if err != nil {
+		return model.CertificateRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.NameConstraints, err = body.NameConstraints.Normalize()
+	if err != nil {
+		return model.CertificateRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
+
+func parseSigningRequest(c *gin.Context) (model.SigningRequest, error) {
+	var body model.SigningRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.SigningRequest{}, err
+	}
+
+	if body.ExpiresInDays <= 0 && body.Profile == "" {
+		body.ExpiresInDays = defaultCertificateExpiry
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.SigningRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.NameConstraints, err = body.NameConstraints.Normalize()
+	if err != nil {
+		return model.SigningRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
+
+func parseRevocationRequest(c *gin.Context) (model.RevocationRequest, error) {
+	var body model.RevocationRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.RevocationRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.RevocationRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.CertificateID = c.Param("id")
+	return body, nil
+}
+
+func parseRenewalRequest(c *gin.Context) (model.RenewalRequest, error) {
+	var body model.RenewalRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.RenewalRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.RenewalRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.CertificateID = c.Param("id")
 	return body, nil
 }
 
@@ -204,3 +480,192 @@ func parseBooleanParameter(c *gin.Context, key string, defaultValue bool) bool {
 
 	return param == "true"
 }
+
+// parseFormatParameter reads the requested download format, which defaults to DER for clients
+// that prefer the binary media type of the download over JSON and PEM.
+func parseFormatParameter(c *gin.Context, binaryType string) string {
+	defaultFormat := model.FormatPEM
+	if negotiateMediaType(c, jsonType, pemFileType, binaryType) == binaryType {
+		defaultFormat = model.FormatDER
+	}
+
+	return strings.ToUpper(c.DefaultQuery("format", defaultFormat))
+}
+
+// downloadType media type of the raw content of a download in a format.
+func downloadType(format, binaryType string) string {
+	if format == model.FormatDER {
+		return binaryType
+	}
+
+	return pemFileType
+}
+
+// keyDownloadTypes media types of the raw content of a private key download in a format. PKCS#8 keys, plain and encrypted,
+// are also offered as application/pkcs8 which is their DER encoding.
+func keyDownloadTypes(format string) []string {
+	switch format {
+	case model.FormatDER:
+		return []string{pkcs8Type}
+	case model.FormatPKCS8, model.FormatEncryptedPKCS8:
+		return []string{pemFileType, pkcs8Type}
+	default:
+		return []string{pemFileType}
+	}
+}
+
+// decodePEMAttachment converts a PEM encoded attachment into the DER encoded content of its block.
+func decodePEMAttachment(attachment model.Attachment, contentType string) (model.Attachment, error) {
+	block, _ := pem.Decode([]byte(attachment.Body))
+	if block == nil {
+		return model.Attachment{}, httputil.InternalServerError(fmt.Errorf("failed to decode pem body of %s", attachment))
+	}
+
+	return model.NewBinaryAttachment(block.Bytes, contentType, attachment.Filename), nil
+}
+
+func negotiateAttachmentType(c *gin.Context, offered ...string) (string, error) {
+	mediaType := negotiateMediaType(c, offered...)
+	if mediaType == "" {
+		err := fmt.Errorf("none of the accepted media types %s are available, must be one of %v", c.GetHeader("Accept"), offered)
+		return "", httputil.NewError(http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable, err)
+	}
+
+	return mediaType, nil
+}
+
+// negotiateMediaType picks the offered media type with the highest quality value in the Accept header, where an offer
+// gets the quality of the most specific media range that matches it and ties go to the range listed first.
+// Media ranges with a quality of 0 are not acceptable. The first offer is used if the client does not state what it accepts.
+func negotiateMediaType(c *gin.Context, offered ...string) string {
+	header := c.GetHeader("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offered[0]
+	}
+
+	accepted := parseAcceptHeader(header)
+	var best mediaRange
+	bestOffer := ""
+	for _, offer := range offered {
+		match, ok := matchMediaRange(accepted, offer)
+		if !ok || match.quality == 0 {
+			continue
+		}
+		if bestOffer == "" || match.quality > best.quality || (match.quality == best.quality && match.position < best.position) {
+			best = match
+			bestOffer = offer
+		}
+	}
+
+	return bestOffer
+}
+
+// mediaRange media range of an Accept header with its quality value and position in the header.
+type mediaRange struct {
+	mediaType string
+	quality   float64
+	position  int
+}
+
+// specificity ranks exact media types over type/* ranges over */*.
+func (m mediaRange) specificity() int {
+	if m.mediaType == "*/*" {
+		return 0
+	}
+	if strings.HasSuffix(m.mediaType, "/*") {
+		return 1
+	}
+	return 2
+}
+
+func (m mediaRange) matches(mediaType string) bool {
+	switch m.specificity() {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(m.mediaType, "*"))
+	default:
+		return m.mediaType == mediaType
+	}
+}
+
+// parseAcceptHeader parses the media ranges of an Accept header, a missing or invalid quality value is treated as 1 and 0 respectively.
+func parseAcceptHeader(header string) []mediaRange {
+	ranges := make([]mediaRange, 0)
+	for i, accepted := range strings.Split(header, ",") {
+		params := strings.Split(accepted, ";")
+		r := mediaRange{
+			mediaType: strings.ToLower(strings.TrimSpace(params[0])),
+			quality:   1,
+			position:  i,
+		}
+		if r.mediaType == "" {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(strings.ToLower(param), "q=") {
+				continue
+			}
+			quality, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || quality < 0 || quality > 1 {
+				quality = 0
+			}
+			r.quality = quality
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+// matchMediaRange finds the most specific media range that matches a media type.
+func matchMediaRange(ranges []mediaRange, mediaType string) (mediaRange, bool) {
+	var match mediaRange
+	found := false
+	for _, r := range ranges {
+		if !r.matches(mediaType) {
+			continue
+		}
+		if !found || r.specificity() > match.specificity() {
+			match = r
+			found = true
+		}
+	}
+
+	return match, found
+}
+
+// writeAttachment responds with an attachment wrapped in JSON or with its content as a file download.
+func writeAttachment(c *gin.Context, attachment model.Attachment, responseType string) error {
+	if responseType == jsonType {
+		c.JSON(http.StatusOK, attachment)
+		return nil
+	}
+
+	body, err := attachment.Content()
+	if err != nil {
+		return httputil.InternalServerError(err)
+	}
+
+	c.Header("Content-Disposition", attachment.ContentDisposition())
+	c.Data(http.StatusOK, responseType, body)
+	return nil
+}
+
+func parseImportRequest(c *gin.Context) (model.ImportRequest, error) {
+	var body model.ImportRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.ImportRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.ImportRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	tracelog "github.com/opentracing/opentracing-go/log"
+)
+
+func (e *env) createCertificateProfile(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_profile_controller_create_certificate_profile")
+	defer span.Finish()
+
+	req, err := parseCertificateProfileRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	profile, err := e.profileService.Create(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (e *env) getCertificateProfiles(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_profile_controller_get_certificate_profiles")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	profiles, err := e.profileService.List(ctx, principal)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+func (e *env) getCertificateProfile(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_profile_controller_get_certificate_profile")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	profile, err := e.profileService.Get(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (e *env) updateCertificateProfile(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_profile_controller_update_certificate_profile")
+	defer span.Finish()
+
+	req, err := parseCertificateProfileRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.ProfileID = c.Param("id")
+	profile, err := e.profileService.Update(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (e *env) deleteCertificateProfile(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "certificate_profile_controller_delete_certificate_profile")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	err = e.profileService.Delete(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func parseCertificateProfileRequest(c *gin.Context) (model.CertificateProfileRequest, error) {
+	var body model.CertificateProfileRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.CertificateProfileRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.CertificateProfileRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
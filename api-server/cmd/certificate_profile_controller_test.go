@@ -0,0 +1,431 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateProfileCRUD(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	profile := createTestProfile(t, server, admin.JWTUser(), newTestCodeSigningProfileRequest())
+	assert.NotEmpty(profile.ID)
+	assert.Equal("code-signing", profile.Name)
+	assert.Equal(admin.Account.ID, profile.AccountID)
+	assert.Equal([]string{model.DigitalSignatureUsage}, profile.KeyUsage)
+	assert.Equal([]string{model.CodeSigningUsage}, profile.ExtKeyUsage)
+	assert.Equal(30, profile.DefaultValidityDays)
+	assert.Equal(90, profile.MaxValidityDays)
+	assert.Equal([]string{"RSA"}, profile.Algorithms)
+	assert.Equal([]int{1024, 2048}, profile.KeySizes)
+	assert.Equal([]string{}, profile.RequiredSANTypes)
+
+	req := createTestRequest("/v1/certificate-profiles", http.MethodPost, admin.JWTUser(), newTestCodeSigningProfileRequest())
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	req = createTestRequest("/v1/certificate-profiles", http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var profiles []model.CertificateProfile
+	err := json.NewDecoder(res.Result().Body).Decode(&profiles)
+	assert.NoError(err)
+	assert.Len(profiles, 1)
+	assert.Equal(profile.ID, profiles[0].ID)
+
+	req = createTestRequest("/v1/certificate-profiles", http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	profiles = nil
+	err = json.NewDecoder(res.Result().Body).Decode(&profiles)
+	assert.NoError(err)
+	assert.Len(profiles, 0)
+
+	route := "/v1/certificate-profiles/" + profile.ID
+	req = createTestRequest(route, http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var fetched model.CertificateProfile
+	err = json.NewDecoder(res.Result().Body).Decode(&fetched)
+	assert.NoError(err)
+	assert.Equal(profile.Name, fetched.Name)
+	assert.Equal(profile.ExtKeyUsage, fetched.ExtKeyUsage)
+
+	req = createTestRequest(route, http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	update := newTestCodeSigningProfileRequest()
+	update.Name = "code-signing-v2"
+	update.ExtKeyUsage = []string{model.CodeSigningUsage, model.TimeStampingUsage}
+	update.MaxValidityDays = 0
+	req = createTestRequest(route, http.MethodPut, otherAdmin.JWTUser(), update)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(route, http.MethodPut, admin.JWTUser(), update)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var updated model.CertificateProfile
+	err = json.NewDecoder(res.Result().Body).Decode(&updated)
+	assert.NoError(err)
+	assert.Equal(profile.ID, updated.ID)
+	assert.Equal("code-signing-v2", updated.Name)
+	assert.Equal([]string{model.CodeSigningUsage, model.TimeStampingUsage}, updated.ExtKeyUsage)
+	assert.Equal(0, updated.MaxValidityDays)
+	assert.True(updated.UpdatedAt.After(profile.UpdatedAt) || updated.UpdatedAt.Equal(profile.UpdatedAt))
+
+	second := newTestCodeSigningProfileRequest()
+	second.Name = "server"
+	createTestProfile(t, server, admin.JWTUser(), second)
+	req = createTestRequest(route, http.MethodPut, admin.JWTUser(), second)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	req = createTestRequest(route, http.MethodDelete, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(route, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestRequest(route, http.MethodGet, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	req = createTestRequest(route, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:certificate-profile:%s", profile.ID))
+	assert.NoError(err)
+	activities := make(map[string]bool)
+	for _, event := range events {
+		activities[event.Activity] = true
+	}
+	assert.Equal(map[string]bool{"CREATE": true, "READ": true, "UPDATE": true, "DELETE": true}, activities)
+}
+
+func TestCreateCertificateProfile_BadRequest(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+
+	invalid := []func(*model.CertificateProfileRequest){
+		func(r *model.CertificateProfileRequest) { r.Name = "" },
+		func(r *model.CertificateProfileRequest) { r.KeyUsage = nil },
+		func(r *model.CertificateProfileRequest) { r.KeyUsage = []string{"certSign"} },
+		func(r *model.CertificateProfileRequest) { r.ExtKeyUsage = []string{"any"} },
+		func(r *model.CertificateProfileRequest) { r.DefaultValidityDays = 0 },
+		func(r *model.CertificateProfileRequest) { r.MaxValidityDays = 10 },
+		func(r *model.CertificateProfileRequest) { r.MaxValidityDays = -1 },
+		func(r *model.CertificateProfileRequest) { r.Algorithms = []string{"DSA"} },
+		func(r *model.CertificateProfileRequest) { r.KeySizes = []int{0} },
+		func(r *model.CertificateProfileRequest) { r.RequiredSANTypes = []string{"OTHER"} },
+	}
+
+	for i, modify := range invalid {
+		body := newTestCodeSigningProfileRequest()
+		modify(&body)
+		req := createTestRequest("/v1/certificate-profiles", http.MethodPost, admin.JWTUser(), body)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusBadRequest, res.Code, i)
+	}
+}
+
+func TestCreateCertificate_CodeSigningProfile(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	createTestProfile(t, server, admin.JWTUser(), newTestCodeSigningProfileRequest())
+
+	rootCert := createTestRootCertificate(t, server, user.JWTUser(), "root-ca", "root-password-longer-than-16")
+	intermediateCert := createTestIntermediateCertificate(t, server, user.JWTUser(), "intermediate-ca", "intermediate-password-longer-than-16", model.Signatory{
+		ID:       rootCert.ID,
+		Password: "root-password-longer-than-16",
+	})
+
+	cert := createTestCertificate(t, server, user.JWTUser(), model.CertificateRequest{
+		Name:      "code-signer",
+		Subject:   model.CertificateSubject{CommonName: "code-signer"},
+		Type:      model.UserCertificateType,
+		Algorithm: "RSA",
+		Password:  "code-signer-password-longer-than-16",
+		Options:   map[string]interface{}{"keySize": 1024},
+		Signatory: model.Signatory{
+			ID:       intermediateCert.ID,
+			Password: "intermediate-password-longer-than-16",
+		},
+		Profile: "code-signing",
+	})
+	assert.Equal(30*24*time.Hour, cert.ExpiresAt.Sub(cert.CreatedAt))
+
+	x509Cert := parseTestCertificate(t, cert.Body)
+	assert.Equal(x509.KeyUsageDigitalSignature, x509Cert.KeyUsage)
+	assert.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, x509Cert.ExtKeyUsage)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(parseTestCertificate(t, rootCert.Body))
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(parseTestCertificate(t, intermediateCert.Body))
+	_, err := x509Cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	assert.NoError(err)
+
+	_, err = x509Cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	assert.Error(err)
+
+	renewal := model.RenewalRequest{
+		Password: "code-signer-password-longer-than-16",
+		Signatory: model.Signatory{
+			Password: "intermediate-password-longer-than-16",
+		},
+	}
+	req := createTestRequest("/v1/certificates/"+cert.ID+"/renewal", http.MethodPost, user.JWTUser(), renewal)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var successor model.Certificate
+	err = json.NewDecoder(res.Result().Body).Decode(&successor)
+	assert.NoError(err)
+	successorCert := parseTestCertificate(t, successor.Body)
+	assert.Equal(x509.KeyUsageDigitalSignature, successorCert.KeyUsage)
+	assert.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, successorCert.ExtKeyUsage)
+
+	defaultCert := createTestUserCertificate(t, server, user.JWTUser(), "default-user", "default-password-longer-than-16", model.Signatory{
+		ID:       intermediateCert.ID,
+		Password: "intermediate-password-longer-than-16",
+	})
+	defaultX509Cert := parseTestCertificate(t, defaultCert.Body)
+	assert.Equal(x509.KeyUsageDigitalSignature, defaultX509Cert.KeyUsage)
+	assert.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}, defaultX509Cert.ExtKeyUsage)
+}
+
+func TestCreateCertificate_ProfileViolations(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	profileReq := newTestCodeSigningProfileRequest()
+	profileReq.Name = "server"
+	profileReq.RequiredSANTypes = []string{model.DNSNameType}
+	createTestProfile(t, server, admin.JWTUser(), profileReq)
+
+	otherReq := newTestCodeSigningProfileRequest()
+	otherReq.Name = "other-account-profile"
+	createTestProfile(t, server, otherAdmin.JWTUser(), otherReq)
+
+	rootCert := createTestRootCertificate(t, server, user.JWTUser(), "root-ca", "root-password-longer-than-16")
+
+	newRequest := func() model.CertificateRequest {
+		return model.CertificateRequest{
+			Name:                    "server-certificate",
+			Subject:                 model.CertificateSubject{CommonName: "webca.io"},
+			SubjectAlternativeNames: model.SubjectAlternativeNames{DNSNames: []string{"webca.io"}},
+			Type:                    model.UserCertificateType,
+			Algorithm:               "RSA",
+			Password:                "server-password-longer-than-16",
+			Options:                 map[string]interface{}{"keySize": 1024},
+			Signatory: model.Signatory{
+				ID:       rootCert.ID,
+				Password: "root-password-longer-than-16",
+			},
+			Profile: "server",
+		}
+	}
+
+	invalid := []func(*model.CertificateRequest){
+		func(r *model.CertificateRequest) { r.ExpiresInDays = 91 },
+		func(r *model.CertificateRequest) {
+			r.Algorithm = "ECDSA"
+			r.Options = map[string]interface{}{"curve": "P256"}
+		},
+		func(r *model.CertificateRequest) { r.Options = map[string]interface{}{"keySize": 1536} },
+		func(r *model.CertificateRequest) { r.SubjectAlternativeNames = model.SubjectAlternativeNames{} },
+		func(r *model.CertificateRequest) { r.Profile = "missing" },
+		func(r *model.CertificateRequest) { r.Profile = "other-account-profile" },
+		func(r *model.CertificateRequest) {
+			r.Type = model.IntermediateCAType
+			r.SubjectAlternativeNames = model.SubjectAlternativeNames{}
+		},
+	}
+
+	for i, modify := range invalid {
+		body := newRequest()
+		modify(&body)
+		req := createTestRequest("/v1/certificates", http.MethodPost, user.JWTUser(), body)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusBadRequest, res.Code, i)
+	}
+
+	body := newRequest()
+	body.ExpiresInDays = 90
+	cert := createTestCertificate(t, server, user.JWTUser(), body)
+	assert.Equal(90*24*time.Hour, cert.ExpiresAt.Sub(cert.CreatedAt))
+}
+
+func TestSignCertificateRequest_Profile(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	rsaProfile := newTestCodeSigningProfileRequest()
+	createTestProfile(t, server, admin.JWTUser(), rsaProfile)
+
+	smimeProfile := model.CertificateProfileRequest{
+		Name:                "s/mime",
+		KeyUsage:            []string{model.DigitalSignatureUsage, model.KeyEnciphermentUsage},
+		ExtKeyUsage:         []string{model.EmailProtectionUsage},
+		DefaultValidityDays: 180,
+		Algorithms:          []string{"ECDSA"},
+		KeySizes:            []int{256},
+		RequiredSANTypes:    []string{model.EmailAddressType},
+	}
+	createTestProfile(t, server, admin.JWTUser(), smimeProfile)
+
+	rootCert := createTestRootCertificate(t, server, user.JWTUser(), "root-ca", "root-password-longer-than-16")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	csr := createTestCSR(t, key, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "Jane Doe"},
+		EmailAddresses: []string{"jane@webca.io"},
+	})
+
+	body := model.SigningRequest{
+		Name: "jane",
+		CSR:  csr,
+		Type: model.UserCertificateType,
+		Signatory: model.Signatory{
+			ID:       rootCert.ID,
+			Password: "root-password-longer-than-16",
+		},
+		Profile: rsaProfile.Name,
+	}
+	req := createTestRequest("/v1/certificates/csr", http.MethodPost, user.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	body.Profile = smimeProfile.Name
+	req = createTestRequest("/v1/certificates/csr", http.MethodPost, user.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var cert model.Certificate
+	err = json.NewDecoder(res.Result().Body).Decode(&cert)
+	assert.NoError(err)
+	assert.Equal(180*24*time.Hour, cert.ExpiresAt.Sub(cert.CreatedAt))
+
+	x509Cert := parseTestCertificate(t, cert.Body)
+	assert.Equal(x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, x509Cert.KeyUsage)
+	assert.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}, x509Cert.ExtKeyUsage)
+}
+
+func TestGetCertificateOptions_Profiles(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+	profile := createTestProfile(t, server, admin.JWTUser(), newTestCodeSigningProfileRequest())
+	createTestProfile(t, server, otherAdmin.JWTUser(), newTestCodeSigningProfileRequest())
+
+	req := createTestRequest("/v1/certificate-options", http.MethodGet, user.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var opts model.CertificateOptions
+	err := json.NewDecoder(res.Result().Body).Decode(&opts)
+	assert.NoError(err)
+	assert.Len(opts.Profiles, 1)
+	assert.Equal(profile.ID, opts.Profiles[0].ID)
+	assert.Equal(model.KeyUsages, opts.KeyUsages)
+	assert.Equal(model.ExtKeyUsages, opts.ExtKeyUsages)
+}
+
+func TestCreateCertificateProfile_BadContentType(t *testing.T) {
+	testBadContentType(t, "/v1/certificate-profiles", http.MethodPost, model.AdminRole)
+}
+
+func TestCertificateProfiles_UnauthorizedAndForbidden(t *testing.T) {
+	testUnauthorized(t, "/v1/certificate-profiles", http.MethodGet)
+	testUnauthorized(t, "/v1/certificate-profiles/some-id", http.MethodGet)
+	testForbidden(t, "/v1/certificate-profiles", http.MethodGet, []string{
+		jwt.AnonymousRole,
+	})
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		testUnauthorized(t, "/v1/certificate-profiles/some-id", method)
+		testForbidden(t, "/v1/certificate-profiles/some-id", method, []string{
+			jwt.AnonymousRole,
+			model.UserRole,
+		})
+	}
+
+	testUnauthorized(t, "/v1/certificate-profiles", http.MethodPost)
+	testForbidden(t, "/v1/certificate-profiles", http.MethodPost, []string{
+		jwt.AnonymousRole,
+		model.UserRole,
+	})
+}
+
+func newTestCodeSigningProfileRequest() model.CertificateProfileRequest {
+	return model.CertificateProfileRequest{
+		Name:                "code-signing",
+		KeyUsage:            []string{model.DigitalSignatureUsage},
+		ExtKeyUsage:         []string{model.CodeSigningUsage},
+		DefaultValidityDays: 30,
+		MaxValidityDays:     90,
+		Algorithms:          []string{"RSA"},
+		KeySizes:            []int{1024, 2048},
+	}
+}
+
+func createTestProfile(t *testing.T, server *http.Server, user jwt.User, body model.CertificateProfileRequest) model.CertificateProfile {
+	assert := assert.New(t)
+
+	req := createTestRequest("/v1/certificate-profiles", http.MethodPost, user, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var profile model.CertificateProfile
+	err := json.NewDecoder(res.Result().Body).Decode(&profile)
+	assert.NoError(err)
+
+	return profile
+}
@@ -1,32 +1,64 @@
 package main
 
 import (
+	"encoding/hex"
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/CzarSimon/httputil/crypto"
 	"github.com/CzarSimon/httputil/dbutil"
 	"github.com/CzarSimon/httputil/environ"
 	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
 	"github.com/CzarSimon/webca/api-server/internal/password"
 	"go.uber.org/zap"
 )
 
 type config struct {
-	db             dbutil.Config
-	port           string
-	passwordPolicy password.Policy
-	migrationsPath string
-	jwtCredentials jwt.Credentials
+	db                        dbutil.Config
+	port                      string
+	passwordPolicy            password.Policy
+	migrationsPath            string
+	jwtCredentials            jwt.Credentials
+	externalBaseURL           string
+	keyWrappingKey            string
+	crlValidity               time.Duration
+	ocspValidity              time.Duration
+	acmeHTTPPort              string
+	acmeAccountRateLimit      int
+	acmeOrderRateLimit        int
+	invitationRateLimit       int
+	webAppBaseURL             string
+	notificationSender        notification.Sender
+	notificationEncryptionKey string
+	notificationRetryDelay    time.Duration
+	notificationAttempts      int
+	expiryWarningPeriod       time.Duration
 }
 
 func getConfig() config {
 	return config{
-		db:             getDBCredentials(),
-		port:           environ.Get("SERVICE_PORT", "8080"),
-		passwordPolicy: getPasswordPolicy(),
-		migrationsPath: environ.Get("MIGRATIONS_PATH", "/etc/api-server/migrations"),
-		jwtCredentials: getJwtCredentials(),
+		db:                        getDBCredentials(),
+		port:                      environ.Get("SERVICE_PORT", "8080"),
+		passwordPolicy:            getPasswordPolicy(),
+		migrationsPath:            environ.Get("MIGRATIONS_PATH", "/etc/api-server/migrations"),
+		jwtCredentials:            getJwtCredentials(),
+		externalBaseURL:           environ.Get("EXTERNAL_BASE_URL", ""),
+		keyWrappingKey:            getKeyWrappingKey(),
+		crlValidity:               time.Duration(getIntFromEnvironment("CRL_VALIDITY_HOURS", 24)) * time.Hour,
+		ocspValidity:              time.Duration(getIntFromEnvironment("OCSP_VALIDITY_HOURS", 1)) * time.Hour,
+		acmeHTTPPort:              environ.Get("ACME_HTTP01_PORT", "80"),
+		acmeAccountRateLimit:      getIntFromEnvironment("ACME_NEW_ACCOUNT_RATE_LIMIT_PER_HOUR", 20),
+		acmeOrderRateLimit:        getIntFromEnvironment("ACME_NEW_ORDER_RATE_LIMIT_PER_HOUR", 300),
+		invitationRateLimit:       getIntFromEnvironment("INVITATION_RATE_LIMIT_PER_MINUTE", 10),
+		webAppBaseURL:             environ.Get("WEB_APP_BASE_URL", "http://localhost:3000"),
+		notificationSender:        getNotificationSender(),
+		notificationEncryptionKey: getNotificationEncryptionKey(),
+		notificationRetryDelay:    time.Duration(getIntFromEnvironment("NOTIFICATION_RETRY_DELAY_SECONDS", 60)) * time.Second,
+		notificationAttempts:      getIntFromEnvironment("NOTIFICATION_MAX_ATTEMPTS", 5),
+		expiryWarningPeriod:       time.Duration(getIntFromEnvironment("EXPIRY_WARNING_DAYS", 30)) * 24 * time.Hour,
 	}
 }
 
@@ -62,6 +94,53 @@ func getPasswordPolicy() password.Policy {
 	}
 }
 
+func getNotificationSender() notification.Sender {
+	host := environ.Get("SMTP_HOST", "")
+	if host == "" {
+		log.Warn("SMTP_HOST not set, notifications will only be logged")
+		return notification.LogSender{}
+	}
+
+	sender := &notification.SMTPSender{
+		Host:     host,
+		Port:     environ.Get("SMTP_PORT", "587"),
+		Username: environ.Get("SMTP_USERNAME", ""),
+		From:     environ.MustGet("SMTP_FROM"),
+	}
+	if sender.Username != "" {
+		sender.Password = strings.TrimSpace(mustReadSecretFromFile("SMTP_PASSWORD_FILE"))
+	}
+
+	return sender
+}
+
+// getNotificationEncryptionKey reads the secret that notification bodies are encrypted with while they wait in the outbox.
+// It must be shared by all replicas when a mail server is configured, since any replica may deliver a notification.
+// Without a mail server notifications are only logged and a random key is used.
+func getNotificationEncryptionKey() string {
+	if environ.Get("NOTIFICATION_ENCRYPTION_KEY_FILE", "") != "" || environ.Get("SMTP_HOST", "") != "" {
+		return mustReadSecretFromFile("NOTIFICATION_ENCRYPTION_KEY_FILE")
+	}
+
+	key, err := crypto.RandomBytes(32)
+	if err != nil {
+		log.Fatal("failed to generate notification encryption key", zap.Error(err))
+	}
+
+	return hex.EncodeToString(key)
+}
+
+// getKeyWrappingKey reads the secret used to wrap the private keys that certificate authority owners
+// have enrolled for unattended signing, it must not be shared with the password encryption key.
+func getKeyWrappingKey() string {
+	if environ.Get("CA_KEY_WRAPPING_KEY_FILE", "") == "" {
+		log.Warn("CA_KEY_WRAPPING_KEY_FILE not set, unattended signing of revocation lists will be disabled")
+		return ""
+	}
+
+	return mustReadSecretFromFile("CA_KEY_WRAPPING_KEY_FILE")
+}
+
 func mustReadSecretFromFile(key string) string {
 	filename := environ.MustGet(key)
 	b, err := ioutil.ReadFile(filename)
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,7 +15,10 @@ import (
 	"github.com/CzarSimon/httputil/jwt"
 	"github.com/CzarSimon/webca/api-server/internal/audit"
 	"github.com/CzarSimon/webca/api-server/internal/authorization"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
+	"github.com/CzarSimon/webca/api-server/internal/notification/smtptest"
 	"github.com/CzarSimon/webca/api-server/internal/password"
+	"github.com/CzarSimon/webca/api-server/internal/ratelimit"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
 	"github.com/CzarSimon/webca/api-server/internal/service"
 	"github.com/opentracing/opentracing-go"
@@ -94,10 +98,29 @@ func testBadContentType(t *testing.T, route, method, role string) {
 // ---- Test utils ----
 
 func createTestEnv() (*env, context.Context) {
+	return createTestEnvWithDB(dbutil.SqliteConfig{})
+}
+
+// createTestEnvWithDB creates a test environment backed by the given sqlite database.
+func createTestEnvWithDB(dbConfig dbutil.SqliteConfig) (*env, context.Context) {
 	cfg := config{
-		db:             dbutil.SqliteConfig{},
-		migrationsPath: "../resources/db/sqlite",
-		jwtCredentials: getTestJWTCredentials(),
+		db:                        dbConfig,
+		migrationsPath:            "../resources/db/sqlite",
+		jwtCredentials:            getTestJWTCredentials(),
+		externalBaseURL:           "https://webca.io/api",
+		keyWrappingKey:            "secret-ca-key-wrapping-key",
+		crlValidity:               24 * time.Hour,
+		ocspValidity:              time.Hour,
+		acmeHTTPPort:              "80",
+		acmeAccountRateLimit:      10,
+		acmeOrderRateLimit:        10,
+		invitationRateLimit:       10,
+		webAppBaseURL:             "https://webca.io",
+		notificationSender:        notification.LogSender{},
+		notificationEncryptionKey: "secret-notification-encryption-key",
+		notificationRetryDelay:    time.Millisecond,
+		notificationAttempts:      3,
+		expiryWarningPeriod:       30 * 24 * time.Hour,
 	}
 
 	db := dbutil.MustConnect(cfg.db)
@@ -117,7 +140,8 @@ func createTestEnv() (*env, context.Context) {
 	}
 
 	policy := password.Policy{SaltLength: 32, MinLength: 16}
-	passwordSvc, err := password.NewService("secret-password-encryption-key", policy)
+	encryptionKey := "secret-password-encryption-key"
+	passwordSvc, err := password.NewService(encryptionKey, policy)
 	if err != nil {
 		log.Fatal("failed create password.Servicie", zap.Error(err))
 	}
@@ -125,37 +149,109 @@ func createTestEnv() (*env, context.Context) {
 	auditRepo := repository.NewAuditEventRepository(db)
 	auditLog := audit.NewLogger("webca:api-server", auditRepo)
 
+	accountRepo := repository.NewAccountRepository(db)
 	userRepo := repository.NewUserRepository(db)
 	authService := authorization.NewService(userRepo)
 
+	certRepo := repository.NewCertificateRepository(db)
+	issuingKeyRepo := repository.NewIssuingKeyRepository(db)
+	profileRepo := repository.NewCertificateProfileRepository(db)
+	keyPairRepo := repository.NewKeyPairRepository(db)
+	crlRepo := repository.NewRevocationListRepository(db)
+	crlService := &service.RevocationListService{
+		CertRepo:        certRepo,
+		KeyPairRepo:     keyPairRepo,
+		CRLRepo:         crlRepo,
+		KeyWrappingKey:  []byte(cfg.keyWrappingKey),
+		ExternalBaseURL: cfg.externalBaseURL,
+		Validity:        cfg.crlValidity,
+	}
+
+	ocspService := &service.OCSPService{
+		CertRepo:        certRepo,
+		ResponderRepo:   repository.NewOCSPResponderRepository(db),
+		KeyWrappingKey:  []byte(cfg.keyWrappingKey),
+		ExternalBaseURL: cfg.externalBaseURL,
+		Validity:        cfg.ocspValidity,
+	}
+
+	notificationService := &service.NotificationService{
+		Sender:           cfg.notificationSender,
+		NotificationRepo: repository.NewNotificationRepository(db),
+		EncryptionKey:    []byte(cfg.notificationEncryptionKey),
+		MaxAttempts:      cfg.notificationAttempts,
+		RetryDelay:       cfg.notificationRetryDelay,
+	}
+
+	certificateService := &service.CertificateService{
+		AuditLog:            auditLog,
+		CertRepo:            certRepo,
+		KeyPairRepo:         keyPairRepo,
+		UserRepo:            userRepo,
+		AccountRepo:         accountRepo,
+		ProfileRepo:         profileRepo,
+		PendingRepo:         repository.NewPendingCertificateRepository(db),
+		IssuingKeyRepo:      issuingKeyRepo,
+		KeyWrappingKey:      []byte(cfg.keyWrappingKey),
+		PasswordService:     passwordSvc,
+		AuthService:         authService,
+		CRLService:          crlService,
+		OCSPService:         ocspService,
+		Notifier:            notificationService,
+		ExpiryWarningPeriod: cfg.expiryWarningPeriod,
+	}
+
+	acmeService := &service.ACMEService{
+		AuditLog:           auditLog,
+		ACMERepo:           repository.NewACMERepository(db),
+		CertRepo:           certRepo,
+		IssuingKeyRepo:     issuingKeyRepo,
+		CertificateService: certificateService,
+		ExternalBaseURL:    cfg.externalBaseURL,
+		HTTPPort:           cfg.acmeHTTPPort,
+		Resolver:           net.DefaultResolver,
+		AccountLimiter:     ratelimit.NewLimiter(cfg.acmeAccountRateLimit, time.Hour),
+		OrderLimiter:       ratelimit.NewLimiter(cfg.acmeOrderRateLimit, time.Hour),
+	}
+
 	e := &env{
 		cfg: cfg,
 		db:  db,
 		accountService: &service.AccountService{
 			JwtIssuer:       jwt.NewIssuer(cfg.jwtCredentials),
 			AuditLog:        auditLog,
-			AccountRepo:     repository.NewAccountRepository(db),
-			UserRepo:        userRepo,
-			PasswordService: passwordSvc,
-		},
-		certificateService: &service.CertificateService{
-			AuditLog:        auditLog,
-			CertRepo:        repository.NewCertificateRepository(db),
-			KeyPairRepo:     repository.NewKeyPairRepository(db),
+			AccountRepo:     accountRepo,
 			UserRepo:        userRepo,
 			PasswordService: passwordSvc,
 			AuthService:     authService,
 		},
+		certificateService: certificateService,
+		profileService: &service.CertificateProfileService{
+			AuditLog:    auditLog,
+			ProfileRepo: profileRepo,
+			UserRepo:    userRepo,
+			AuthService: authService,
+		},
+		crlService:  crlService,
+		ocspService: ocspService,
+		acmeService: acmeService,
 		userService: &service.UserService{
 			AuditLog:    auditLog,
 			UserRepo:    userRepo,
 			AuthService: authService,
 		},
+		invitationLimiter: ratelimit.NewLimiter(cfg.invitationRateLimit, time.Minute),
 		invitationService: &service.InvitationService{
-			AuditLog:       auditLog,
-			InvitationRepo: repository.NewInvitationRepository(db),
-			UserRepo:       userRepo,
+			JwtIssuer:       jwt.NewIssuer(cfg.jwtCredentials),
+			AuditLog:        auditLog,
+			InvitationRepo:  repository.NewInvitationRepository(db),
+			UserRepo:        userRepo,
+			PasswordService: passwordSvc,
+			AuthService:     authService,
+			Notifier:        notificationService,
+			AppBaseURL:      cfg.webAppBaseURL,
 		},
+		notificationService: notificationService,
 	}
 
 	return e, context.Background()
@@ -197,6 +293,18 @@ func createUnauthenticatedTestRequest(route, method string, body interface{}) *h
 	return req
 }
 
+// useTestSMTPServer makes the environment deliver notifications to an in-process SMTP server.
+func useTestSMTPServer(e *env) *smtptest.Server {
+	srv := smtptest.NewServer()
+	e.notificationService.Sender = &notification.SMTPSender{
+		Host: srv.Host,
+		Port: srv.Port,
+		From: "WebCA <no-reply@webca.io>",
+	}
+
+	return srv
+}
+
 func getTestJWTCredentials() jwt.Credentials {
 	return jwt.Credentials{
 		Issuer: "test",
@@ -2,7 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"io"
+	"net"
+	"time"
 
 	"github.com/CzarSimon/httputil"
 	"github.com/CzarSimon/httputil/dbutil"
@@ -10,6 +13,7 @@ import (
 	"github.com/CzarSimon/webca/api-server/internal/audit"
 	"github.com/CzarSimon/webca/api-server/internal/authorization"
 	"github.com/CzarSimon/webca/api-server/internal/password"
+	"github.com/CzarSimon/webca/api-server/internal/ratelimit"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
 	"github.com/CzarSimon/webca/api-server/internal/service"
 	"github.com/gin-gonic/gin"
@@ -19,13 +23,19 @@ import (
 )
 
 type env struct {
-	cfg                config
-	db                 *sql.DB
-	accountService     *service.AccountService
-	certificateService *service.CertificateService
-	userService        *service.UserService
-	invitationService  *service.InvitationService
-	traceCloser        io.Closer
+	cfg                 config
+	db                  *sql.DB
+	accountService      *service.AccountService
+	certificateService  *service.CertificateService
+	profileService      *service.CertificateProfileService
+	crlService          *service.RevocationListService
+	ocspService         *service.OCSPService
+	acmeService         *service.ACMEService
+	userService         *service.UserService
+	invitationService   *service.InvitationService
+	invitationLimiter   *ratelimit.Limiter
+	notificationService *service.NotificationService
+	traceCloser         io.Closer
 }
 
 func (e *env) checkHealth() error {
@@ -70,7 +80,8 @@ func setupEnv() *env {
 		log.Fatal("failed to apply database migrations", zap.Error(err))
 	}
 
-	passwordSvc, err := password.NewService(mustReadSecretFromFile("PASSWORD_ENCRYPTION_KEY_FILE"), cfg.passwordPolicy)
+	encryptionKey := mustReadSecretFromFile("PASSWORD_ENCRYPTION_KEY_FILE")
+	passwordSvc, err := password.NewService(encryptionKey, cfg.passwordPolicy)
 	if err != nil {
 		log.Fatal("failed create password.Service", zap.Error(err))
 	}
@@ -78,41 +89,127 @@ func setupEnv() *env {
 	auditRepo := repository.NewAuditEventRepository(db)
 	auditLog := audit.NewLogger("webca:api-server", auditRepo)
 
+	accountRepo := repository.NewAccountRepository(db)
 	userRepo := repository.NewUserRepository(db)
 	authService := authorization.NewService(userRepo)
 
+	certRepo := repository.NewCertificateRepository(db)
+	issuingKeyRepo := repository.NewIssuingKeyRepository(db)
+	profileRepo := repository.NewCertificateProfileRepository(db)
+	keyPairRepo := repository.NewKeyPairRepository(db)
+	crlRepo := repository.NewRevocationListRepository(db)
+	crlService := &service.RevocationListService{
+		CertRepo:        certRepo,
+		KeyPairRepo:     keyPairRepo,
+		CRLRepo:         crlRepo,
+		KeyWrappingKey:  []byte(cfg.keyWrappingKey),
+		ExternalBaseURL: cfg.externalBaseURL,
+		Validity:        cfg.crlValidity,
+	}
+
+	ocspService := &service.OCSPService{
+		CertRepo:        certRepo,
+		ResponderRepo:   repository.NewOCSPResponderRepository(db),
+		KeyWrappingKey:  []byte(cfg.keyWrappingKey),
+		ExternalBaseURL: cfg.externalBaseURL,
+		Validity:        cfg.ocspValidity,
+	}
+
+	notificationService := &service.NotificationService{
+		Sender:           cfg.notificationSender,
+		NotificationRepo: repository.NewNotificationRepository(db),
+		EncryptionKey:    []byte(cfg.notificationEncryptionKey),
+		MaxAttempts:      cfg.notificationAttempts,
+		RetryDelay:       cfg.notificationRetryDelay,
+	}
+
+	certificateService := &service.CertificateService{
+		AuditLog:            auditLog,
+		CertRepo:            certRepo,
+		KeyPairRepo:         keyPairRepo,
+		UserRepo:            userRepo,
+		AccountRepo:         accountRepo,
+		ProfileRepo:         profileRepo,
+		PendingRepo:         repository.NewPendingCertificateRepository(db),
+		IssuingKeyRepo:      issuingKeyRepo,
+		KeyWrappingKey:      []byte(cfg.keyWrappingKey),
+		PasswordService:     passwordSvc,
+		AuthService:         authService,
+		CRLService:          crlService,
+		OCSPService:         ocspService,
+		Notifier:            notificationService,
+		ExpiryWarningPeriod: cfg.expiryWarningPeriod,
+	}
+
+	acmeService := &service.ACMEService{
+		AuditLog:           auditLog,
+		ACMERepo:           repository.NewACMERepository(db),
+		CertRepo:           certRepo,
+		IssuingKeyRepo:     issuingKeyRepo,
+		CertificateService: certificateService,
+		ExternalBaseURL:    cfg.externalBaseURL,
+		HTTPPort:           cfg.acmeHTTPPort,
+		Resolver:           net.DefaultResolver,
+		AccountLimiter:     ratelimit.NewLimiter(cfg.acmeAccountRateLimit, time.Hour),
+		OrderLimiter:       ratelimit.NewLimiter(cfg.acmeOrderRateLimit, time.Hour),
+	}
+
 	return &env{
 		cfg: cfg,
 		db:  db,
 		accountService: &service.AccountService{
 			JwtIssuer:       jwt.NewIssuer(cfg.jwtCredentials),
 			AuditLog:        auditLog,
-			AccountRepo:     repository.NewAccountRepository(db),
-			UserRepo:        userRepo,
-			PasswordService: passwordSvc,
-		},
-		certificateService: &service.CertificateService{
-			AuditLog:        auditLog,
-			CertRepo:        repository.NewCertificateRepository(db),
-			KeyPairRepo:     repository.NewKeyPairRepository(db),
+			AccountRepo:     accountRepo,
 			UserRepo:        userRepo,
 			PasswordService: passwordSvc,
 			AuthService:     authService,
 		},
+		certificateService: certificateService,
+		profileService: &service.CertificateProfileService{
+			AuditLog:    auditLog,
+			ProfileRepo: profileRepo,
+			UserRepo:    userRepo,
+			AuthService: authService,
+		},
+		crlService:  crlService,
+		ocspService: ocspService,
+		acmeService: acmeService,
 		userService: &service.UserService{
 			AuditLog:    auditLog,
 			UserRepo:    userRepo,
 			AuthService: authService,
 		},
+		invitationLimiter: ratelimit.NewLimiter(cfg.invitationRateLimit, time.Minute),
 		invitationService: &service.InvitationService{
-			AuditLog:       auditLog,
-			InvitationRepo: repository.NewInvitationRepository(db),
-			UserRepo:       userRepo,
+			JwtIssuer:       jwt.NewIssuer(cfg.jwtCredentials),
+			AuditLog:        auditLog,
+			InvitationRepo:  repository.NewInvitationRepository(db),
+			UserRepo:        userRepo,
+			PasswordService: passwordSvc,
+			AuthService:     authService,
+			Notifier:        notificationService,
+			AppBaseURL:      cfg.webAppBaseURL,
 		},
-		traceCloser: closer,
+		notificationService: notificationService,
+		traceCloser:         closer,
 	}
 }
 
 func notImplemented(c *gin.Context) {
 	c.Error(httputil.NotImplementedError(nil))
 }
+
+// rateLimit rejects requests from clients that have exceeded their allowance of the limiter.
+func rateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !limiter.Allow(c.ClientIP()) {
+			err := fmt.Errorf("client(ip=%s) exceeded the rate limit of %s", c.ClientIP(), c.FullPath())
+			c.Error(httputil.TooManyRequestsError(err))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
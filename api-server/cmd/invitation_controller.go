@@ -43,8 +43,7 @@ func (e *env) getInvitation(c *gin.Context) {
 	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "invitation_controller_get_invitation")
 	defer span.Finish()
 
-	inviteID := c.Param("id")
-	invite, err := e.invitationService.GetInvitation(ctx, inviteID)
+	invite, err := e.invitationService.GetInvitation(ctx, c.Param("token"))
 	if err != nil {
 		span.LogFields(tracelog.Error(err))
 		c.Error(err)
@@ -54,6 +53,97 @@ func (e *env) getInvitation(c *gin.Context) {
 	c.JSON(http.StatusOK, invite)
 }
 
+func (e *env) getInvitations(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "invitation_controller_get_invitations")
+	defer span.Finish()
+
+	filter, err := parseInvitationFilter(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	invites, err := e.invitationService.GetInvitations(ctx, principal, filter)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+func (e *env) revokeInvitation(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "invitation_controller_revoke_invitation")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	invite, err := e.invitationService.Revoke(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invite)
+}
+
+func (e *env) resendInvitation(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "invitation_controller_resend_invitation")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	invite, err := e.invitationService.Resend(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invite)
+}
+
+func (e *env) acceptInvitation(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "invitation_controller_accept_invitation")
+	defer span.Finish()
+
+	req, err := parseInvitaionAcceptanceRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	res, err := e.invitationService.Accept(ctx, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
 func parseInvitaionCreationRequest(c *gin.Context) (model.InvitationCreationRequest, error) {
 	var body model.InvitationCreationRequest
 	err := c.BindJSON(&body)
@@ -69,3 +159,39 @@ func parseInvitaionCreationRequest(c *gin.Context) (model.InvitationCreationRequ
 
 	return body, nil
 }
+
+func parseInvitaionAcceptanceRequest(c *gin.Context) (model.InvitationAcceptanceRequest, error) {
+	var body model.InvitationAcceptanceRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.InvitationAcceptanceRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.InvitationAcceptanceRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
+
+func parseInvitationFilter(c *gin.Context) (model.InvitationFilter, error) {
+	accountID, err := httputil.ParseQueryValue(c, "accountId")
+	if err != nil {
+		return model.InvitationFilter{}, err
+	}
+
+	statuses, _ := httputil.ParseQueryValues(c, "status")
+	filter := model.InvitationFilter{
+		AccountID: accountID,
+		Statuses:  statuses,
+	}
+
+	err = filter.Validate()
+	if err != nil {
+		return model.InvitationFilter{}, httputil.BadRequestError(err)
+	}
+
+	return filter, nil
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,6 +15,8 @@ import (
 	"github.com/CzarSimon/httputil/id"
 	"github.com/CzarSimon/httputil/jwt"
 	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
+	"github.com/CzarSimon/webca/api-server/internal/ratelimit"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
 	"github.com/CzarSimon/webca/api-server/internal/timeutil"
 	"github.com/stretchr/testify/assert"
@@ -19,6 +26,8 @@ func TestCreateInvitation(t *testing.T) {
 	assert := assert.New(t)
 	e, ctx := createTestEnv()
 	server := newServer(e)
+	smtpServer := useTestSMTPServer(e)
+	defer smtpServer.Close()
 
 	startTime := timeutil.Now()
 	account, admin, _ := createTestAccount(t, e)
@@ -43,11 +52,18 @@ func TestCreateInvitation(t *testing.T) {
 	assert.Equal(invite.CreatedAt.Add(24*time.Hour), invite.ValidTo)
 	assert.True(invite.CreatedAt.Before(timeutil.Now()))
 	assert.True(invite.CreatedAt.After(startTime))
+	assert.Len(invite.Token, 43)
+	assert.Empty(invite.TokenHash)
 
 	inviteRepo := repository.NewInvitationRepository(e.db)
 	stored, exists, err := inviteRepo.Find(ctx, invite.ID)
 	assert.NoError(err)
 	assert.True(exists)
+	assert.Empty(stored.Token)
+	assert.Equal(hashTestInvitationToken(invite.Token), stored.TokenHash)
+	assert.NotContains(stored.TokenHash, invite.Token)
+	stored.Token = invite.Token
+	stored.TokenHash = ""
 	assert.Equal(invite, stored)
 
 	auditRepo := repository.NewAuditEventRepository(e.db)
@@ -56,6 +72,28 @@ func TestCreateInvitation(t *testing.T) {
 	assert.Len(events, 1)
 	assert.Equal("CREATE", events[0].Activity)
 	assert.Equal(admin.ID, events[0].UserID)
+
+	// Notifications are only stored by the request and delivered from the outbox.
+	notifications := findTestNotifications(t, e, "new-user@webca.io")
+	assert.Len(notifications, 1)
+	assert.Equal(model.NotificationPending, notifications[0].Status)
+	assert.Equal(0, notifications[0].Attempts)
+	assert.Len(smtpServer.Messages(), 0)
+
+	e.notificationService.DeliverPending(ctx)
+	messages := smtpServer.Messages()
+	assert.Len(messages, 1)
+	assert.Equal([]string{"new-user@webca.io"}, messages[0].To)
+	assert.Contains(messages[0].Subject, account.Name)
+	assert.Contains(messages[0].Body, "https://webca.io/invitations/"+invite.Token)
+	assert.Contains(messages[0].Body, admin.Email)
+
+	notifications = findTestNotifications(t, e, "new-user@webca.io")
+	assert.Len(notifications, 1)
+	assert.Equal(model.NotificationSent, notifications[0].Status)
+	assert.Equal(notification.InvitationTemplate, notifications[0].Template)
+	assert.Equal(1, notifications[0].Attempts)
+	assert.Empty(notifications[0].Body)
 }
 
 func TestCreateInvitation_InvalidRole(t *testing.T) {
@@ -111,39 +149,575 @@ func TestGetInvitation(t *testing.T) {
 
 	startTime := timeutil.Now()
 	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.AdminRole, startTime.Add(24*time.Hour))
+
+	path := fmt.Sprintf("/v1/invitations/%s", invite.Token)
+	req := createUnauthenticatedTestRequest(path, http.MethodGet, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var rBody model.Invitation
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
+	assert.NoError(err)
+	assert.Empty(rBody.Token)
+	expected := invite
+	expected.Token = ""
+	expected.TokenHash = ""
+	assert.Equal(expected, rBody)
+
+	for _, key := range []string{invite.ID, invite.TokenHash, "unknown-token"} {
+		req = createUnauthenticatedTestRequest("/v1/invitations/"+key, http.MethodGet, nil)
+		res = performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusNotFound, res.Code)
+	}
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:invitation:%s", invite.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("READ", events[0].Activity)
+	assert.Equal("ANONYMOUS", events[0].UserID)
+}
+
+func TestGetInvitation_Expired(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(-time.Minute))
+
+	req := createUnauthenticatedTestRequest("/v1/invitations/"+invite.Token, http.MethodGet, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusGone, res.Code)
+}
+
+func TestGetInvitation_RateLimit(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	e.invitationLimiter = ratelimit.NewLimiter(3, time.Minute)
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(24*time.Hour))
+
+	for i := 0; i < 3; i++ {
+		req := createUnauthenticatedTestRequest("/v1/invitations/"+id.New(), http.MethodGet, nil)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusNotFound, res.Code)
+	}
+
+	req := createUnauthenticatedTestRequest("/v1/invitations/"+invite.Token, http.MethodGet, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	req = createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+
+	req = createTestRequest(fmt.Sprintf("/v1/invitations?accountId=%s", account.ID), http.MethodGet, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+}
+
+func TestGetInvitation_BadContentType(t *testing.T) {
+	path := fmt.Sprintf("/v1/invitations/%s", id.New())
+	testBadContentType(t, path, http.MethodGet, model.AdminRole)
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	startTime := timeutil.Now()
+	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.AdminRole, startTime.Add(24*time.Hour))
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	req := createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var rBody model.AuthenticationResponse
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
+	assert.NoError(err)
+
+	user, err := jwt.NewVerifier(e.cfg.jwtCredentials, 0).Verify(rBody.Token)
+	assert.NoError(err)
+	assert.Equal(rBody.User.ID, user.ID)
+	assert.True(user.HasRole(model.AdminRole))
+	assert.Equal(invite.Email, rBody.User.Email)
+	assert.Equal(account.ID, rBody.User.Account.ID)
+	assert.Empty(rBody.User.Credentials.Password)
+	assert.Empty(rBody.User.Credentials.Salt)
+
+	userRepo := repository.NewUserRepository(e.db)
+	storedUser, exists, err := userRepo.Find(ctx, user.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.AdminRole, storedUser.Role)
+	assert.Equal(account.ID, storedUser.Account.ID)
+	assert.NotEmpty(storedUser.Credentials.Password)
+	assert.NotEqual(body.Password, storedUser.Credentials.Password)
+
+	inviteRepo := repository.NewInvitationRepository(e.db)
+	storedInvite, exists, err := inviteRepo.Find(ctx, invite.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.InvitationAccepted, storedInvite.Status)
+	assert.Equal(storedUser.CreatedAt, storedInvite.AcceptedAt)
+	assert.True(storedInvite.AcceptedAt.After(startTime))
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:invitation:%s", invite.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("UPDATE", events[0].Activity)
+	assert.Equal(user.ID, events[0].UserID)
+
+	events, err = auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:user:%s", user.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("CREATE", events[0].Activity)
+	assert.Equal(user.ID, events[0].UserID)
+
+	loginBody := model.AuthenticationRequest{
+		AccountName: account.Name,
+		Email:       invite.Email,
+		Password:    body.Password,
+	}
+	req = createUnauthenticatedTestRequest("/v1/login", http.MethodPost, loginBody)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+}
+
+func TestAcceptInvitation_AlreadyAccepted(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(24*time.Hour))
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	req := createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	body.Password = "9c1d0b8a7e6f5d4c3b2a19f8e7d6c5b4"
+	req = createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	req = createUnauthenticatedTestRequest("/v1/invitations/"+invite.Token, http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	userRepo := repository.NewUserRepository(e.db)
+	user, exists, err := userRepo.FindByAccountNameAndEmail(ctx, account.Name, invite.Email)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.UserRole, user.Role)
+
+	inviteRepo := repository.NewInvitationRepository(e.db)
+	stored, exists, err := inviteRepo.Find(ctx, invite.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.InvitationAccepted, stored.Status)
+	assert.Empty(stored.TokenHash)
+}
+
+func TestAcceptInvitation_Expired(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(-time.Minute))
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	req := createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusGone, res.Code)
+
+	userRepo := repository.NewUserRepository(e.db)
+	_, exists, err := userRepo.FindByAccountNameAndEmail(ctx, account.Name, invite.Email)
+	assert.NoError(err)
+	assert.False(exists)
+
 	inviteRepo := repository.NewInvitationRepository(e.db)
-	invite := model.Invitation{
+	storedInvite, exists, err := inviteRepo.Find(ctx, invite.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.InvitationCreated, storedInvite.Status)
+	assert.True(storedInvite.AcceptedAt.IsZero())
+}
+
+func TestAcceptInvitation_ExistingUser(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, user := createTestAccount(t, e)
+	invite := saveTestInvitation(t, e, model.Invitation{
 		ID:          id.New(),
-		Email:       "new-user@webca.io",
+		Email:       user.Email,
 		Role:        model.AdminRole,
 		Status:      model.InvitationCreated,
 		CreatedByID: admin.ID,
 		Account:     account,
-		CreatedAt:   startTime,
-		ValidTo:     startTime.Add(24 * time.Hour),
+		CreatedAt:   timeutil.Now(),
+		ValidTo:     timeutil.Now().Add(24 * time.Hour),
+	})
+	inviteRepo := repository.NewInvitationRepository(e.db)
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
 	}
-	err := inviteRepo.Save(ctx, invite)
+	req := createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	storedInvite, exists, err := inviteRepo.Find(ctx, invite.ID)
 	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.InvitationCreated, storedInvite.Status)
+}
 
+func TestAcceptInvitation_BadRequest(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(24*time.Hour))
+
+	cases := []struct {
+		body model.InvitationAcceptanceRequest
+		code int
+	}{
+		{body: model.InvitationAcceptanceRequest{Token: invite.Token}, code: http.StatusBadRequest},
+		{body: model.InvitationAcceptanceRequest{Password: "a5f3feccb16822dcfaa50c9fba91cab3"}, code: http.StatusBadRequest},
+		{body: model.InvitationAcceptanceRequest{Token: invite.Token, Password: "short"}, code: http.StatusBadRequest},
+		{body: model.InvitationAcceptanceRequest{Token: invite.ID, Password: "a5f3feccb16822dcfaa50c9fba91cab3"}, code: http.StatusNotFound},
+		{body: model.InvitationAcceptanceRequest{Token: id.New(), Password: "a5f3feccb16822dcfaa50c9fba91cab3"}, code: http.StatusNotFound},
+	}
+
+	for i, tc := range cases {
+		req := createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, tc.body)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(tc.code, res.Code, fmt.Sprintf("Test case %d failed", i))
+	}
+}
+
+func TestAcceptInvitation_BadContentType(t *testing.T) {
+	testBadContentType(t, "/v1/invitations", http.MethodPut, jwt.AnonymousRole)
+}
+
+func createTestInvitation(t *testing.T, e *env, admin model.User, account model.Account, role string, validTo time.Time) model.Invitation {
+	return saveTestInvitation(t, e, model.Invitation{
+		ID:          id.New(),
+		Email:       "new-user@webca.io",
+		Role:        role,
+		Status:      model.InvitationCreated,
+		CreatedByID: admin.ID,
+		Account:     account,
+		CreatedAt:   timeutil.Now(),
+		ValidTo:     validTo,
+	})
+}
+
+func saveTestInvitation(t *testing.T, e *env, invite model.Invitation) model.Invitation {
+	assert := assert.New(t)
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	assert.NoError(err)
+	invite.Token = base64.RawURLEncoding.EncodeToString(b)
+	invite.TokenHash = hashTestInvitationToken(invite.Token)
+
+	err = repository.NewInvitationRepository(e.db).Save(context.Background(), invite)
+	assert.NoError(err)
+
+	return invite
+}
+
+func hashTestInvitationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestCreateInvitation_Lifetime(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+
+	body := model.InvitationCreationRequest{
+		Email:         "new-user@webca.io",
+		Role:          model.UserRole,
+		LifetimeHours: 72,
+	}
+	req := createTestRequest("/v1/invitations", http.MethodPost, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var invite model.Invitation
+	err := json.NewDecoder(res.Result().Body).Decode(&invite)
+	assert.NoError(err)
+	assert.Equal(invite.CreatedAt.Add(72*time.Hour), invite.ValidTo)
+
+	account.InvitationLifetimeHours = 48
+	err = repository.NewAccountRepository(e.db).Update(ctx, account)
+	assert.NoError(err)
+
+	body.LifetimeHours = 0
+	req = createTestRequest("/v1/invitations", http.MethodPost, admin.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	err = json.NewDecoder(res.Result().Body).Decode(&invite)
+	assert.NoError(err)
+	assert.Equal(invite.CreatedAt.Add(48*time.Hour), invite.ValidTo)
+
+	for _, hours := range []int{-1, model.MaxInvitationLifetimeHours + 1} {
+		body.LifetimeHours = hours
+		req = createTestRequest("/v1/invitations", http.MethodPost, admin.JWTUser(), body)
+		res = performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestGetInvitations(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	otherAccount, otherAdmin, _ := createTestAccount(t, e)
+	validTo := timeutil.Now().Add(24 * time.Hour)
+
+	pending := createTestInvitation(t, e, admin, account, model.UserRole, validTo)
+	revoked := createTestInvitation(t, e, admin, account, model.UserRole, validTo)
+	accepted := createTestInvitation(t, e, admin, account, model.AdminRole, validTo)
+	createTestInvitation(t, e, otherAdmin, otherAccount, model.UserRole, validTo)
+
+	inviteRepo := repository.NewInvitationRepository(e.db)
+	revoked.Status = model.InvitationRevoked
+	updated, err := inviteRepo.Update(ctx, revoked, model.InvitationCreated)
+	assert.NoError(err)
+	assert.True(updated)
+	accepted.Status = model.InvitationAccepted
+	updated, err = inviteRepo.Update(ctx, accepted, model.InvitationCreated)
+	assert.NoError(err)
+	assert.True(updated)
+
+	cases := []struct {
+		query string
+		ids   []string
+	}{
+		{query: "", ids: []string{pending.ID, revoked.ID, accepted.ID}},
+		{query: "&status=CREATED", ids: []string{pending.ID}},
+		{query: "&status=CREATED&status=REVOKED", ids: []string{pending.ID, revoked.ID}},
+		{query: "&status=ACCEPTED", ids: []string{accepted.ID}},
+	}
+
+	for i, tc := range cases {
+		path := fmt.Sprintf("/v1/invitations?accountId=%s%s", account.ID, tc.query)
+		req := createTestRequest(path, http.MethodGet, admin.JWTUser(), nil)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(http.StatusOK, res.Code, fmt.Sprintf("Test case %d failed", i))
+
+		var invites []model.Invitation
+		err = json.NewDecoder(res.Result().Body).Decode(&invites)
+		assert.NoError(err)
+		ids := make([]string, 0, len(invites))
+		for _, invite := range invites {
+			assert.Equal(account.ID, invite.Account.ID)
+			ids = append(ids, invite.ID)
+		}
+		assert.ElementsMatch(tc.ids, ids, fmt.Sprintf("Test case %d failed", i))
+	}
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:invitation:%s", pending.ID))
+	assert.NoError(err)
+	assert.Len(events, 3)
+	for _, event := range events {
+		assert.Equal("READ", event.Activity)
+		assert.Equal(admin.ID, event.UserID)
+	}
+
+	path := fmt.Sprintf("/v1/invitations?accountId=%s&status=EXPIRED", account.ID)
+	req := createTestRequest(path, http.MethodGet, admin.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest("/v1/invitations", http.MethodGet, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	path = fmt.Sprintf("/v1/invitations?accountId=%s", account.ID)
+	req = createTestRequest(path, http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, _ := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(24*time.Hour))
 	path := fmt.Sprintf("/v1/invitations/%s", invite.ID)
-	req := createUnauthenticatedTestRequest(path, http.MethodGet, nil)
+
+	req := createTestRequest(path, http.MethodDelete, otherAdmin.JWTUser(), nil)
 	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(path, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
 	assert.Equal(http.StatusOK, res.Code)
+	var rBody model.Invitation
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
+	assert.NoError(err)
+	assert.Equal(invite.ID, rBody.ID)
+	assert.Equal(model.InvitationRevoked, rBody.Status)
+
+	inviteRepo := repository.NewInvitationRepository(e.db)
+	stored, exists, err := inviteRepo.Find(ctx, invite.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.InvitationRevoked, stored.Status)
+	assert.Empty(stored.TokenHash)
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:invitation:%s", invite.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("DELETE", events[0].Activity)
+	assert.Equal(admin.ID, events[0].UserID)
+
+	req = createTestRequest(path, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	req = createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
 
+	req = createUnauthenticatedTestRequest("/v1/invitations/"+invite.Token, http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	req = createTestRequest(fmt.Sprintf("/v1/invitations/%s", id.New()), http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestResendInvitation(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	smtpServer := useTestSMTPServer(e)
+	defer smtpServer.Close()
+
+	account, admin, _ := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+	invite := createTestInvitation(t, e, admin, account, model.UserRole, timeutil.Now().Add(-time.Hour))
+	path := fmt.Sprintf("/v1/invitations/%s/resend", invite.ID)
+
+	req := createTestRequest(path, http.MethodPost, otherAdmin.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	startTime := timeutil.Now()
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
 	var rBody model.Invitation
-	err = json.NewDecoder(res.Result().Body).Decode(&rBody)
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
 	assert.NoError(err)
-	assert.Equal(invite, rBody)
+	assert.Equal(model.InvitationCreated, rBody.Status)
+	assert.False(rBody.ValidTo.Before(startTime.Add(24 * time.Hour)))
+	assert.True(rBody.ValidTo.Before(timeutil.Now().Add(24 * time.Hour)))
+	assert.Len(rBody.Token, 43)
+	assert.NotEqual(invite.Token, rBody.Token)
+
+	inviteRepo := repository.NewInvitationRepository(e.db)
+	stored, exists, err := inviteRepo.Find(ctx, invite.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(rBody.ValidTo, stored.ValidTo)
+	assert.Equal(hashTestInvitationToken(rBody.Token), stored.TokenHash)
+
+	e.notificationService.DeliverPending(ctx)
+	messages := smtpServer.Messages()
+	assert.Len(messages, 1)
+	assert.Equal([]string{invite.Email}, messages[0].To)
+	assert.Contains(messages[0].Body, "https://webca.io/invitations/"+rBody.Token)
+	assert.NotContains(messages[0].Body, invite.Token)
 
 	auditRepo := repository.NewAuditEventRepository(e.db)
 	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:invitation:%s", invite.ID))
 	assert.NoError(err)
 	assert.Len(events, 1)
-	assert.Equal("READ", events[0].Activity)
-	assert.Equal("ANONYMOUS", events[0].UserID)
+	assert.Equal("UPDATE", events[0].Activity)
+	assert.Equal(admin.ID, events[0].UserID)
+
+	body := model.InvitationAcceptanceRequest{
+		Token:    invite.Token,
+		Password: "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	req = createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	body.Token = rBody.Token
+	req = createUnauthenticatedTestRequest("/v1/invitations", http.MethodPut, body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
 }
 
-func TestGetInvitation_BadContentType(t *testing.T) {
-	path := fmt.Sprintf("/v1/invitations/%s", id.New())
-	testBadContentType(t, path, http.MethodGet, model.AdminRole)
+func TestInvitationManagement_UnauthorizedAndForbidden(t *testing.T) {
+	routes := []struct {
+		path   string
+		method string
+	}{
+		{path: "/v1/invitations?accountId=some-id", method: http.MethodGet},
+		{path: "/v1/invitations/some-id", method: http.MethodDelete},
+		{path: "/v1/invitations/some-id/resend", method: http.MethodPost},
+	}
+
+	for _, route := range routes {
+		testUnauthorized(t, route.path, route.method)
+		testForbidden(t, route.path, route.method, []string{
+			jwt.AnonymousRole,
+			model.UserRole,
+		})
+	}
 }
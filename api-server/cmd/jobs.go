@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	acmeNonceCleanupRate     = time.Hour
+	notificationDeliveryRate = 30 * time.Second
+	expiryWarningRate        = time.Hour
+)
+
+// runRevocationListUpdates regenerates the revocation lists of enrolled certificate authorities
+// at half their validity, so that relying parties never see an outdated list.
+func runRevocationListUpdates(e *env) {
+	ticker := time.NewTicker(e.cfg.crlValidity / 2)
+	defer ticker.Stop()
+
+	for {
+		e.crlService.GenerateAll(context.Background())
+		<-ticker.C
+	}
+}
+
+func runACMENonceCleanup(e *env) {
+	ticker := time.NewTicker(acmeNonceCleanupRate)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		err := e.acmeService.DeleteExpiredNonces(context.Background())
+		if err != nil {
+			log.Error("failed to delete expired acme nonces", zap.Error(err))
+		}
+	}
+}
+
+// runNotificationDelivery delivers due notifications from the outbox. Notifications are claimed
+// before they are sent, so the job can run in several replicas of the server.
+func runNotificationDelivery(e *env) {
+	ticker := time.NewTicker(notificationDeliveryRate)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		e.notificationService.DeliverPending(context.Background())
+	}
+}
+
+// runExpiryWarnings warns account admins about certificates that are about to expire.
+func runExpiryWarnings(e *env) {
+	ticker := time.NewTicker(expiryWarningRate)
+	defer ticker.Stop()
+
+	for {
+		e.certificateService.WarnExpiring(context.Background())
+		<-ticker.C
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationDelivery_Retry(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	smtpServer := useTestSMTPServer(e)
+	defer smtpServer.Close()
+
+	_, admin, _ := createTestAccount(t, e)
+
+	smtpServer.Reject(1)
+	body := model.InvitationCreationRequest{
+		Email: "new-user@webca.io",
+		Role:  model.UserRole,
+	}
+	req := createTestRequest("/v1/invitations", http.MethodPost, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	e.notificationService.DeliverPending(ctx)
+	assert.Len(smtpServer.Messages(), 0)
+
+	notifications := findTestNotifications(t, e, "new-user@webca.io")
+	assert.Len(notifications, 1)
+	failed := notifications[0]
+	assert.Equal(model.NotificationPending, failed.Status)
+	assert.Equal(1, failed.Attempts)
+	assert.Contains(failed.LastError, "451")
+	assert.NotEmpty(failed.Body)
+	assert.NotEmpty(failed.EncryptionSalt)
+	assert.NotContains(failed.Body, "invitations/")
+	assert.NotContains(failed.Body, admin.Email)
+	assert.True(failed.ClaimedUntil.IsZero())
+
+	time.Sleep(10 * time.Millisecond)
+	e.notificationService.DeliverPending(ctx)
+
+	messages := smtpServer.Messages()
+	assert.Len(messages, 1)
+	assert.Equal([]string{"new-user@webca.io"}, messages[0].To)
+
+	repo := repository.NewNotificationRepository(e.db)
+	delivered, exists, err := repo.Find(ctx, failed.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.NotificationSent, delivered.Status)
+	assert.Equal(2, delivered.Attempts)
+	assert.False(delivered.SentAt.IsZero())
+	assert.Empty(delivered.Body)
+	assert.Empty(delivered.EncryptionSalt)
+
+	e.notificationService.DeliverPending(ctx)
+	assert.Len(smtpServer.Messages(), 1)
+}
+
+func TestNotificationDelivery_MaxAttempts(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	smtpServer := useTestSMTPServer(e)
+	defer smtpServer.Close()
+
+	_, admin, _ := createTestAccount(t, e)
+
+	smtpServer.Reject(e.cfg.notificationAttempts)
+	body := model.InvitationCreationRequest{
+		Email: "new-user@webca.io",
+		Role:  model.UserRole,
+	}
+	req := createTestRequest("/v1/invitations", http.MethodPost, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	for i := 0; i < e.cfg.notificationAttempts; i++ {
+		time.Sleep(10 * time.Millisecond)
+		e.notificationService.DeliverPending(ctx)
+	}
+
+	notifications := findTestNotifications(t, e, "new-user@webca.io")
+	assert.Len(notifications, 1)
+	assert.Equal(model.NotificationFailed, notifications[0].Status)
+	assert.Equal(e.cfg.notificationAttempts, notifications[0].Attempts)
+	assert.NotEmpty(notifications[0].LastError)
+	assert.Empty(notifications[0].Body)
+
+	time.Sleep(10 * time.Millisecond)
+	e.notificationService.DeliverPending(ctx)
+	assert.Len(smtpServer.Messages(), 0)
+}
+
+func TestNotificationDelivery_Claims(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	smtpServer := useTestSMTPServer(e)
+	defer smtpServer.Close()
+
+	_, admin, _ := createTestAccount(t, e)
+	repo := repository.NewNotificationRepository(e.db)
+
+	body := model.InvitationCreationRequest{
+		Email: "new-user@webca.io",
+		Role:  model.UserRole,
+	}
+	req := createTestRequest("/v1/invitations", http.MethodPost, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(smtpServer.Messages(), 0)
+
+	notifications := findTestNotifications(t, e, "new-user@webca.io")
+	assert.Len(notifications, 1)
+	pending := notifications[0]
+	assert.Equal(model.NotificationPending, pending.Status)
+	assert.True(pending.ClaimedUntil.IsZero())
+
+	due, err := repo.FindDue(ctx, timeutil.Now(), 10)
+	assert.NoError(err)
+	assert.Len(due, 1)
+
+	claimed, err := repo.Claim(ctx, pending, timeutil.Now(), timeutil.Now().Add(50*time.Millisecond))
+	assert.NoError(err)
+	assert.True(claimed)
+	claimed, err = repo.Claim(ctx, pending, timeutil.Now(), timeutil.Now().Add(50*time.Millisecond))
+	assert.NoError(err)
+	assert.False(claimed)
+
+	e.notificationService.DeliverPending(ctx)
+	assert.Len(smtpServer.Messages(), 0)
+
+	// A claim that is not released, for example by a replica that stopped while sending, expires.
+	time.Sleep(60 * time.Millisecond)
+	e.notificationService.DeliverPending(ctx)
+	e.notificationService.DeliverPending(ctx)
+	assert.Len(smtpServer.Messages(), 1)
+
+	delivered, exists, err := repo.Find(ctx, pending.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.NotificationSent, delivered.Status)
+	assert.Equal(1, delivered.Attempts)
+}
+
+func TestExpiryWarnings(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	smtpServer := useTestSMTPServer(e)
+	defer smtpServer.Close()
+
+	account, admin, user := createTestAccount(t, e)
+	newTestRootCertificate := func(name string, expiresInDays int) model.Certificate {
+		return createTestCertificate(t, server, admin.JWTUser(), model.CertificateRequest{
+			Name: name,
+			Subject: model.CertificateSubject{
+				CommonName: name,
+			},
+			Type:      model.RootCAType,
+			Algorithm: "RSA",
+			Password:  "e4d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9",
+			Options: map[string]interface{}{
+				"keySize": 1024,
+			},
+			ExpiresInDays: expiresInDays,
+		})
+	}
+
+	expiring := newTestRootCertificate("expiring-ca", 10)
+	newTestRootCertificate("long-lived-ca", 365)
+	revoked := newTestRootCertificate("revoked-ca", 10)
+
+	body := model.RevocationRequest{
+		Reason: model.ReasonKeyCompromise,
+	}
+	path := fmt.Sprintf("/v1/certificates/%s/revocation", revoked.ID)
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	e.certificateService.WarnExpiring(ctx)
+
+	notifications := findTestNotifications(t, e, admin.Email)
+	assert.Len(notifications, 1)
+	assert.Equal(notification.ExpiryWarningTemplate, notifications[0].Template)
+	assert.Equal(model.NotificationPending, notifications[0].Status)
+	assert.Len(findTestNotifications(t, e, user.Email), 0)
+
+	// Each certificate is only warned about once.
+	e.certificateService.WarnExpiring(ctx)
+	assert.Len(findTestNotifications(t, e, admin.Email), 1)
+
+	e.notificationService.DeliverPending(ctx)
+	messages := smtpServer.Messages()
+	assert.Len(messages, 1)
+	assert.Equal([]string{admin.Email}, messages[0].To)
+	assert.Equal(fmt.Sprintf("Certificate %s expires %s", expiring.Name, expiring.ExpiresAt.UTC().Format("2006-01-02")), messages[0].Subject)
+	assert.Contains(messages[0].Body, account.Name)
+	assert.Contains(messages[0].Body, expiring.SerialNumber)
+}
+
+const findTestNotificationIDsQuery = `SELECT id FROM notification WHERE recipient = ? ORDER BY created_at`
+
+func findTestNotifications(t *testing.T, e *env, recipient string) []model.Notification {
+	ctx := context.Background()
+	rows, err := e.db.QueryContext(ctx, findTestNotificationIDsQuery, recipient)
+	assert.NoError(t, err)
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		assert.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+
+	repo := repository.NewNotificationRepository(e.db)
+	notifications := make([]model.Notification, 0, len(ids))
+	for _, id := range ids {
+		n, exists, err := repo.Find(ctx, id)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		notifications = append(notifications, n)
+	}
+
+	return notifications
+}
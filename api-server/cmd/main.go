@@ -18,6 +18,10 @@ func main() {
 	defer e.close()
 
 	server := newServer(e)
+	go runRevocationListUpdates(e)
+	go runACMENonceCleanup(e)
+	go runNotificationDelivery(e)
+	go runExpiryWarnings(e)
 	log.Info("Started api-server listening on port: " + e.cfg.port)
 
 	err := server.ListenAndServe()
@@ -28,6 +32,22 @@ func main() {
 
 func newServer(e *env) *http.Server {
 	r := httputil.NewRouter("api-server", e.checkHealth)
+	// Revocation lists and OCSP responses are fetched by relying parties that do not send JSON
+	// and ACME clients send JWS messages, so the routes are registered before the content type restriction is applied.
+	r.GET("/v1/ca/:id/crl", e.getRevocationList)
+	r.GET("/v1/ocsp/*request", e.getOCSPResponse)
+	r.POST("/v1/ocsp", e.postOCSPResponse)
+	r.GET("/v1/acme/:id/directory", e.getACMEDirectory)
+	r.HEAD("/v1/acme/:id/new-nonce", e.getACMENonce)
+	r.GET("/v1/acme/:id/new-nonce", e.getACMENonce)
+	r.POST("/v1/acme/:id/new-account", e.newACMEAccount)
+	r.POST("/v1/acme/:id/account/:resourceId", e.updateACMEAccount)
+	r.POST("/v1/acme/:id/new-order", e.newACMEOrder)
+	r.POST("/v1/acme/:id/order/:resourceId", e.getACMEOrder)
+	r.POST("/v1/acme/:id/order/:resourceId/finalize", e.finalizeACMEOrder)
+	r.POST("/v1/acme/:id/authz/:resourceId", e.getACMEAuthorization)
+	r.POST("/v1/acme/:id/challenge/:resourceId", e.respondACMEChallenge)
+	r.POST("/v1/acme/:id/certificate/:resourceId", e.getACMECertificate)
 	r.Use(httputil.AllowJSON())
 
 	rbac := httputil.NewRBAC(e.cfg.jwtCredentials)
@@ -36,18 +56,47 @@ func newServer(e *env) *http.Server {
 
 	r.POST("/v1/signup", e.signup)
 	r.POST("/v1/login", e.login)
-	r.GET("/v1/invitations/:id", e.getInvitation)
-	// r.PUT("/v1/invitations", e.acceptInvitation)
+	r.GET("/v1/invitations/:token", rateLimit(e.invitationLimiter), e.getInvitation)
+	r.PUT("/v1/invitations", rateLimit(e.invitationLimiter), e.acceptInvitation)
 
 	secured.POST("/v1/certificates", e.createCertificate)
+	secured.POST("/v1/certificates/csr", e.signCertificateRequest)
 	secured.GET("/v1/certificates", e.getCertificates)
 	secured.GET("/v1/certificates/:id", e.getCertificate)
 	secured.GET("/v1/certificates/:id/body", e.getCertificateBody)
+	secured.POST("/v1/certificates/:id/renewal", e.renewCertificate)
 	secured.GET("/v1/certificate-options", e.getCertificateOptions)
+	secured.GET("/v1/certificate-profiles", e.getCertificateProfiles)
+	secured.GET("/v1/certificate-profiles/:id", e.getCertificateProfile)
+	secured.POST("/v1/pending-certificates", e.createPendingCertificate)
+	secured.GET("/v1/pending-certificates", e.getPendingCertificates)
+	secured.GET("/v1/pending-certificates/:id", e.getPendingCertificate)
+	secured.POST("/v1/pending-certificates/:id/activation", e.activatePendingCertificate)
 	secured.GET("/v1/users/:id", e.getUser)
+	secured.GET("/v1/accounts/:id", e.getAccount)
 
 	admin.GET("/v1/certificates/:id/private-key", e.getCertificatePrivateKey)
+	admin.GET("/v1/certificates/:id/pkcs12", e.getCertificatePKCS12)
+	admin.POST("/v1/certificates/import", e.importCertificate)
+	admin.POST("/v1/certificates/:id/revocation", e.revokeCertificate)
+	admin.POST("/v1/certificates/:id/crl-signing", e.enableRevocationList)
+	admin.DELETE("/v1/certificates/:id/crl-signing", e.disableRevocationList)
+	admin.POST("/v1/certificates/:id/ocsp-responder", e.issueOCSPResponder)
+	admin.POST("/v1/certificates/:id/unattended-issuance", e.enableUnattendedIssuance)
+	admin.DELETE("/v1/certificates/:id/unattended-issuance", e.disableUnattendedIssuance)
+	admin.POST("/v1/certificates/:id/acme", e.enableACME)
+	admin.DELETE("/v1/certificates/:id/acme", e.disableACME)
+	admin.GET("/v1/invitations", e.getInvitations)
 	admin.POST("/v1/invitations", e.createInvitation)
+	admin.DELETE("/v1/invitations/:id", e.revokeInvitation)
+	admin.POST("/v1/invitations/:id/resend", e.resendInvitation)
+	admin.POST("/v1/certificate-profiles", e.createCertificateProfile)
+	admin.PUT("/v1/certificate-profiles/:id", e.updateCertificateProfile)
+	admin.DELETE("/v1/certificate-profiles/:id", e.deleteCertificateProfile)
+	admin.PUT("/v1/accounts/:id/policy", e.updateAccountPolicy)
+	admin.GET("/v1/accounts/:id/users", e.getAccountUsers)
+	admin.PUT("/v1/users/:id/role", e.updateUserRole)
+	admin.POST("/v1/users/:id/deactivation", e.deactivateUser)
 
 	return &http.Server{
 		Addr:    ":" + e.cfg.port,
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	tracelog "github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	ocspRequestType  = "application/ocsp-request"
+	ocspResponseType = "application/ocsp-response"
+	maxOCSPRequest   = 10 * 1024
+)
+
+// getOCSPResponse handles OCSP requests sent with the GET method defined in RFC 6960 appendix A.1,
+// where the DER encoded request is base64 encoded and appended to the responder url.
+func (e *env) getOCSPResponse(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "ocsp_controller_get_ocsp_response")
+	defer span.Finish()
+
+	der, err := parseOCSPRequestPath(c.Param("request"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	res := e.ocspService.Respond(ctx, der)
+	maxAge := int(time.Until(res.NextUpdate).Seconds())
+	if maxAge > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	}
+
+	c.Data(http.StatusOK, ocspResponseType, res.Body)
+}
+
+// postOCSPResponse handles OCSP requests sent with the POST method defined in RFC 6960 appendix A.1.
+func (e *env) postOCSPResponse(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "ocsp_controller_post_ocsp_response")
+	defer span.Finish()
+
+	der, err := parseOCSPRequestBody(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	res := e.ocspService.Respond(ctx, der)
+	c.Data(http.StatusOK, ocspResponseType, res.Body)
+}
+
+func (e *env) issueOCSPResponder(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "ocsp_controller_issue_ocsp_responder")
+	defer span.Finish()
+
+	req, err := parseOCSPResponderRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	responder, err := e.certificateService.IssueOCSPResponder(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, responder)
+}
+
+func parseOCSPResponderRequest(c *gin.Context) (model.OCSPResponderRequest, error) {
+	var body model.OCSPResponderRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.OCSPResponderRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.OCSPResponderRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.CertificateID = c.Param("id")
+	return body, nil
+}
+
+func parseOCSPRequestPath(path string) ([]byte, error) {
+	encoded, err := url.PathUnescape(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, httputil.BadRequestError(fmt.Errorf("failed to unescape ocsp request: %w", err))
+	}
+
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, httputil.BadRequestError(fmt.Errorf("failed to decode ocsp request: %w", err))
+	}
+
+	return der, nil
+}
+
+func parseOCSPRequestBody(c *gin.Context) ([]byte, error) {
+	contentType := c.ContentType()
+	if contentType != ocspRequestType {
+		return nil, httputil.UnsupportedMediaTypeError(fmt.Errorf("unsupported content type: %s", contentType))
+	}
+
+	der, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxOCSPRequest))
+	if err != nil {
+		return nil, httputil.BadRequestError(fmt.Errorf("failed to read ocsp request: %w", err))
+	}
+
+	return der, nil
+}
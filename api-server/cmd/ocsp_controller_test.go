@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPResponse(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	rootPassword := "7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	issueTestOCSPResponder(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+	assert.Empty(rootCert.OCSPServer)
+
+	cert := createTestUserCertificate(t, server, user.JWTUser(), "user-cert", "2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+	assert.Equal([]string{"https://webca.io/api/v1/ocsp"}, x509Cert.OCSPServer)
+
+	ocspReq, err := ocsp.CreateRequest(x509Cert, rootCert, nil)
+	assert.NoError(err)
+
+	res := getTestOCSPResponse(t, server, ocspReq)
+	assert.Contains(res.Header().Get("Cache-Control"), "max-age=")
+	ocspRes, err := ocsp.ParseResponseForCert(res.Body.Bytes(), x509Cert, rootCert)
+	assert.NoError(err)
+	assert.Equal(ocsp.Good, ocspRes.Status)
+	assert.Equal(0, ocspRes.SerialNumber.Cmp(x509Cert.SerialNumber))
+	assert.True(ocspRes.NextUpdate.After(ocspRes.ThisUpdate))
+
+	responderCert := ocspRes.Certificate
+	assert.NotNil(responderCert)
+	assert.Equal("root-ca OCSP Responder", responderCert.Subject.CommonName)
+	assert.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, responderCert.ExtKeyUsage)
+	assert.NoError(responderCert.CheckSignatureFrom(rootCert))
+
+	path := fmt.Sprintf("/v1/certificates/%s/revocation", cert.ID)
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.RevocationRequest{
+		Reason: model.ReasonKeyCompromise,
+	})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = postTestOCSPRequest(t, server, ocspReq)
+	ocspRes, err = ocsp.ParseResponseForCert(res.Body.Bytes(), x509Cert, rootCert)
+	assert.NoError(err)
+	assert.Equal(ocsp.Revoked, ocspRes.Status)
+	assert.Equal(model.ReasonKeyCompromise, ocspRes.RevocationReason)
+	assert.False(ocspRes.RevokedAt.IsZero())
+	assert.Equal(responderCert.SerialNumber, ocspRes.Certificate.SerialNumber)
+}
+
+func TestOCSPResponse_IntermediateCA(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "3f2e1d0c9b8a7f6e5d4c3b2a1f0e9d8c"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	issueTestOCSPResponder(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+
+	intermediatePassword := "8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f"
+	intermediateCA := createTestIntermediateCertificate(t, server, admin.JWTUser(), "intermediate-ca", intermediatePassword, model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	issueTestOCSPResponder(t, server, admin.JWTUser(), intermediateCA.ID, intermediatePassword)
+	intermediateCert := parseTestCertificate(t, intermediateCA.Body)
+
+	ocspReq, err := ocsp.CreateRequest(intermediateCert, rootCert, nil)
+	assert.NoError(err)
+	res := postTestOCSPRequest(t, server, ocspReq)
+	ocspRes, err := ocsp.ParseResponseForCert(res.Body.Bytes(), intermediateCert, rootCert)
+	assert.NoError(err)
+	assert.Equal(ocsp.Good, ocspRes.Status)
+
+	cert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "e3f2d1c0b9a8f7e6d5c4b3a2f1e0d9c8", model.Signatory{
+		ID:       intermediateCA.ID,
+		Password: intermediatePassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+
+	ocspReq, err = ocsp.CreateRequest(x509Cert, intermediateCert, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	assert.NoError(err)
+	res = getTestOCSPResponse(t, server, ocspReq)
+	ocspRes, err = ocsp.ParseResponseForCert(res.Body.Bytes(), x509Cert, intermediateCert)
+	assert.NoError(err)
+	assert.Equal(ocsp.Good, ocspRes.Status)
+	assert.Equal("intermediate-ca OCSP Responder", ocspRes.Certificate.Subject.CommonName)
+}
+
+func TestOCSPResponse_Unknown(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	issueTestOCSPResponder(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+
+	cert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+	x509Cert.SerialNumber = new(big.Int).Add(x509Cert.SerialNumber, big.NewInt(1))
+
+	ocspReq, err := ocsp.CreateRequest(x509Cert, rootCert, nil)
+	assert.NoError(err)
+	res := postTestOCSPRequest(t, server, ocspReq)
+	ocspRes, err := ocsp.ParseResponseForCert(res.Body.Bytes(), x509Cert, rootCert)
+	assert.NoError(err)
+	assert.Equal(ocsp.Unknown, ocspRes.Status)
+}
+
+func TestOCSPResponse_UnknownIssuer(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	issueTestOCSPResponder(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+	otherCA := createTestRootCertificate(t, server, admin.JWTUser(), "other-ca", "f6e5d4c3b2a1f0e9d8c7b6a5f4e3d2c1")
+	otherCert := parseTestCertificate(t, otherCA.Body)
+	otherCert.RawSubject = []byte("unknown-issuer")
+
+	cert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+
+	ocspReq, err := ocsp.CreateRequest(x509Cert, otherCert, nil)
+	assert.NoError(err)
+	res := postTestOCSPRequest(t, server, ocspReq)
+	_, err = ocsp.ParseResponse(res.Body.Bytes(), rootCert)
+	assert.Equal(ocsp.ResponseError{Status: ocsp.Unauthorized}, err)
+}
+
+func TestOCSPResponse_NoResponder(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+
+	cert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+	assert.Empty(x509Cert.OCSPServer)
+	assert.NotEmpty(x509Cert.CRLDistributionPoints)
+
+	ocspReq, err := ocsp.CreateRequest(x509Cert, rootCert, nil)
+	assert.NoError(err)
+	res := postTestOCSPRequest(t, server, ocspReq)
+	_, err = ocsp.ParseResponse(res.Body.Bytes(), rootCert)
+	assert.Equal(ocsp.ResponseError{Status: ocsp.Unauthorized}, err)
+}
+
+func TestIssueOCSPResponder(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	rootPassword := "e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	path := fmt.Sprintf("/v1/certificates/%s/ocsp-responder", rootCA.ID)
+
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.OCSPResponderRequest{Password: "wrong-password"})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.OCSPResponderRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, user.JWTUser(), model.OCSPResponderRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	responder := issueTestOCSPResponder(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	assert.Equal(rootCA.ID, responder.CertificateID)
+	assert.True(responder.ExpiresAt.After(responder.CreatedAt))
+	responderCert := parseTestCertificate(t, responder.Body)
+	assert.NoError(responderCert.CheckSignatureFrom(parseTestCertificate(t, rootCA.Body)))
+
+	auditEvents, err := repository.NewAuditEventRepository(e.db).FindByResource(ctx, fmt.Sprintf("webca:api-server:ocsp-responder:%s", responder.ID))
+	assert.NoError(err)
+	assert.Len(auditEvents, 1)
+	assert.Equal("CREATE", auditEvents[0].Activity)
+
+	reissued := issueTestOCSPResponder(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	assert.NotEqual(responder.ID, reissued.ID)
+
+	e.ocspService.KeyWrappingKey = nil
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.OCSPResponderRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusPreconditionRequired, res.Code)
+}
+
+func TestOCSPResponse_MalformedRequest(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	res := postTestOCSPRequest(t, server, []byte("not an ocsp request"))
+	_, err := ocsp.ParseResponse(res.Body.Bytes(), nil)
+	assert.Equal(ocsp.ResponseError{Status: ocsp.Malformed}, err)
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/ocsp/not-base64!", nil)
+	assert.NoError(err)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req, err = http.NewRequest(http.MethodPost, "/v1/ocsp", bytes.NewReader([]byte{}))
+	assert.NoError(err)
+	req.Header.Set("Content-Type", "text/plain")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+}
+
+func issueTestOCSPResponder(t *testing.T, server *http.Server, jwtUser jwt.User, caID, password string) model.OCSPResponder {
+	assert := assert.New(t)
+
+	path := fmt.Sprintf("/v1/certificates/%s/ocsp-responder", caID)
+	req := createTestRequest(path, http.MethodPost, jwtUser, model.OCSPResponderRequest{Password: password})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.NotContains(res.Body.String(), "privateKey")
+
+	var responder model.OCSPResponder
+	err := json.NewDecoder(res.Body).Decode(&responder)
+	assert.NoError(err)
+
+	return responder
+}
+
+func getTestOCSPResponse(t *testing.T, server *http.Server, ocspReq []byte) *httptest.ResponseRecorder {
+	assert := assert.New(t)
+
+	path := "/v1/ocsp/" + url.PathEscape(base64.StdEncoding.EncodeToString(ocspReq))
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	assert.NoError(err)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("application/ocsp-response", res.Header().Get("Content-Type"))
+
+	return res
+}
+
+func postTestOCSPRequest(t *testing.T, server *http.Server, ocspReq []byte) *httptest.ResponseRecorder {
+	assert := assert.New(t)
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/ocsp", bytes.NewReader(ocspReq))
+	assert.NoError(err)
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("application/ocsp-response", res.Header().Get("Content-Type"))
+
+	return res
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	tracelog "github.com/opentracing/opentracing-go/log"
+)
+
+func (e *env) createPendingCertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "pending_certificate_controller_create_pending_certificate")
+	defer span.Finish()
+
+	req, err := parsePendingCertificateRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	pending, err := e.certificateService.CreatePending(ctx, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pending)
+}
+
+func (e *env) getPendingCertificates(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "pending_certificate_controller_get_pending_certificates")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	pendingCerts, err := e.certificateService.ListPending(ctx, principal)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pendingCerts)
+}
+
+func (e *env) getPendingCertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "pending_certificate_controller_get_pending_certificate")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	pending, err := e.certificateService.GetPending(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pending)
+}
+
+func (e *env) activatePendingCertificate(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "pending_certificate_controller_activate_pending_certificate")
+	defer span.Finish()
+
+	req, err := parseActivationRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.PendingCertificateID = c.Param("id")
+	req.UserID = principal.ID
+	cert, err := e.certificateService.Activate(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
+func parsePendingCertificateRequest(c *gin.Context) (model.PendingCertificateRequest, error) {
+	var body model.PendingCertificateRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.PendingCertificateRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.PendingCertificateRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
+
+func parseActivationRequest(c *gin.Context) (model.ActivationRequest, error) {
+	var body model.ActivationRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.ActivationRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.ActivationRequest{}, httputil.BadRequestError(err)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivatePendingCertificate(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+	_, admin, user := createTestAccount(t, e)
+
+	password := "e0b3d4f5a9a64f3c8b1a7d2c6e9f0a1b"
+	pending := createTestPendingCertificate(t, server, admin.JWTUser(), "offline-intermediate", password)
+	assert.Equal(model.PendingCertificatePending, pending.Status)
+	assert.Empty(pending.CertificateID)
+	assert.Nil(pending.ActivatedAt)
+
+	csr := parseTestCSR(t, pending.CSR)
+	assert.NoError(csr.CheckSignature())
+	assert.Equal("offline-intermediate", csr.Subject.CommonName)
+	assert.Equal([]string{"ca.webca.test"}, csr.DNSNames)
+
+	root, rootKey := createTestExternalCA(t, "External Root CA", nil, nil)
+	externalIntermediate, externalKey := createTestExternalCA(t, "External Intermediate CA", root, rootKey)
+	signed := signTestPendingCertificate(t, csr, externalIntermediate, externalKey)
+
+	path := fmt.Sprintf("/v1/pending-certificates/%s/activation", pending.ID)
+	body := model.ActivationRequest{
+		Certificate: signed,
+		Chain:       encodeTestCertificate(externalIntermediate) + encodeTestCertificate(root),
+		Password:    password,
+	}
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var cert model.Certificate
+	err := json.NewDecoder(res.Result().Body).Decode(&cert)
+	assert.NoError(err)
+	assert.Equal("offline-intermediate", cert.Name)
+	assert.Equal(model.IntermediateCAType, cert.Type)
+	assert.Equal(model.CertificateActive, cert.Status)
+	assert.Empty(cert.SignatoryID)
+	assert.Equal(signed, cert.Body)
+
+	req = createTestRequest("/v1/pending-certificates/"+pending.ID, http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var activated model.PendingCertificate
+	err = json.NewDecoder(res.Result().Body).Decode(&activated)
+	assert.NoError(err)
+	assert.Equal(model.PendingCertificateActivated, activated.Status)
+	assert.Equal(cert.ID, activated.CertificateID)
+	assert.Equal(encodeTestCertificate(externalIntermediate), activated.IssuerChain)
+	assert.NotNil(activated.ActivatedAt)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), body)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	stale := pending
+	stale.Status = model.PendingCertificateActivated
+	duplicate := cert
+	duplicate.ID = "duplicate-" + cert.ID
+	duplicate.Name = "offline-intermediate-duplicate"
+	duplicate.SerialNumber = "7f"
+	duplicate.Body = signTestPendingCertificate(t, csr, root, rootKey)
+	updated, err := e.certificateService.PendingRepo.Activate(ctx, stale, duplicate)
+	assert.NoError(err)
+	assert.False(updated)
+	_, found, err := e.certificateService.CertRepo.Find(ctx, duplicate.ID)
+	assert.NoError(err)
+	assert.False(found)
+
+	req = createTestRequest(fmt.Sprintf("/v1/certificates/%s/body?fullchain=true", cert.ID), http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var fullchain model.Attachment
+	err = json.NewDecoder(res.Result().Body).Decode(&fullchain)
+	assert.NoError(err)
+	assert.Equal(signed+encodeTestCertificate(externalIntermediate), fullchain.Body)
+
+	leafPassword := "5c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f"
+	leaf := createTestUserCertificate(t, server, admin.JWTUser(), "leaf", leafPassword, model.Signatory{
+		ID:       cert.ID,
+		Password: password,
+	})
+	assert.Equal(cert.ID, leaf.SignatoryID)
+
+	req = createTestRequest(fmt.Sprintf("/v1/certificates/%s/body?fullchain=true", leaf.ID), http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	err = json.NewDecoder(res.Result().Body).Decode(&fullchain)
+	assert.NoError(err)
+	assert.Equal(leaf.Body+signed+encodeTestCertificate(externalIntermediate), fullchain.Body)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(fullchain.Body))
+	_, err = parseTestCertificate(t, leaf.Body).Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(err)
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, "webca:api-server:pending-certificate:"+pending.ID)
+	assert.NoError(err)
+	assert.Len(events, 2)
+	assert.Equal("CREATE", events[0].Activity)
+	assert.Equal("READ", events[1].Activity)
+
+	events, err = auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:pending-certificate:%s:activation", pending.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(admin.ID, events[0].UserID)
+
+	events, err = auditRepo.FindByResource(ctx, "webca:api-server:certificate:"+cert.ID)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("CREATE", events[0].Activity)
+}
+
+func TestActivatePendingCertificate_Rejected(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+	_, admin, _ := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	password := "e0b3d4f5a9a64f3c8b1a7d2c6e9f0a1b"
+	pending := createTestPendingCertificate(t, server, admin.JWTUser(), "offline-intermediate", password)
+	csr := parseTestCSR(t, pending.CSR)
+
+	root, rootKey := createTestExternalCA(t, "External Root CA", nil, nil)
+	otherRoot, _ := createTestExternalCA(t, "Other Root CA", nil, nil)
+	signed := signTestPendingCertificate(t, csr, root, rootKey)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	otherCSR := parseTestCSR(t, createTestCSR(t, otherKey, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "offline-intermediate"},
+	}))
+	otherSigned := signTestPendingCertificate(t, otherCSR, root, rootKey)
+
+	path := fmt.Sprintf("/v1/pending-certificates/%s/activation", pending.ID)
+	tests := []struct {
+		name   string
+		user   jwt.User
+		body   model.ActivationRequest
+		status int
+	}{
+		{
+			name:   "wrong password",
+			user:   admin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: signed, Chain: encodeTestCertificate(root), Password: "39f2a7c6d1e84b0f9a3c5e7d2b4f6a8c"},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "certificate for another key",
+			user:   admin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: otherSigned, Chain: encodeTestCertificate(root), Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "chain of another root",
+			user:   admin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: signed, Chain: encodeTestCertificate(otherRoot), Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "chain without root",
+			user:   admin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: signed, Chain: signed, Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid chain",
+			user:   admin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: signed, Chain: "not a certificate", Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "missing chain",
+			user:   admin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: signed, Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "other account",
+			user:   otherAdmin.JWTUser(),
+			body:   model.ActivationRequest{Certificate: signed, Chain: encodeTestCertificate(root), Password: password},
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tests {
+		req := createTestRequest(path, http.MethodPost, tc.user, tc.body)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(tc.status, res.Code, tc.name)
+	}
+
+	req := createTestRequest("/v1/pending-certificates/missing-id/activation", http.MethodPost, admin.JWTUser(), model.ActivationRequest{
+		Certificate: signed,
+		Chain:       encodeTestCertificate(root),
+		Password:    password,
+	})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	req = createTestRequest("/v1/pending-certificates/"+pending.ID, http.MethodGet, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var fetched model.PendingCertificate
+	err = json.NewDecoder(res.Result().Body).Decode(&fetched)
+	assert.NoError(err)
+	assert.Equal(model.PendingCertificatePending, fetched.Status)
+}
+
+func TestCreatePendingCertificate_BadRequest(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+	_, admin, _ := createTestAccount(t, e)
+
+	password := "e0b3d4f5a9a64f3c8b1a7d2c6e9f0a1b"
+	createTestPendingCertificate(t, server, admin.JWTUser(), "offline-intermediate", password)
+	createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", password)
+
+	tests := []struct {
+		name   string
+		body   model.PendingCertificateRequest
+		status int
+	}{
+		{
+			name:   "missing name",
+			body:   model.PendingCertificateRequest{Subject: model.CertificateSubject{CommonName: "ca"}, Algorithm: "ECDSA", Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "missing common name",
+			body:   model.PendingCertificateRequest{Name: "ca", Algorithm: "ECDSA", Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "weak password",
+			body:   model.PendingCertificateRequest{Name: "ca", Subject: model.CertificateSubject{CommonName: "ca"}, Algorithm: "ECDSA", Password: "short"},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unsupported algorithm",
+			body:   model.PendingCertificateRequest{Name: "ca", Subject: model.CertificateSubject{CommonName: "ca"}, Algorithm: "DSA", Password: password},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "duplicate pending name",
+			body:   model.PendingCertificateRequest{Name: "offline-intermediate", Subject: model.CertificateSubject{CommonName: "ca"}, Algorithm: "ECDSA", Password: password},
+			status: http.StatusConflict,
+		},
+		{
+			name:   "duplicate certificate name",
+			body:   model.PendingCertificateRequest{Name: "root-ca", Subject: model.CertificateSubject{CommonName: "ca"}, Algorithm: "ECDSA", Password: password},
+			status: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tests {
+		req := createTestRequest("/v1/pending-certificates", http.MethodPost, admin.JWTUser(), tc.body)
+		res := performTestRequest(server.Handler, req)
+		assert.Equal(tc.status, res.Code, tc.name)
+	}
+}
+
+func TestGetPendingCertificates(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+	_, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	password := "e0b3d4f5a9a64f3c8b1a7d2c6e9f0a1b"
+	pending := createTestPendingCertificate(t, server, admin.JWTUser(), "offline-intermediate", password)
+	createTestPendingCertificate(t, server, otherAdmin.JWTUser(), "other-intermediate", password)
+
+	req := createTestRequest("/v1/pending-certificates", http.MethodGet, user.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var pendingCerts []model.PendingCertificate
+	err := json.NewDecoder(res.Result().Body).Decode(&pendingCerts)
+	assert.NoError(err)
+	assert.Len(pendingCerts, 1)
+	assert.Equal(pending.ID, pendingCerts[0].ID)
+	assert.Equal(pending.CSR, pendingCerts[0].CSR)
+
+	req = createTestRequest("/v1/pending-certificates/"+pending.ID, http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest("/v1/pending-certificates/missing-id", http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestPendingCertificates_BadContentType(t *testing.T) {
+	testBadContentType(t, "/v1/pending-certificates", http.MethodPost, model.AdminRole)
+	testBadContentType(t, "/v1/pending-certificates/some-id/activation", http.MethodPost, model.AdminRole)
+}
+
+func TestPendingCertificates_UnauthorizedAndForbidden(t *testing.T) {
+	routes := []struct {
+		route  string
+		method string
+	}{
+		{"/v1/pending-certificates", http.MethodPost},
+		{"/v1/pending-certificates", http.MethodGet},
+		{"/v1/pending-certificates/some-id", http.MethodGet},
+		{"/v1/pending-certificates/some-id/activation", http.MethodPost},
+	}
+
+	for _, r := range routes {
+		testUnauthorized(t, r.route, r.method)
+		testForbidden(t, r.route, r.method, []string{
+			jwt.AnonymousRole,
+		})
+	}
+}
+
+func createTestPendingCertificate(t *testing.T, server *http.Server, user jwt.User, name, password string) model.PendingCertificate {
+	assert := assert.New(t)
+
+	body := model.PendingCertificateRequest{
+		Name: name,
+		Subject: model.CertificateSubject{
+			CommonName: name,
+		},
+		SubjectAlternativeNames: model.SubjectAlternativeNames{
+			DNSNames: []string{"ca.webca.test"},
+		},
+		Algorithm: "ECDSA",
+		Password:  password,
+		Options: map[string]interface{}{
+			"curve": "P-256",
+		},
+	}
+	req := createTestRequest("/v1/pending-certificates", http.MethodPost, user, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var pending model.PendingCertificate
+	err := json.NewDecoder(res.Result().Body).Decode(&pending)
+	assert.NoError(err)
+
+	return pending
+}
+
+// createTestExternalCA creates a certificate authority outside of webca, which is self-signed if no parent is given.
+func createTestExternalCA(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	assert := assert.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	assert.NoError(err)
+
+	cert, err := x509.ParseCertificate(b)
+	assert.NoError(err)
+
+	return cert, key
+}
+
+func signTestPendingCertificate(t *testing.T, csr *x509.CertificateRequest, parent *x509.Certificate, parentKey crypto.Signer) string {
+	assert := assert.New(t)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               csr.Subject,
+		DNSNames:              csr.DNSNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(2, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+		PermittedDNSDomains:   []string{"webca.test"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, csr.PublicKey, parentKey)
+	assert.NoError(err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b}))
+}
+
+func parseTestCSR(t *testing.T, body string) *x509.CertificateRequest {
+	assert := assert.New(t)
+
+	block, _ := pem.Decode([]byte(body))
+	assert.NotNil(block)
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.NoError(err)
+
+	return csr
+}
+
+func encodeTestCertificate(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
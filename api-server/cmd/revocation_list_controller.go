@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	tracelog "github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	derFormat   = "DER"
+	pemFormat   = "PEM"
+	pkixCRLType = "application/pkix-crl"
+	pemFileType = "application/x-pem-file"
+)
+
+func (e *env) getRevocationList(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "revocation_list_controller_get_revocation_list")
+	defer span.Finish()
+
+	format := strings.ToUpper(c.DefaultQuery("format", derFormat))
+	if format != derFormat && format != pemFormat {
+		err := httputil.BadRequestError(fmt.Errorf("unsupported revocation list format: %s", format))
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	crl, err := e.crlService.GetRevocationList(ctx, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	maxAge := int(time.Until(crl.NextUpdate).Seconds())
+	if maxAge > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	}
+
+	if format == pemFormat {
+		c.Data(http.StatusOK, pemFileType, []byte(crl.Body))
+		return
+	}
+
+	block, _ := pem.Decode([]byte(crl.Body))
+	if block == nil {
+		err = httputil.InternalServerError(fmt.Errorf("failed to decode pem body of %s", crl))
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.Data(http.StatusOK, pkixCRLType, block.Bytes)
+}
+
+func (e *env) enableRevocationList(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "revocation_list_controller_enable_revocation_list")
+	defer span.Finish()
+
+	req, err := parseCRLSigningRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	req.UserID = principal.ID
+	cert, err := e.certificateService.EnableRevocationList(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
+func (e *env) disableRevocationList(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "revocation_list_controller_disable_revocation_list")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	err = e.certificateService.DisableRevocationList(ctx, principal, c.Param("id"))
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func parseCRLSigningRequest(c *gin.Context) (model.CRLSigningRequest, error) {
+	var body model.CRLSigningRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.CRLSigningRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.CRLSigningRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.CertificateID = c.Param("id")
+	return body, nil
+}
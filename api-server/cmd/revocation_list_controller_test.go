@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/ecdsautil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRevocationList(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	rootPassword := "0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	rootCert := parseTestCertificate(t, rootCA.Body)
+	assert.Empty(rootCert.CRLDistributionPoints)
+	assert.NotEqual(0, rootCert.KeyUsage&x509.KeyUsageCRLSign)
+
+	crl := getTestRevocationList(t, server, rootCA.ID)
+	assert.Equal(int64(1), crl.Number.Int64())
+	assert.Empty(crl.RevokedCertificateEntries)
+	assert.NoError(crl.CheckSignatureFrom(rootCert))
+	assert.True(crl.NextUpdate.After(crl.ThisUpdate))
+
+	cert := createTestUserCertificate(t, server, user.JWTUser(), "user-cert", "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+	crlURL := fmt.Sprintf("https://webca.io/api/v1/ca/%s/crl", rootCA.ID)
+	assert.Equal([]string{crlURL}, x509Cert.CRLDistributionPoints)
+
+	invalidityDate := timeutil.Now().Add(-time.Hour).Truncate(time.Second)
+	path := fmt.Sprintf("/v1/certificates/%s/revocation", cert.ID)
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.RevocationRequest{
+		Reason:         model.ReasonKeyCompromise,
+		InvalidityDate: &invalidityDate,
+	})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	crl = getTestRevocationList(t, server, rootCA.ID)
+	assert.Equal(int64(2), crl.Number.Int64())
+	assert.NoError(crl.CheckSignatureFrom(rootCert))
+	assert.Len(crl.RevokedCertificateEntries, 1)
+	entry := crl.RevokedCertificateEntries[0]
+	assert.Equal(0, entry.SerialNumber.Cmp(x509Cert.SerialNumber))
+	assert.Equal(model.ReasonKeyCompromise, entry.ReasonCode)
+	assert.Len(entry.Extensions, 2)
+
+	e.crlService.GenerateAll(ctx)
+	crl = getTestRevocationList(t, server, rootCA.ID)
+	assert.Equal(int64(3), crl.Number.Int64())
+	assert.Len(crl.RevokedCertificateEntries, 1)
+
+	req = createUnauthenticatedTestRequest(fmt.Sprintf("/v1/ca/%s/crl?format=pem", rootCA.ID), http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("application/x-pem-file", res.Header().Get("Content-Type"))
+
+	block, _ := pem.Decode(res.Body.Bytes())
+	assert.NotNil(block)
+	assert.Equal("X509 CRL", block.Type)
+	pemCRL, err := x509.ParseRevocationList(block.Bytes)
+	assert.NoError(err)
+	assert.Equal(crl.Number, pemCRL.Number)
+}
+
+func TestGetRevocationList_IntermediateCA(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+
+	intermediatePassword := "4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e"
+	intermediateCA := createTestCertificate(t, server, admin.JWTUser(), model.CertificateRequest{
+		Name:      "ecdsa-intermediate-ca",
+		Subject:   model.CertificateSubject{CommonName: "ecdsa-intermediate-ca"},
+		Type:      model.IntermediateCAType,
+		Algorithm: ecdsautil.Algorithm,
+		Password:  intermediatePassword,
+		Options: map[string]interface{}{
+			"curve": ecdsautil.P256,
+		},
+		Signatory: model.Signatory{
+			ID:       rootCA.ID,
+			Password: rootPassword,
+		},
+	})
+	enableTestRevocationList(t, server, admin.JWTUser(), intermediateCA.ID, intermediatePassword)
+	intermediateCert := parseTestCertificate(t, intermediateCA.Body)
+	assert.Equal([]string{fmt.Sprintf("https://webca.io/api/v1/ca/%s/crl", rootCA.ID)}, intermediateCert.CRLDistributionPoints)
+
+	cert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "d9e8c7b6a5f4e3d2c1b0a9f8e7d6c5b4", model.Signatory{
+		ID:       intermediateCA.ID,
+		Password: intermediatePassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+	assert.Equal([]string{fmt.Sprintf("https://webca.io/api/v1/ca/%s/crl", intermediateCA.ID)}, x509Cert.CRLDistributionPoints)
+
+	path := fmt.Sprintf("/v1/certificates/%s/revocation", cert.ID)
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.RevocationRequest{
+		Reason: model.ReasonSuperseded,
+	})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	crl := getTestRevocationList(t, server, intermediateCA.ID)
+	assert.Equal(int64(2), crl.Number.Int64())
+	assert.Equal(x509.ECDSAWithSHA256, crl.SignatureAlgorithm)
+	assert.NoError(crl.CheckSignatureFrom(intermediateCert))
+	assert.Len(crl.RevokedCertificateEntries, 1)
+	assert.Equal(model.ReasonSuperseded, crl.RevokedCertificateEntries[0].ReasonCode)
+
+	rootCRL := getTestRevocationList(t, server, rootCA.ID)
+	assert.Equal(int64(1), rootCRL.Number.Int64())
+	assert.Empty(rootCRL.RevokedCertificateEntries)
+}
+
+func TestGenerateRevocationList_Concurrent(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+
+	latest, _, err := repository.NewRevocationListRepository(e.db).FindLatest(ctx, rootCA.ID)
+	assert.NoError(err)
+	latest.ID = id.New()
+	err = repository.NewRevocationListRepository(e.db).Save(ctx, latest)
+	assert.True(errors.Is(err, repository.ErrDuplicateRevocationListNumber))
+
+	// Every connection to an in-memory sqlite database opens a database of its own.
+	e.db.SetMaxOpenConns(1)
+	var wg sync.WaitGroup
+	numbers := make(chan int64, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			crl, err := e.crlService.Generate(ctx, rootCA.ID)
+			assert.NoError(err)
+			numbers <- crl.Number
+		}()
+	}
+	wg.Wait()
+	close(numbers)
+
+	seen := make(map[int64]bool)
+	for number := range numbers {
+		assert.False(seen[number])
+		seen[number] = true
+	}
+	assert.Equal(map[int64]bool{2: true, 3: true, 4: true}, seen)
+}
+
+func TestGetRevocationList_NotFound(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	path := fmt.Sprintf("/v1/ca/%s/crl", id.New())
+	req := createUnauthenticatedTestRequest(path, http.MethodGet, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", "2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b")
+	req = createUnauthenticatedTestRequest(fmt.Sprintf("/v1/ca/%s/crl", rootCA.ID), http.MethodGet, nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	enrolled, err := e.crlService.Enrolled(ctx, rootCA.ID)
+	assert.NoError(err)
+	assert.False(enrolled)
+}
+
+func TestEnableRevocationList(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	rootPassword := "6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+	path := fmt.Sprintf("/v1/certificates/%s/crl-signing", rootCA.ID)
+
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.CRLSigningRequest{Password: "wrong-password"})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.CRLSigningRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, user.JWTUser(), model.CRLSigningRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	enableTestRevocationList(t, server, admin.JWTUser(), rootCA.ID, rootPassword)
+	crl := getTestRevocationList(t, server, rootCA.ID)
+	assert.Equal(int64(1), crl.Number.Int64())
+
+	auditEvents, err := repository.NewAuditEventRepository(e.db).FindByResource(ctx, fmt.Sprintf("webca:api-server:certificate:%s:crl-signing-key", rootCA.ID))
+	assert.NoError(err)
+	assert.Len(auditEvents, 1)
+	assert.Equal("CREATE", auditEvents[0].Activity)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), model.CRLSigningRequest{Password: rootPassword})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	userCert := createTestUserCertificate(t, server, admin.JWTUser(), "user-cert", "7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	req = createTestRequest(fmt.Sprintf("/v1/certificates/%s/crl-signing", userCert.ID), http.MethodPost, admin.JWTUser(), model.CRLSigningRequest{Password: "7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e"})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodDelete, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	cert := createTestUserCertificate(t, server, admin.JWTUser(), "other-user-cert", "8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f", model.Signatory{
+		ID:       rootCA.ID,
+		Password: rootPassword,
+	})
+	x509Cert := parseTestCertificate(t, cert.Body)
+	assert.Empty(x509Cert.CRLDistributionPoints)
+	assert.Empty(x509Cert.OCSPServer)
+
+	crl = getTestRevocationList(t, server, rootCA.ID)
+	assert.Equal(int64(1), crl.Number.Int64())
+}
+
+func TestEnableRevocationList_NoKeyWrappingKey(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	e.crlService.KeyWrappingKey = nil
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootPassword := "9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a"
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", rootPassword)
+
+	path := fmt.Sprintf("/v1/certificates/%s/crl-signing", rootCA.ID)
+	req := createTestRequest(path, http.MethodPost, admin.JWTUser(), model.CRLSigningRequest{Password: rootPassword})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusPreconditionRequired, res.Code)
+}
+
+func TestGetRevocationList_BadFormat(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, _ := createTestAccount(t, e)
+	rootCA := createTestRootCertificate(t, server, admin.JWTUser(), "root-ca", "5c4b3a2f1e0d9c8b7a6f5e4d3c2b1a0f")
+
+	path := fmt.Sprintf("/v1/ca/%s/crl?format=xml", rootCA.ID)
+	req := createUnauthenticatedTestRequest(path, http.MethodGet, nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+}
+
+func enableTestRevocationList(t *testing.T, server *http.Server, jwtUser jwt.User, caID, password string) {
+	path := fmt.Sprintf("/v1/certificates/%s/crl-signing", caID)
+	req := createTestRequest(path, http.MethodPost, jwtUser, model.CRLSigningRequest{Password: password})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func getTestRevocationList(t *testing.T, server *http.Server, caID string) *x509.RevocationList {
+	assert := assert.New(t)
+
+	path := fmt.Sprintf("/v1/ca/%s/crl", caID)
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	assert.NoError(err)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("application/pkix-crl", res.Header().Get("Content-Type"))
+	assert.Contains(res.Header().Get("Cache-Control"), "max-age=")
+
+	crl, err := x509.ParseRevocationList(res.Body.Bytes())
+	assert.NoError(err)
+
+	return crl
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
 	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
 	tracelog "github.com/opentracing/opentracing-go/log"
@@ -30,3 +32,92 @@ func (e *env) getUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, user)
 }
+
+func (e *env) getAccountUsers(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "user_controller_get_account_users")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	accountID := c.Param("id")
+	users, err := e.userService.GetAccountUsers(ctx, principal, accountID)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (e *env) updateUserRole(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "user_controller_update_user_role")
+	defer span.Finish()
+
+	req, err := parseUserRoleRequest(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	user, err := e.userService.UpdateRole(ctx, principal, req)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (e *env) deactivateUser(c *gin.Context) {
+	span, ctx := opentracing.StartSpanFromContext(c.Request.Context(), "user_controller_deactivate_user")
+	defer span.Finish()
+
+	principal, err := httputil.MustGetPrincipal(c)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	userID := c.Param("id")
+	user, err := e.userService.Deactivate(ctx, principal, userID)
+	if err != nil {
+		span.LogFields(tracelog.Error(err))
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func parseUserRoleRequest(c *gin.Context) (model.UserRoleRequest, error) {
+	var body model.UserRoleRequest
+	err := c.BindJSON(&body)
+	if err != nil {
+		err = httputil.BadRequestError(fmt.Errorf("failed to parse request body. %w", err))
+		return model.UserRoleRequest{}, err
+	}
+
+	err = body.Validate()
+	if err != nil {
+		return model.UserRoleRequest{}, httputil.BadRequestError(err)
+	}
+
+	body.UserID = c.Param("id")
+	return body, nil
+}
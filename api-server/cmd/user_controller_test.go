@@ -2,10 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/CzarSimon/httputil/dbutil"
 	"github.com/CzarSimon/httputil/id"
 	"github.com/CzarSimon/httputil/jwt"
 	"github.com/CzarSimon/webca/api-server/internal/model"
@@ -152,3 +157,383 @@ func TestGetUser_UnauthorizedAndForbidden(t *testing.T) {
 		jwt.AnonymousRole,
 	})
 }
+
+func TestGetAccountUsers(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, user := createTestAccount(t, e)
+	otherAccount, otherAdmin, _ := createTestAccount(t, e)
+
+	path := fmt.Sprintf("/v1/accounts/%s/users", account.ID)
+	req := createTestRequest(path, http.MethodGet, admin.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var users []model.User
+	err := json.NewDecoder(res.Result().Body).Decode(&users)
+	assert.NoError(err)
+	assert.Len(users, 2)
+	assert.Equal(admin.ID, users[0].ID)
+	assert.Equal(model.AdminRole, users[0].Role)
+	assert.True(users[0].Active)
+	assert.Equal(user.ID, users[1].ID)
+	assert.Equal(model.UserRole, users[1].Role)
+	assert.True(users[1].Active)
+	for _, u := range users {
+		assert.Equal(account.ID, u.Account.ID)
+		assert.Empty(u.Credentials)
+	}
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:user:%s", user.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("READ", events[0].Activity)
+	assert.Equal(admin.ID, events[0].UserID)
+
+	req = createTestRequest(path, http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	otherPath := fmt.Sprintf("/v1/accounts/%s/users", otherAccount.ID)
+	req = createTestRequest(otherPath, http.MethodGet, otherAdmin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	err = json.NewDecoder(res.Result().Body).Decode(&users)
+	assert.NoError(err)
+	assert.Len(users, 2)
+	assert.Equal(otherAdmin.ID, users[0].ID)
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	account, admin, user := createTestAccount(t, e)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	userPath := fmt.Sprintf("/v1/users/%s/role", user.ID)
+	adminPath := fmt.Sprintf("/v1/users/%s/role", admin.ID)
+
+	req := createTestRequest(adminPath, http.MethodPut, admin.JWTUser(), model.UserRoleRequest{Role: model.UserRole})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	req = createTestRequest(userPath, http.MethodPut, otherAdmin.JWTUser(), model.UserRoleRequest{Role: model.AdminRole})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(userPath, http.MethodPut, admin.JWTUser(), model.UserRoleRequest{Role: model.AdminRole})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var rBody model.User
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
+	assert.NoError(err)
+	assert.Equal(user.ID, rBody.ID)
+	assert.Equal(model.AdminRole, rBody.Role)
+	assert.True(rBody.Active)
+	assert.True(rBody.UpdatedAt.After(user.UpdatedAt))
+
+	userRepo := repository.NewUserRepository(e.db)
+	stored, exists, err := userRepo.Find(ctx, user.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.AdminRole, stored.Role)
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:user:%s", user.ID))
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("UPDATE", events[0].Activity)
+	assert.Equal(admin.ID, events[0].UserID)
+
+	// Tokens issued for the previous role are no longer accepted.
+	accountPath := fmt.Sprintf("/v1/accounts/%s", account.ID)
+	req = createTestRequest(accountPath, http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(accountPath, http.MethodGet, stored.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestRequest(adminPath, http.MethodPut, stored.JWTUser(), model.UserRoleRequest{Role: model.UserRole})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestRequest(userPath, http.MethodPut, stored.JWTUser(), model.UserRoleRequest{Role: model.UserRole})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	stored, exists, err = userRepo.Find(ctx, user.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.AdminRole, stored.Role)
+
+	demoted, exists, err := userRepo.Find(ctx, admin.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.UserRole, demoted.Role)
+}
+
+func TestUpdateUserRole_Concurrent(t *testing.T) {
+	assert := assert.New(t)
+	// Every connection to an in-memory sqlite database opens a database of its own, so concurrent
+	// updates need a database file that several connections can write to.
+	e, ctx := createTestEnvWithDB(dbutil.SqliteConfig{
+		Name: fmt.Sprintf("file:%s?_foreign_keys=on", filepath.Join(t.TempDir(), "webca.db")),
+	})
+	defer e.db.Close()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	userPath := fmt.Sprintf("/v1/users/%s/role", user.ID)
+	req := createTestRequest(userPath, http.MethodPut, admin.JWTUser(), model.UserRoleRequest{Role: model.AdminRole})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	userRepo := repository.NewUserRepository(e.db)
+	promoted, exists, err := userRepo.Find(ctx, user.ID)
+	assert.NoError(err)
+	assert.True(exists)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for _, u := range []model.User{admin, promoted} {
+		wg.Add(1)
+		go func(u model.User) {
+			defer wg.Done()
+			path := fmt.Sprintf("/v1/users/%s/role", u.ID)
+			req := createTestRequest(path, http.MethodPut, u.JWTUser(), model.UserRoleRequest{Role: model.UserRole})
+			res := performTestRequest(server.Handler, req)
+			codes <- res.Code
+		}(u)
+	}
+	wg.Wait()
+	close(codes)
+
+	seen := make(map[int]int)
+	for code := range codes {
+		seen[code]++
+	}
+	assert.Equal(map[int]int{http.StatusOK: 1, http.StatusConflict: 1}, seen)
+
+	admins := 0
+	for _, id := range []string{admin.ID, user.ID} {
+		stored, exists, err := userRepo.Find(ctx, id)
+		assert.NoError(err)
+		assert.True(exists)
+		if stored.Role == model.AdminRole {
+			admins++
+		}
+	}
+	assert.Equal(1, admins)
+}
+
+func TestUpdateUserRole_ConcurrentPromotion(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnvWithDB(dbutil.SqliteConfig{
+		Name: fmt.Sprintf("file:%s?_foreign_keys=on", filepath.Join(t.TempDir(), "webca.db")),
+	})
+	defer e.db.Close()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+
+	// A promotion of the user that is still being written when the admin demotes itself.
+	tx, err := e.db.BeginTx(ctx, nil)
+	assert.NoError(err)
+	_, err = tx.ExecContext(ctx, "UPDATE user_account SET role = ? WHERE id = ?", model.AdminRole, user.ID)
+	assert.NoError(err)
+
+	codes := make(chan int, 1)
+	go func() {
+		path := fmt.Sprintf("/v1/users/%s/role", admin.ID)
+		req := createTestRequest(path, http.MethodPut, admin.JWTUser(), model.UserRoleRequest{Role: model.UserRole})
+		res := performTestRequest(server.Handler, req)
+		codes <- res.Code
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(tx.Commit())
+	assert.Equal(http.StatusOK, <-codes)
+
+	userRepo := repository.NewUserRepository(e.db)
+	demoted, exists, err := userRepo.Find(ctx, admin.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal(model.UserRole, demoted.Role)
+}
+
+func TestUpdateUser_Outcomes(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+
+	account, admin, _ := createTestAccount(t, e)
+	userRepo := repository.NewUserRepository(e.db)
+
+	missing := model.NewUser("missing@account.com", model.AdminRole, model.Credentials{}, account)
+	err := userRepo.Update(ctx, missing)
+	assert.True(errors.Is(err, repository.ErrUserNotFound))
+
+	missing.Role = model.UserRole
+	err = userRepo.Update(ctx, missing)
+	assert.True(errors.Is(err, repository.ErrUserNotFound))
+
+	admin.Active = false
+	err = userRepo.Update(ctx, admin)
+	assert.True(errors.Is(err, repository.ErrLastActiveAdmin))
+
+	stored, exists, err := userRepo.Find(ctx, admin.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.True(stored.Active)
+}
+
+func TestUpdateUserRole_BadRequest(t *testing.T) {
+	assert := assert.New(t)
+	e, _ := createTestEnv()
+	server := newServer(e)
+
+	_, admin, user := createTestAccount(t, e)
+	path := fmt.Sprintf("/v1/users/%s/role", user.ID)
+
+	req := createTestRequest(path, http.MethodPut, admin.JWTUser(), model.UserRoleRequest{Role: "OWNER"})
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodPut, admin.JWTUser(), model.UserRoleRequest{})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(path, http.MethodPut, admin.JWTUser(), "not-a-role-request")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestRequest(fmt.Sprintf("/v1/users/%s/role", id.New()), http.MethodPut, admin.JWTUser(), model.UserRoleRequest{Role: model.AdminRole})
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func TestDeactivateUser(t *testing.T) {
+	assert := assert.New(t)
+	e, ctx := createTestEnv()
+	server := newServer(e)
+
+	adminCredentials := model.AuthenticationRequest{
+		AccountName: "test-account",
+		Email:       "admin@mail.com",
+		Password:    "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	admin := signupTestUser(t, server, adminCredentials)
+
+	userCredentials := model.AuthenticationRequest{
+		AccountName: "test-account",
+		Email:       "user@mail.com",
+		Password:    "a5f3feccb16822dcfaa50c9fba91cab3",
+	}
+	user := signupTestUser(t, server, userCredentials)
+	assert.Equal(model.UserRole, user.Role)
+	_, otherAdmin, _ := createTestAccount(t, e)
+
+	path := fmt.Sprintf("/v1/users/%s/deactivation", user.ID)
+	req := createTestRequest(path, http.MethodPost, otherAdmin.JWTUser(), nil)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var rBody model.User
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
+	assert.NoError(err)
+	assert.Equal(user.ID, rBody.ID)
+	assert.False(rBody.Active)
+
+	userRepo := repository.NewUserRepository(e.db)
+	stored, exists, err := userRepo.Find(ctx, user.ID)
+	assert.NoError(err)
+	assert.True(exists)
+	assert.False(stored.Active)
+
+	auditRepo := repository.NewAuditEventRepository(e.db)
+	events, err := auditRepo.FindByResource(ctx, fmt.Sprintf("webca:api-server:user:%s", user.ID))
+	assert.NoError(err)
+	assert.Equal("UPDATE", events[len(events)-1].Activity)
+	assert.Equal(admin.ID, events[len(events)-1].UserID)
+
+	// The deactivated user can neither log in nor use its unexpired token.
+	req = createUnauthenticatedTestRequest("/v1/login", http.MethodPost, userCredentials)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(fmt.Sprintf("/v1/users/%s", user.ID), http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(fmt.Sprintf("/v1/accounts/%s", user.Account.ID), http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest("/v1/certificate-profiles", http.MethodGet, user.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestRequest(path, http.MethodPost, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	// The last active admin cannot be deactivated.
+	adminPath := fmt.Sprintf("/v1/users/%s/deactivation", admin.ID)
+	req = createTestRequest(adminPath, http.MethodPost, admin.JWTUser(), nil)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusConflict, res.Code)
+
+	req = createUnauthenticatedTestRequest("/v1/login", http.MethodPost, adminCredentials)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+}
+
+func TestUserManagement_BadContentType(t *testing.T) {
+	testBadContentType(t, "/v1/accounts/some-id/users", http.MethodGet, model.AdminRole)
+	testBadContentType(t, "/v1/users/some-id/role", http.MethodPut, model.AdminRole)
+	testBadContentType(t, "/v1/users/some-id/deactivation", http.MethodPost, model.AdminRole)
+}
+
+func TestUserManagement_UnauthorizedAndForbidden(t *testing.T) {
+	routes := []struct {
+		path   string
+		method string
+	}{
+		{path: "/v1/accounts/some-id/users", method: http.MethodGet},
+		{path: "/v1/users/some-id/role", method: http.MethodPut},
+		{path: "/v1/users/some-id/deactivation", method: http.MethodPost},
+	}
+
+	for _, r := range routes {
+		testUnauthorized(t, r.path, r.method)
+		testForbidden(t, r.path, r.method, []string{
+			jwt.AnonymousRole,
+			model.UserRole,
+		})
+	}
+}
+
+func signupTestUser(t *testing.T, server *http.Server, body model.AuthenticationRequest) model.User {
+	assert := assert.New(t)
+
+	req := createUnauthenticatedTestRequest("/v1/signup", http.MethodPost, body)
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var rBody model.AuthenticationResponse
+	err := json.NewDecoder(res.Result().Body).Decode(&rBody)
+	assert.NoError(err)
+
+	return rBody.User
+}
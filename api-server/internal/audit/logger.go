@@ -16,12 +16,16 @@ var log = logger.GetDefaultLogger("api-server/audit")
 const (
 	CreateActivity = "CREATE"
 	ReadActivity   = "READ"
+	UpdateActivity = "UPDATE"
+	DeleteActivity = "DELETE"
 )
 
 // Logger interface for logging of AuditEvents.
 type Logger interface {
 	Create(ctx context.Context, userID, resourcePattern string, args ...interface{})
 	Read(ctx context.Context, userID, resourcePattern string, args ...interface{})
+	Update(ctx context.Context, userID, resourcePattern string, args ...interface{})
+	Delete(ctx context.Context, userID, resourcePattern string, args ...interface{})
 	Log(ctx context.Context, event model.AuditEvent)
 }
 
@@ -48,6 +52,16 @@ func (l *dbLogger) Read(ctx context.Context, userID, resourcePattern string, arg
 	l.Log(ctx, event)
 }
 
+func (l *dbLogger) Update(ctx context.Context, userID, resourcePattern string, args ...interface{}) {
+	event := l.createEvent(userID, UpdateActivity, resourcePattern, args...)
+	l.Log(ctx, event)
+}
+
+func (l *dbLogger) Delete(ctx context.Context, userID, resourcePattern string, args ...interface{}) {
+	event := l.createEvent(userID, DeleteActivity, resourcePattern, args...)
+	l.Log(ctx, event)
+}
+
 func (l *dbLogger) Log(ctx context.Context, event model.AuditEvent) {
 	log.Info(event.String())
 	err := l.repo.Save(ctx, event)
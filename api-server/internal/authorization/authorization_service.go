@@ -32,17 +32,12 @@ func (s *Service) AssertAccountAccess(ctx context.Context, principal jwt.User, a
 	span, ctx := opentracing.StartSpanFromContext(ctx, "authorization_service_assert_account_access")
 	defer span.Finish()
 
-	user, err := s.findUser(ctx, principal.ID)
+	user, err := s.findPrincipal(ctx, principal)
 	if err != nil {
 		return err
 	}
 
-	if user.Account.ID != accountID {
-		err = fmt.Errorf("%s is not alowed to access certificates for account(id=%s)", user, accountID)
-		return httputil.ForbiddenError(err)
-	}
-
-	return nil
+	return assertAccountMembership(user, accountID)
 }
 
 // AssertUserAccess assert a principals access to a user.
@@ -56,7 +51,7 @@ func (s *Service) AssertUserAccess(ctx context.Context, principal jwt.User, user
 	}
 
 	if principal.ID == userID {
-		return nil
+		return s.assertSelfAccess(ctx, principal)
 	}
 
 	if !principal.HasRole(model.AdminRole) {
@@ -72,6 +67,51 @@ func (s *Service) AssertUserAccess(ctx context.Context, principal jwt.User, user
 	return s.AssertAccountAccess(ctx, principal, user.Account.ID)
 }
 
+// assertSelfAccess asserts that a principal is still allowed to act as itself.
+// Users that do not exist are left for the caller to report as not found.
+func (s *Service) assertSelfAccess(ctx context.Context, principal jwt.User) error {
+	user, exists, err := s.userRepo.Find(ctx, principal.ID)
+	if err != nil {
+		return httputil.InternalServerError(err)
+	}
+
+	if !exists {
+		return nil
+	}
+
+	return assertCurrentPrincipal(principal, user)
+}
+
+func (s *Service) findPrincipal(ctx context.Context, principal jwt.User) (model.User, error) {
+	user, err := s.findUser(ctx, principal.ID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	err = assertCurrentPrincipal(principal, user)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return user, nil
+}
+
+// assertCurrentPrincipal rejects deactivated users and principals holding a role
+// that the user no longer has, even though their token is still valid.
+func assertCurrentPrincipal(principal jwt.User, user model.User) error {
+	if !user.Active {
+		err := fmt.Errorf("%s has been deactivated", user)
+		return httputil.UnauthorizedError(err)
+	}
+
+	if !principal.HasRole(user.Role) {
+		err := fmt.Errorf("%s does not hold the current role of %s", principal, user)
+		return httputil.UnauthorizedError(err)
+	}
+
+	return nil
+}
+
 func (s *Service) findUser(ctx context.Context, userID string) (model.User, error) {
 	user, exists, err := s.userRepo.Find(ctx, userID)
 	if err != nil {
@@ -86,6 +126,15 @@ func (s *Service) findUser(ctx context.Context, userID string) (model.User, erro
 	return user, nil
 }
 
+func assertAccountMembership(user model.User, accountID string) error {
+	if user.Account.ID != accountID {
+		err := fmt.Errorf("%s is not alowed to access certificates for account(id=%s)", user, accountID)
+		return httputil.ForbiddenError(err)
+	}
+
+	return nil
+}
+
 func assertUserAccessRole(principal jwt.User) error {
 	for _, role := range userAccessRoles {
 		if principal.HasRole(role) {
@@ -0,0 +1,242 @@
+package ecdsautil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+)
+
+// Algorithm name of the ECDSA algorithm.
+const Algorithm = "ECDSA"
+
+// Supported curves
+const (
+	P256 = "P-256"
+	P384 = "P-384"
+	P521 = "P-521"
+)
+
+const (
+	publicKeyType       = "PUBLIC KEY"
+	privateKeyType      = "EC PRIVATE KEY"
+	pkcs8PrivateKeyType = "PRIVATE KEY"
+)
+
+var curves = map[string]elliptic.Curve{
+	P256: elliptic.P256(),
+	P384: elliptic.P384(),
+	P521: elliptic.P521(),
+}
+
+// options options for generation of an ECDSA key.
+type options struct {
+	curve     string
+	algorithm string
+}
+
+func (o options) String() string {
+	return fmt.Sprintf("options(algorithm=%s, curve=%s)", o.algorithm, o.curve)
+}
+
+// KeyPair asymmetric key pair of a ECDSA public and private keys.
+type KeyPair struct {
+	publicKey  *ecdsa.PublicKey
+	privateKey *ecdsa.PrivateKey
+	options
+}
+
+// Encode encodes and ECDSA keypair into a serialized KeyPair.
+// The public key is encoded as PKIX and the private key as SEC1.
+func (p KeyPair) Encode() model.KeyPair {
+	pub, err := x509.MarshalPKIXPublicKey(p.publicKey)
+	if err != nil {
+		panic(fmt.Errorf("ecdsautil: failed to marshal public key: %w", err))
+	}
+
+	priv, err := x509.MarshalECPrivateKey(p.privateKey)
+	if err != nil {
+		panic(fmt.Errorf("ecdsautil: failed to marshal private key: %w", err))
+	}
+
+	return model.KeyPair{
+		ID:         id.New(),
+		PublicKey:  encodePem(publicKeyType, pub),
+		PrivateKey: encodePem(privateKeyType, priv),
+		Format:     "PEM",
+		Algorithm:  Algorithm,
+		CreatedAt:  timeutil.Now(),
+	}
+}
+
+// PublicKey provides external access to the public key.
+func (p KeyPair) PublicKey() interface{} {
+	return p.publicKey
+}
+
+// PrivateKey provides external access to the private key.
+func (p KeyPair) PrivateKey() interface{} {
+	return p.privateKey
+}
+
+// Decode decodes a PEM formated keypair into a ECDSA keypair,
+// the private key may be encoded either as SEC1 or PKCS#8.
+func Decode(keyPair model.KeyPair) (KeyPair, error) {
+	pub, err := decodePublicKey(keyPair.PublicKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	priv, err := decodePrivateKey(keyPair.PrivateKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	return KeyPair{
+		publicKey:  pub,
+		privateKey: priv,
+		options: options{
+			curve:     priv.Curve.Params().Name,
+			algorithm: Algorithm,
+		},
+	}, nil
+}
+
+func decodePublicKey(s string) (*ecdsa.PublicKey, error) {
+	block, err := decodePem(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != publicKeyType {
+		return nil, fmt.Errorf("expected blocktype '%s' but got '%s'", publicKeyType, block.Type)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected ecdsa public key but got %T", key)
+	}
+
+	return pub, nil
+}
+
+func decodePrivateKey(s string) (*ecdsa.PrivateKey, error) {
+	block, err := decodePem(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case privateKeyType:
+		return x509.ParseECPrivateKey(block.Bytes)
+	case pkcs8PrivateKeyType:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("expected ecdsa private key but got %T", key)
+		}
+
+		return priv, nil
+	default:
+		return nil, fmt.Errorf("expected blocktype '%s' or '%s' but got '%s'", privateKeyType, pkcs8PrivateKeyType, block.Type)
+	}
+}
+
+// GenerateKeys parses key options and generates an ECDSA KeyPair.
+func GenerateKeys(req model.KeyRequest) (KeyPair, error) {
+	opts, err := parseOptions(req)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(curves[opts.curve], rand.Reader)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("ecdsautil: failed to generate ECDSA key options=%s: %w", opts, err)
+	}
+
+	return KeyPair{
+		publicKey:  &key.PublicKey,
+		privateKey: key,
+		options:    opts,
+	}, nil
+}
+
+// FromPrivateKey creates an ECDSA KeyPair from an existing private key on one of the supported curves.
+func FromPrivateKey(key *ecdsa.PrivateKey) (KeyPair, error) {
+	curve := key.Curve.Params().Name
+	if _, ok := curves[curve]; !ok {
+		return KeyPair{}, fmt.Errorf("ecdsautil: unsupported curve=%s", curve)
+	}
+
+	return KeyPair{
+		publicKey:  &key.PublicKey,
+		privateKey: key,
+		options: options{
+			curve:     curve,
+			algorithm: Algorithm,
+		},
+	}, nil
+}
+
+func parseOptions(req model.KeyRequest) (options, error) {
+	if req.Algorithm != Algorithm {
+		return options{}, fmt.Errorf("ecdsautil: unsupported KeyRequest.Algorithm=%s", req.Algorithm)
+	}
+
+	if req.Options == nil {
+		return options{}, fmt.Errorf("ecdsautil: KeyRequest.Options cannot be nil")
+	}
+
+	val, ok := req.Options["curve"]
+	if !ok {
+		return options{}, fmt.Errorf("ecdsautil: KeyRequest.Options must contain curve")
+	}
+
+	curve, ok := val.(string)
+	if !ok {
+		return options{}, fmt.Errorf("ecdsautil: KeyRequest.Options.curve must be a string got value=%v type=%T", val, val)
+	}
+
+	if _, ok := curves[curve]; !ok {
+		return options{}, fmt.Errorf("ecdsautil: unsupported KeyRequest.Options.curve=%s", curve)
+	}
+
+	return options{
+		curve:     curve,
+		algorithm: req.Algorithm,
+	}, nil
+}
+
+func encodePem(blockType string, b []byte) string {
+	block := &pem.Block{
+		Type:  blockType,
+		Bytes: b,
+	}
+
+	return string(pem.EncodeToMemory(block))
+}
+
+func decodePem(s string) (*pem.Block, error) {
+	b := []byte(s)
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem block input: %s", s)
+	}
+
+	return block, nil
+}
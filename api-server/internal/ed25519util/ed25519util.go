@@ -0,0 +1,168 @@
+package ed25519util
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+)
+
+// Algorithm name of the Ed25519 algorithm.
+const Algorithm = "ED25519"
+
+const (
+	publicKeyType  = "PUBLIC KEY"
+	privateKeyType = "PRIVATE KEY"
+)
+
+// KeyPair asymmetric key pair of a Ed25519 public and private keys.
+type KeyPair struct {
+	publicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// Encode encodes and Ed25519 keypair into a serialized KeyPair.
+// The public key is encoded as PKIX and the private key as PKCS#8.
+func (p KeyPair) Encode() model.KeyPair {
+	pub, err := x509.MarshalPKIXPublicKey(p.publicKey)
+	if err != nil {
+		panic(fmt.Errorf("ed25519util: failed to marshal public key: %w", err))
+	}
+
+	priv, err := x509.MarshalPKCS8PrivateKey(p.privateKey)
+	if err != nil {
+		panic(fmt.Errorf("ed25519util: failed to marshal private key: %w", err))
+	}
+
+	return model.KeyPair{
+		ID:         id.New(),
+		PublicKey:  encodePem(publicKeyType, pub),
+		PrivateKey: encodePem(privateKeyType, priv),
+		Format:     "PEM",
+		Algorithm:  Algorithm,
+		CreatedAt:  timeutil.Now(),
+	}
+}
+
+// PublicKey provides external access to the public key.
+func (p KeyPair) PublicKey() interface{} {
+	return p.publicKey
+}
+
+// PrivateKey provides external access to the private key.
+func (p KeyPair) PrivateKey() interface{} {
+	return p.privateKey
+}
+
+// Decode decodes a PEM formated keypair into a Ed25519 keypair
+func Decode(keyPair model.KeyPair) (KeyPair, error) {
+	pub, err := decodePublicKey(keyPair.PublicKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	priv, err := decodePrivateKey(keyPair.PrivateKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	return KeyPair{
+		publicKey:  pub,
+		privateKey: priv,
+	}, nil
+}
+
+func decodePublicKey(s string) (ed25519.PublicKey, error) {
+	block, err := decodePem(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != publicKeyType {
+		return nil, fmt.Errorf("expected blocktype '%s' but got '%s'", publicKeyType, block.Type)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected ed25519 public key but got %T", key)
+	}
+
+	return pub, nil
+}
+
+func decodePrivateKey(s string) (ed25519.PrivateKey, error) {
+	block, err := decodePem(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != privateKeyType {
+		return nil, fmt.Errorf("expected blocktype '%s' but got '%s'", privateKeyType, block.Type)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected ed25519 private key but got %T", key)
+	}
+
+	return priv, nil
+}
+
+// GenerateKeys generates an Ed25519 KeyPair, Ed25519 keys have a fixed size and take no options.
+func GenerateKeys(req model.KeyRequest) (KeyPair, error) {
+	if req.Algorithm != Algorithm {
+		return KeyPair{}, fmt.Errorf("ed25519util: unsupported KeyRequest.Algorithm=%s", req.Algorithm)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("ed25519util: failed to generate Ed25519 key: %w", err)
+	}
+
+	return KeyPair{
+		publicKey:  pub,
+		privateKey: priv,
+	}, nil
+}
+
+// FromPrivateKey creates an Ed25519 KeyPair from an existing private key.
+func FromPrivateKey(key ed25519.PrivateKey) KeyPair {
+	return KeyPair{
+		publicKey:  key.Public().(ed25519.PublicKey),
+		privateKey: key,
+	}
+}
+
+func encodePem(blockType string, b []byte) string {
+	block := &pem.Block{
+		Type:  blockType,
+		Bytes: b,
+	}
+
+	return string(pem.EncodeToMemory(block))
+}
+
+func decodePem(s string) (*pem.Block, error) {
+	b := []byte(s)
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem block input: %s", s)
+	}
+
+	return block, nil
+}
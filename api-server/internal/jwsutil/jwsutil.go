@@ -0,0 +1,244 @@
+package jwsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // needed for ES384 and ES512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Supported signature algorithms
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	ES384 = "ES384"
+	ES512 = "ES512"
+)
+
+// ErrUnsupportedAlgorithm returned for messages signed with an algorithm that is not supported.
+var ErrUnsupportedAlgorithm = errors.New("jwsutil: unsupported algorithm")
+
+type algorithm struct {
+	hash  crypto.Hash
+	curve elliptic.Curve
+}
+
+var algorithms = map[string]algorithm{
+	RS256: {hash: crypto.SHA256},
+	ES256: {hash: crypto.SHA256, curve: elliptic.P256()},
+	ES384: {hash: crypto.SHA384, curve: elliptic.P384()},
+	ES512: {hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// Message JWS in the flattened JSON serialization used by ACME (RFC 8555 section 6.2).
+type Message struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// Header protected header of a JWS message.
+type Header struct {
+	Algorithm string          `json:"alg"`
+	JWK       json.RawMessage `json:"jwk,omitempty"`
+	KeyID     string          `json:"kid,omitempty"`
+	Nonce     string          `json:"nonce"`
+	URL       string          `json:"url"`
+}
+
+func (h Header) String() string {
+	return fmt.Sprintf("Header(alg=%s, kid=%s, nonce=%s, url=%s)", h.Algorithm, h.KeyID, h.Nonce, h.URL)
+}
+
+// Parse parses a JWS message in flattened JSON serialization and decodes its protected header.
+func Parse(body []byte) (Message, Header, error) {
+	var msg Message
+	err := json.Unmarshal(body, &msg)
+	if err != nil {
+		return Message{}, Header{}, fmt.Errorf("jwsutil: failed to parse message: %w", err)
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return Message{}, Header{}, fmt.Errorf("jwsutil: failed to decode protected header: %w", err)
+	}
+
+	var header Header
+	err = json.Unmarshal(b, &header)
+	if err != nil {
+		return Message{}, Header{}, fmt.Errorf("jwsutil: failed to parse protected header: %w", err)
+	}
+
+	if _, ok := algorithms[header.Algorithm]; !ok {
+		return Message{}, Header{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, header.Algorithm)
+	}
+
+	return msg, header, nil
+}
+
+// Verify verifies the signature of a message with a public key and returns the decoded payload.
+func (m Message) Verify(alg string, key crypto.PublicKey) ([]byte, error) {
+	a, ok := algorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("jwsutil: failed to decode signature: %w", err)
+	}
+
+	h := a.hash.New()
+	h.Write([]byte(m.Protected + "." + m.Payload))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if a.curve != nil {
+			return nil, fmt.Errorf("jwsutil: algorithm %s cannot be used with an RSA key", alg)
+		}
+
+		err = rsa.VerifyPKCS1v15(pub, a.hash, digest, sig)
+		if err != nil {
+			return nil, fmt.Errorf("jwsutil: invalid signature: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		if a.curve != pub.Curve {
+			return nil, fmt.Errorf("jwsutil: algorithm %s cannot be used with an ECDSA %s key", alg, pub.Params().Name)
+		}
+
+		size := (pub.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return nil, fmt.Errorf("jwsutil: invalid signature length: %d", len(sig))
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return nil, fmt.Errorf("jwsutil: invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("jwsutil: unsupported key type: %T", key)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(m.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("jwsutil: failed to decode payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// ParseJWK parses an RSA or ECDSA public key in JWK format (RFC 7517).
+func ParseJWK(b []byte) (crypto.PublicKey, error) {
+	var k jwk
+	err := json.Unmarshal(b, &k)
+	if err != nil {
+		return nil, fmt.Errorf("jwsutil: failed to parse jwk: %w", err)
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("jwsutil: invalid rsa exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Curve]
+		if !ok {
+			return nil, fmt.Errorf("jwsutil: unsupported curve: %s", k.Curve)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("jwsutil: point is not on curve %s", k.Curve)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("jwsutil: unsupported key type: %s", k.KeyType)
+	}
+}
+
+// EncodeJWK encodes an RSA or ECDSA public key as a JWK containing only the required members in lexicographic order,
+// which is the form used to compute thumbprints.
+func EncodeJWK(key crypto.PublicKey) (string, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(pub.E))
+		return fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, encodeBytes(e.Bytes()), encodeBytes(pub.N.Bytes())), nil
+	case *ecdsa.PublicKey:
+		size := (pub.Params().BitSize + 7) / 8
+		x := pub.X.FillBytes(make([]byte, size))
+		y := pub.Y.FillBytes(make([]byte, size))
+		return fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, pub.Params().Name, encodeBytes(x), encodeBytes(y)), nil
+	default:
+		return "", fmt.Errorf("jwsutil: unsupported key type: %T", key)
+	}
+}
+
+// Thumbprint computes the SHA-256 JWK thumbprint (RFC 7638) of a public key.
+func Thumbprint(key crypto.PublicKey) (string, error) {
+	encoded, err := EncodeJWK(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(encoded))
+	return encodeBytes(sum[:]), nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("jwsutil: invalid jwk parameter: %q", s)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func encodeBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
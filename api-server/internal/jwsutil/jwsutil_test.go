@@ -0,0 +1,183 @@
+package jwsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+)
+
+func TestParseAndVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(err)
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(err)
+
+	keys := map[string]crypto.Signer{
+		RS256: rsaKey,
+		ES256: p256Key,
+		ES384: p384Key,
+		ES512: p521Key,
+	}
+
+	for alg, key := range keys {
+		body := createTestMessage(t, alg, key, `{"hello":"world"}`)
+		msg, header, err := Parse(body)
+		assert.NoError(err)
+		assert.Equal(alg, header.Algorithm)
+		assert.Equal("nonce-value", header.Nonce)
+		assert.Equal("https://webca.io/api/v1/acme/ca-id/new-account", header.URL)
+		assert.Empty(header.KeyID)
+
+		pub, err := ParseJWK(header.JWK)
+		assert.NoError(err)
+
+		payload, err := msg.Verify(header.Algorithm, pub)
+		assert.NoError(err)
+		assert.Equal(`{"hello":"world"}`, string(payload))
+
+		msg.Payload = base64.RawURLEncoding.EncodeToString([]byte(`{"hello":"mallory"}`))
+		_, err = msg.Verify(header.Algorithm, pub)
+		assert.Error(err)
+	}
+
+	// Signature algorithm must match the key.
+	body := createTestMessage(t, ES256, p256Key, "")
+	msg, _, err := Parse(body)
+	assert.NoError(err)
+	_, err = msg.Verify(ES384, p256Key.Public())
+	assert.Error(err)
+	_, err = msg.Verify(RS256, p256Key.Public())
+	assert.Error(err)
+	_, err = msg.Verify(ES256, rsaKey.Public())
+	assert.Error(err)
+	payload, err := msg.Verify(ES256, p256Key.Public())
+	assert.NoError(err)
+	assert.Empty(payload)
+}
+
+func TestParse_BadMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := Parse([]byte("not json"))
+	assert.Error(err)
+
+	_, _, err = Parse([]byte(`{"protected": "%%%", "payload": "", "signature": ""}`))
+	assert.Error(err)
+
+	for _, alg := range []string{"none", "HS256", "PS256", ""} {
+		protected := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"alg":%q,"nonce":"n","url":"u"}`, alg)))
+		_, _, err = Parse([]byte(fmt.Sprintf(`{"protected": %q, "payload": "", "signature": ""}`, protected)))
+		assert.True(errors.Is(err, ErrUnsupportedAlgorithm), alg)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	assert := assert.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(err)
+
+	for _, key := range []crypto.PublicKey{rsaKey.Public(), ecKey.Public()} {
+		thumbprint, err := Thumbprint(key)
+		assert.NoError(err)
+		expected, err := acme.JWKThumbprint(key)
+		assert.NoError(err)
+		assert.Equal(expected, thumbprint)
+
+		encoded, err := EncodeJWK(key)
+		assert.NoError(err)
+		parsed, err := ParseJWK([]byte(encoded))
+		assert.NoError(err)
+		assert.Equal(key, parsed)
+	}
+
+	// Example from RFC 7638 section 3.1.
+	rfcKey, err := ParseJWK([]byte(`{
+		"kty": "RSA",
+		"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		"e": "AQAB",
+		"alg": "RS256",
+		"kid": "2011-04-29"
+	}`))
+	assert.NoError(err)
+	thumbprint, err := Thumbprint(rfcKey)
+	assert.NoError(err)
+	assert.Equal("NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
+
+func TestParseJWK_BadKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	badKeys := []string{
+		"not json",
+		`{"kty": "oct", "k": "c2VjcmV0"}`,
+		`{"kty": "RSA", "n": "", "e": "AQAB"}`,
+		`{"kty": "RSA", "n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc", "e": "AQ"}`,
+		`{"kty": "EC", "crv": "P-192", "x": "AQ", "y": "AQ"}`,
+		`{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}`,
+	}
+
+	for _, key := range badKeys {
+		_, err := ParseJWK([]byte(key))
+		assert.Error(err, key)
+	}
+}
+
+func createTestMessage(t *testing.T, alg string, key crypto.Signer, payload string) []byte {
+	assert := assert.New(t)
+
+	encodedKey, err := EncodeJWK(key.Public())
+	assert.NoError(err)
+
+	header := fmt.Sprintf(`{"alg":%q,"jwk":%s,"nonce":"nonce-value","url":"https://webca.io/api/v1/acme/ca-id/new-account"}`, alg, encodedKey)
+	protected := base64.RawURLEncoding.EncodeToString([]byte(header))
+	encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	h := algorithms[alg].hash.New()
+	h.Write([]byte(protected + "." + encodedPayload))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, algorithms[alg].hash, digest)
+		assert.NoError(err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		assert.NoError(err)
+		size := (k.Params().BitSize + 7) / 8
+		sig = append(padBytes(r, size), padBytes(s, size)...)
+	}
+
+	body, err := json.Marshal(Message{
+		Protected: protected,
+		Payload:   encodedPayload,
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	})
+	assert.NoError(err)
+
+	return body
+}
+
+func padBytes(n *big.Int, size int) []byte {
+	return n.FillBytes(make([]byte, size))
+}
@@ -0,0 +1,362 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ACME object statuses as defined in RFC 8555 section 7.1.6.
+const (
+	ACMEStatusPending     = "pending"
+	ACMEStatusReady       = "ready"
+	ACMEStatusProcessing  = "processing"
+	ACMEStatusValid       = "valid"
+	ACMEStatusInvalid     = "invalid"
+	ACMEStatusDeactivated = "deactivated"
+)
+
+// ACME challenge types
+const (
+	HTTP01Challenge = "http-01"
+	DNS01Challenge  = "dns-01"
+)
+
+// ACMEDNSIdentifier the only ACME identifier type supported.
+const ACMEDNSIdentifier = "dns"
+
+// nonPublicDomains domains reserved for local, private or special use. Names under them
+// cannot be publicly owned, so validating them would only probe the network of the server,
+// unless the account admin has allowed them for the ACME directory of a certificate authority.
+var nonPublicDomains = []string{
+	"localhost", "localdomain", "local", "lan", "internal", "intranet", "corp", "home", "home.arpa", "arpa",
+	"test", "example", "invalid", "onion",
+}
+
+// ACME error types as defined in RFC 8555 section 6.7.
+const (
+	ACMEAccountDoesNotExist     = "urn:ietf:params:acme:error:accountDoesNotExist"
+	ACMEBadCSR                  = "urn:ietf:params:acme:error:badCSR"
+	ACMEBadNonce                = "urn:ietf:params:acme:error:badNonce"
+	ACMEBadSignatureAlgorithm   = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	ACMEConnection              = "urn:ietf:params:acme:error:connection"
+	ACMEDNS                     = "urn:ietf:params:acme:error:dns"
+	ACMEIncorrectResponse       = "urn:ietf:params:acme:error:incorrectResponse"
+	ACMEMalformed               = "urn:ietf:params:acme:error:malformed"
+	ACMEOrderNotReady           = "urn:ietf:params:acme:error:orderNotReady"
+	ACMERateLimited             = "urn:ietf:params:acme:error:rateLimited"
+	ACMERejectedIdentifier      = "urn:ietf:params:acme:error:rejectedIdentifier"
+	ACMEServerInternal          = "urn:ietf:params:acme:error:serverInternal"
+	ACMEUnauthorized            = "urn:ietf:params:acme:error:unauthorized"
+	ACMEUnsupportedContact      = "urn:ietf:params:acme:error:unsupportedContact"
+	ACMEUnsupportedIdentifier   = "urn:ietf:params:acme:error:unsupportedIdentifier"
+	ACMEExternalAccountRequired = "urn:ietf:params:acme:error:externalAccountRequired"
+)
+
+// ACMEProblem problem document (RFC 7807) describing an ACME error.
+type ACMEProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// NewACMEProblem creates an ACMEProblem, the http status is derived from the problem type.
+func NewACMEProblem(problemType, detailPattern string, args ...interface{}) *ACMEProblem {
+	status := http.StatusBadRequest
+	switch problemType {
+	case ACMEUnauthorized:
+		status = http.StatusUnauthorized
+	case ACMEOrderNotReady:
+		status = http.StatusForbidden
+	case ACMERateLimited:
+		status = http.StatusTooManyRequests
+	case ACMEServerInternal:
+		status = http.StatusInternalServerError
+	}
+
+	return &ACMEProblem{
+		Type:   problemType,
+		Detail: fmt.Sprintf(detailPattern, args...),
+		Status: status,
+	}
+}
+
+func (p *ACMEProblem) Error() string {
+	return fmt.Sprintf("ACMEProblem(type=%s, detail=%s, status=%d)", p.Type, p.Detail, p.Status)
+}
+
+// ACMERequest JWS signed request to an ACME resource of a certificate authority.
+// URL is the full url the request was sent to, which must match the url in the protected header.
+type ACMERequest struct {
+	CertificateID string
+	ResourceID    string
+	URL           string
+	Body          []byte
+}
+
+func (r ACMERequest) String() string {
+	return fmt.Sprintf("ACMERequest(certificateId=%s, resourceId=%s, url=%s)", r.CertificateID, r.ResourceID, r.URL)
+}
+
+// ACMEDirectory ACME resource urls of a certificate authority.
+type ACMEDirectory struct {
+	NewNonce   string            `json:"newNonce"`
+	NewAccount string            `json:"newAccount"`
+	NewOrder   string            `json:"newOrder"`
+	Meta       ACMEDirectoryMeta `json:"meta"`
+}
+
+// ACMEEnrollment record of an account admin enabling the ACME directory of a certificate authority.
+// AllowedDomains are private domains that may be ordered despite not being publicly resolvable and
+// AllowedNetworks are private address ranges that http-01 validation may connect to.
+type ACMEEnrollment struct {
+	CertificateID   string    `json:"certificateId"`
+	DirectoryURL    string    `json:"directoryUrl"`
+	AllowedDomains  []string  `json:"allowedDomains"`
+	AllowedNetworks []string  `json:"allowedNetworks"`
+	CreatedBy       string    `json:"createdBy"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+func (e ACMEEnrollment) String() string {
+	return fmt.Sprintf(
+		"ACMEEnrollment(certificateId=%s, allowedDomains=%v, allowedNetworks=%v, createdBy=%s, createdAt=%v)",
+		e.CertificateID, e.AllowedDomains, e.AllowedNetworks, e.CreatedBy, e.CreatedAt,
+	)
+}
+
+// ACMEEnrollmentRequest request to enable the ACME directory of a certificate authority.
+type ACMEEnrollmentRequest struct {
+	CertificateID   string   `json:"-"`
+	AllowedDomains  []string `json:"allowedDomains,omitempty"`
+	AllowedNetworks []string `json:"allowedNetworks,omitempty"`
+}
+
+// Normalize validates the allowed domains and networks and returns them in their canonical form.
+func (r ACMEEnrollmentRequest) Normalize() (ACMEEnrollmentRequest, error) {
+	domains := make([]string, 0, len(r.AllowedDomains))
+	for _, domain := range r.AllowedDomains {
+		normalized, err := normalizeDomain(strings.TrimSuffix(domain, "."))
+		if err != nil {
+			return ACMEEnrollmentRequest{}, fmt.Errorf("invalid allowed domain %q: %w", domain, err)
+		}
+
+		if net.ParseIP(normalized) != nil {
+			return ACMEEnrollmentRequest{}, fmt.Errorf("invalid allowed domain %q: ip addresses are not domains", domain)
+		}
+		domains = append(domains, normalized)
+	}
+
+	networks := make([]string, 0, len(r.AllowedNetworks))
+	for _, network := range r.AllowedNetworks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return ACMEEnrollmentRequest{}, fmt.Errorf("invalid allowed network %q: %w", network, err)
+		}
+		networks = append(networks, ipNet.String())
+	}
+
+	return ACMEEnrollmentRequest{
+		CertificateID:   r.CertificateID,
+		AllowedDomains:  domains,
+		AllowedNetworks: networks,
+	}, nil
+}
+
+// ACMEDirectoryMeta metadata about an ACME directory.
+type ACMEDirectoryMeta struct {
+	Website                 string `json:"website,omitempty"`
+	ExternalAccountRequired bool   `json:"externalAccountRequired"`
+}
+
+// ACMEAccount account registered by an ACME client with a certificate authority, identified by its public key.
+type ACMEAccount struct {
+	ID            string    `json:"-"`
+	CertificateID string    `json:"-"`
+	Status        string    `json:"status"`
+	Contact       []string  `json:"contact,omitempty"`
+	PublicKey     string    `json:"-"`
+	KeyThumbprint string    `json:"-"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+func (a ACMEAccount) String() string {
+	return fmt.Sprintf("ACMEAccount(id=%s, certificateId=%s, status=%s, createdAt=%v)", a.ID, a.CertificateID, a.Status, a.CreatedAt)
+}
+
+// ACMEAccountRequest payload of new-account and account update requests.
+type ACMEAccountRequest struct {
+	Contact              []string `json:"contact"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	Status               string   `json:"status"`
+}
+
+// Validate checks that all contacts are email addresses, the only contact type supported.
+func (r ACMEAccountRequest) Validate() error {
+	for _, contact := range r.Contact {
+		if !strings.HasPrefix(contact, "mailto:") {
+			return NewACMEProblem(ACMEUnsupportedContact, "unsupported contact: %s", contact)
+		}
+
+		_, err := normalizeEmailAddress(strings.TrimPrefix(contact, "mailto:"))
+		if err != nil {
+			return NewACMEProblem(ACMEUnsupportedContact, "invalid contact: %s", contact)
+		}
+	}
+
+	if r.Status != "" && r.Status != ACMEStatusDeactivated {
+		return NewACMEProblem(ACMEMalformed, "account status can only be changed to %s", ACMEStatusDeactivated)
+	}
+
+	return nil
+}
+
+// ACMEIdentifier identifier that an ACME client wants a certificate for.
+type ACMEIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ACMEOrderRequest payload of a new-order request.
+type ACMEOrderRequest struct {
+	Identifiers []ACMEIdentifier `json:"identifiers"`
+	NotBefore   string           `json:"notBefore"`
+	NotAfter    string           `json:"notAfter"`
+}
+
+// Validate checks that the requested identifiers are valid dns names and normalizes them.
+// Names that are not publicly resolvable are only accepted if they are within one of the allowed domains.
+func (r ACMEOrderRequest) Validate(allowedDomains []string) (ACMEOrderRequest, error) {
+	if len(r.Identifiers) == 0 {
+		return ACMEOrderRequest{}, NewACMEProblem(ACMEMalformed, "an order must contain at least one identifier")
+	}
+
+	if r.NotBefore != "" || r.NotAfter != "" {
+		return ACMEOrderRequest{}, NewACMEProblem(ACMEMalformed, "notBefore and notAfter are not supported")
+	}
+
+	seen := make(map[string]bool)
+	identifiers := make([]ACMEIdentifier, 0, len(r.Identifiers))
+	for _, identifier := range r.Identifiers {
+		if identifier.Type != ACMEDNSIdentifier {
+			return ACMEOrderRequest{}, NewACMEProblem(ACMEUnsupportedIdentifier, "unsupported identifier type: %s", identifier.Type)
+		}
+
+		name, err := normalizeDNSName(identifier.Value)
+		if err != nil {
+			return ACMEOrderRequest{}, NewACMEProblem(ACMERejectedIdentifier, "invalid dns name: %s", identifier.Value)
+		}
+
+		err = checkAllowedDomain(strings.TrimPrefix(name, "*."), allowedDomains)
+		if err != nil {
+			return ACMEOrderRequest{}, NewACMEProblem(ACMERejectedIdentifier, "%s: %v", identifier.Value, err)
+		}
+
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		identifiers = append(identifiers, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: name})
+	}
+
+	return ACMEOrderRequest{Identifiers: identifiers}, nil
+}
+
+// checkAllowedDomain accepts names within the allowed domains and otherwise requires the name to be public.
+func checkAllowedDomain(domain string, allowedDomains []string) error {
+	if net.ParseIP(domain) != nil {
+		return fmt.Errorf("ip addresses are not supported")
+	}
+
+	for _, allowed := range allowedDomains {
+		if matchDomainConstraint(domain, allowed) {
+			return nil
+		}
+	}
+
+	return checkPublicDomain(domain)
+}
+
+// checkPublicDomain rejects ip addresses, single label names and names under domains that are not publicly delegated.
+func checkPublicDomain(domain string) error {
+	domain = strings.TrimSuffix(domain, ".")
+	if net.ParseIP(domain) != nil {
+		return fmt.Errorf("ip addresses are not supported")
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("single label names are not publicly resolvable")
+	}
+
+	tld := labels[len(labels)-1]
+	if strings.Trim(tld, "0123456789") == "" || strings.HasPrefix(tld, "0x") {
+		return fmt.Errorf("numeric top level domains are not allowed")
+	}
+
+	for _, reserved := range nonPublicDomains {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return fmt.Errorf("names under %s are not publicly resolvable", reserved)
+		}
+	}
+
+	return nil
+}
+
+// ACMEOrder request by an ACME account for a certificate.
+type ACMEOrder struct {
+	ID             string           `json:"-"`
+	AccountID      string           `json:"-"`
+	Status         string           `json:"status"`
+	Identifiers    []ACMEIdentifier `json:"identifiers"`
+	Error          *ACMEProblem     `json:"error,omitempty"`
+	CertificateID  string           `json:"-"`
+	Authorizations []string         `json:"authorizations"`
+	FinalizeURL    string           `json:"finalize"`
+	CertificateURL string           `json:"certificate,omitempty"`
+	CreatedAt      time.Time        `json:"-"`
+	ExpiresAt      time.Time        `json:"expires"`
+}
+
+func (o ACMEOrder) String() string {
+	return fmt.Sprintf("ACMEOrder(id=%s, accountId=%s, status=%s, certificateId=%s, createdAt=%v, expiresAt=%v)", o.ID, o.AccountID, o.Status, o.CertificateID, o.CreatedAt, o.ExpiresAt)
+}
+
+// ACMEFinalizeRequest payload of a request to finalize an order.
+type ACMEFinalizeRequest struct {
+	CSR string `json:"csr"`
+}
+
+// ACMEAuthorization proof of control of an identifier that must be given by an ACME account.
+type ACMEAuthorization struct {
+	ID         string          `json:"-"`
+	OrderID    string          `json:"-"`
+	Identifier ACMEIdentifier  `json:"identifier"`
+	Status     string          `json:"status"`
+	Wildcard   bool            `json:"wildcard,omitempty"`
+	Challenges []ACMEChallenge `json:"challenges"`
+	ExpiresAt  time.Time       `json:"expires"`
+}
+
+func (a ACMEAuthorization) String() string {
+	return fmt.Sprintf("ACMEAuthorization(id=%s, orderId=%s, identifier=%s, status=%s, expiresAt=%v)", a.ID, a.OrderID, a.Identifier.Value, a.Status, a.ExpiresAt)
+}
+
+// ACMEChallenge way for an ACME account to prove control of an identifier.
+type ACMEChallenge struct {
+	ID              string       `json:"-"`
+	AuthorizationID string       `json:"-"`
+	Type            string       `json:"type"`
+	URL             string       `json:"url"`
+	Token           string       `json:"token"`
+	Status          string       `json:"status"`
+	ValidatedAt     *time.Time   `json:"validated,omitempty"`
+	Error           *ACMEProblem `json:"error,omitempty"`
+}
+
+func (c ACMEChallenge) String() string {
+	return fmt.Sprintf("ACMEChallenge(id=%s, authorizationId=%s, type=%s, status=%s)", c.ID, c.AuthorizationID, c.Type, c.Status)
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+)
+
+// Key usages that can be granted by a certificate profile.
+const (
+	DigitalSignatureUsage  = "digitalSignature"
+	ContentCommitmentUsage = "contentCommitment"
+	KeyEnciphermentUsage   = "keyEncipherment"
+	DataEnciphermentUsage  = "dataEncipherment"
+	KeyAgreementUsage      = "keyAgreement"
+)
+
+// Extended key usages that can be granted by a certificate profile.
+const (
+	ServerAuthUsage      = "serverAuth"
+	ClientAuthUsage      = "clientAuth"
+	CodeSigningUsage     = "codeSigning"
+	EmailProtectionUsage = "emailProtection"
+	TimeStampingUsage    = "timeStamping"
+	OCSPSigningUsage     = "ocspSigning"
+)
+
+// Subject alternative name types that a certificate profile can require.
+const (
+	DNSNameType      = "DNS"
+	IPAddressType    = "IP"
+	EmailAddressType = "EMAIL"
+	URIType          = "URI"
+)
+
+// KeyUsages lists the key usages that can be granted by a certificate profile.
+var KeyUsages = []string{
+	DigitalSignatureUsage,
+	ContentCommitmentUsage,
+	KeyEnciphermentUsage,
+	DataEnciphermentUsage,
+	KeyAgreementUsage,
+}
+
+// ExtKeyUsages lists the extended key usages that can be granted by a certificate profile.
+var ExtKeyUsages = []string{
+	ServerAuthUsage,
+	ClientAuthUsage,
+	CodeSigningUsage,
+	EmailProtectionUsage,
+	TimeStampingUsage,
+	OCSPSigningUsage,
+}
+
+var (
+	alternativeNameTypes = []string{DNSNameType, IPAddressType, EmailAddressType, URIType}
+	profileAlgorithms    = []string{"RSA", "ECDSA", "ED25519"}
+)
+
+// CertificateProfile reusable template of the usages, validity, keys and names allowed for certificates
+// issued within an account, e.g. for server, client, code-signing or S/MIME certificates.
+// Empty Algorithms, KeySizes and RequiredSANTypes place no restrictions on the certificate,
+// MaxValidityDays of 0 means that the validity is not limited beyond that of the signatory.
+type CertificateProfile struct {
+	ID                  string    `json:"id,omitempty"`
+	Name                string    `json:"name,omitempty"`
+	KeyUsage            []string  `json:"keyUsage"`
+	ExtKeyUsage         []string  `json:"extKeyUsage"`
+	DefaultValidityDays int       `json:"defaultValidityDays"`
+	MaxValidityDays     int       `json:"maxValidityDays"`
+	Algorithms          []string  `json:"algorithms"`
+	KeySizes            []int     `json:"keySizes"`
+	RequiredSANTypes    []string  `json:"requiredSanTypes"`
+	AccountID           string    `json:"accountId,omitempty"`
+	CreatedAt           time.Time `json:"createdAt,omitempty"`
+	UpdatedAt           time.Time `json:"updatedAt,omitempty"`
+}
+
+// NewCertificateProfile creates a new certificate profile for an account.
+func NewCertificateProfile(req CertificateProfileRequest, accountID string) CertificateProfile {
+	now := timeutil.Now()
+	profile := CertificateProfile{
+		ID:        id.New(),
+		AccountID: accountID,
+		CreatedAt: now,
+	}
+
+	return profile.Apply(req, now)
+}
+
+// Apply returns a copy of the profile with the policy of a profile request.
+func (p CertificateProfile) Apply(req CertificateProfileRequest, updatedAt time.Time) CertificateProfile {
+	p.Name = req.Name
+	p.KeyUsage = nonNilStrings(req.KeyUsage)
+	p.ExtKeyUsage = nonNilStrings(req.ExtKeyUsage)
+	p.DefaultValidityDays = req.DefaultValidityDays
+	p.MaxValidityDays = req.MaxValidityDays
+	p.Algorithms = nonNilStrings(req.Algorithms)
+	p.KeySizes = req.KeySizes
+	if p.KeySizes == nil {
+		p.KeySizes = []int{}
+	}
+	p.RequiredSANTypes = nonNilStrings(req.RequiredSANTypes)
+	p.UpdatedAt = updatedAt
+	return p
+}
+
+// AllowsAlgorithm checks if keys of an algorithm may be used with the profile.
+func (p CertificateProfile) AllowsAlgorithm(algorithm string) bool {
+	return len(p.Algorithms) == 0 || containsString(p.Algorithms, algorithm)
+}
+
+// AllowsKeySize checks if keys of a size in bits may be used with the profile.
+func (p CertificateProfile) AllowsKeySize(bits int) bool {
+	if len(p.KeySizes) == 0 {
+		return true
+	}
+
+	for _, size := range p.KeySizes {
+		if size == bits {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AssertRequiredNames checks that alternative names of every type required by the profile are present.
+func (p CertificateProfile) AssertRequiredNames(names SubjectAlternativeNames) error {
+	for _, sanType := range p.RequiredSANTypes {
+		var present bool
+		switch sanType {
+		case DNSNameType:
+			present = len(names.DNSNames) > 0
+		case IPAddressType:
+			present = len(names.IPAddresses) > 0
+		case EmailAddressType:
+			present = len(names.EmailAddresses) > 0
+		case URIType:
+			present = len(names.URIs) > 0
+		}
+
+		if !present {
+			return fmt.Errorf("certificate profile %s requires at least one %s subject alternative name", p.Name, sanType)
+		}
+	}
+
+	return nil
+}
+
+func (p CertificateProfile) String() string {
+	return fmt.Sprintf(
+		"CertificateProfile(id=%s, name=%s, keyUsage=%v, extKeyUsage=%v, defaultValidityDays=%d, maxValidityDays=%d, accountId=%s, createdAt=%v, updatedAt=%v)",
+		p.ID, p.Name, p.KeyUsage, p.ExtKeyUsage, p.DefaultValidityDays, p.MaxValidityDays, p.AccountID, p.CreatedAt, p.UpdatedAt,
+	)
+}
+
+// CertificateProfileRequest request to create or update a certificate profile.
+type CertificateProfileRequest struct {
+	Name                string   `json:"name,omitempty"`
+	KeyUsage            []string `json:"keyUsage,omitempty"`
+	ExtKeyUsage         []string `json:"extKeyUsage,omitempty"`
+	DefaultValidityDays int      `json:"defaultValidityDays,omitempty"`
+	MaxValidityDays     int      `json:"maxValidityDays,omitempty"`
+	Algorithms          []string `json:"algorithms,omitempty"`
+	KeySizes            []int    `json:"keySizes,omitempty"`
+	RequiredSANTypes    []string `json:"requiredSanTypes,omitempty"`
+	ProfileID           string   `json:"-"`
+	UserID              string   `json:"-"`
+}
+
+// Validate validates the contents of a CertificateProfileRequest
+func (r CertificateProfileRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+
+	if len(r.KeyUsage) == 0 {
+		return fmt.Errorf("keyUsage cannot be empty")
+	}
+
+	err := assertKnownValues("keyUsage", r.KeyUsage, KeyUsages)
+	if err != nil {
+		return err
+	}
+
+	err = assertKnownValues("extKeyUsage", r.ExtKeyUsage, ExtKeyUsages)
+	if err != nil {
+		return err
+	}
+
+	if r.DefaultValidityDays <= 0 {
+		return fmt.Errorf("invalid defaultValidityDays: %d", r.DefaultValidityDays)
+	}
+
+	if r.MaxValidityDays < 0 || (r.MaxValidityDays > 0 && r.MaxValidityDays < r.DefaultValidityDays) {
+		return fmt.Errorf("invalid maxValidityDays: %d, must be 0 or at least defaultValidityDays", r.MaxValidityDays)
+	}
+
+	err = assertKnownValues("algorithms", r.Algorithms, profileAlgorithms)
+	if err != nil {
+		return err
+	}
+
+	for _, size := range r.KeySizes {
+		if size <= 0 {
+			return fmt.Errorf("invalid key size: %d", size)
+		}
+	}
+
+	return assertKnownValues("requiredSanTypes", r.RequiredSANTypes, alternativeNameTypes)
+}
+
+func assertKnownValues(field string, values, known []string) error {
+	for _, value := range values {
+		if !containsString(known, value) {
+			return fmt.Errorf("invalid %s: %s, must be one of %v", field, value, known)
+		}
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
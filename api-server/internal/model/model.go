@@ -1,12 +1,19 @@
 package model
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/CzarSimon/httputil/id"
 	"github.com/CzarSimon/httputil/jwt"
 	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"golang.org/x/net/idna"
 )
 
 // Certificate types
@@ -16,6 +23,63 @@ const (
 	UserCertificateType = "CERTIFICATE"
 )
 
+// Download formats of certificates and private keys. PEM keys are in the format they are stored in,
+// DER keys are unencrypted PKCS#8.
+const (
+	FormatPEM            = "PEM"
+	FormatDER            = "DER"
+	FormatPKCS8          = "PKCS8"
+	FormatEncryptedPKCS8 = "PKCS8_ENCRYPTED"
+)
+
+// CertificateFormats formats that certificates can be downloaded in.
+var CertificateFormats = []string{FormatPEM, FormatDER}
+
+// KeyFormats formats that private keys can be downloaded in.
+var KeyFormats = []string{FormatPEM, FormatDER, FormatPKCS8, FormatEncryptedPKCS8}
+
+// ValidateCertificateFormat checks that certificates can be downloaded in a format.
+func ValidateCertificateFormat(format string) error {
+	return assertKnownValues("format", []string{format}, CertificateFormats)
+}
+
+// ValidateKeyFormat checks that private keys can be downloaded in a format.
+func ValidateKeyFormat(format string) error {
+	return assertKnownValues("format", []string{format}, KeyFormats)
+}
+
+// Base64Encoding marks an attachment with a binary body which has been base64 encoded.
+const Base64Encoding = "base64"
+
+// Validity policies that decide how issuance handles certificates that would outlive their issuer.
+const (
+	ValidityPolicyClamp  = "CLAMP"
+	ValidityPolicyReject = "REJECT"
+)
+
+// MaxBackdate is how far before the time of issuance the validity of a certificate may start.
+const MaxBackdate = 24 * time.Hour
+
+// Certificate statuses
+const (
+	CertificateActive  = "ACTIVE"
+	CertificateRevoked = "REVOKED"
+)
+
+// Revocation reason codes as defined in RFC 5280 section 5.3.1.
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonCertificateHold      = 6
+	ReasonRemoveFromCRL        = 8
+	ReasonPrivilegeWithdrawn   = 9
+	ReasonAACompromise         = 10
+)
+
 // User roles
 const (
 	AdminRole = "ADMIN"
@@ -26,6 +90,16 @@ const (
 const (
 	InvitationCreated  = "CREATED"
 	InvitationAccepted = "ACCEPTED"
+	InvitationRevoked  = "REVOKED"
+)
+
+// InvitationStatuses the statuses by which invitations can be filtered.
+var InvitationStatuses = []string{InvitationCreated, InvitationAccepted, InvitationRevoked}
+
+// Invitation lifetimes in hours, the default is used for accounts that have not configured their own.
+const (
+	DefaultInvitationLifetimeHours = 24
+	MaxInvitationLifetimeHours     = 30 * 24
 )
 
 // AuthenticationRequest authentication information.
@@ -46,6 +120,7 @@ type User struct {
 	ID          string      `json:"id,omitempty"`
 	Email       string      `json:"email,omitempty"`
 	Role        string      `json:"role,omitempty"`
+	Active      bool        `json:"active"`
 	Credentials Credentials `json:"-"`
 	CreatedAt   time.Time   `json:"createdAt,omitempty"`
 	UpdatedAt   time.Time   `json:"updatedAt,omitempty"`
@@ -60,6 +135,7 @@ func NewUser(email, role string, credentials Credentials, account Account) User
 		ID:          id.New(),
 		Email:       email,
 		Role:        role,
+		Active:      true,
 		Credentials: credentials,
 		CreatedAt:   now,
 		UpdatedAt:   now,
@@ -78,15 +154,35 @@ func (u User) JWTUser() jwt.User {
 }
 
 func (u User) String() string {
-	return fmt.Sprintf("User(id=%s, role=%s, createdAt=%v, updatedAt=%v, account=%s)", u.ID, u.Role, u.CreatedAt, u.UpdatedAt, u.Account)
+	return fmt.Sprintf("User(id=%s, role=%s, active=%t, createdAt=%v, updatedAt=%v, account=%s)", u.ID, u.Role, u.Active, u.CreatedAt, u.UpdatedAt, u.Account)
+}
+
+// UserRoleRequest request to change the role of a user.
+type UserRoleRequest struct {
+	UserID string `json:"-"`
+	Role   string `json:"role,omitempty"`
+}
+
+// Validate validates the contents of a UserRoleRequest
+func (r UserRoleRequest) Validate() error {
+	if r.Role != AdminRole && r.Role != UserRole {
+		return fmt.Errorf("invalid role: %s", r.Role)
+	}
+
+	return nil
 }
 
 // Account user account.
+// ValidityPolicy decides if certificates that would be valid outside of the validity of their issuer
+// are clamped to fit within it or rejected.
+// InvitationLifetimeHours is how long invitations to the account are valid unless specified when created.
 type Account struct {
-	ID        string    `json:"id,omitempty"`
-	Name      string    `json:"name,omitempty"`
-	CreatedAt time.Time `json:"createdAt,omitempty"`
-	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+	ID                      string    `json:"id,omitempty"`
+	Name                    string    `json:"name,omitempty"`
+	ValidityPolicy          string    `json:"validityPolicy,omitempty"`
+	InvitationLifetimeHours int       `json:"invitationLifetimeHours,omitempty"`
+	CreatedAt               time.Time `json:"createdAt,omitempty"`
+	UpdatedAt               time.Time `json:"updatedAt,omitempty"`
 }
 
 // NewAccount creates a new account.
@@ -94,18 +190,47 @@ func NewAccount(name string) Account {
 	now := timeutil.Now()
 
 	return Account{
-		ID:        id.New(),
-		Name:      name,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:                      id.New(),
+		Name:                    name,
+		ValidityPolicy:          ValidityPolicyClamp,
+		InvitationLifetimeHours: DefaultInvitationLifetimeHours,
+		CreatedAt:               now,
+		UpdatedAt:               now,
+	}
+}
+
+// InvitationLifetime returns how long invitations to the account are valid.
+func (a Account) InvitationLifetime() time.Duration {
+	if a.InvitationLifetimeHours <= 0 {
+		return DefaultInvitationLifetimeHours * time.Hour
 	}
+
+	return time.Duration(a.InvitationLifetimeHours) * time.Hour
 }
 
 func (a Account) String() string {
-	return fmt.Sprintf("Account(id=%s, name=%s, createdAt=%v, updatedAt=%v)", a.ID, a.Name, a.CreatedAt, a.UpdatedAt)
+	return fmt.Sprintf("Account(id=%s, name=%s, validityPolicy=%s, invitationLifetimeHours=%d, createdAt=%v, updatedAt=%v)", a.ID, a.Name, a.ValidityPolicy, a.InvitationLifetimeHours, a.CreatedAt, a.UpdatedAt)
+}
+
+// AccountPolicyRequest request to update the issuance policies of an account.
+// An InvitationLifetimeHours of 0 leaves the current invitation lifetime unchanged.
+type AccountPolicyRequest struct {
+	ValidityPolicy          string `json:"validityPolicy,omitempty"`
+	InvitationLifetimeHours int    `json:"invitationLifetimeHours,omitempty"`
+	AccountID               string `json:"-"`
+}
+
+// Validate validates the contents of an AccountPolicyRequest
+func (r AccountPolicyRequest) Validate() error {
+	if r.ValidityPolicy != ValidityPolicyClamp && r.ValidityPolicy != ValidityPolicyReject {
+		return fmt.Errorf("invalid validityPolicy: %s", r.ValidityPolicy)
+	}
+
+	return validateInvitationLifetime(r.InvitationLifetimeHours)
 }
 
 // Invitation signup invitation.
+// The secret Token is only present when an invitation is created or resent, only its hash is stored.
 type Invitation struct {
 	ID          string    `json:"id,omitempty"`
 	Email       string    `json:"email,omitempty"`
@@ -116,6 +241,8 @@ type Invitation struct {
 	CreatedAt   time.Time `json:"createdAt,omitempty"`
 	ValidTo     time.Time `json:"validTo,omitempty"`
 	AcceptedAt  time.Time `json:"acceptedAt,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	TokenHash   string    `json:"-"`
 }
 
 func (i Invitation) String() string {
@@ -123,9 +250,11 @@ func (i Invitation) String() string {
 }
 
 // InvitationCreationRequest request to create and invitation.
+// A LifetimeHours of 0 means that the invitation lifetime of the account is used.
 type InvitationCreationRequest struct {
-	Email string `json:"email,omitempty"`
-	Role  string `json:"role,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Role          string `json:"role,omitempty"`
+	LifetimeHours int    `json:"lifetimeHours,omitempty"`
 }
 
 // Validate validates the contents of a InvitationCreationRequest
@@ -138,15 +267,51 @@ func (i InvitationCreationRequest) Validate() error {
 		return fmt.Errorf("email cannot be empty")
 	}
 
+	return validateInvitationLifetime(i.LifetimeHours)
+}
+
+func validateInvitationLifetime(hours int) error {
+	if hours < 0 || hours > MaxInvitationLifetimeHours {
+		return fmt.Errorf("invalid invitation lifetime: %d hours, must be between 0 and %d", hours, MaxInvitationLifetimeHours)
+	}
+
 	return nil
 }
 
+// InvitationFilter collection of parameters by which to filter an invitation retrival.
+type InvitationFilter struct {
+	AccountID string
+	Statuses  []string
+}
+
+// Validate validates the contents of an InvitationFilter
+func (f InvitationFilter) Validate() error {
+	if f.AccountID == "" {
+		return fmt.Errorf("accountId cannot be empty")
+	}
+
+	return assertKnownValues("status", f.Statuses, InvitationStatuses)
+}
+
 // InvitationAcceptanceRequest request to accept an invitation.
 type InvitationAcceptanceRequest struct {
-	ID       string `json:"id,omitempty"`
+	Token    string `json:"token,omitempty"`
 	Password string `json:"password,omitempty"`
 }
 
+// Validate validates the contents of a InvitationAcceptanceRequest
+func (i InvitationAcceptanceRequest) Validate() error {
+	if i.Token == "" {
+		return fmt.Errorf("token cannot be empty")
+	}
+
+	if i.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	return nil
+}
+
 // Credentials authentication session.
 type Credentials struct {
 	Password string
@@ -165,15 +330,22 @@ func (s Signatory) String() string {
 
 // CertificateRequest certificate creation request body.
 type CertificateRequest struct {
-	Name          string                 `json:"name,omitempty"`
-	Subject       CertificateSubject     `json:"subject,omitempty"`
-	Type          string                 `json:"type,omitempty"`
-	Algorithm     string                 `json:"algorithm,omitempty"`
-	Signatory     Signatory              `json:"signatory,omitempty"`
-	Password      string                 `json:"password,omitempty"`
-	Options       map[string]interface{} `json:"options,omitempty"`
-	ExpiresInDays int                    `json:"expiresInDays,omitempty"`
-	UserID        string                 `json:"-"`
+	Name                    string                  `json:"name,omitempty"`
+	Subject                 CertificateSubject      `json:"subject,omitempty"`
+	SubjectAlternativeNames SubjectAlternativeNames `json:"subjectAlternativeNames,omitempty"`
+	Type                    string                  `json:"type,omitempty"`
+	Algorithm               string                  `json:"algorithm,omitempty"`
+	Signatory               Signatory               `json:"signatory,omitempty"`
+	Password                string                  `json:"password,omitempty"`
+	Options                 map[string]interface{}  `json:"options,omitempty"`
+	ExpiresInDays           int                     `json:"expiresInDays,omitempty"`
+	MaxPathLen              *int                    `json:"maxPathLen,omitempty"`
+	NameConstraints         NameConstraints         `json:"nameConstraints,omitempty"`
+	Profile                 string                  `json:"profile,omitempty"`
+	NotBefore               *time.Time              `json:"notBefore,omitempty"`
+	KeyUsage                []string                `json:"-"`
+	ExtKeyUsage             []string                `json:"-"`
+	UserID                  string                  `json:"-"`
 }
 
 // KeyRequest extracts key request from a certificate request.
@@ -199,6 +371,182 @@ func (c CertificateRequest) Validate() error {
 		return fmt.Errorf("certificate type %s requires a valid signatory", c.Type)
 	}
 
+	err := validateMaxPathLen(c.Type, c.MaxPathLen)
+	if err != nil {
+		return err
+	}
+
+	err = validateNameConstraints(c.Type, c.NameConstraints)
+	if err != nil {
+		return err
+	}
+
+	err = validateProfile(c.Type, c.Profile)
+	if err != nil {
+		return err
+	}
+
+	err = validateNotBefore(c.NotBefore)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.SubjectAlternativeNames.Normalize()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SigningRequest request to issue a certificate for an externally generated PKCS#10 certificate signing request.
+type SigningRequest struct {
+	Name            string          `json:"name,omitempty"`
+	CSR             string          `json:"csr,omitempty"`
+	Type            string          `json:"type,omitempty"`
+	Signatory       Signatory       `json:"signatory,omitempty"`
+	ExpiresInDays   int             `json:"expiresInDays,omitempty"`
+	MaxPathLen      *int            `json:"maxPathLen,omitempty"`
+	NameConstraints NameConstraints `json:"nameConstraints,omitempty"`
+	Profile         string          `json:"profile,omitempty"`
+	NotBefore       *time.Time      `json:"notBefore,omitempty"`
+	UserID          string          `json:"-"`
+}
+
+// Validate validates the contents of a SigningRequest
+func (s SigningRequest) Validate() error {
+	if s.Type != IntermediateCAType && s.Type != UserCertificateType {
+		return fmt.Errorf("invalid certificate type for signing request: %s", s.Type)
+	}
+
+	if s.Signatory.ID == "" || s.Signatory.Password == "" {
+		return fmt.Errorf("certificate type %s requires a valid signatory", s.Type)
+	}
+
+	if s.CSR == "" {
+		return fmt.Errorf("csr cannot be empty")
+	}
+
+	err := validateMaxPathLen(s.Type, s.MaxPathLen)
+	if err != nil {
+		return err
+	}
+
+	err = validateNameConstraints(s.Type, s.NameConstraints)
+	if err != nil {
+		return err
+	}
+
+	err = validateProfile(s.Type, s.Profile)
+	if err != nil {
+		return err
+	}
+
+	return validateNotBefore(s.NotBefore)
+}
+
+// Formats of imported certificate authorities.
+const (
+	ImportFormatPEM    = "PEM"
+	ImportFormatPKCS12 = "PKCS12"
+)
+
+// ImportRequest request to import an existing certificate authority together with its private key.
+// PEM imports provide an unencrypted private key, PKCS12 imports a base64 encoded bundle protected by BundlePassword.
+// Password is the new password the private key is encrypted with once imported.
+type ImportRequest struct {
+	Name           string `json:"name,omitempty"`
+	Format         string `json:"format,omitempty"`
+	Certificate    string `json:"certificate,omitempty"`
+	PrivateKey     string `json:"privateKey,omitempty"`
+	Bundle         string `json:"bundle,omitempty"`
+	BundlePassword string `json:"bundlePassword,omitempty"`
+	Password       string `json:"password,omitempty"`
+	UserID         string `json:"-"`
+}
+
+// Validate validates the contents of an ImportRequest
+func (r ImportRequest) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+
+	if r.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	switch r.Format {
+	case ImportFormatPEM:
+		if r.Certificate == "" || r.PrivateKey == "" {
+			return fmt.Errorf("certificate and privateKey are required for format %s", r.Format)
+		}
+	case ImportFormatPKCS12:
+		if r.Bundle == "" {
+			return fmt.Errorf("bundle is required for format %s", r.Format)
+		}
+	default:
+		return fmt.Errorf("invalid format: %s, must be one of %v", r.Format, []string{ImportFormatPEM, ImportFormatPKCS12})
+	}
+
+	return nil
+}
+
+// validateMaxPathLen checks that a path length constraint is only given for certificate authorities.
+// A nil maxPathLen means that the path length is unconstrained, unless limited by the issuer.
+func validateMaxPathLen(certType string, maxPathLen *int) error {
+	if maxPathLen == nil {
+		return nil
+	}
+
+	if certType != RootCAType && certType != IntermediateCAType {
+		return fmt.Errorf("maxPathLen can only be specified for certificate authorities")
+	}
+
+	if *maxPathLen < 0 {
+		return fmt.Errorf("invalid maxPathLen: %d", *maxPathLen)
+	}
+
+	return nil
+}
+
+// validateNameConstraints checks that name constraints are only given for intermediate certificate authorities.
+func validateNameConstraints(certType string, constraints NameConstraints) error {
+	if constraints.Empty() {
+		return nil
+	}
+
+	if certType != IntermediateCAType {
+		return fmt.Errorf("nameConstraints can only be specified for intermediate certificate authorities")
+	}
+
+	_, err := constraints.Normalize()
+	return err
+}
+
+// validateProfile checks that a certificate profile is only selected for user certificates.
+func validateProfile(certType, profile string) error {
+	if profile != "" && certType != UserCertificateType {
+		return fmt.Errorf("profile can only be specified for certificate type %s", UserCertificateType)
+	}
+
+	return nil
+}
+
+// validateNotBefore checks that an explicit start of validity only backdates a certificate, by at most MaxBackdate.
+func validateNotBefore(notBefore *time.Time) error {
+	if notBefore == nil {
+		return nil
+	}
+
+	now := timeutil.Now()
+	if notBefore.After(now) {
+		return fmt.Errorf("notBefore=%v cannot be in the future", *notBefore)
+	}
+
+	if notBefore.Before(now.Add(-MaxBackdate)) {
+		return fmt.Errorf("notBefore=%v cannot be more than %v before the time of issuance", *notBefore, MaxBackdate)
+	}
+
 	return nil
 }
 
@@ -230,29 +578,747 @@ func (s CertificateSubject) String() string {
 	return fmt.Sprintf("C=%s, ST=%s, L=%s, O=%s, OU=%s, CN=%s", s.Country, s.State, s.Locality, s.Organization, s.OrganizationalUnit, s.CommonName)
 }
 
+// SubjectAlternativeNames additional identities to which a certificate is issued.
+type SubjectAlternativeNames struct {
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+}
+
+// Empty checks if no alternative names are present.
+func (s SubjectAlternativeNames) Empty() bool {
+	return len(s.DNSNames) == 0 && len(s.IPAddresses) == 0 && len(s.EmailAddresses) == 0 && len(s.URIs) == 0
+}
+
+// Normalize validates each alternative name by type and returns them in their canonical form,
+// internationalized domain names are converted to punycode.
+func (s SubjectAlternativeNames) Normalize() (SubjectAlternativeNames, error) {
+	dnsNames, err := normalizeNames(s.DNSNames, normalizeDNSName)
+	if err != nil {
+		return SubjectAlternativeNames{}, err
+	}
+
+	ips, err := normalizeNames(s.IPAddresses, normalizeIPAddress)
+	if err != nil {
+		return SubjectAlternativeNames{}, err
+	}
+
+	emails, err := normalizeNames(s.EmailAddresses, normalizeEmailAddress)
+	if err != nil {
+		return SubjectAlternativeNames{}, err
+	}
+
+	uris, err := normalizeNames(s.URIs, normalizeURI)
+	if err != nil {
+		return SubjectAlternativeNames{}, err
+	}
+
+	return SubjectAlternativeNames{
+		DNSNames:       dnsNames,
+		IPAddresses:    ips,
+		EmailAddresses: emails,
+		URIs:           uris,
+	}, nil
+}
+
+func (s SubjectAlternativeNames) String() string {
+	return fmt.Sprintf("DNS=%v, IP=%v, email=%v, URI=%v", s.DNSNames, s.IPAddresses, s.EmailAddresses, s.URIs)
+}
+
+// NameConstraints limits the names that certificates issued by an intermediate certificate authority
+// and its descendants may contain (RFC 5280 section 4.2.1.10).
+type NameConstraints struct {
+	Permitted NameSubtrees `json:"permitted,omitempty"`
+	Excluded  NameSubtrees `json:"excluded,omitempty"`
+}
+
+// NameSubtrees name spaces by type. Domains match the domain itself and all subdomains,
+// unless prefixed with a period in which case only subdomains match. Email constraints are either
+// a mailbox or a domain and IP ranges are given in CIDR notation.
+type NameSubtrees struct {
+	DNSDomains     []string `json:"dnsDomains,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	IPRanges       []string `json:"ipRanges,omitempty"`
+	URIDomains     []string `json:"uriDomains,omitempty"`
+}
+
+// Empty checks if no name constraints are present.
+func (n NameConstraints) Empty() bool {
+	return n.Permitted.Empty() && n.Excluded.Empty()
+}
+
+// Normalize validates the name constraints and returns them in their canonical form.
+func (n NameConstraints) Normalize() (NameConstraints, error) {
+	permitted, err := n.Permitted.Normalize()
+	if err != nil {
+		return NameConstraints{}, fmt.Errorf("invalid permitted name constraint: %w", err)
+	}
+
+	excluded, err := n.Excluded.Normalize()
+	if err != nil {
+		return NameConstraints{}, fmt.Errorf("invalid excluded name constraint: %w", err)
+	}
+
+	return NameConstraints{
+		Permitted: permitted,
+		Excluded:  excluded,
+	}, nil
+}
+
+// Permits checks that normalized alternative names are within the permitted and outside of the excluded subtrees.
+// When permitted subtrees are given for a name type, names of that type must match at least one of them.
+func (n NameConstraints) Permits(names SubjectAlternativeNames) error {
+	for _, name := range names.DNSNames {
+		err := checkNameConstraints("dns name", name, n.Permitted.DNSDomains, n.Excluded.DNSDomains, matchDNSConstraint)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, addr := range names.IPAddresses {
+		err := checkNameConstraints("ip address", addr, n.Permitted.IPRanges, n.Excluded.IPRanges, matchIPConstraint)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, email := range names.EmailAddresses {
+		err := checkNameConstraints("email address", email, n.Permitted.EmailAddresses, n.Excluded.EmailAddresses, matchEmailConstraint)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, uri := range names.URIs {
+		err := checkNameConstraints("uri", uri, n.Permitted.URIDomains, n.Excluded.URIDomains, matchURIConstraint)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n NameConstraints) String() string {
+	return fmt.Sprintf("NameConstraints(permitted=[%s], excluded=[%s])", n.Permitted, n.Excluded)
+}
+
+// Empty checks if no name subtrees are present.
+func (n NameSubtrees) Empty() bool {
+	return len(n.DNSDomains) == 0 && len(n.EmailAddresses) == 0 && len(n.IPRanges) == 0 && len(n.URIDomains) == 0
+}
+
+// Normalize validates each name subtree by type and returns them in their canonical form.
+func (n NameSubtrees) Normalize() (NameSubtrees, error) {
+	dnsDomains, err := normalizeNames(n.DNSDomains, normalizeDomainConstraint)
+	if err != nil {
+		return NameSubtrees{}, err
+	}
+
+	emails, err := normalizeNames(n.EmailAddresses, normalizeEmailConstraint)
+	if err != nil {
+		return NameSubtrees{}, err
+	}
+
+	ipRanges, err := normalizeNames(n.IPRanges, normalizeIPRange)
+	if err != nil {
+		return NameSubtrees{}, err
+	}
+
+	uriDomains, err := normalizeNames(n.URIDomains, normalizeDomainConstraint)
+	if err != nil {
+		return NameSubtrees{}, err
+	}
+
+	return NameSubtrees{
+		DNSDomains:     dnsDomains,
+		EmailAddresses: emails,
+		IPRanges:       ipRanges,
+		URIDomains:     uriDomains,
+	}, nil
+}
+
+func (n NameSubtrees) String() string {
+	return fmt.Sprintf("DNS=%v, IP=%v, email=%v, URI=%v", n.DNSDomains, n.IPRanges, n.EmailAddresses, n.URIDomains)
+}
+
+func checkNameConstraints(kind, name string, permitted, excluded []string, match func(name, constraint string, excluded bool) bool) error {
+	for _, constraint := range excluded {
+		if match(name, constraint, true) {
+			return fmt.Errorf("%s %q is excluded by name constraint %q", kind, name, constraint)
+		}
+	}
+
+	if len(permitted) == 0 {
+		return nil
+	}
+
+	for _, constraint := range permitted {
+		if match(name, constraint, false) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s %q is not within the permitted name constraints", kind, name)
+}
+
+// matchDNSConstraint matches a dns name against a domain constraint. A wildcard name also matches an excluded
+// constraint for a single name it covers, since the certificate would otherwise be valid for an excluded name.
+func matchDNSConstraint(name, constraint string, excluded bool) bool {
+	if excluded && strings.HasPrefix(name, "*.") && !strings.HasPrefix(constraint, ".") {
+		base := strings.TrimPrefix(name, "*")
+		label := strings.TrimSuffix(constraint, base)
+		if label != constraint && label != "" && !strings.Contains(label, ".") {
+			return true
+		}
+	}
+
+	return matchDomainConstraint(name, constraint)
+}
+
+func matchDomainConstraint(domain, constraint string) bool {
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+func matchIPConstraint(addr, constraint string, excluded bool) bool {
+	_, ipRange, err := net.ParseCIDR(constraint)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(addr)
+	if ip4 := ip.To4(); ip4 != nil && len(ipRange.IP) == net.IPv4len {
+		ip = ip4
+	}
+
+	return len(ip) == len(ipRange.IP) && ipRange.Contains(ip)
+}
+
+// matchEmailConstraint matches an email address against a mailbox, a host or, if prefixed with a period, any subdomain of a host.
+func matchEmailConstraint(email, constraint string, excluded bool) bool {
+	if strings.Contains(constraint, "@") {
+		return email == constraint
+	}
+
+	host := email[strings.LastIndex(email, "@")+1:]
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+
+	return host == constraint
+}
+
+// matchURIConstraint matches the host of a uri against a host or, if prefixed with a period, any subdomain of a host.
+// URIs with an ip address as host cannot be matched by a uri constraint.
+func matchURIConstraint(uri, constraint string, excluded bool) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return excluded
+	}
+
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return excluded
+	}
+
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+
+	return host == constraint
+}
+
+func normalizeDomainConstraint(constraint string) (string, error) {
+	prefix := ""
+	if strings.HasPrefix(constraint, ".") {
+		prefix = "."
+	}
+
+	domain, err := normalizeDomain(strings.TrimPrefix(constraint, "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid domain constraint %q: %w", constraint, err)
+	}
+
+	return prefix + domain, nil
+}
+
+func normalizeEmailConstraint(constraint string) (string, error) {
+	if strings.Contains(constraint, "@") {
+		return normalizeEmailAddress(constraint)
+	}
+
+	return normalizeDomainConstraint(constraint)
+}
+
+func normalizeIPRange(ipRange string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return "", fmt.Errorf("invalid ip range %q", ipRange)
+	}
+
+	return ipNet.String(), nil
+}
+
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(true),
+	idna.ValidateLabels(true),
+	idna.VerifyDNSLength(true),
+)
+
+func normalizeNames(names []string, normalize func(string) (string, error)) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		n, err := normalize(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[n] {
+			seen[n] = true
+			normalized = append(normalized, n)
+		}
+	}
+
+	return normalized, nil
+}
+
+func normalizeDNSName(name string) (string, error) {
+	if strings.HasPrefix(name, "*.") {
+		domain, err := normalizeDomain(strings.TrimPrefix(name, "*."))
+		if err != nil {
+			return "", fmt.Errorf("invalid dns name %q: %w", name, err)
+		}
+
+		return "*." + domain, nil
+	}
+
+	domain, err := normalizeDomain(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid dns name %q: %w", name, err)
+	}
+
+	return domain, nil
+}
+
+func normalizeDomain(domain string) (string, error) {
+	if domain == "" {
+		return "", fmt.Errorf("domain cannot be empty")
+	}
+
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Contains(ascii, "*") {
+		return "", fmt.Errorf("wildcards are only allowed as the leftmost label")
+	}
+
+	return ascii, nil
+}
+
+func normalizeIPAddress(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("invalid ip address %q", addr)
+	}
+
+	return ip.String(), nil
+}
+
+func normalizeEmailAddress(email string) (string, error) {
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Address != email {
+		return "", fmt.Errorf("invalid email address %q", email)
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	for _, r := range local {
+		if r > unicode.MaxASCII {
+			return "", fmt.Errorf("invalid email address %q: local part must be ascii", email)
+		}
+	}
+
+	asciiDomain, err := normalizeDomain(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address %q: %w", email, err)
+	}
+
+	return local + "@" + asciiDomain, nil
+}
+
+func normalizeURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %q: %w", uri, err)
+	}
+
+	if u.Scheme == "" || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid uri %q: must be absolute and contain a host", uri)
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %q: %w", uri, err)
+	}
+
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	u.Host = host
+	return u.String(), nil
+}
+
+func normalizeHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	return normalizeDomain(host)
+}
+
 // Certificate tls certificate and metadata.
 type Certificate struct {
-	ID           string             `json:"id,omitempty"`
-	Name         string             `json:"name,omitempty"`
-	SerialNumber int64              `json:"serialNumber,omitempty"`
-	Subject      CertificateSubject `json:"subject"`
-	Body         string             `json:"body,omitempty"`
-	KeyPair      KeyPair            `json:"-"`
-	Format       string             `json:"format,omitempty"`
-	Type         string             `json:"type,omitempty"`
-	SignatoryID  string             `json:"signatoryId,omitempty"`
-	AccountID    string             `json:"accountId,omitempty"`
-	CreatedAt    time.Time          `json:"createdAt,omitempty"`
-	ExpiresAt    time.Time          `json:"expiresAt,omitempty"`
+	ID                      string                  `json:"id,omitempty"`
+	Name                    string                  `json:"name,omitempty"`
+	SerialNumber            string                  `json:"serialNumber,omitempty"`
+	Subject                 CertificateSubject      `json:"subject"`
+	SubjectAlternativeNames SubjectAlternativeNames `json:"subjectAlternativeNames"`
+	Body                    string                  `json:"body,omitempty"`
+	KeyPair                 KeyPair                 `json:"-"`
+	Format                  string                  `json:"format,omitempty"`
+	Type                    string                  `json:"type,omitempty"`
+	SignatoryID             string                  `json:"signatoryId,omitempty"`
+	IssuerID                string                  `json:"-"`
+	AccountID               string                  `json:"accountId,omitempty"`
+	Status                  string                  `json:"status,omitempty"`
+	Revocation              *Revocation             `json:"revocation,omitempty"`
+	PredecessorID           string                  `json:"predecessorId,omitempty"`
+	SuccessorID             string                  `json:"successorId,omitempty"`
+	Generation              int                     `json:"generation,omitempty"`
+	MaxPathLen              *int                    `json:"-"`
+	NameConstraints         NameConstraints         `json:"-"`
+	KeyUsage                []string                `json:"-"`
+	ExtKeyUsage             []string                `json:"-"`
+	NotBefore               time.Time               `json:"-"`
+	CreatedAt               time.Time               `json:"createdAt,omitempty"`
+	ExpiresAt               time.Time               `json:"expiresAt,omitempty"`
+}
+
+// Renewed returns true if a successor has been issued for the certificate.
+func (c Certificate) Renewed() bool {
+	return c.SuccessorID != ""
+}
+
+// Revoked returns true if the certificate has been revoked.
+func (c Certificate) Revoked() bool {
+	return c.Status == CertificateRevoked
 }
 
 func (c Certificate) String() string {
 	return fmt.Sprintf(
-		"Certificate(id=%s, name=%s, serialNumber=%d, subject=[%s], format=%s, type=%s, signatoryId=%s, accountId=%s, createdAt=%v, expiresAt=%v)",
-		c.ID, c.Name, c.SerialNumber, c.Subject, c.Format, c.Type, c.SignatoryID, c.AccountID, c.CreatedAt, c.ExpiresAt,
+		"Certificate(id=%s, name=%s, serialNumber=%s, subject=[%s], format=%s, type=%s, signatoryId=%s, accountId=%s, status=%s, createdAt=%v, expiresAt=%v)",
+		c.ID, c.Name, c.SerialNumber, c.Subject, c.Format, c.Type, c.SignatoryID, c.AccountID, c.Status, c.CreatedAt, c.ExpiresAt,
 	)
 }
 
+// Revocation record of when and why a certificate was revoked.
+type Revocation struct {
+	Reason         int        `json:"reason"`
+	RevokedAt      time.Time  `json:"revokedAt"`
+	InvalidityDate *time.Time `json:"invalidityDate,omitempty"`
+}
+
+// RenewalRequest request to issue a successor of a certificate with the same subject, alternative names, type and signatory.
+// Password unlocks the existing key pair when the key is reused and protects the new key pair when Rekey is true,
+// Algorithm and Options default to the algorithm of the existing key pair.
+type RenewalRequest struct {
+	Password      string                 `json:"password,omitempty"`
+	Rekey         bool                   `json:"rekey,omitempty"`
+	Algorithm     string                 `json:"algorithm,omitempty"`
+	Options       map[string]interface{} `json:"options,omitempty"`
+	Signatory     Signatory              `json:"signatory,omitempty"`
+	ExpiresInDays int                    `json:"expiresInDays,omitempty"`
+	CertificateID string                 `json:"-"`
+	UserID        string                 `json:"-"`
+}
+
+// Validate validates the contents of a RenewalRequest
+func (r RenewalRequest) Validate() error {
+	if r.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	if !r.Rekey && (r.Algorithm != "" || len(r.Options) > 0) {
+		return fmt.Errorf("algorithm and options can only be specified when rekeying")
+	}
+
+	if r.ExpiresInDays < 0 {
+		return fmt.Errorf("invalid expiresInDays: %d", r.ExpiresInDays)
+	}
+
+	return nil
+}
+
+// Pending certificate statuses
+const (
+	PendingCertificatePending   = "PENDING"
+	PendingCertificateActivated = "ACTIVATED"
+)
+
+// PendingCertificate intermediate certificate authority awaiting signature by an external, typically offline, root.
+// The key pair is generated and stored by webca, CSR is handed to the external signer and the signed certificate
+// is imported on activation. IssuerChain holds the certificates between the activated certificate and the external root.
+type PendingCertificate struct {
+	ID            string     `json:"id,omitempty"`
+	Name          string     `json:"name,omitempty"`
+	CSR           string     `json:"csr,omitempty"`
+	Status        string     `json:"status,omitempty"`
+	KeyPair       KeyPair    `json:"-"`
+	AccountID     string     `json:"accountId,omitempty"`
+	CertificateID string     `json:"certificateId,omitempty"`
+	IssuerChain   string     `json:"issuerChain,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt,omitempty"`
+	ActivatedAt   *time.Time `json:"activatedAt,omitempty"`
+}
+
+func (p PendingCertificate) String() string {
+	return fmt.Sprintf(
+		"PendingCertificate(id=%s, name=%s, status=%s, accountId=%s, certificateId=%s, createdAt=%v)",
+		p.ID, p.Name, p.Status, p.AccountID, p.CertificateID, p.CreatedAt,
+	)
+}
+
+// PendingCertificateRequest request to generate a key pair and CSR for an intermediate certificate authority
+// that is signed outside of webca.
+type PendingCertificateRequest struct {
+	Name                    string                  `json:"name,omitempty"`
+	Subject                 CertificateSubject      `json:"subject,omitempty"`
+	SubjectAlternativeNames SubjectAlternativeNames `json:"subjectAlternativeNames,omitempty"`
+	Algorithm               string                  `json:"algorithm,omitempty"`
+	Password                string                  `json:"password,omitempty"`
+	Options                 map[string]interface{}  `json:"options,omitempty"`
+	UserID                  string                  `json:"-"`
+}
+
+// KeyRequest extracts key request from a pending certificate request.
+func (p PendingCertificateRequest) KeyRequest() KeyRequest {
+	return CertificateRequest{Algorithm: p.Algorithm, Options: p.Options}.KeyRequest()
+}
+
+// Validate validates the contents of a PendingCertificateRequest
+func (p PendingCertificateRequest) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+
+	if p.Subject.CommonName == "" {
+		return fmt.Errorf("subject.commonName cannot be empty")
+	}
+
+	_, err := p.SubjectAlternativeNames.Normalize()
+	return err
+}
+
+// ActivationRequest request to activate a pending certificate with the certificate issued by the external signer.
+// Chain contains the certificates of the external signer up to and including its root.
+type ActivationRequest struct {
+	Certificate          string `json:"certificate,omitempty"`
+	Chain                string `json:"chain,omitempty"`
+	Password             string `json:"password,omitempty"`
+	PendingCertificateID string `json:"-"`
+	UserID               string `json:"-"`
+}
+
+// Validate validates the contents of an ActivationRequest
+func (a ActivationRequest) Validate() error {
+	if a.Certificate == "" {
+		return fmt.Errorf("certificate cannot be empty")
+	}
+
+	if a.Chain == "" {
+		return fmt.Errorf("chain cannot be empty")
+	}
+
+	if a.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	return nil
+}
+
+// RevocationRequest request to revoke an issued certificate.
+type RevocationRequest struct {
+	Reason         int        `json:"reason"`
+	InvalidityDate *time.Time `json:"invalidityDate,omitempty"`
+	CertificateID  string     `json:"-"`
+	UserID         string     `json:"-"`
+}
+
+// Validate validates the contents of a RevocationRequest
+func (r RevocationRequest) Validate() error {
+	switch r.Reason {
+	case ReasonUnspecified, ReasonKeyCompromise, ReasonCACompromise, ReasonAffiliationChanged, ReasonSuperseded,
+		ReasonCessationOfOperation, ReasonPrivilegeWithdrawn, ReasonAACompromise:
+	case ReasonCertificateHold, ReasonRemoveFromCRL:
+		return fmt.Errorf("revocation reason %d is not supported as revocations are permanent", r.Reason)
+	default:
+		return fmt.Errorf("invalid revocation reason: %d", r.Reason)
+	}
+
+	if r.InvalidityDate != nil && r.InvalidityDate.After(timeutil.Now()) {
+		return fmt.Errorf("invalidity date cannot be in the future: %v", r.InvalidityDate)
+	}
+
+	return nil
+}
+
+// CRLSigningRequest request by the owner of a certificate authority to let the server sign its revocation lists unattended.
+// Password unlocks the private key of the certificate authority so that it can be wrapped with the server held key wrapping key.
+type CRLSigningRequest struct {
+	Password      string `json:"password,omitempty"`
+	CertificateID string `json:"-"`
+	UserID        string `json:"-"`
+}
+
+// Validate validates the contents of a CRLSigningRequest
+func (r CRLSigningRequest) Validate() error {
+	if r.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	return nil
+}
+
+// UnattendedIssuanceRequest request by the owner of a certificate authority to let the server issue leaf certificates unattended,
+// which is required to enable ACME. Password unlocks the private key of the certificate authority so that it can be wrapped with
+// the server held key wrapping key. Enrollment for revocation lists does not allow the server to issue certificates.
+type UnattendedIssuanceRequest struct {
+	Password      string `json:"password,omitempty"`
+	CertificateID string `json:"-"`
+	UserID        string `json:"-"`
+}
+
+// Validate validates the contents of an UnattendedIssuanceRequest
+func (r UnattendedIssuanceRequest) Validate() error {
+	if r.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	return nil
+}
+
+// RevocationList signed X.509 certificate revocation list published by a certificate authority.
+type RevocationList struct {
+	ID            string    `json:"id,omitempty"`
+	CertificateID string    `json:"certificateId,omitempty"`
+	Number        int64     `json:"number,omitempty"`
+	Body          string    `json:"body,omitempty"`
+	ThisUpdate    time.Time `json:"thisUpdate,omitempty"`
+	NextUpdate    time.Time `json:"nextUpdate,omitempty"`
+	CreatedAt     time.Time `json:"createdAt,omitempty"`
+}
+
+func (l RevocationList) String() string {
+	return fmt.Sprintf(
+		"RevocationList(id=%s, certificateId=%s, number=%d, thisUpdate=%v, nextUpdate=%v, createdAt=%v)",
+		l.ID, l.CertificateID, l.Number, l.ThisUpdate, l.NextUpdate, l.CreatedAt,
+	)
+}
+
+// CRLSigningKey private key of a certificate authority held by the server after its owner enrolled it
+// in order to sign revocation lists without user interaction, the private key is wrapped with the key wrapping key.
+type CRLSigningKey struct {
+	CertificateID  string
+	PrivateKey     string
+	EncryptionSalt string
+	CreatedAt      time.Time
+}
+
+func (k CRLSigningKey) String() string {
+	return fmt.Sprintf("CRLSigningKey(certificateId=%s, createdAt=%v)", k.CertificateID, k.CreatedAt)
+}
+
+// IssuingKey private key of a certificate authority held by the server after its owner enrolled it
+// in order to issue leaf certificates without user interaction, the private key is wrapped with the key wrapping key.
+type IssuingKey struct {
+	CertificateID  string
+	PrivateKey     string
+	EncryptionSalt string
+	CreatedBy      string
+	CreatedAt      time.Time
+}
+
+func (k IssuingKey) String() string {
+	return fmt.Sprintf("IssuingKey(certificateId=%s, createdBy=%s, createdAt=%v)", k.CertificateID, k.CreatedBy, k.CreatedAt)
+}
+
+// OCSPResponder delegated OCSP signing certificate issued by a certificate authority when requested by its owner,
+// only the private key of the responder is held by the server, wrapped with a server held key so that responses can be signed without user interaction.
+type OCSPResponder struct {
+	ID            string           `json:"id,omitempty"`
+	CertificateID string           `json:"certificateId,omitempty"`
+	Body          string           `json:"body,omitempty"`
+	KeyPair       KeyPair          `json:"-"`
+	IssuerHashes  []OCSPIssuerHash `json:"-"`
+	CreatedAt     time.Time        `json:"createdAt,omitempty"`
+	ExpiresAt     time.Time        `json:"expiresAt,omitempty"`
+}
+
+func (r OCSPResponder) String() string {
+	return fmt.Sprintf("OCSPResponder(id=%s, certificateId=%s, createdAt=%v, expiresAt=%v)", r.ID, r.CertificateID, r.CreatedAt, r.ExpiresAt)
+}
+
+// OCSPIssuerHash hex encoded hashes of the subject name and public key by which OCSP requests identify a certificate authority.
+type OCSPIssuerHash struct {
+	Algorithm string
+	NameHash  string
+	KeyHash   string
+}
+
+func (h OCSPIssuerHash) String() string {
+	return fmt.Sprintf("OCSPIssuerHash(algorithm=%s, nameHash=%s, keyHash=%s)", h.Algorithm, h.NameHash, h.KeyHash)
+}
+
+// OCSPResponderRequest request by the owner of a certificate authority to issue a delegated OCSP responder certificate.
+// Password unlocks the private key of the certificate authority which signs the responder certificate.
+type OCSPResponderRequest struct {
+	Password      string `json:"password,omitempty"`
+	CertificateID string `json:"-"`
+	UserID        string `json:"-"`
+}
+
+// Validate validates the contents of an OCSPResponderRequest
+func (r OCSPResponderRequest) Validate() error {
+	if r.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	return nil
+}
+
+// OCSPResponse DER encoded OCSP response and the time after which newer status information will be available.
+type OCSPResponse struct {
+	Body       []byte
+	NextUpdate time.Time
+}
+
 // CertificateFilter collection of parameters by which to filter a certificate retrival.
 type CertificateFilter struct {
 	AccountID string
@@ -273,9 +1339,13 @@ func (t CertificateType) String() string {
 
 // CertificateOptions options for creation of certificates.
 type CertificateOptions struct {
-	Types      []CertificateType `json:"types"`
-	Algorithms []string          `json:"algorithms"`
-	Formats    []string          `json:"formats"`
+	Types        []CertificateType    `json:"types"`
+	Algorithms   []string             `json:"algorithms"`
+	Formats      []string             `json:"formats"`
+	KeyFormats   []string             `json:"keyFormats"`
+	Profiles     []CertificateProfile `json:"profiles"`
+	KeyUsages    []string             `json:"keyUsages"`
+	ExtKeyUsages []string             `json:"extKeyUsages"`
 }
 
 // KeyPair asymmetric key pair of a public and private key, the private key is encrypted.
@@ -304,6 +1374,53 @@ type CertificatePage struct {
 	Results        []Certificate `json:"results"`
 }
 
+// Notification statuses
+const (
+	NotificationPending = "PENDING"
+	NotificationSending = "SENDING"
+	NotificationSent    = "SENT"
+	NotificationFailed  = "FAILED"
+)
+
+// Notification rendered message in the outbox of messages to deliver to users.
+// As messages such as invitations contain secrets the body is stored encrypted with EncryptionSalt,
+// and it is cleared once the notification has been sent or has failed for good.
+// A notification that is being delivered is claimed until ClaimedUntil, after which it may be delivered again.
+type Notification struct {
+	ID             string    `json:"id,omitempty"`
+	Template       string    `json:"template,omitempty"`
+	Recipient      string    `json:"recipient,omitempty"`
+	Subject        string    `json:"subject,omitempty"`
+	Body           string    `json:"-"`
+	EncryptionSalt string    `json:"-"`
+	Status         string    `json:"status,omitempty"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time `json:"createdAt,omitempty"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt,omitempty"`
+	ClaimedUntil   time.Time `json:"-"`
+	SentAt         time.Time `json:"sentAt,omitempty"`
+}
+
+// NewNotification creates a pending notification that is due for delivery from the outbox right away.
+func NewNotification(template, recipient, subject, body string) Notification {
+	now := timeutil.Now()
+	return Notification{
+		ID:            id.New(),
+		Template:      template,
+		Recipient:     recipient,
+		Subject:       subject,
+		Body:          body,
+		Status:        NotificationPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+}
+
+func (n Notification) String() string {
+	return fmt.Sprintf("Notification(id=%s, template=%s, status=%s, attempts=%d, createdAt=%v, nextAttemptAt=%v, sentAt=%v)", n.ID, n.Template, n.Status, n.Attempts, n.CreatedAt, n.NextAttemptAt, n.SentAt)
+}
+
 // AuditEvent sensitive activity performed in the system.
 type AuditEvent struct {
 	ID        string    `json:"id,omitempty"`
@@ -328,11 +1445,36 @@ func (e AuditEvent) String() string {
 	return fmt.Sprintf("AuditEvent(id=%s, userId=%s, activity=%s, resource=%s, createdAt=%v)", e.ID, e.UserID, e.Activity, e.Resource, e.CreatedAt)
 }
 
-// Attachment file attachment.
+// Attachment file attachment, binary bodies are base64 encoded and marked by their encoding.
 type Attachment struct {
 	Body        string `json:"body,omitempty"`
 	ContentType string `json:"contentType,omitempty"`
 	Filename    string `json:"filename,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// NewBinaryAttachment creates an attachment with a base64 encoded binary body.
+func NewBinaryAttachment(body []byte, contentType, filename string) Attachment {
+	return Attachment{
+		Body:        base64.StdEncoding.EncodeToString(body),
+		ContentType: contentType,
+		Filename:    filename,
+		Encoding:    Base64Encoding,
+	}
+}
+
+// Content returns the decoded body of the attachment.
+func (a Attachment) Content() ([]byte, error) {
+	if a.Encoding != Base64Encoding {
+		return []byte(a.Body), nil
+	}
+
+	content, err := base64.StdEncoding.DecodeString(a.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode body of %s: %w", a, err)
+	}
+
+	return content, nil
 }
 
 // ContentDisposition creates the value for the Content-Disposition header.
@@ -2,8 +2,10 @@ package model_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,4 +22,235 @@ func TestCertificateSubject_String(t *testing.T) {
 	}
 
 	assert.Equal("C=SE, ST=, L=Stockholm, O=WebCA AB, OU=Engineering, CN=WebCA Test Root CA", sub.String())
-}	
\ No newline at end of file
+}
+
+func TestSubjectAlternativeNames_Normalize(t *testing.T) {
+	assert := assert.New(t)
+
+	names := model.SubjectAlternativeNames{
+		DNSNames:       []string{"WebCA.io", "*.webca.io", " api.webca.io ", "bücher.example", "webca.io"},
+		IPAddresses:    []string{"10.0.0.1", "::ffff:192.168.0.1", "2001:0db8:0000:0000:0000:0000:0000:0001"},
+		EmailAddresses: []string{"admin@webca.io", "info@bücher.example"},
+		URIs:           []string{"spiffe://webca.io/api-server", "https://bücher.example:8443/path"},
+	}
+
+	normalized, err := names.Normalize()
+	assert.NoError(err)
+	assert.Equal([]string{"webca.io", "*.webca.io", "api.webca.io", "xn--bcher-kva.example"}, normalized.DNSNames)
+	assert.Equal([]string{"10.0.0.1", "192.168.0.1", "2001:db8::1"}, normalized.IPAddresses)
+	assert.Equal([]string{"admin@webca.io", "info@xn--bcher-kva.example"}, normalized.EmailAddresses)
+	assert.Equal([]string{"spiffe://webca.io/api-server", "https://xn--bcher-kva.example:8443/path"}, normalized.URIs)
+	assert.False(normalized.Empty())
+
+	normalized, err = model.SubjectAlternativeNames{}.Normalize()
+	assert.NoError(err)
+	assert.True(normalized.Empty())
+
+	invalid := []model.SubjectAlternativeNames{
+		{DNSNames: []string{""}},
+		{DNSNames: []string{"web_ca.io"}},
+		{DNSNames: []string{"api.*.webca.io"}},
+		{DNSNames: []string{"-webca.io"}},
+		{IPAddresses: []string{"10.0.0.256"}},
+		{IPAddresses: []string{"webca.io"}},
+		{EmailAddresses: []string{"admin"}},
+		{EmailAddresses: []string{"Admin <admin@webca.io>"}},
+		{EmailAddresses: []string{"ädmin@webca.io"}},
+		{URIs: []string{"/relative/path"}},
+		{URIs: []string{"urn:isbn:0451450523"}},
+	}
+
+	for _, names := range invalid {
+		_, err = names.Normalize()
+		assert.Error(err, names.String())
+	}
+}
+
+func TestRevocationRequest_Validate(t *testing.T) {
+	assert := assert.New(t)
+
+	past := timeutil.Now().Add(-time.Hour)
+	future := timeutil.Now().Add(time.Hour)
+
+	valid := []model.RevocationRequest{
+		{Reason: model.ReasonUnspecified},
+		{Reason: model.ReasonKeyCompromise, InvalidityDate: &past},
+		{Reason: model.ReasonCACompromise},
+		{Reason: model.ReasonAffiliationChanged},
+		{Reason: model.ReasonSuperseded},
+		{Reason: model.ReasonCessationOfOperation},
+		{Reason: model.ReasonPrivilegeWithdrawn},
+		{Reason: model.ReasonAACompromise},
+	}
+	for _, req := range valid {
+		assert.NoError(req.Validate(), req.Reason)
+	}
+
+	invalid := []model.RevocationRequest{
+		{Reason: -1},
+		{Reason: 7},
+		{Reason: 11},
+		{Reason: model.ReasonCertificateHold},
+		{Reason: model.ReasonRemoveFromCRL},
+		{Reason: model.ReasonKeyCompromise, InvalidityDate: &future},
+	}
+	for _, req := range invalid {
+		assert.Error(req.Validate(), req.Reason)
+	}
+}
+
+func TestACMEOrderRequest_Validate(t *testing.T) {
+	assert := assert.New(t)
+
+	req, err := model.ACMEOrderRequest{Identifiers: []model.ACMEIdentifier{
+		{Type: model.ACMEDNSIdentifier, Value: "App.WebCA.io"},
+		{Type: model.ACMEDNSIdentifier, Value: "*.webca.io"},
+		{Type: model.ACMEDNSIdentifier, Value: "app.webca.io"},
+	}}.Validate(nil)
+	assert.NoError(err)
+	assert.Equal([]model.ACMEIdentifier{
+		{Type: model.ACMEDNSIdentifier, Value: "app.webca.io"},
+		{Type: model.ACMEDNSIdentifier, Value: "*.webca.io"},
+	}, req.Identifiers)
+
+	rejected := []string{
+		"localhost",
+		"localhost.",
+		"app.localhost",
+		"metadata.google.internal",
+		"printer.local",
+		"router.home.arpa",
+		"1.0.0.127.in-addr.arpa",
+		"intranet",
+		"127.0.0.1",
+		"169.254.169.254",
+		"::1",
+		"127.1",
+		"0x7f.0x1",
+		"*.internal",
+	}
+	for _, name := range rejected {
+		_, err = model.ACMEOrderRequest{Identifiers: []model.ACMEIdentifier{{Type: model.ACMEDNSIdentifier, Value: name}}}.Validate(nil)
+		problem, ok := err.(*model.ACMEProblem)
+		if assert.True(ok, name) {
+			assert.Equal(model.ACMERejectedIdentifier, problem.Type, name)
+		}
+	}
+
+	allowedDomains := []string{"localhost", "corp.internal", ".home.arpa"}
+	for _, name := range []string{"localhost", "app.localhost", "corp.internal", "*.corp.internal", "router.home.arpa"} {
+		_, err = model.ACMEOrderRequest{Identifiers: []model.ACMEIdentifier{{Type: model.ACMEDNSIdentifier, Value: name}}}.Validate(allowedDomains)
+		assert.NoError(err, name)
+	}
+
+	for _, name := range []string{"home.arpa", "metadata.google.internal", "127.0.0.1", "*.internal"} {
+		_, err = model.ACMEOrderRequest{Identifiers: []model.ACMEIdentifier{{Type: model.ACMEDNSIdentifier, Value: name}}}.Validate(allowedDomains)
+		problem, ok := err.(*model.ACMEProblem)
+		if assert.True(ok, name) {
+			assert.Equal(model.ACMERejectedIdentifier, problem.Type, name)
+		}
+	}
+}
+
+func TestNameConstraints_Permits(t *testing.T) {
+	assert := assert.New(t)
+
+	constraints, err := model.NameConstraints{
+		Permitted: model.NameSubtrees{
+			DNSDomains:     []string{"webca.io", ".example.com"},
+			EmailAddresses: []string{"webca.io", "admin@example.com"},
+			IPRanges:       []string{"10.0.0.0/8", "fd00::/8"},
+			URIDomains:     []string{".webca.io"},
+		},
+		Excluded: model.NameSubtrees{
+			DNSDomains: []string{"secret.webca.io"},
+			IPRanges:   []string{"10.10.0.0/16"},
+		},
+	}.Normalize()
+	assert.NoError(err)
+
+	permitted := []model.SubjectAlternativeNames{
+		{DNSNames: []string{"webca.io", "api.webca.io", "a.b.webca.io"}},
+		{DNSNames: []string{"www.example.com", "*.api.webca.io"}},
+		{DNSNames: []string{"public.secret-webca.io.webca.io"}},
+		{IPAddresses: []string{"10.1.2.3", "fd00::1"}},
+		{EmailAddresses: []string{"user@webca.io", "admin@example.com"}},
+		{URIs: []string{"spiffe://cluster.webca.io/api"}},
+		{},
+	}
+	for _, names := range permitted {
+		assert.NoError(constraints.Permits(names), names.String())
+	}
+
+	forbidden := []model.SubjectAlternativeNames{
+		{DNSNames: []string{"example.com"}},
+		{DNSNames: []string{"webca.io.evil.com"}},
+		{DNSNames: []string{"notwebca.io"}},
+		{DNSNames: []string{"secret.webca.io"}},
+		{DNSNames: []string{"db.secret.webca.io"}},
+		{DNSNames: []string{"*.webca.io"}},
+		{IPAddresses: []string{"192.168.0.1"}},
+		{IPAddresses: []string{"10.10.1.1"}},
+		{IPAddresses: []string{"fe80::1"}},
+		{EmailAddresses: []string{"user@sub.webca.io"}},
+		{EmailAddresses: []string{"user@example.com"}},
+		{URIs: []string{"spiffe://webca.io/api"}},
+		{URIs: []string{"https://10.0.0.1/api"}},
+	}
+	for _, names := range forbidden {
+		assert.Error(constraints.Permits(names), names.String())
+	}
+}
+
+func TestNameConstraints_Normalize(t *testing.T) {
+	assert := assert.New(t)
+
+	constraints, err := model.NameConstraints{
+		Permitted: model.NameSubtrees{
+			DNSDomains:     []string{"WebCA.io", ".Bücher.example"},
+			EmailAddresses: []string{"Example.com", "admin@WebCA.io"},
+			IPRanges:       []string{"10.1.2.3/8"},
+		},
+	}.Normalize()
+	assert.NoError(err)
+	assert.Equal([]string{"webca.io", ".xn--bcher-kva.example"}, constraints.Permitted.DNSDomains)
+	assert.Equal([]string{"example.com", "admin@webca.io"}, constraints.Permitted.EmailAddresses)
+	assert.Equal([]string{"10.0.0.0/8"}, constraints.Permitted.IPRanges)
+
+	invalid := []model.NameConstraints{
+		{Permitted: model.NameSubtrees{DNSDomains: []string{"*.webca.io"}}},
+		{Permitted: model.NameSubtrees{IPRanges: []string{"10.0.0.1"}}},
+		{Excluded: model.NameSubtrees{IPRanges: []string{"10.0.0.0/33"}}},
+		{Excluded: model.NameSubtrees{EmailAddresses: []string{"not an email@"}}},
+		{Excluded: model.NameSubtrees{URIDomains: []string{""}}},
+	}
+	for _, constraints := range invalid {
+		_, err = constraints.Normalize()
+		assert.Error(err, constraints.String())
+	}
+}
+
+func TestAttachment_Content(t *testing.T) {
+	assert := assert.New(t)
+
+	pemAttachment := model.Attachment{
+		Body:        "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n",
+		ContentType: "text/plain",
+		Filename:    "cert.pem",
+	}
+	content, err := pemAttachment.Content()
+	assert.NoError(err)
+	assert.Equal([]byte(pemAttachment.Body), content)
+
+	binary := []byte{0x30, 0x82, 0x00, 0xff, 0xfe}
+	binaryAttachment := model.NewBinaryAttachment(binary, "application/pkix-cert", "cert.der")
+	assert.Equal("MIIA//4=", binaryAttachment.Body)
+	assert.Equal(model.Base64Encoding, binaryAttachment.Encoding)
+	content, err = binaryAttachment.Content()
+	assert.NoError(err)
+	assert.Equal(binary, content)
+
+	binaryAttachment.Body = "not base64!"
+	_, err = binaryAttachment.Content()
+	assert.Error(err)
+}
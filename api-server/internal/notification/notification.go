@@ -0,0 +1,142 @@
+// Package notification renders and delivers messages to users.
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/CzarSimon/httputil/logger"
+	"go.uber.org/zap"
+)
+
+var log = logger.GetDefaultLogger("api-server/notification")
+
+// Message templates
+const (
+	InvitationTemplate    = "INVITATION"
+	ExpiryWarningTemplate = "EXPIRY_WARNING"
+)
+
+// Message rendered notification addressed to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+func (m Message) String() string {
+	return fmt.Sprintf("Message(to=%s, subject=%s)", m.To, m.Subject)
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// InvitationData content of an invitation to join an account.
+type InvitationData struct {
+	AccountName string
+	Role        string
+	InvitedBy   string
+	Link        string
+	ValidTo     time.Time
+}
+
+// ExpiryWarningData content of a warning that a certificate is about to expire.
+type ExpiryWarningData struct {
+	AccountName     string
+	CertificateName string
+	SerialNumber    string
+	ExpiresAt       time.Time
+}
+
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var templates = map[string]messageTemplate{
+	InvitationTemplate: newMessageTemplate(InvitationTemplate,
+		`You have been invited to {{.AccountName}} on webca`,
+		`Hi,
+
+{{.InvitedBy}} has invited you to join the account {{.AccountName}} on webca as {{.Role}}.
+
+Accept the invitation and choose a password by following the link below:
+
+{{.Link}}
+
+The invitation is valid until {{formatTime .ValidTo}}. If you were not expecting it you can ignore this email.
+`),
+	ExpiryWarningTemplate: newMessageTemplate(ExpiryWarningTemplate,
+		`Certificate {{.CertificateName}} expires {{formatDate .ExpiresAt}}`,
+		`Hi,
+
+The certificate {{.CertificateName}} (serial number {{.SerialNumber}}) in the account {{.AccountName}} on webca expires at {{formatTime .ExpiresAt}}.
+
+Renew the certificate before then to avoid disruptions.
+`),
+}
+
+var templateFuncs = template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+	"formatDate": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02")
+	},
+}
+
+func newMessageTemplate(name, subject, body string) messageTemplate {
+	return messageTemplate{
+		subject: template.Must(template.New(name + ":subject").Funcs(templateFuncs).Option("missingkey=error").Parse(subject)),
+		body:    template.Must(template.New(name + ":body").Funcs(templateFuncs).Option("missingkey=error").Parse(body)),
+	}
+}
+
+// Render renders the named template with data into a message to a recipient.
+func Render(name, to string, data interface{}) (Message, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("notification: unknown template %s", name)
+	}
+
+	subject, err := execute(tmpl.subject, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	body, err := execute(tmpl.body, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}, nil
+}
+
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("notification: failed to render %s: %w", tmpl.Name(), err)
+	}
+
+	return buf.String(), nil
+}
+
+// LogSender logs messages instead of delivering them, for use when no mail server is configured.
+// Message bodies are not logged since they may contain secrets.
+type LogSender struct{}
+
+// Send logs the recipient and subject of a message.
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	log.Info("no mail server configured, skipping delivery", zap.String("to", msg.To), zap.String("subject", msg.Subject))
+	return nil
+}
@@ -0,0 +1,90 @@
+package notification
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CzarSimon/webca/api-server/internal/notification/smtptest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	assert := assert.New(t)
+
+	validTo := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+	msg, err := Render(InvitationTemplate, "new-user@webca.io", InvitationData{
+		AccountName: "test-account",
+		Role:        "ADMIN",
+		InvitedBy:   "admin@webca.io",
+		Link:        "https://webca.io/invitations/secret-token",
+		ValidTo:     validTo,
+	})
+	assert.NoError(err)
+	assert.Equal("new-user@webca.io", msg.To)
+	assert.Equal("You have been invited to test-account on webca", msg.Subject)
+	assert.Contains(msg.Body, "admin@webca.io has invited you to join the account test-account on webca as ADMIN.")
+	assert.Contains(msg.Body, "https://webca.io/invitations/secret-token")
+	assert.Contains(msg.Body, "2021-03-04 12:30 UTC")
+	assert.NotContains(msg.String(), "secret-token")
+
+	msg, err = Render(ExpiryWarningTemplate, "admin@webca.io", ExpiryWarningData{
+		AccountName:     "test-account",
+		CertificateName: "root-ca",
+		SerialNumber:    "0a:1b",
+		ExpiresAt:       validTo,
+	})
+	assert.NoError(err)
+	assert.Equal("Certificate root-ca expires 2021-03-04", msg.Subject)
+	assert.Contains(msg.Body, "serial number 0a:1b")
+
+	_, err = Render("UNKNOWN", "user@webca.io", nil)
+	assert.Error(err)
+
+	_, err = Render(ExpiryWarningTemplate, "admin@webca.io", InvitationData{})
+	assert.Error(err)
+}
+
+func TestSMTPSender(t *testing.T) {
+	assert := assert.New(t)
+
+	server := smtptest.NewServer()
+	defer server.Close()
+
+	sender := &SMTPSender{
+		Host: server.Host,
+		Port: server.Port,
+		From: "webca <no-reply@webca.io>",
+	}
+
+	msg := Message{
+		To:      "new-user@webca.io",
+		Subject: "Välkommen to webca",
+		Body:    "Hi,\n\n.a line starting with a dot and a long line " + strings.Repeat("x", 100) + "\n",
+	}
+	err := sender.Send(context.Background(), msg)
+	assert.NoError(err)
+
+	messages := server.Messages()
+	assert.Len(messages, 1)
+	assert.Equal("no-reply@webca.io", messages[0].From)
+	assert.Equal([]string{"new-user@webca.io"}, messages[0].To)
+	assert.Equal(msg.Subject, messages[0].Subject)
+	assert.Equal(msg.Body, messages[0].Body)
+
+	server.Reject(1)
+	err = sender.Send(context.Background(), msg)
+	assert.Error(err)
+	assert.Len(server.Messages(), 1)
+
+	msg.To = "new-user@webca.io\r\nBcc: attacker@example.com"
+	err = sender.Send(context.Background(), msg)
+	assert.Error(err)
+	assert.Len(server.Messages(), 1)
+
+	server.Close()
+	msg.To = "new-user@webca.io"
+	err = sender.Send(context.Background(), msg)
+	assert.Error(err)
+}
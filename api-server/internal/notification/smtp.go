@@ -0,0 +1,163 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPSender delivers messages through an SMTP server. STARTTLS is used when the server supports it
+// and credentials are only sent when a Username is configured.
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+// Send delivers a message to its recipient.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("notification: invalid sender address: %w", err)
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("notification: invalid recipient address: %w", err)
+	}
+
+	data, err := encodeMessage(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = s.deliver(client, from.Address, to.Address, data)
+	if err != nil {
+		return fmt.Errorf("notification: failed to deliver %s: %w", msg, err)
+	}
+
+	return client.Quit()
+}
+
+func (s *SMTPSender) connect(ctx context.Context) (*smtp.Client, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, s.Port))
+	if err != nil {
+		return nil, fmt.Errorf("notification: failed to connect to smtp server: %w", err)
+	}
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("notification: failed to greet smtp server: %w", err)
+	}
+
+	err = s.authenticate(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (s *SMTPSender) authenticate(client *smtp.Client) error {
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err := client.StartTLS(&tls.Config{ServerName: s.Host})
+		if err != nil {
+			return fmt.Errorf("notification: failed to start tls: %w", err)
+		}
+	}
+
+	if s.Username == "" {
+		return nil
+	}
+
+	err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+	if err != nil {
+		return fmt.Errorf("notification: failed to authenticate with smtp server: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SMTPSender) deliver(client *smtp.Client, from, to string, data []byte) error {
+	err := client.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(to)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func encodeMessage(from, to *mail.Address, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", timeutil.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	_, err := w.Write(bytes.ReplaceAll([]byte(msg.Body), []byte("\n"), []byte("\r\n")))
+	if err != nil {
+		return nil, fmt.Errorf("notification: failed to encode %s: %w", msg, err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("notification: failed to encode %s: %w", msg, err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,198 @@
+// Package smtptest provides an in-process SMTP server for testing mail delivery.
+package smtptest
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// Message mail received by a Server.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	Data    []byte
+}
+
+// Server minimal SMTP server which accepts all mail and records it.
+// Deliveries can be made to fail with Reject.
+type Server struct {
+	Host string
+	Port string
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []Message
+	reject   int
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a Server listening on a random local port.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("smtptest: failed to listen on a port: %v", err))
+	}
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	s := &Server{
+		Host:     host,
+		Port:     port,
+		listener: l,
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]Message, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
+
+// Reject makes the server reject the next n deliveries with a temporary failure.
+func (s *Server) Reject(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reject = n
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	reply("220 smtptest ready")
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 smtptest")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = Message{From: parsePath(line)}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.To = append(msg.To, parsePath(line))
+			reply("250 OK")
+		case cmd == "DATA":
+			if s.shouldReject() {
+				reply("451 delivery temporarily rejected")
+				continue
+			}
+
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			msg.Data, err = readData(r)
+			if err != nil {
+				return
+			}
+
+			s.record(msg)
+			reply("250 OK")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func (s *Server) shouldReject() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reject == 0 {
+		return false
+	}
+
+	s.reject--
+	return true
+}
+
+func (s *Server) record(msg Message) {
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg.Data)))
+	if err == nil {
+		msg.Subject, _ = new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(parsed.Body))
+		if err == nil {
+			msg.Body = strings.ReplaceAll(string(body), "\r\n", "\n")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msg)
+}
+
+func readData(r *bufio.Reader) ([]byte, error) {
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		if line == ".\r\n" {
+			return []byte(data.String()), nil
+		}
+
+		data.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+func parsePath(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return line[start+1 : end]
+}
@@ -0,0 +1,478 @@
+package pkcs12util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Iterations number of key derivation iterations used for both encryption and the integrity MAC,
+// matches the default used by OpenSSL 3.
+const Iterations = 2048
+
+const (
+	saltSize    = 16
+	aesKeySize  = 32
+	pfxVersion  = 3
+	macKeyID    = 3
+	sha256Block = 64
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509Certificate  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256           = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC                = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256                   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// Bundle certificate, issuer chain and private key to store in a PKCS#12 archive.
+type Bundle struct {
+	FriendlyName   string
+	PrivateKey     interface{}
+	Certificate    *x509.Certificate
+	CACertificates []*x509.Certificate
+}
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	AlgorithmIdentifier pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// Encode creates a DER encoded PKCS#12 archive of a bundle. The private key and certificates are encrypted
+// with AES-256-CBC using a key derived by PBKDF2 with HMAC-SHA256 and the archive is integrity protected by
+// an HMAC-SHA256 MAC, both keyed by the supplied password.
+func Encode(bundle Bundle, password string) ([]byte, error) {
+	if bundle.Certificate == nil || bundle.PrivateKey == nil {
+		return nil, errors.New("pkcs12util: certificate and private key are required")
+	}
+
+	attributes, err := bagAttributes(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	certBags := make([]safeBag, 0, len(bundle.CACertificates)+1)
+	leafBag, err := newCertBag(bundle.Certificate, attributes)
+	if err != nil {
+		return nil, err
+	}
+	certBags = append(certBags, leafBag)
+
+	for _, cert := range bundle.CACertificates {
+		bag, err := newCertBag(cert, nil)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+	}
+
+	keyBag, err := newShroudedKeyBag(bundle.PrivateKey, password, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	certContent, err := newEncryptedContentInfo(certBags, password)
+	if err != nil {
+		return nil, err
+	}
+
+	keyContent, err := newDataContentInfo([]safeBag{keyBag})
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{certContent, keyContent})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to marshal authenticated safe: %w", err)
+	}
+
+	mac, err := newMacData(authSafe, password)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to marshal authenticated safe content: %w", err)
+	}
+
+	pfx := pfxPdu{
+		Version:  pfxVersion,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicit(content)},
+		MacData:  mac,
+	}
+
+	der, err := asn1.Marshal(pfx)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to marshal pfx: %w", err)
+	}
+
+	return der, nil
+}
+
+// bagAttributes creates the attributes linking the leaf certificate to its private key,
+// the localKeyId is the SHA-1 fingerprint of the certificate as is customary.
+func bagAttributes(bundle Bundle) ([]pkcs12Attribute, error) {
+	fingerprint := sha1.Sum(bundle.Certificate.Raw)
+	keyID, err := asn1.Marshal(fingerprint[:])
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to marshal localKeyId: %w", err)
+	}
+
+	attributes := []pkcs12Attribute{
+		{ID: oidLocalKeyID, Value: setOf(keyID)},
+	}
+
+	if bundle.FriendlyName != "" {
+		name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(bundle.FriendlyName)})
+		if err != nil {
+			return nil, fmt.Errorf("pkcs12util: failed to marshal friendlyName: %w", err)
+		}
+		attributes = append(attributes, pkcs12Attribute{ID: oidFriendlyName, Value: setOf(name)})
+	}
+
+	return attributes, nil
+}
+
+func newCertBag(cert *x509.Certificate, attributes []pkcs12Attribute) (safeBag, error) {
+	bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509Certificate, Data: cert.Raw})
+	if err != nil {
+		return safeBag{}, fmt.Errorf("pkcs12util: failed to marshal certificate bag: %w", err)
+	}
+
+	return safeBag{
+		ID:         oidCertBag,
+		Value:      explicit(bag),
+		Attributes: attributes,
+	}, nil
+}
+
+// EncryptPrivateKey creates a DER encoded PKCS#8 EncryptedPrivateKeyInfo of a private key, encrypted in
+// the same way as the key bag of a bundle created by Encode.
+func EncryptPrivateKey(privateKey interface{}, password string) ([]byte, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to marshal private key: %w", err)
+	}
+
+	algorithm, ciphertext, err := encrypt(pkcs8, password)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		AlgorithmIdentifier: algorithm,
+		EncryptedData:       ciphertext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to marshal encrypted private key: %w", err)
+	}
+
+	return der, nil
+}
+
+func newShroudedKeyBag(privateKey interface{}, password string, attributes []pkcs12Attribute) (safeBag, error) {
+	bag, err := EncryptPrivateKey(privateKey, password)
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	return safeBag{
+		ID:         oidPKCS8ShroudedKeyBag,
+		Value:      explicit(bag),
+		Attributes: attributes,
+	}, nil
+}
+
+func newDataContentInfo(bags []safeBag) (contentInfo, error) {
+	contents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, fmt.Errorf("pkcs12util: failed to marshal safe contents: %w", err)
+	}
+
+	data, err := asn1.Marshal(contents)
+	if err != nil {
+		return contentInfo{}, fmt.Errorf("pkcs12util: failed to marshal data content: %w", err)
+	}
+
+	return contentInfo{ContentType: oidDataContentType, Content: explicit(data)}, nil
+}
+
+func newEncryptedContentInfo(bags []safeBag, password string) (contentInfo, error) {
+	contents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, fmt.Errorf("pkcs12util: failed to marshal safe contents: %w", err)
+	}
+
+	algorithm, ciphertext, err := encrypt(contents, password)
+	if err != nil {
+		return contentInfo{}, err
+	}
+
+	data, err := asn1.Marshal(encryptedData{
+		Version: 0,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: algorithm,
+			EncryptedContent:           ciphertext,
+		},
+	})
+	if err != nil {
+		return contentInfo{}, fmt.Errorf("pkcs12util: failed to marshal encrypted data: %w", err)
+	}
+
+	return contentInfo{
+		ContentType: oidEncryptedDataContentType,
+		Content:     explicit(data),
+	}, nil
+}
+
+// encrypt encrypts plaintext using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC. The password
+// is used as its UTF-8 bytes as specified for PBES2 in RFC 9579.
+func encrypt(plaintext []byte, password string) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt, err := randomBytes(saltSize)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	iv, err := randomBytes(aes.BlockSize)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, Iterations, aesKeySize, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, fmt.Errorf("pkcs12util: failed to create cipher: %w", err)
+	}
+
+	ciphertext := pad(plaintext, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	algorithm, err := pbes2Algorithm(salt, iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	return algorithm, ciphertext, nil
+}
+
+func pbes2Algorithm(salt, iv []byte) (pkix.AlgorithmIdentifier, error) {
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("pkcs12util: failed to marshal pbkdf2 parameters: %w", err)
+	}
+
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("pkcs12util: failed to marshal iv: %w", err)
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("pkcs12util: failed to marshal pbes2 parameters: %w", err)
+	}
+
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oidPBES2,
+		Parameters: asn1.RawValue{FullBytes: params},
+	}, nil
+}
+
+func newMacData(authSafe []byte, password string) (macData, error) {
+	salt, err := randomBytes(saltSize)
+	if err != nil {
+		return macData{}, err
+	}
+
+	key := pkcs12KDF(sha256.New, sha256Block, bmpPassword(password), salt, Iterations, macKeyID, sha256.Size)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(authSafe)
+
+	return macData{
+		Mac: digestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			Digest:    mac.Sum(nil),
+		},
+		MacSalt:    salt,
+		Iterations: Iterations,
+	}, nil
+}
+
+// pkcs12KDF derives key material using the PKCS#12 key derivation function described in RFC 7292 appendix B.2,
+// which is what PKCS#12 readers expect for the integrity MAC key.
+func pkcs12KDF(newHash func() hash.Hash, v int, password, salt []byte, iterations int, id byte, size int) []byte {
+	diversifier := make([]byte, v)
+	for i := range diversifier {
+		diversifier[i] = id
+	}
+
+	input := append(repeatToBlocks(salt, v), repeatToBlocks(password, v)...)
+	out := make([]byte, 0, size)
+	h := newHash()
+	for len(out) < size {
+		h.Reset()
+		h.Write(diversifier)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		b := repeatToBlocks(a, v)[:v]
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+
+	return out[:size]
+}
+
+func repeatToBlocks(b []byte, v int) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+
+	out := make([]byte, v*((len(b)+v-1)/v))
+	for i := range out {
+		out[i] = b[i%len(b)]
+	}
+	return out
+}
+
+// bmpPassword encodes a password as a null terminated big endian UTF-16 string as required by the PKCS#12 KDF.
+func bmpPassword(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
+
+func bmpString(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	out := make([]byte, 0, len(codes)*2)
+	for _, c := range codes {
+		out = append(out, byte(c>>8), byte(c))
+	}
+	return out
+}
+
+func pad(b []byte, blockSize int) []byte {
+	n := blockSize - len(b)%blockSize
+	padded := make([]byte, len(b), len(b)+n)
+	copy(padded, b)
+	for i := 0; i < n; i++ {
+		padded = append(padded, byte(n))
+	}
+	return padded
+}
+
+// explicit wraps a DER encoded value in an explicit [0] tag, which encoding/asn1 does not add to raw values.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func setOf(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+func randomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12util: failed to read random bytes: %w", err)
+	}
+	return b, nil
+}
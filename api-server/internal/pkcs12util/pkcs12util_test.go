@@ -0,0 +1,334 @@
+package pkcs12util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// openSSLBundle created with `openssl pkcs12 -export` using the OpenSSL 3 defaults
+// (PBES2 AES-256-CBC, SHA-256 MAC) and the password "fixture-password".
+const openSSLBundle = `MIIEHAIBAzCCA9IGCSqGSIb3DQEHAaCCA8MEggO/MIIDuzCCAnIGCSqGSIb3DQEHBqCCAmMwggJf
+AgEAMIICWAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjEL7s/bg5V
+7QICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEA5zPrdz1ci2JQwjYcqx3HCAggHwnfPS
+xK9Ai//wPi0/ewvA8doCBkWxy2quqfM7C665ybjlP9dSu+aYOqN0J918aFq/CO82lbPM7LwKGJU8
+KAPp7YF1Fm/+IEdMXyGf7HFAn6PXvm7AazfYKA+SARHrkSKGz4wzghpam4Os02EPs+eJJfPH5QhK
+lJb6EK/bh7nahSWXs2ITHVQDX2mmfNrr0YRhZ7fEU2Q5k5pgzIbCnQI/C8xkp9V0iZpNJsrtHvFo
+83Uzp2chWc5fVHIeUVKJiwd35hdqTjmN0Kciecf6dAB142v62hVNe2z33mptCsHMykP4fMYlmRKZ
+/4Oex9qnFPVWzdPam8a2EvIxyg+I/6PddetZ2NP8CXtQ7q9mG92zyIbnEX8EKA9c1doLIXilErAW
+kleyA+vb0ZSXoQLgc2nsG5Uk6dpKFG7n18s5mvEQWDq/VOrOsw6xzUYCv5r+xOnk8mwACnkqHiuu
+Jg1rBJeHK+Dtl2YSBgWJ0cwwc+/Kij59ZYWF1rPwnNe0+JLim0qutPhFkldetpqMZB5yjGoXzL3g
+3jakG1mwDrowJNoCwB/7+MJa/mMUzy5lWRO7QgevOueDjWO0i3jvDlmt5FVbTkcVR+egVu+7B4Ob
+HeFxnyo+RNVUQbgJjdaLhud2Y/4U/5ImnV6RZpa3xynzvOiyBzCCAUEGCSqGSIb3DQEHAaCCATIE
+ggEuMIIBKjCCASYGCyqGSIb3DQEMCgECoIHvMIHsMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEF
+DDAcBAhKaK5BE/3L+gICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEJ6kuaT1qbv88tD8
++PZL2uUEgZA9QajjcCqFo0QRpeWp85bGZGp/k9QHUYlY0RRvXUS5BmcWNlM0wRG7TdI7BWY7kH+h
+Y+6Hju611sEIO85Li4oamxdnC9osCy839io3/G/GJv99tTDeV2nBeiGTlslSuKNIwW/YLcJMurd3
+fU53GeePOWFj72HG8agJcIIEDTIIakfByY2+9dA6c47vnK5zFf8xJTAjBgkqhkiG9w0BCRUxFgQU
+lcNTzsJ7wtwB5yZYNONK0Wvhd10wQTAxMA0GCWCGSAFlAwQCAQUABCDQrO9op9tTa7silpYUvDMq
++7GmpTlrcp+Z4UJ/fR3/+gQIRkcxYgFjS1MCAggA`
+
+func TestEncode(t *testing.T) {
+	assert := assert.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(err)
+	root := createTestCertificate(t, "root", &rootKey.PublicKey, nil, rootKey)
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	intermediate := createTestCertificate(t, "intermediate", &intermediateKey.PublicKey, root, rootKey)
+
+	keys := []crypto.Signer{rsaKey, ecdsaKey, ed25519Key}
+	for _, key := range keys {
+		leaf := createTestCertificate(t, "leaf", key.Public(), intermediate, intermediateKey)
+		der, err := Encode(Bundle{
+			FriendlyName:   "Leaf Certificäte",
+			PrivateKey:     key,
+			Certificate:    leaf,
+			CACertificates: []*x509.Certificate{intermediate, root},
+		}, "export-pässword")
+		assert.NoError(err)
+
+		decoded, err := decode(der, "export-pässword")
+		assert.NoError(err)
+		assert.Equal(key, decoded.key)
+		assert.Len(decoded.certs, 3)
+		assert.Equal(leaf.Raw, decoded.certs[0].Raw)
+		assert.Equal(intermediate.Raw, decoded.certs[1].Raw)
+		assert.Equal(root.Raw, decoded.certs[2].Raw)
+		assert.Equal("Leaf Certificäte", decoded.friendlyName)
+
+		_, err = decode(der, "wrong-password")
+		assert.Error(err)
+	}
+}
+
+func TestEncode_MissingInput(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	cert := createTestCertificate(t, "leaf", &key.PublicKey, nil, key)
+
+	_, err = Encode(Bundle{Certificate: cert}, "export-password")
+	assert.Error(err)
+
+	_, err = Encode(Bundle{PrivateKey: key}, "export-password")
+	assert.Error(err)
+}
+
+func TestEncryptPrivateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	der, err := EncryptPrivateKey(key, "export-password")
+	assert.NoError(err)
+
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	assert.NoError(err)
+	assert.Empty(rest)
+	assert.True(info.AlgorithmIdentifier.Algorithm.Equal(oidPBES2))
+
+	pkcs8, err := decrypt(info.AlgorithmIdentifier, info.EncryptedData, "export-password")
+	assert.NoError(err)
+	decoded, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	assert.NoError(err)
+	assert.Equal(key, decoded)
+
+	_, err = EncryptPrivateKey("not a key", "export-password")
+	assert.Error(err)
+}
+
+// TestDecodeOpenSSLBundle checks the test decoder, and through it the key derivation used by Encode,
+// against a bundle created by OpenSSL.
+func TestDecodeOpenSSLBundle(t *testing.T) {
+	assert := assert.New(t)
+
+	der, err := base64.StdEncoding.DecodeString(openSSLBundle)
+	assert.NoError(err)
+
+	decoded, err := decode(der, "fixture-password")
+	assert.NoError(err)
+	assert.IsType(&ecdsa.PrivateKey{}, decoded.key)
+	assert.Len(decoded.certs, 1)
+	assert.Equal("openssl-fixture", decoded.certs[0].Subject.CommonName)
+	assert.Equal(decoded.certs[0].PublicKey, decoded.key.(*ecdsa.PrivateKey).Public())
+
+	_, err = decode(der, "wrong-password")
+	assert.Error(err)
+}
+
+type decodedBundle struct {
+	key          interface{}
+	certs        []*x509.Certificate
+	friendlyName string
+}
+
+func decode(der []byte, password string) (decodedBundle, error) {
+	var pfx pfxPdu
+	_, err := asn1.Unmarshal(der, &pfx)
+	if err != nil {
+		return decodedBundle{}, err
+	}
+
+	var authSafe []byte
+	_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe)
+	if err != nil {
+		return decodedBundle{}, err
+	}
+
+	macKey := pkcs12KDF(sha256.New, sha256Block, bmpPassword(password), pfx.MacData.MacSalt, pfx.MacData.Iterations, macKeyID, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		return decodedBundle{}, errors.New("mac verification failed")
+	}
+
+	var contents []contentInfo
+	_, err = asn1.Unmarshal(authSafe, &contents)
+	if err != nil {
+		return decodedBundle{}, err
+	}
+
+	var bundle decodedBundle
+	for _, content := range contents {
+		var safeContents []byte
+		if content.ContentType.Equal(oidEncryptedDataContentType) {
+			var data encryptedData
+			_, err = asn1.Unmarshal(content.Content.Bytes, &data)
+			if err != nil {
+				return decodedBundle{}, err
+			}
+			info := data.EncryptedContentInfo
+			safeContents, err = decrypt(info.ContentEncryptionAlgorithm, info.EncryptedContent, password)
+		} else {
+			_, err = asn1.Unmarshal(content.Content.Bytes, &safeContents)
+		}
+		if err != nil {
+			return decodedBundle{}, err
+		}
+
+		var bags []safeBag
+		_, err = asn1.Unmarshal(safeContents, &bags)
+		if err != nil {
+			return decodedBundle{}, err
+		}
+
+		for _, bag := range bags {
+			err = decodeBag(bag, password, &bundle)
+			if err != nil {
+				return decodedBundle{}, err
+			}
+		}
+	}
+
+	return bundle, nil
+}
+
+func decodeBag(bag safeBag, password string, bundle *decodedBundle) error {
+	for _, attribute := range bag.Attributes {
+		if attribute.ID.Equal(oidFriendlyName) {
+			var name asn1.RawValue
+			_, err := asn1.Unmarshal(attribute.Value.Bytes, &name)
+			if err != nil {
+				return err
+			}
+			bundle.friendlyName = decodeBMPString(name.Bytes)
+		}
+	}
+
+	switch {
+	case bag.ID.Equal(oidCertBag):
+		var cb certBag
+		_, err := asn1.Unmarshal(bag.Value.Bytes, &cb)
+		if err != nil {
+			return err
+		}
+		cert, err := x509.ParseCertificate(cb.Data)
+		if err != nil {
+			return err
+		}
+		bundle.certs = append(bundle.certs, cert)
+	case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+		var info encryptedPrivateKeyInfo
+		_, err := asn1.Unmarshal(bag.Value.Bytes, &info)
+		if err != nil {
+			return err
+		}
+		pkcs8, err := decrypt(info.AlgorithmIdentifier, info.EncryptedData, password)
+		if err != nil {
+			return err
+		}
+		bundle.key, err = x509.ParsePKCS8PrivateKey(pkcs8)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unexpected safe bag type")
+	}
+
+	return nil
+}
+
+func decrypt(algorithm pkix.AlgorithmIdentifier, ciphertext []byte, password string) ([]byte, error) {
+	if !algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errors.New("unexpected encryption algorithm")
+	}
+
+	var params pbes2Params
+	_, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	var kdfParams pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key([]byte(password), kdfParams.Salt, kdfParams.IterationCount, aesKeySize, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	n := int(plaintext[len(plaintext)-1])
+	if n < 1 || n > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("invalid padding")
+	}
+
+	return plaintext[:len(plaintext)-n], nil
+}
+
+func decodeBMPString(b []byte) string {
+	codes := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		codes = append(codes, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(codes))
+}
+
+func createTestCertificate(t *testing.T, name string, pub crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil || name != "leaf",
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent = template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
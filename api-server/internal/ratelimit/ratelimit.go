@@ -0,0 +1,83 @@
+// Package ratelimit provides in-memory rate limiting of requests per client.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+)
+
+// Limiter allows each key a burst of requests which is refilled evenly over an interval.
+type Limiter struct {
+	burst    float64
+	interval time.Duration
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	sweptAt  time.Time
+	now      func() time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewLimiter creates a Limiter that allows limit requests per key and interval.
+func NewLimiter(limit int, interval time.Duration) *Limiter {
+	return &Limiter{
+		burst:    float64(limit),
+		interval: interval,
+		buckets:  make(map[string]*bucket),
+		now:      timeutil.Now,
+	}
+}
+
+// Allow reports whether a request for the key is allowed and if so consumes it from the key's allowance.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.removeFull(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updatedAt)
+	tokens := b.tokens + l.burst*float64(elapsed)/float64(l.interval)
+	if tokens > l.burst {
+		return l.burst
+	}
+
+	return tokens
+}
+
+// removeFull forgets keys whose allowance has been completely refilled, as they are
+// indistinguishable from keys that have not been seen before. Sweeps at most once per interval.
+func (l *Limiter) removeFull(now time.Time) {
+	if now.Sub(l.sweptAt) < l.interval {
+		return
+	}
+
+	l.sweptAt = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= l.interval {
+			delete(l.buckets, key)
+		}
+	}
+}
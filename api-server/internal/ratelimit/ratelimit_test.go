@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.True(l.Allow("client-1"), "request %d should be allowed", i)
+	}
+	assert.False(l.Allow("client-1"))
+	assert.True(l.Allow("client-2"))
+
+	now = now.Add(20 * time.Second)
+	assert.True(l.Allow("client-1"))
+	assert.False(l.Allow("client-1"))
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.True(l.Allow("client-1"), "request %d should be allowed after the allowance was refilled", i)
+	}
+	assert.False(l.Allow("client-1"))
+	assert.Len(l.buckets, 1, "the refilled allowance of client-2 should have been forgotten")
+}
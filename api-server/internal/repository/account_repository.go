@@ -12,6 +12,8 @@ import (
 // AccountRepository data access layer for accounts.
 type AccountRepository interface {
 	Save(ctx context.Context, account model.Account) error
+	Update(ctx context.Context, account model.Account) error
+	Find(ctx context.Context, id string) (model.Account, bool, error)
 	FindByName(ctx context.Context, name string) (model.Account, bool, error)
 }
 
@@ -26,15 +28,34 @@ type accountRepo struct {
 	db *sql.DB
 }
 
+const findAccountQuery = `
+	SELECT id, name, validity_policy, invitation_lifetime_hours, created_at, updated_at FROM account WHERE id = ?`
+
+func (r *accountRepo) Find(ctx context.Context, id string) (model.Account, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "account_repo_find")
+	defer span.Finish()
+
+	var a model.Account
+	err := r.db.QueryRowContext(ctx, findAccountQuery, id).Scan(&a.ID, &a.Name, &a.ValidityPolicy, &a.InvitationLifetimeHours, &a.CreatedAt, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return model.Account{}, false, nil
+	}
+	if err != nil {
+		return model.Account{}, false, fmt.Errorf("failed to query account by id=%s: %w", id, err)
+	}
+
+	return a, true, nil
+}
+
 const findAccountByNameQuery = `
-	SELECT id, name, created_at, updated_at FROM account WHERE name = ?`
+	SELECT id, name, validity_policy, invitation_lifetime_hours, created_at, updated_at FROM account WHERE name = ?`
 
 func (r *accountRepo) FindByName(ctx context.Context, name string) (model.Account, bool, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "account_repo_find_by_name")
 	defer span.Finish()
 
 	var a model.Account
-	err := r.db.QueryRowContext(ctx, findAccountByNameQuery, name).Scan(&a.ID, &a.Name, &a.CreatedAt, &a.UpdatedAt)
+	err := r.db.QueryRowContext(ctx, findAccountByNameQuery, name).Scan(&a.ID, &a.Name, &a.ValidityPolicy, &a.InvitationLifetimeHours, &a.CreatedAt, &a.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return model.Account{}, false, nil
 	}
@@ -46,16 +67,31 @@ func (r *accountRepo) FindByName(ctx context.Context, name string) (model.Accoun
 }
 
 const saveAccountQuery = `
-	INSERT INTO account(id, name, created_at, updated_at) VALUES (?, ?, ?, ?)`
+	INSERT INTO account(id, name, validity_policy, invitation_lifetime_hours, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
 
 func (r *accountRepo) Save(ctx context.Context, account model.Account) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "account_repo_save")
 	defer span.Finish()
 
-	_, err := r.db.ExecContext(ctx, saveAccountQuery, account.ID, account.Name, account.CreatedAt, account.UpdatedAt)
+	_, err := r.db.ExecContext(ctx, saveAccountQuery, account.ID, account.Name, account.ValidityPolicy, account.InvitationLifetimeHours, account.CreatedAt, account.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to save %s: %w", account, err)
 	}
 
 	return nil
 }
+
+const updateAccountQuery = `
+	UPDATE account SET validity_policy = ?, invitation_lifetime_hours = ?, updated_at = ? WHERE id = ?`
+
+func (r *accountRepo) Update(ctx context.Context, account model.Account) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "account_repo_update")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, updateAccountQuery, account.ValidityPolicy, account.InvitationLifetimeHours, account.UpdatedAt, account.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", account, err)
+	}
+
+	return nil
+}
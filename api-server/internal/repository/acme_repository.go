@@ -0,0 +1,670 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CzarSimon/httputil/dbutil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// ACMERepository data access layer for ACME nonces, accounts, orders, authorizations and challenges.
+type ACMERepository interface {
+	SaveEnrollment(ctx context.Context, enrollment model.ACMEEnrollment) error
+	FindEnrollment(ctx context.Context, certificateID string) (model.ACMEEnrollment, bool, error)
+	DeleteEnrollment(ctx context.Context, certificateID string) (bool, error)
+	SaveNonce(ctx context.Context, nonce string, expiresAt time.Time) error
+	UseNonce(ctx context.Context, nonce string, now time.Time) (bool, error)
+	DeleteExpiredNonces(ctx context.Context, now time.Time) error
+	SaveAccount(ctx context.Context, account model.ACMEAccount) error
+	FindAccount(ctx context.Context, id string) (model.ACMEAccount, bool, error)
+	FindAccountByThumbprint(ctx context.Context, certificateID, thumbprint string) (model.ACMEAccount, bool, error)
+	UpdateAccount(ctx context.Context, account model.ACMEAccount) error
+	SaveOrder(ctx context.Context, order model.ACMEOrder, authorizations []model.ACMEAuthorization) error
+	FindOrder(ctx context.Context, id string) (model.ACMEOrder, bool, error)
+	UpdateOrder(ctx context.Context, order model.ACMEOrder, fromStatus string) (bool, error)
+	FindAuthorization(ctx context.Context, id string) (model.ACMEAuthorization, bool, error)
+	FindAuthorizationsByOrderID(ctx context.Context, orderID string) ([]model.ACMEAuthorization, error)
+	FindChallenge(ctx context.Context, id string) (model.ACMEChallenge, bool, error)
+	UpdateChallenge(ctx context.Context, challenge model.ACMEChallenge, fromStatus string) (bool, error)
+	CompleteChallenge(ctx context.Context, challenge model.ACMEChallenge, authorization model.ACMEAuthorization) error
+}
+
+// NewACMERepository creates an ACMERepository using the default implementation.
+func NewACMERepository(db *sql.DB) ACMERepository {
+	return &acmeRepo{
+		db: db,
+	}
+}
+
+type acmeRepo struct {
+	db *sql.DB
+}
+
+const saveACMEEnrollmentQuery = `
+	INSERT INTO acme_enrollment(certificate_id, allowed_domains, allowed_networks, created_by, created_at) VALUES (?, ?, ?, ?, ?)`
+
+func (r *acmeRepo) SaveEnrollment(ctx context.Context, enrollment model.ACMEEnrollment) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_save_enrollment")
+	defer span.Finish()
+
+	domains, err := encodeJSON(enrollment.AllowedDomains)
+	if err != nil {
+		return fmt.Errorf("failed to encode allowed domains of %s: %w", enrollment, err)
+	}
+
+	networks, err := encodeJSON(enrollment.AllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("failed to encode allowed networks of %s: %w", enrollment, err)
+	}
+
+	_, err = r.db.ExecContext(ctx, saveACMEEnrollmentQuery, enrollment.CertificateID, domains, networks, enrollment.CreatedBy, enrollment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", enrollment, err)
+	}
+
+	return nil
+}
+
+const findACMEEnrollmentQuery = `
+	SELECT
+		certificate_id,
+		allowed_domains,
+		allowed_networks,
+		created_by,
+		created_at
+	FROM
+		acme_enrollment
+	WHERE
+		certificate_id = ?`
+
+func (r *acmeRepo) FindEnrollment(ctx context.Context, certificateID string) (model.ACMEEnrollment, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_enrollment")
+	defer span.Finish()
+
+	var e model.ACMEEnrollment
+	var domains, networks string
+	err := r.db.QueryRowContext(ctx, findACMEEnrollmentQuery, certificateID).Scan(&e.CertificateID, &domains, &networks, &e.CreatedBy, &e.CreatedAt)
+	if err == sql.ErrNoRows {
+		return model.ACMEEnrollment{}, false, nil
+	}
+	if err != nil {
+		return model.ACMEEnrollment{}, false, fmt.Errorf("failed to query acme_enrollment(certificate_id=%s): %w", certificateID, err)
+	}
+
+	err = json.Unmarshal([]byte(domains), &e.AllowedDomains)
+	if err != nil {
+		return model.ACMEEnrollment{}, false, fmt.Errorf("failed to decode allowed domains of %s: %w", e, err)
+	}
+
+	err = json.Unmarshal([]byte(networks), &e.AllowedNetworks)
+	if err != nil {
+		return model.ACMEEnrollment{}, false, fmt.Errorf("failed to decode allowed networks of %s: %w", e, err)
+	}
+
+	return e, true, nil
+}
+
+const deleteACMEEnrollmentQuery = `
+	DELETE FROM acme_enrollment WHERE certificate_id = ?`
+
+func (r *acmeRepo) DeleteEnrollment(ctx context.Context, certificateID string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_delete_enrollment")
+	defer span.Finish()
+
+	res, err := r.db.ExecContext(ctx, deleteACMEEnrollmentQuery, certificateID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete acme_enrollment(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return rowsAffected(res)
+}
+
+const saveACMENonceQuery = `
+	INSERT INTO acme_nonce(id, expires_at) VALUES (?, ?)`
+
+func (r *acmeRepo) SaveNonce(ctx context.Context, nonce string, expiresAt time.Time) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_save_nonce")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, saveACMENonceQuery, nonce, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert acme_nonce: %w", err)
+	}
+
+	return nil
+}
+
+const useACMENonceQuery = `
+	DELETE FROM acme_nonce WHERE id = ? AND expires_at > ?`
+
+func (r *acmeRepo) UseNonce(ctx context.Context, nonce string, now time.Time) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_use_nonce")
+	defer span.Finish()
+
+	res, err := r.db.ExecContext(ctx, useACMENonceQuery, nonce, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete acme_nonce: %w", err)
+	}
+
+	return rowsAffected(res)
+}
+
+const deleteExpiredACMENoncesQuery = `
+	DELETE FROM acme_nonce WHERE expires_at <= ?`
+
+func (r *acmeRepo) DeleteExpiredNonces(ctx context.Context, now time.Time) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_delete_expired_nonces")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, deleteExpiredACMENoncesQuery, now)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired acme_nonce rows: %w", err)
+	}
+
+	return nil
+}
+
+const saveACMEAccountQuery = `
+	INSERT INTO acme_account(id, certificate_id, status, contact, public_key, key_thumbprint, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+func (r *acmeRepo) SaveAccount(ctx context.Context, account model.ACMEAccount) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_save_account")
+	defer span.Finish()
+
+	contact, err := encodeJSON(account.Contact)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, saveACMEAccountQuery,
+		account.ID, account.CertificateID, account.Status, contact, account.PublicKey, account.KeyThumbprint, account.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", account, err)
+	}
+
+	return nil
+}
+
+const findACMEAccountQuery = `
+	SELECT
+		id,
+		certificate_id,
+		status,
+		contact,
+		public_key,
+		key_thumbprint,
+		created_at
+	FROM
+		acme_account
+	WHERE
+		id = ?`
+
+func (r *acmeRepo) FindAccount(ctx context.Context, id string) (model.ACMEAccount, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_account")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findACMEAccountQuery, id)
+	account, found, err := scanACMEAccount(row)
+	if err != nil {
+		return model.ACMEAccount{}, false, fmt.Errorf("failed to query acme_account(id=%s): %w", id, err)
+	}
+
+	return account, found, nil
+}
+
+const findACMEAccountByThumbprintQuery = `
+	SELECT
+		id,
+		certificate_id,
+		status,
+		contact,
+		public_key,
+		key_thumbprint,
+		created_at
+	FROM
+		acme_account
+	WHERE
+		certificate_id = ?
+		AND key_thumbprint = ?`
+
+func (r *acmeRepo) FindAccountByThumbprint(ctx context.Context, certificateID, thumbprint string) (model.ACMEAccount, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_account_by_thumbprint")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findACMEAccountByThumbprintQuery, certificateID, thumbprint)
+	account, found, err := scanACMEAccount(row)
+	if err != nil {
+		return model.ACMEAccount{}, false, fmt.Errorf("failed to query acme_account(certificate_id=%s, key_thumbprint=%s): %w", certificateID, thumbprint, err)
+	}
+
+	return account, found, nil
+}
+
+const updateACMEAccountQuery = `
+	UPDATE acme_account SET status = ?, contact = ? WHERE id = ?`
+
+func (r *acmeRepo) UpdateAccount(ctx context.Context, account model.ACMEAccount) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_update_account")
+	defer span.Finish()
+
+	contact, err := encodeJSON(account.Contact)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, updateACMEAccountQuery, account.Status, contact, account.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", account, err)
+	}
+
+	return nil
+}
+
+const saveACMEOrderQuery = `
+	INSERT INTO acme_order(id, account_id, status, identifiers, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`
+
+const saveACMEAuthorizationQuery = `
+	INSERT INTO acme_authorization(id, order_id, identifier_type, identifier_value, wildcard, status, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+const saveACMEChallengeQuery = `
+	INSERT INTO acme_challenge(id, authorization_id, type, token, status) VALUES (?, ?, ?, ?, ?)`
+
+func (r *acmeRepo) SaveOrder(ctx context.Context, order model.ACMEOrder, authorizations []model.ACMEAuthorization) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_save_order")
+	defer span.Finish()
+
+	identifiers, err := encodeJSON(order.Identifiers)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, saveACMEOrderQuery, order.ID, order.AccountID, order.Status, identifiers, order.CreatedAt, order.ExpiresAt)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to insert %s: %w", order, err)
+	}
+
+	for _, authz := range authorizations {
+		_, err = tx.ExecContext(ctx, saveACMEAuthorizationQuery,
+			authz.ID, authz.OrderID, authz.Identifier.Type, authz.Identifier.Value, authz.Wildcard, authz.Status, authz.ExpiresAt,
+		)
+		if err != nil {
+			dbutil.Rollback(tx)
+			return fmt.Errorf("failed to insert %s: %w", authz, err)
+		}
+
+		for _, chal := range authz.Challenges {
+			_, err = tx.ExecContext(ctx, saveACMEChallengeQuery, chal.ID, chal.AuthorizationID, chal.Type, chal.Token, chal.Status)
+			if err != nil {
+				dbutil.Rollback(tx)
+				return fmt.Errorf("failed to insert %s: %w", chal, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+const findACMEOrderQuery = `
+	SELECT
+		id,
+		account_id,
+		status,
+		identifiers,
+		error,
+		certificate_id,
+		created_at,
+		expires_at
+	FROM
+		acme_order
+	WHERE
+		id = ?`
+
+func (r *acmeRepo) FindOrder(ctx context.Context, id string) (model.ACMEOrder, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_order")
+	defer span.Finish()
+
+	var o model.ACMEOrder
+	var identifiers string
+	var problem, certID sql.NullString
+	err := r.db.QueryRowContext(ctx, findACMEOrderQuery, id).Scan(
+		&o.ID, &o.AccountID, &o.Status, &identifiers, &problem, &certID, &o.CreatedAt, &o.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.ACMEOrder{}, false, nil
+	}
+	if err != nil {
+		return model.ACMEOrder{}, false, fmt.Errorf("failed to query acme_order(id=%s): %w", id, err)
+	}
+
+	err = json.Unmarshal([]byte(identifiers), &o.Identifiers)
+	if err != nil {
+		return model.ACMEOrder{}, false, fmt.Errorf("failed to decode identifiers of %s: %w", o, err)
+	}
+
+	o.Error, err = decodeACMEProblem(problem)
+	if err != nil {
+		return model.ACMEOrder{}, false, err
+	}
+
+	o.CertificateID = certID.String
+	return o, true, nil
+}
+
+const updateACMEOrderQuery = `
+	UPDATE acme_order SET status = ?, error = ?, certificate_id = ? WHERE id = ? AND status = ?`
+
+func (r *acmeRepo) UpdateOrder(ctx context.Context, order model.ACMEOrder, fromStatus string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_update_order")
+	defer span.Finish()
+
+	problem, err := encodeACMEProblem(order.Error)
+	if err != nil {
+		return false, err
+	}
+
+	certID := sql.NullString{
+		String: order.CertificateID,
+		Valid:  order.CertificateID != "",
+	}
+
+	res, err := r.db.ExecContext(ctx, updateACMEOrderQuery, order.Status, problem, certID, order.ID, fromStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", order, err)
+	}
+
+	return rowsAffected(res)
+}
+
+const findACMEAuthorizationQuery = `
+	SELECT
+		id,
+		order_id,
+		identifier_type,
+		identifier_value,
+		wildcard,
+		status,
+		expires_at
+	FROM
+		acme_authorization
+	WHERE
+		id = ?`
+
+const findACMEChallengesByAuthorizationIDQuery = `
+	SELECT
+		id,
+		authorization_id,
+		type,
+		token,
+		status,
+		validated_at,
+		error
+	FROM
+		acme_challenge
+	WHERE
+		authorization_id = ?
+	ORDER BY type`
+
+func (r *acmeRepo) FindAuthorization(ctx context.Context, id string) (model.ACMEAuthorization, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_authorization")
+	defer span.Finish()
+
+	var a model.ACMEAuthorization
+	err := r.db.QueryRowContext(ctx, findACMEAuthorizationQuery, id).Scan(
+		&a.ID, &a.OrderID, &a.Identifier.Type, &a.Identifier.Value, &a.Wildcard, &a.Status, &a.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.ACMEAuthorization{}, false, nil
+	}
+	if err != nil {
+		return model.ACMEAuthorization{}, false, fmt.Errorf("failed to query acme_authorization(id=%s): %w", id, err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, findACMEChallengesByAuthorizationIDQuery, id)
+	if err != nil {
+		return model.ACMEAuthorization{}, false, fmt.Errorf("failed to query acme_challenge(authorization_id=%s): %w", id, err)
+	}
+	defer rows.Close()
+
+	a.Challenges = make([]model.ACMEChallenge, 0)
+	for rows.Next() {
+		c, err := scanACMEChallenge(rows)
+		if err != nil {
+			return model.ACMEAuthorization{}, false, err
+		}
+		a.Challenges = append(a.Challenges, c)
+	}
+
+	return a, true, nil
+}
+
+const findACMEAuthorizationsByOrderIDQuery = `
+	SELECT
+		id,
+		order_id,
+		identifier_type,
+		identifier_value,
+		wildcard,
+		status,
+		expires_at
+	FROM
+		acme_authorization
+	WHERE
+		order_id = ?
+	ORDER BY identifier_value`
+
+func (r *acmeRepo) FindAuthorizationsByOrderID(ctx context.Context, orderID string) ([]model.ACMEAuthorization, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_authorizations_by_order_id")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findACMEAuthorizationsByOrderIDQuery, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query acme_authorization(order_id=%s): %w", orderID, err)
+	}
+	defer rows.Close()
+
+	authorizations := make([]model.ACMEAuthorization, 0)
+	for rows.Next() {
+		var a model.ACMEAuthorization
+		err = rows.Scan(&a.ID, &a.OrderID, &a.Identifier.Type, &a.Identifier.Value, &a.Wildcard, &a.Status, &a.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan acme_authorization row: %w", err)
+		}
+		authorizations = append(authorizations, a)
+	}
+
+	return authorizations, nil
+}
+
+const findACMEChallengeQuery = `
+	SELECT
+		id,
+		authorization_id,
+		type,
+		token,
+		status,
+		validated_at,
+		error
+	FROM
+		acme_challenge
+	WHERE
+		id = ?`
+
+func (r *acmeRepo) FindChallenge(ctx context.Context, id string) (model.ACMEChallenge, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_find_challenge")
+	defer span.Finish()
+
+	c, err := scanACMEChallenge(r.db.QueryRowContext(ctx, findACMEChallengeQuery, id))
+	if err == sql.ErrNoRows {
+		return model.ACMEChallenge{}, false, nil
+	}
+	if err != nil {
+		return model.ACMEChallenge{}, false, fmt.Errorf("failed to query acme_challenge(id=%s): %w", id, err)
+	}
+
+	return c, true, nil
+}
+
+const updateACMEChallengeQuery = `
+	UPDATE acme_challenge SET status = ?, validated_at = ?, error = ? WHERE id = ? AND status = ?`
+
+func (r *acmeRepo) UpdateChallenge(ctx context.Context, challenge model.ACMEChallenge, fromStatus string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_update_challenge")
+	defer span.Finish()
+
+	problem, err := encodeACMEProblem(challenge.Error)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.db.ExecContext(ctx, updateACMEChallengeQuery,
+		challenge.Status, nullTime(challenge.ValidatedAt), problem, challenge.ID, fromStatus,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", challenge, err)
+	}
+
+	return rowsAffected(res)
+}
+
+const updateACMEAuthorizationStatusQuery = `
+	UPDATE acme_authorization SET status = ? WHERE id = ?`
+
+func (r *acmeRepo) CompleteChallenge(ctx context.Context, challenge model.ACMEChallenge, authorization model.ACMEAuthorization) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_repo_complete_challenge")
+	defer span.Finish()
+
+	problem, err := encodeACMEProblem(challenge.Error)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, updateACMEChallengeQuery,
+		challenge.Status, nullTime(challenge.ValidatedAt), problem, challenge.ID, model.ACMEStatusProcessing,
+	)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to update %s: %w", challenge, err)
+	}
+
+	_, err = tx.ExecContext(ctx, updateACMEAuthorizationStatusQuery, authorization.Status, authorization.ID)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to update %s: %w", authorization, err)
+	}
+
+	return tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanACMEAccount(row rowScanner) (model.ACMEAccount, bool, error) {
+	var a model.ACMEAccount
+	var contact string
+	err := row.Scan(&a.ID, &a.CertificateID, &a.Status, &contact, &a.PublicKey, &a.KeyThumbprint, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return model.ACMEAccount{}, false, nil
+	}
+	if err != nil {
+		return model.ACMEAccount{}, false, err
+	}
+
+	err = json.Unmarshal([]byte(contact), &a.Contact)
+	if err != nil {
+		return model.ACMEAccount{}, false, fmt.Errorf("failed to decode contact of %s: %w", a, err)
+	}
+
+	return a, true, nil
+}
+
+func scanACMEChallenge(row rowScanner) (model.ACMEChallenge, error) {
+	var c model.ACMEChallenge
+	var validatedAt sql.NullTime
+	var problem sql.NullString
+	err := row.Scan(&c.ID, &c.AuthorizationID, &c.Type, &c.Token, &c.Status, &validatedAt, &problem)
+	if err != nil {
+		return model.ACMEChallenge{}, err
+	}
+
+	if validatedAt.Valid {
+		c.ValidatedAt = &validatedAt.Time
+	}
+
+	c.Error, err = decodeACMEProblem(problem)
+	if err != nil {
+		return model.ACMEChallenge{}, err
+	}
+
+	return c, nil
+}
+
+func encodeJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %v: %w", v, err)
+	}
+
+	return string(b), nil
+}
+
+func encodeACMEProblem(problem *model.ACMEProblem) (sql.NullString, error) {
+	if problem == nil {
+		return sql.NullString{}, nil
+	}
+
+	s, err := encodeJSON(problem)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: s, Valid: true}, nil
+}
+
+func decodeACMEProblem(s sql.NullString) (*model.ACMEProblem, error) {
+	if !s.Valid || s.String == "" {
+		return nil, nil
+	}
+
+	var problem model.ACMEProblem
+	err := json.Unmarshal([]byte(s.String), &problem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode acme problem: %w", err)
+	}
+
+	return &problem, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func rowsAffected(res sql.Result) (bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check number of affected rows: %w", err)
+	}
+
+	return n > 0, nil
+}
@@ -3,14 +3,32 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/CzarSimon/httputil/dbutil"
 	"github.com/CzarSimon/webca/api-server/internal/model"
 	"github.com/opentracing/opentracing-go"
 )
 
+// ErrDuplicateSerialNumber returned when a certificate is saved with a serial number that is already in use by its issuer.
+var ErrDuplicateSerialNumber = errors.New("duplicate certificate serial number")
+
+// ErrDuplicateKeyPair returned when a certificate is saved with a new key pair whose keys are already stored,
+// which is the case when importing an already imported certificate.
+var ErrDuplicateKeyPair = errors.New("duplicate key pair")
+
+// ErrDuplicateGeneration returned when a certificate is saved with a name and generation that is already in use in its account,
+// or as a renewal of a certificate that already has a successor. This is the case when certificates are created or renewed concurrently.
+var ErrDuplicateGeneration = errors.New("duplicate certificate generation")
+
+// ErrCertificateNotActive returned when revoking a certificate that is no longer active,
+// which is the case when it has been revoked concurrently.
+var ErrCertificateNotActive = errors.New("certificate is not active")
+
 // CertificateRepository data access layer for certificates.
 type CertificateRepository interface {
 	Save(ctx context.Context, cert model.Certificate) error
@@ -18,7 +36,12 @@ type CertificateRepository interface {
 	FindByNameAndAccountID(ctx context.Context, name, accountID string) (model.Certificate, bool, error)
 	FindByAccountID(ctx context.Context, accountID string) ([]model.Certificate, error)
 	FindByAccountIDAndTypes(ctx context.Context, accountID string, types []string) ([]model.Certificate, error)
+	FindBySignatoryIDAndSerialNumber(ctx context.Context, signatoryID string, serialNumber string) (model.Certificate, bool, error)
+	FindRevokedBySignatoryID(ctx context.Context, signatoryID string) ([]model.Certificate, error)
+	FindExpiringWithoutWarning(ctx context.Context, now, expiresBefore time.Time) ([]model.Certificate, error)
+	SaveExpiryWarning(ctx context.Context, cert model.Certificate, createdAt time.Time) (bool, error)
 	FindTypes(ctx context.Context) ([]model.CertificateType, error)
+	Revoke(ctx context.Context, cert model.Certificate) error
 }
 
 // NewCertificateRepository creates an CertificateRepository using the default implementation.
@@ -33,7 +56,7 @@ type certRepo struct {
 }
 
 const saveCertificateQuery = `
-	INSERT INTO certificate(id, name, serial_number, subject, body, format, type, key_pair_id, signatory_id, account_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	INSERT INTO certificate(id, name, serial_number, subject, subject_alternative_names, body, format, type, key_pair_id, signatory_id, account_id, status, created_at, expires_at, predecessor_id, generation, issuer_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 func (r *certRepo) Save(ctx context.Context, cert model.Certificate) error {
 	span, _ := opentracing.StartSpanFromContext(ctx, "key_pair_repo_save")
@@ -44,29 +67,90 @@ func (r *certRepo) Save(ctx context.Context, cert model.Certificate) error {
 		return fmt.Errorf("failed to start transtaction: %w", err)
 	}
 
-	key := cert.KeyPair
-	_, err = tx.ExecContext(ctx, saveKeyPairQuery,
-		key.ID, key.PublicKey, key.PrivateKey, key.Format, key.Algorithm, key.EncryptionSalt,
-		key.Credentials.Password, key.Credentials.Salt, key.AccountID, key.CreatedAt,
-	)
+	err = saveCertificate(ctx, tx, cert)
 	if err != nil {
 		dbutil.Rollback(tx)
-		return fmt.Errorf("failed to insert %s: %w", key, err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// saveCertificate stores a certificate and its key pair, unless the key pair is already stored.
+// Violations of the unique keys of the key pair or of the serial number are reported as ErrDuplicateKeyPair and ErrDuplicateSerialNumber.
+func saveCertificate(ctx context.Context, tx *sql.Tx, cert model.Certificate) error {
+	key := cert.KeyPair
+	keyPairID := sql.NullString{
+		String: key.ID,
+		Valid:  key.ID != "",
+	}
+	if keyPairID.Valid {
+		err := saveKeyPairIfNew(ctx, tx, key)
+		if isDuplicateKey(err, keyPairPublicKey) || isDuplicateKey(err, keyPairPrivateKey) {
+			return fmt.Errorf("failed to insert %s: %w", key, ErrDuplicateKeyPair)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
 	sigID := sql.NullString{
 		String: cert.SignatoryID,
 		Valid:  cert.SignatoryID != "",
 	}
+	altNames, err := encodeAlternativeNames(cert.SubjectAlternativeNames)
+	if err != nil {
+		return err
+	}
+
+	status := cert.Status
+	if status == "" {
+		status = model.CertificateActive
+	}
+
+	predecessorID := sql.NullString{
+		String: cert.PredecessorID,
+		Valid:  cert.PredecessorID != "",
+	}
+	generation := cert.Generation
+	if generation == 0 {
+		generation = 1
+	}
+
 	_, err = tx.ExecContext(ctx, saveCertificateQuery,
-		cert.ID, cert.Name, cert.SerialNumber, cert.Subject.String(), cert.Body, cert.Format, cert.Type, cert.KeyPair.ID, sigID, cert.AccountID, cert.CreatedAt, cert.ExpiresAt,
+		cert.ID, cert.Name, cert.SerialNumber, cert.Subject.String(), altNames, cert.Body, cert.Format, cert.Type, keyPairID, sigID, cert.AccountID, status, cert.CreatedAt, cert.ExpiresAt,
+		predecessorID, generation, cert.IssuerID,
 	)
+	if isDuplicateKey(err, certificateSerialNumberKey) {
+		return fmt.Errorf("failed to insert %s: %w", cert, ErrDuplicateSerialNumber)
+	}
+	if isDuplicateKey(err, certificatePredecessorKey) || isDuplicateKey(err, certificateGenerationKey) {
+		return fmt.Errorf("failed to insert %s: %w", cert, ErrDuplicateGeneration)
+	}
 	if err != nil {
-		dbutil.Rollback(tx)
 		return fmt.Errorf("failed to insert %s: %w", cert, err)
 	}
 
-	return tx.Commit()
+	return nil
+}
+
+// saveKeyPairIfNew stores a key pair unless it already exists,
+// which is the case for renewed certificates that reuse the key pair of their predecessor.
+func saveKeyPairIfNew(ctx context.Context, tx *sql.Tx, key model.KeyPair) error {
+	_, exists, err := findKeyPair(ctx, tx, key.ID)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, saveKeyPairQuery,
+		key.ID, key.PublicKey, key.PrivateKey, key.Format, key.Algorithm, key.EncryptionSalt,
+		key.Credentials.Password, key.Credentials.Salt, key.AccountID, key.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", key, err)
+	}
+
+	return nil
 }
 
 const findCertificateQuery = `
@@ -74,13 +158,21 @@ const findCertificateQuery = `
 		id, 
 		name,
 		serial_number,
+		subject_alternative_names,
 		body,
 		format,
 		type,
 		signatory_id,
 		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
 		created_at,
-		expires_at
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
 	FROM 
 		certificate
 	WHERE
@@ -91,9 +183,14 @@ func (r *certRepo) Find(ctx context.Context, id string) (model.Certificate, bool
 	defer span.Finish()
 
 	var c model.Certificate
+	var rev revocationColumns
+	var ren renewalColumns
 	sigID := sql.NullString{}
+	altNames := sql.NullString{}
 	err := r.db.QueryRowContext(ctx, findCertificateQuery, id).Scan(
-		&c.ID, &c.Name, &c.SerialNumber, &c.Body, &c.Format, &c.Type, &sigID, &c.AccountID, &c.CreatedAt, &c.ExpiresAt,
+		&c.ID, &c.Name, &c.SerialNumber, &altNames, &c.Body, &c.Format, &c.Type, &sigID, &c.AccountID,
+		&c.Status, &rev.revokedAt, &rev.reason, &rev.invalidityDate, &c.CreatedAt, &c.ExpiresAt,
+		&ren.predecessorID, &ren.successorID, &c.Generation,
 	)
 	if err == sql.ErrNoRows {
 		return model.Certificate{}, false, nil
@@ -102,7 +199,15 @@ func (r *certRepo) Find(ctx context.Context, id string) (model.Certificate, bool
 		return model.Certificate{}, false, fmt.Errorf("failed to query certificate(id=%s): %w", id, err)
 	}
 
+	c.SubjectAlternativeNames, err = decodeAlternativeNames(altNames)
+	if err != nil {
+		return model.Certificate{}, false, err
+	}
+
 	c.SignatoryID = sigID.String
+	c.Revocation = rev.revocation()
+	c.PredecessorID = ren.predecessorID.String
+	c.SuccessorID = ren.successorID.String
 	return c, true, nil
 }
 
@@ -111,20 +216,32 @@ const findCertificateByNameAndAccountIDQuery = `
 		id, 
 		name,
 		serial_number,
+		subject_alternative_names,
 		body,
 		format,
 		type,
 		key_pair_id,
 		signatory_id,
 		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
 		created_at,
-		expires_at
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
 	FROM 
 		certificate
 	WHERE
 		name = ?
-		AND account_id = ?`
+		AND account_id = ?
+	ORDER BY
+		generation DESC
+	LIMIT 1`
 
+// FindByNameAndAccountID finds the latest generation of a named certificate in an account.
 func (r *certRepo) FindByNameAndAccountID(ctx context.Context, name, accountID string) (model.Certificate, bool, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "cert_repo_find_by_name_and_account_id")
 	defer span.Finish()
@@ -135,10 +252,15 @@ func (r *certRepo) FindByNameAndAccountID(ctx context.Context, name, accountID s
 	}
 
 	var c model.Certificate
-	var keyPairID string
+	var rev revocationColumns
+	var ren renewalColumns
+	keyPairID := sql.NullString{}
 	sigID := sql.NullString{}
+	altNames := sql.NullString{}
 	err = tx.QueryRowContext(ctx, findCertificateByNameAndAccountIDQuery, name, accountID).Scan(
-		&c.ID, &c.Name, &c.SerialNumber, &c.Body, &c.Format, &c.Type, &keyPairID, &sigID, &c.AccountID, &c.CreatedAt, &c.ExpiresAt,
+		&c.ID, &c.Name, &c.SerialNumber, &altNames, &c.Body, &c.Format, &c.Type, &keyPairID, &sigID, &c.AccountID,
+		&c.Status, &rev.revokedAt, &rev.reason, &rev.invalidityDate, &c.CreatedAt, &c.ExpiresAt,
+		&ren.predecessorID, &ren.successorID, &c.Generation,
 	)
 	if err == sql.ErrNoRows {
 		dbutil.Rollback(tx)
@@ -149,14 +271,27 @@ func (r *certRepo) FindByNameAndAccountID(ctx context.Context, name, accountID s
 		return model.Certificate{}, false, fmt.Errorf("failed to query certificate(name=%s, account_id=%s): %w", name, accountID, err)
 	}
 
-	keyPair, found, err := findKeyPair(ctx, tx, keyPairID)
+	c.SubjectAlternativeNames, err = decodeAlternativeNames(altNames)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return model.Certificate{}, false, err
+	}
+
+	c.SignatoryID = sigID.String
+	c.Revocation = rev.revocation()
+	c.PredecessorID = ren.predecessorID.String
+	c.SuccessorID = ren.successorID.String
+	if !keyPairID.Valid {
+		return c, true, tx.Commit()
+	}
+
+	keyPair, found, err := findKeyPair(ctx, tx, keyPairID.String)
 	if !found || err != nil {
 		dbutil.Rollback(tx)
 		return model.Certificate{}, found, err
 	}
 
 	c.KeyPair = keyPair
-	c.SignatoryID = sigID.String
 	return c, true, tx.Commit()
 }
 
@@ -200,13 +335,21 @@ const findCertificatesByAccountIDQuery = `
 		id, 
 		name,
 		serial_number,
+		subject_alternative_names,
 		body,
 		format,
 		type,
 		signatory_id,
 		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
 		created_at,
-		expires_at
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
 	FROM 
 		certificate
 	WHERE
@@ -230,13 +373,21 @@ const findCertificatesByAccountIDAndTypesQuery = `
 		id, 
 		name,
 		serial_number,
+		subject_alternative_names,
 		body,
 		format,
 		type,
 		signatory_id,
 		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
 		created_at,
-		expires_at
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
 	FROM 
 		certificate
 	WHERE
@@ -258,6 +409,153 @@ func (r *certRepo) FindByAccountIDAndTypes(ctx context.Context, accountID string
 	return mapRowsToCertificates(rows)
 }
 
+const findCertificateBySignatoryIDAndSerialNumberQuery = `
+	SELECT 
+		id, 
+		name,
+		serial_number,
+		subject_alternative_names,
+		body,
+		format,
+		type,
+		signatory_id,
+		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
+		created_at,
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
+	FROM 
+		certificate
+	WHERE
+		signatory_id = ?
+		AND serial_number = ?`
+
+func (r *certRepo) FindBySignatoryIDAndSerialNumber(ctx context.Context, signatoryID string, serialNumber string) (model.Certificate, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cert_repo_find_by_signatory_id_and_serial_number")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findCertificateBySignatoryIDAndSerialNumberQuery, signatoryID, serialNumber)
+	if err != nil {
+		return model.Certificate{}, false, fmt.Errorf("failed to query certificate by signatoryId=%s and serialNumber=%s: %w", signatoryID, serialNumber, err)
+	}
+	defer rows.Close()
+
+	certs, err := mapRowsToCertificates(rows)
+	if err != nil || len(certs) == 0 {
+		return model.Certificate{}, false, err
+	}
+
+	return certs[0], true, nil
+}
+
+const findRevokedCertificatesBySignatoryIDQuery = `
+	SELECT 
+		id, 
+		name,
+		serial_number,
+		subject_alternative_names,
+		body,
+		format,
+		type,
+		signatory_id,
+		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
+		created_at,
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
+	FROM 
+		certificate
+	WHERE
+		signatory_id = ?
+		AND status = ?`
+
+func (r *certRepo) FindRevokedBySignatoryID(ctx context.Context, signatoryID string) ([]model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cert_repo_find_revoked_by_signatory_id")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findRevokedCertificatesBySignatoryIDQuery, signatoryID, model.CertificateRevoked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revoked certificate by signatoryId=%s: %w", signatoryID, err)
+	}
+	defer rows.Close()
+
+	return mapRowsToCertificates(rows)
+}
+
+const findExpiringCertificatesWithoutWarningQuery = `
+	SELECT 
+		id, 
+		name,
+		serial_number,
+		subject_alternative_names,
+		body,
+		format,
+		type,
+		signatory_id,
+		account_id,
+		status,
+		revoked_at,
+		revocation_reason,
+		invalidity_date,
+		created_at,
+		expires_at,
+		predecessor_id,
+		(SELECT s.id FROM certificate s WHERE s.predecessor_id = certificate.id),
+		generation
+	FROM 
+		certificate
+	WHERE
+		status = ?
+		AND expires_at > ?
+		AND expires_at <= ?
+		AND NOT EXISTS (SELECT 1 FROM certificate s WHERE s.predecessor_id = certificate.id)
+		AND NOT EXISTS (SELECT 1 FROM certificate_expiry_warning w WHERE w.certificate_id = certificate.id)`
+
+// FindExpiringWithoutWarning finds active certificates that expire before expiresBefore, have not been renewed
+// and that no expiry warning has been saved for.
+func (r *certRepo) FindExpiringWithoutWarning(ctx context.Context, now, expiresBefore time.Time) ([]model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cert_repo_find_expiring_without_warning")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findExpiringCertificatesWithoutWarningQuery, model.CertificateActive, now, expiresBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates expiring before %v: %w", expiresBefore, err)
+	}
+	defer rows.Close()
+
+	return mapRowsToCertificates(rows)
+}
+
+const saveExpiryWarningQuery = `
+	INSERT INTO certificate_expiry_warning(certificate_id, created_at) VALUES (?, ?)`
+
+// SaveExpiryWarning records that the expiry of a certificate is being warned about.
+// False is returned if a warning has already been saved, so that only one warning is sent.
+func (r *certRepo) SaveExpiryWarning(ctx context.Context, cert model.Certificate, createdAt time.Time) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cert_repo_save_expiry_warning")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, saveExpiryWarningQuery, cert.ID, createdAt)
+	if isDuplicateKey(err, expiryWarningKey) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to save expiry warning of %s: %w", cert, err)
+	}
+
+	return true, nil
+}
+
 func createAccountIDAndTypesArgs(accountID string, types []string) []interface{} {
 	args := make([]interface{}, 1, len(types)+1)
 	args[0] = accountID
@@ -268,6 +566,54 @@ func createAccountIDAndTypesArgs(accountID string, types []string) []interface{}
 	return args
 }
 
+const revokeCertificateQuery = `
+	UPDATE 
+		certificate
+	SET
+		status = ?,
+		revoked_at = ?,
+		revocation_reason = ?,
+		invalidity_date = ?
+	WHERE
+		id = ?
+		AND status = ?`
+
+func (r *certRepo) Revoke(ctx context.Context, cert model.Certificate) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cert_repo_revoke")
+	defer span.Finish()
+
+	if cert.Revocation == nil {
+		return fmt.Errorf("cannot revoke %s without a revocation", cert)
+	}
+
+	rev := cert.Revocation
+	invalidityDate := sql.NullTime{}
+	if rev.InvalidityDate != nil {
+		invalidityDate = sql.NullTime{
+			Time:  *rev.InvalidityDate,
+			Valid: true,
+		}
+	}
+
+	res, err := r.db.ExecContext(ctx, revokeCertificateQuery,
+		model.CertificateRevoked, rev.RevokedAt, rev.Reason, invalidityDate, cert.ID, model.CertificateActive,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke %s: %w", cert, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check result of revoking %s: %w", cert, err)
+	}
+
+	if updated != 1 {
+		return fmt.Errorf("failed to revoke %s: %w", cert, ErrCertificateNotActive)
+	}
+
+	return nil
+}
+
 const findCertificateTypesQuery = `
 	SELECT 
 		name,
@@ -305,16 +651,90 @@ func (r *certRepo) FindTypes(ctx context.Context) ([]model.CertificateType, erro
 func mapRowsToCertificates(rows *sql.Rows) ([]model.Certificate, error) {
 	certs := make([]model.Certificate, 0)
 
-	var c model.Certificate
-	sigID := sql.NullString{}
 	for rows.Next() {
-		err := rows.Scan(&c.ID, &c.Name, &c.SerialNumber, &c.Body, &c.Format, &c.Type, &sigID, &c.AccountID, &c.CreatedAt, &c.ExpiresAt)
+		var c model.Certificate
+		var rev revocationColumns
+		var ren renewalColumns
+		sigID := sql.NullString{}
+		altNames := sql.NullString{}
+		err := rows.Scan(
+			&c.ID, &c.Name, &c.SerialNumber, &altNames, &c.Body, &c.Format, &c.Type, &sigID, &c.AccountID,
+			&c.Status, &rev.revokedAt, &rev.reason, &rev.invalidityDate, &c.CreatedAt, &c.ExpiresAt,
+			&ren.predecessorID, &ren.successorID, &c.Generation,
+		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row for certificate. %w", err)
 		}
+
+		c.SubjectAlternativeNames, err = decodeAlternativeNames(altNames)
+		if err != nil {
+			return nil, err
+		}
+
 		c.SignatoryID = sigID.String
+		c.Revocation = rev.revocation()
+		c.PredecessorID = ren.predecessorID.String
+		c.SuccessorID = ren.successorID.String
 		certs = append(certs, c)
 	}
 
 	return certs, nil
 }
+
+func encodeAlternativeNames(names model.SubjectAlternativeNames) (sql.NullString, error) {
+	if names.Empty() {
+		return sql.NullString{}, nil
+	}
+
+	b, err := json.Marshal(names)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode subject alternative names [%s]: %w", names, err)
+	}
+
+	return sql.NullString{
+		String: string(b),
+		Valid:  true,
+	}, nil
+}
+
+func decodeAlternativeNames(s sql.NullString) (model.SubjectAlternativeNames, error) {
+	var names model.SubjectAlternativeNames
+	if !s.Valid || s.String == "" {
+		return names, nil
+	}
+
+	err := json.Unmarshal([]byte(s.String), &names)
+	if err != nil {
+		return model.SubjectAlternativeNames{}, fmt.Errorf("failed to decode subject alternative names: %w", err)
+	}
+
+	return names, nil
+}
+
+type renewalColumns struct {
+	predecessorID sql.NullString
+	successorID   sql.NullString
+}
+
+type revocationColumns struct {
+	revokedAt      sql.NullTime
+	reason         sql.NullInt64
+	invalidityDate sql.NullTime
+}
+
+func (r revocationColumns) revocation() *model.Revocation {
+	if !r.revokedAt.Valid {
+		return nil
+	}
+
+	rev := &model.Revocation{
+		Reason:    int(r.reason.Int64),
+		RevokedAt: r.revokedAt.Time,
+	}
+	if r.invalidityDate.Valid {
+		invalidityDate := r.invalidityDate.Time
+		rev.InvalidityDate = &invalidityDate
+	}
+
+	return rev
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// CertificateProfileRepository data access layer for certificate profiles.
+type CertificateProfileRepository interface {
+	Save(ctx context.Context, profile model.CertificateProfile) error
+	Update(ctx context.Context, profile model.CertificateProfile) error
+	Delete(ctx context.Context, id string) error
+	Find(ctx context.Context, id string) (model.CertificateProfile, bool, error)
+	FindByNameAndAccountID(ctx context.Context, name, accountID string) (model.CertificateProfile, bool, error)
+	FindByAccountID(ctx context.Context, accountID string) ([]model.CertificateProfile, error)
+}
+
+// NewCertificateProfileRepository creates a CertificateProfileRepository using the default implementation.
+func NewCertificateProfileRepository(db *sql.DB) CertificateProfileRepository {
+	return &profileRepo{
+		db: db,
+	}
+}
+
+type profileRepo struct {
+	db *sql.DB
+}
+
+const saveCertificateProfileQuery = `
+	INSERT INTO certificate_profile(
+		id,
+		name,
+		key_usage,
+		ext_key_usage,
+		default_validity_days,
+		max_validity_days,
+		algorithms,
+		key_sizes,
+		required_san_types,
+		account_id,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (r *profileRepo) Save(ctx context.Context, profile model.CertificateProfile) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_repo_save")
+	defer span.Finish()
+
+	policy, err := encodeProfilePolicy(profile)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, saveCertificateProfileQuery,
+		profile.ID,
+		profile.Name,
+		policy.keyUsage,
+		policy.extKeyUsage,
+		profile.DefaultValidityDays,
+		profile.MaxValidityDays,
+		policy.algorithms,
+		policy.keySizes,
+		policy.requiredSANTypes,
+		profile.AccountID,
+		profile.CreatedAt,
+		profile.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", profile, err)
+	}
+
+	return nil
+}
+
+const updateCertificateProfileQuery = `
+	UPDATE certificate_profile SET
+		name = ?,
+		key_usage = ?,
+		ext_key_usage = ?,
+		default_validity_days = ?,
+		max_validity_days = ?,
+		algorithms = ?,
+		key_sizes = ?,
+		required_san_types = ?,
+		updated_at = ?
+	WHERE
+		id = ?`
+
+func (r *profileRepo) Update(ctx context.Context, profile model.CertificateProfile) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_repo_update")
+	defer span.Finish()
+
+	policy, err := encodeProfilePolicy(profile)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, updateCertificateProfileQuery,
+		profile.Name,
+		policy.keyUsage,
+		policy.extKeyUsage,
+		profile.DefaultValidityDays,
+		profile.MaxValidityDays,
+		policy.algorithms,
+		policy.keySizes,
+		policy.requiredSANTypes,
+		profile.UpdatedAt,
+		profile.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", profile, err)
+	}
+
+	return nil
+}
+
+const deleteCertificateProfileQuery = `
+	DELETE FROM certificate_profile WHERE id = ?`
+
+func (r *profileRepo) Delete(ctx context.Context, id string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_repo_delete")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, deleteCertificateProfileQuery, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate_profile(id=%s): %w", id, err)
+	}
+
+	return nil
+}
+
+const findCertificateProfileQuery = `
+	SELECT
+		id,
+		name,
+		key_usage,
+		ext_key_usage,
+		default_validity_days,
+		max_validity_days,
+		algorithms,
+		key_sizes,
+		required_san_types,
+		account_id,
+		created_at,
+		updated_at
+	FROM
+		certificate_profile
+	WHERE
+		id = ?`
+
+func (r *profileRepo) Find(ctx context.Context, id string) (model.CertificateProfile, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_repo_find")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findCertificateProfileQuery, id)
+	profile, err := scanCertificateProfile(row)
+	if err == sql.ErrNoRows {
+		return model.CertificateProfile{}, false, nil
+	}
+	if err != nil {
+		return model.CertificateProfile{}, false, fmt.Errorf("failed to query certificate_profile by id=%s: %w", id, err)
+	}
+
+	return profile, true, nil
+}
+
+const findCertificateProfileByNameAndAccountIDQuery = `
+	SELECT
+		id,
+		name,
+		key_usage,
+		ext_key_usage,
+		default_validity_days,
+		max_validity_days,
+		algorithms,
+		key_sizes,
+		required_san_types,
+		account_id,
+		created_at,
+		updated_at
+	FROM
+		certificate_profile
+	WHERE
+		name = ?
+		AND account_id = ?`
+
+func (r *profileRepo) FindByNameAndAccountID(ctx context.Context, name, accountID string) (model.CertificateProfile, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_repo_find_by_name_and_account_id")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findCertificateProfileByNameAndAccountIDQuery, name, accountID)
+	profile, err := scanCertificateProfile(row)
+	if err == sql.ErrNoRows {
+		return model.CertificateProfile{}, false, nil
+	}
+	if err != nil {
+		return model.CertificateProfile{}, false, fmt.Errorf("failed to query certificate_profile by name=%s and accountId=%s: %w", name, accountID, err)
+	}
+
+	return profile, true, nil
+}
+
+const findCertificateProfilesByAccountIDQuery = `
+	SELECT
+		id,
+		name,
+		key_usage,
+		ext_key_usage,
+		default_validity_days,
+		max_validity_days,
+		algorithms,
+		key_sizes,
+		required_san_types,
+		account_id,
+		created_at,
+		updated_at
+	FROM
+		certificate_profile
+	WHERE
+		account_id = ?
+	ORDER BY
+		name`
+
+func (r *profileRepo) FindByAccountID(ctx context.Context, accountID string) ([]model.CertificateProfile, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_repo_find_by_account_id")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findCertificateProfilesByAccountIDQuery, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate_profile by accountId=%s: %w", accountID, err)
+	}
+	defer rows.Close()
+
+	profiles := make([]model.CertificateProfile, 0)
+	for rows.Next() {
+		profile, err := scanCertificateProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map row to model.CertificateProfile: %w", err)
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// profilePolicy JSON encoded list columns of a certificate profile.
+type profilePolicy struct {
+	keyUsage         string
+	extKeyUsage      string
+	algorithms       string
+	keySizes         string
+	requiredSANTypes string
+}
+
+func encodeProfilePolicy(profile model.CertificateProfile) (profilePolicy, error) {
+	var policy profilePolicy
+	var err error
+	fields := []struct {
+		dest  *string
+		value interface{}
+	}{
+		{&policy.keyUsage, profile.KeyUsage},
+		{&policy.extKeyUsage, profile.ExtKeyUsage},
+		{&policy.algorithms, profile.Algorithms},
+		{&policy.keySizes, profile.KeySizes},
+		{&policy.requiredSANTypes, profile.RequiredSANTypes},
+	}
+
+	for _, field := range fields {
+		*field.dest, err = encodeJSON(field.value)
+		if err != nil {
+			return profilePolicy{}, err
+		}
+	}
+
+	return policy, nil
+}
+
+func scanCertificateProfile(row rowScanner) (model.CertificateProfile, error) {
+	var p model.CertificateProfile
+	var policy profilePolicy
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&policy.keyUsage,
+		&policy.extKeyUsage,
+		&p.DefaultValidityDays,
+		&p.MaxValidityDays,
+		&policy.algorithms,
+		&policy.keySizes,
+		&policy.requiredSANTypes,
+		&p.AccountID,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	fields := []struct {
+		value string
+		dest  interface{}
+	}{
+		{policy.keyUsage, &p.KeyUsage},
+		{policy.extKeyUsage, &p.ExtKeyUsage},
+		{policy.algorithms, &p.Algorithms},
+		{policy.keySizes, &p.KeySizes},
+		{policy.requiredSANTypes, &p.RequiredSANTypes},
+	}
+
+	for _, field := range fields {
+		err = json.Unmarshal([]byte(field.value), field.dest)
+		if err != nil {
+			return model.CertificateProfile{}, fmt.Errorf("failed to decode policy of %s: %w", p, err)
+		}
+	}
+
+	return p, nil
+}
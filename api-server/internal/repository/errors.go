@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+const mysqlDuplicateEntry = 1062
+
+// uniqueKey a primary key or unique constraint of a table. Violations are reported by mysql
+// with the name of the index and by sqlite with the constrained columns.
+type uniqueKey struct {
+	table   string
+	index   string
+	columns []string
+}
+
+var (
+	keyPairPublicKey           = uniqueKey{table: "key_pair", index: "public_key", columns: []string{"public_key"}}
+	keyPairPrivateKey          = uniqueKey{table: "key_pair", index: "private_key", columns: []string{"private_key"}}
+	certificateSerialNumberKey = uniqueKey{table: "certificate", index: "uq_certificate_issuer_serial_number", columns: []string{"issuer_id", "serial_number"}}
+	certificatePredecessorKey  = uniqueKey{table: "certificate", index: "uq_certificate_predecessor", columns: []string{"predecessor_id"}}
+	certificateGenerationKey   = uniqueKey{table: "certificate", index: "uq_certificate_name_generation", columns: []string{"name", "account_id", "generation"}}
+	revocationListNumberKey    = uniqueKey{table: "certificate_revocation_list", index: "certificate_id", columns: []string{"certificate_id", "number"}}
+	expiryWarningKey           = uniqueKey{table: "certificate_expiry_warning", index: "PRIMARY", columns: []string{"certificate_id"}}
+)
+
+// isDuplicateKey checks if a failed statement violated the given primary key or unique constraint in either of the supported databases.
+func isDuplicateKey(err error, key uniqueKey) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDuplicateEntry && key.mysqlMatches(mysqlErr.Message)
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		unique := sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+		return unique && key.sqliteMatches(sqliteErr.Error())
+	}
+
+	return false
+}
+
+// mysqlMatches checks a message like "Duplicate entry 'x' for key 'table.index'",
+// where versions of mysql before 8.0.19 leave out the table name.
+func (k uniqueKey) mysqlMatches(message string) bool {
+	i := strings.LastIndex(message, " for key '")
+	if i < 0 {
+		return false
+	}
+
+	index := strings.TrimSuffix(message[i+len(" for key '"):], "'")
+	return index == k.index || index == k.table+"."+k.index
+}
+
+// sqliteMatches checks a message like "UNIQUE constraint failed: table.column, table.column".
+func (k uniqueKey) sqliteMatches(message string) bool {
+	columns := make([]string, len(k.columns))
+	for i, column := range k.columns {
+		columns[i] = k.table + "." + column
+	}
+
+	return strings.HasSuffix(message, "constraint failed: "+strings.Join(columns, ", "))
+}
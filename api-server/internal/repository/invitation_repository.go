@@ -3,16 +3,27 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/CzarSimon/httputil/dbutil"
 	"github.com/CzarSimon/webca/api-server/internal/model"
 	"github.com/opentracing/opentracing-go"
 )
 
+// ErrInvitationNotPending is returned when accepting an invitation that has already been used.
+var ErrInvitationNotPending = errors.New("invitation is no longer pending")
+
 // InvitationRepository data access layer for invitations.
 type InvitationRepository interface {
 	Save(ctx context.Context, invite model.Invitation) error
 	Find(ctx context.Context, id string) (model.Invitation, bool, error)
+	FindByTokenHash(ctx context.Context, hash string) (model.Invitation, bool, error)
+	FindByAccountID(ctx context.Context, accountID string) ([]model.Invitation, error)
+	FindByAccountIDAndStatuses(ctx context.Context, accountID string, statuses []string) ([]model.Invitation, error)
+	Update(ctx context.Context, invite model.Invitation, fromStatus string) (bool, error)
+	Accept(ctx context.Context, invite model.Invitation, user model.User) error
 }
 
 // NewInvitationRepository creates an InvitationRepository using the default implementation.
@@ -27,7 +38,7 @@ type invitationRepo struct {
 }
 
 const saveInvitationQuery = `
-	INSERT INTO invitation(id, email, role, status, created_by_id, account_id, created_at, valid_to) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	INSERT INTO invitation(id, email, role, status, created_by_id, account_id, created_at, valid_to, token_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 func (r *invitationRepo) Save(ctx context.Context, invite model.Invitation) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_save")
@@ -36,7 +47,7 @@ func (r *invitationRepo) Save(ctx context.Context, invite model.Invitation) erro
 	_, err := r.db.ExecContext(ctx, saveInvitationQuery,
 		invite.ID, invite.Email, invite.Role, invite.Status,
 		invite.CreatedByID, invite.Account.ID,
-		invite.CreatedAt, invite.ValidTo,
+		invite.CreatedAt, invite.ValidTo, tokenHash(invite),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save %s: %w", invite, err)
@@ -45,8 +56,7 @@ func (r *invitationRepo) Save(ctx context.Context, invite model.Invitation) erro
 	return nil
 }
 
-const findInvitationQuery = `
-	SELECT 
+const invitationColumns = `
 		i.id, 
 		i.email, 
 		i.role,
@@ -55,10 +65,16 @@ const findInvitationQuery = `
 		i.created_at,
 		i.valid_to,
 		i.accepted_at,
+		i.token_hash,
 		a.id,
 		a.name,
+		a.validity_policy,
+		a.invitation_lifetime_hours,
 		a.created_at,
-		a.updated_at
+		a.updated_at`
+
+const findInvitationQuery = `
+	SELECT` + invitationColumns + `
 	FROM 
 		invitation i 
 		INNER JOIN account a ON a.id = i.account_id
@@ -69,9 +85,179 @@ func (r *invitationRepo) Find(ctx context.Context, id string) (model.Invitation,
 	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_find")
 	defer span.Finish()
 
+	i, err := scanInvitation(r.db.QueryRowContext(ctx, findInvitationQuery, id))
+	if err == sql.ErrNoRows {
+		return model.Invitation{}, false, nil
+	}
+	if err != nil {
+		return model.Invitation{}, false, fmt.Errorf("failed to query inivtation by id=%s: %w", id, err)
+	}
+
+	return i, true, nil
+}
+
+const findInvitationByTokenHashQuery = `
+	SELECT` + invitationColumns + `
+	FROM 
+		invitation i 
+		INNER JOIN account a ON a.id = i.account_id
+	WHERE 
+		i.token_hash = ?`
+
+func (r *invitationRepo) FindByTokenHash(ctx context.Context, hash string) (model.Invitation, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_find_by_token_hash")
+	defer span.Finish()
+
+	i, err := scanInvitation(r.db.QueryRowContext(ctx, findInvitationByTokenHashQuery, hash))
+	if err == sql.ErrNoRows {
+		return model.Invitation{}, false, nil
+	}
+	if err != nil {
+		return model.Invitation{}, false, fmt.Errorf("failed to query inivtation by token hash: %w", err)
+	}
+
+	return i, true, nil
+}
+
+const findInvitationsByAccountIDQuery = `
+	SELECT` + invitationColumns + `
+	FROM 
+		invitation i 
+		INNER JOIN account a ON a.id = i.account_id
+	WHERE 
+		i.account_id = ?
+	ORDER BY
+		i.created_at`
+
+func (r *invitationRepo) FindByAccountID(ctx context.Context, accountID string) ([]model.Invitation, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_find_by_account_id")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findInvitationsByAccountIDQuery, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invitations by accountId=%s: %w", accountID, err)
+	}
+	defer rows.Close()
+
+	return mapRowsToInvitations(rows)
+}
+
+const findInvitationsByAccountIDAndStatusesQuery = `
+	SELECT` + invitationColumns + `
+	FROM 
+		invitation i 
+		INNER JOIN account a ON a.id = i.account_id
+	WHERE 
+		i.account_id = ?
+		AND i.status IN (?%s)
+	ORDER BY
+		i.created_at`
+
+func (r *invitationRepo) FindByAccountIDAndStatuses(ctx context.Context, accountID string, statuses []string) ([]model.Invitation, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_find_by_account_id_and_statuses")
+	defer span.Finish()
+
+	query := fmt.Sprintf(findInvitationsByAccountIDAndStatusesQuery, strings.Repeat(", ?", len(statuses)-1))
+	args := createAccountIDAndTypesArgs(accountID, statuses)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invitations by accountId=%s and statuses=%v: %w", accountID, statuses, err)
+	}
+	defer rows.Close()
+
+	return mapRowsToInvitations(rows)
+}
+
+const updateInvitationQuery = `
+	UPDATE invitation SET
+		status = ?,
+		valid_to = ?,
+		token_hash = ?
+	WHERE
+		id = ?
+		AND status = ?`
+
+// Update updates the status, validity and token of an invitation if it is still in the expected status.
+func (r *invitationRepo) Update(ctx context.Context, invite model.Invitation, fromStatus string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_update")
+	defer span.Finish()
+
+	res, err := r.db.ExecContext(ctx, updateInvitationQuery, invite.Status, invite.ValidTo, tokenHash(invite), invite.ID, fromStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", invite, err)
+	}
+
+	return rowsAffected(res)
+}
+
+const acceptInvitationQuery = `
+	UPDATE invitation SET
+		status = ?,
+		accepted_at = ?,
+		token_hash = NULL
+	WHERE
+		id = ?
+		AND status = ?`
+
+// Accept marks an invitation as accepted, clears its token and saves the invited user in a single transaction.
+// Returns ErrInvitationNotPending if the invitation has already been accepted.
+func (r *invitationRepo) Accept(ctx context.Context, invite model.Invitation, user model.User) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_repo_accept")
+	defer span.Finish()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, acceptInvitationQuery, model.InvitationAccepted, invite.AcceptedAt, invite.ID, model.InvitationCreated)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to accept %s: %w", invite, err)
+	}
+
+	updated, err := rowsAffected(res)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return err
+	}
+
+	if !updated {
+		dbutil.Rollback(tx)
+		return ErrInvitationNotPending
+	}
+
+	_, err = tx.ExecContext(ctx, saveUserQuery,
+		user.ID, user.Email, user.Role, user.Active, user.Account.ID, user.CreatedAt, user.UpdatedAt,
+		user.Credentials.Password, user.Credentials.Salt,
+	)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to save %s: %w", user, err)
+	}
+
+	return tx.Commit()
+}
+
+func mapRowsToInvitations(rows *sql.Rows) ([]model.Invitation, error) {
+	invites := make([]model.Invitation, 0)
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map row to model.Invitation: %w", err)
+		}
+
+		invites = append(invites, i)
+	}
+
+	return invites, nil
+}
+
+func scanInvitation(row rowScanner) (model.Invitation, error) {
 	var i model.Invitation
 	var acceptedAt sql.NullTime
-	err := r.db.QueryRowContext(ctx, findInvitationQuery, id).Scan(
+	var hash sql.NullString
+	err := row.Scan(
 		&i.ID,
 		&i.Email,
 		&i.Role,
@@ -80,18 +266,27 @@ func (r *invitationRepo) Find(ctx context.Context, id string) (model.Invitation,
 		&i.CreatedAt,
 		&i.ValidTo,
 		&acceptedAt,
+		&hash,
 		&i.Account.ID,
 		&i.Account.Name,
+		&i.Account.ValidityPolicy,
+		&i.Account.InvitationLifetimeHours,
 		&i.Account.CreatedAt,
 		&i.Account.UpdatedAt,
 	)
-	if err == sql.ErrNoRows {
-		return model.Invitation{}, false, nil
-	}
 	if err != nil {
-		return model.Invitation{}, false, fmt.Errorf("failed to query inivtation by id=%s: %w", id, err)
+		return model.Invitation{}, err
 	}
 
 	i.AcceptedAt = acceptedAt.Time
-	return i, true, nil
+	i.TokenHash = hash.String
+	return i, nil
+}
+
+// tokenHash stores invitations without a token hash as NULL so that they cannot collide on the unique index.
+func tokenHash(invite model.Invitation) sql.NullString {
+	return sql.NullString{
+		String: invite.TokenHash,
+		Valid:  invite.TokenHash != "",
+	}
 }
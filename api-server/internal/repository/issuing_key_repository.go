@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// IssuingKeyRepository data access layer for the server held keys of certificate authorities enrolled for unattended issuance.
+type IssuingKeyRepository interface {
+	Save(ctx context.Context, key model.IssuingKey) error
+	Find(ctx context.Context, certificateID string) (model.IssuingKey, bool, error)
+	Delete(ctx context.Context, certificateID string) (bool, error)
+}
+
+// NewIssuingKeyRepository creates an IssuingKeyRepository using the default implementation.
+func NewIssuingKeyRepository(db *sql.DB) IssuingKeyRepository {
+	return &issuingKeyRepo{
+		db: db,
+	}
+}
+
+type issuingKeyRepo struct {
+	db *sql.DB
+}
+
+const saveIssuingKeyQuery = `
+	INSERT INTO issuing_key(certificate_id, private_key, encryption_salt, created_by, created_at) VALUES (?, ?, ?, ?, ?)`
+
+func (r *issuingKeyRepo) Save(ctx context.Context, key model.IssuingKey) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "issuing_key_repo_save")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, saveIssuingKeyQuery, key.CertificateID, key.PrivateKey, key.EncryptionSalt, key.CreatedBy, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", key, err)
+	}
+
+	return nil
+}
+
+const findIssuingKeyQuery = `
+	SELECT
+		certificate_id,
+		private_key,
+		encryption_salt,
+		created_by,
+		created_at
+	FROM
+		issuing_key
+	WHERE
+		certificate_id = ?`
+
+func (r *issuingKeyRepo) Find(ctx context.Context, certificateID string) (model.IssuingKey, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "issuing_key_repo_find")
+	defer span.Finish()
+
+	var k model.IssuingKey
+	err := r.db.QueryRowContext(ctx, findIssuingKeyQuery, certificateID).Scan(
+		&k.CertificateID, &k.PrivateKey, &k.EncryptionSalt, &k.CreatedBy, &k.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.IssuingKey{}, false, nil
+	}
+	if err != nil {
+		return model.IssuingKey{}, false, fmt.Errorf("failed to query issuing_key(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return k, true, nil
+}
+
+const deleteIssuingKeyQuery = `
+	DELETE FROM issuing_key WHERE certificate_id = ?`
+
+func (r *issuingKeyRepo) Delete(ctx context.Context, certificateID string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "issuing_key_repo_delete")
+	defer span.Finish()
+
+	res, err := r.db.ExecContext(ctx, deleteIssuingKeyQuery, certificateID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete issuing_key(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return rowsAffected(res)
+}
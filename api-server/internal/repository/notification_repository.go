@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// NotificationRepository data access layer for the notification outbox.
+type NotificationRepository interface {
+	Save(ctx context.Context, notification model.Notification) error
+	Update(ctx context.Context, notification model.Notification) error
+	Claim(ctx context.Context, notification model.Notification, now, claimedUntil time.Time) (bool, error)
+	Find(ctx context.Context, id string) (model.Notification, bool, error)
+	FindDue(ctx context.Context, now time.Time, limit int) ([]model.Notification, error)
+}
+
+// NewNotificationRepository creates a NotificationRepository using the default implementation.
+func NewNotificationRepository(db *sql.DB) NotificationRepository {
+	return &notificationRepo{
+		db: db,
+	}
+}
+
+type notificationRepo struct {
+	db *sql.DB
+}
+
+const saveNotificationQuery = `
+	INSERT INTO notification(
+		id,
+		template,
+		recipient,
+		subject,
+		body,
+		encryption_salt,
+		status,
+		attempts,
+		last_error,
+		created_at,
+		next_attempt_at,
+		claimed_until,
+		sent_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (r *notificationRepo) Save(ctx context.Context, n model.Notification) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_repo_save")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, saveNotificationQuery,
+		n.ID, n.Template, n.Recipient, n.Subject, n.Body, nullString(n.EncryptionSalt), n.Status, n.Attempts,
+		nullString(n.LastError), n.CreatedAt, n.NextAttemptAt, nullTimeValue(n.ClaimedUntil), nullTimeValue(n.SentAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", n, err)
+	}
+
+	return nil
+}
+
+const updateNotificationQuery = `
+	UPDATE notification SET
+		body = ?,
+		encryption_salt = ?,
+		status = ?,
+		attempts = ?,
+		last_error = ?,
+		next_attempt_at = ?,
+		claimed_until = ?,
+		sent_at = ?
+	WHERE
+		id = ?`
+
+func (r *notificationRepo) Update(ctx context.Context, n model.Notification) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_repo_update")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, updateNotificationQuery,
+		n.Body, nullString(n.EncryptionSalt), n.Status, n.Attempts, nullString(n.LastError), n.NextAttemptAt, nullTimeValue(n.ClaimedUntil), nullTimeValue(n.SentAt), n.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", n, err)
+	}
+
+	return nil
+}
+
+const claimNotificationQuery = `
+	UPDATE notification SET
+		status = ?,
+		claimed_until = ?
+	WHERE
+		id = ?
+		AND (
+			(status = ? AND next_attempt_at <= ?)
+			OR (status = ? AND claimed_until <= ?)
+		)`
+
+// Claim marks a due notification as being sent until claimedUntil. A notification can only be claimed by one
+// delivery attempt at the time, false is returned if it was claimed by another one or is no longer due.
+func (r *notificationRepo) Claim(ctx context.Context, n model.Notification, now, claimedUntil time.Time) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_repo_claim")
+	defer span.Finish()
+
+	res, err := r.db.ExecContext(ctx, claimNotificationQuery,
+		model.NotificationSending, claimedUntil, n.ID, model.NotificationPending, now, model.NotificationSending, now,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim %s: %w", n, err)
+	}
+
+	return rowsAffected(res)
+}
+
+const notificationColumns = `
+		id,
+		template,
+		recipient,
+		subject,
+		body,
+		encryption_salt,
+		status,
+		attempts,
+		last_error,
+		created_at,
+		next_attempt_at,
+		claimed_until,
+		sent_at`
+
+const findNotificationQuery = `
+	SELECT` + notificationColumns + `
+	FROM
+		notification
+	WHERE
+		id = ?`
+
+func (r *notificationRepo) Find(ctx context.Context, id string) (model.Notification, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_repo_find")
+	defer span.Finish()
+
+	n, err := scanNotification(r.db.QueryRowContext(ctx, findNotificationQuery, id))
+	if err == sql.ErrNoRows {
+		return model.Notification{}, false, nil
+	}
+	if err != nil {
+		return model.Notification{}, false, fmt.Errorf("failed to query notification by id=%s: %w", id, err)
+	}
+
+	return n, true, nil
+}
+
+const findDueNotificationsQuery = `
+	SELECT` + notificationColumns + `
+	FROM
+		notification
+	WHERE
+		(status = ? AND next_attempt_at <= ?)
+		OR (status = ? AND claimed_until <= ?)
+	ORDER BY
+		next_attempt_at
+	LIMIT ?`
+
+func (r *notificationRepo) FindDue(ctx context.Context, now time.Time, limit int) ([]model.Notification, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_repo_find_due")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findDueNotificationsQuery, model.NotificationPending, now, model.NotificationSending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications due at %v: %w", now, err)
+	}
+	defer rows.Close()
+
+	notifications := make([]model.Notification, 0)
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map row to model.Notification: %w", err)
+		}
+
+		notifications = append(notifications, n)
+	}
+
+	return notifications, nil
+}
+
+func scanNotification(row rowScanner) (model.Notification, error) {
+	var n model.Notification
+	var encryptionSalt sql.NullString
+	var lastError sql.NullString
+	var claimedUntil sql.NullTime
+	var sentAt sql.NullTime
+	err := row.Scan(
+		&n.ID,
+		&n.Template,
+		&n.Recipient,
+		&n.Subject,
+		&n.Body,
+		&encryptionSalt,
+		&n.Status,
+		&n.Attempts,
+		&lastError,
+		&n.CreatedAt,
+		&n.NextAttemptAt,
+		&claimedUntil,
+		&sentAt,
+	)
+	if err != nil {
+		return model.Notification{}, err
+	}
+
+	n.EncryptionSalt = encryptionSalt.String
+	n.LastError = lastError.String
+	n.ClaimedUntil = claimedUntil.Time
+	n.SentAt = sentAt.Time
+	return n, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTimeValue(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/CzarSimon/httputil/dbutil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// OCSPResponderRepository data access layer for delegated OCSP responders and the issuer hashes of their certificate authorities.
+type OCSPResponderRepository interface {
+	Save(ctx context.Context, responder model.OCSPResponder) error
+	FindLatest(ctx context.Context, certificateID string) (model.OCSPResponder, bool, error)
+	FindCertificateIDsByIssuerHash(ctx context.Context, hash model.OCSPIssuerHash) ([]string, error)
+}
+
+// NewOCSPResponderRepository creates an OCSPResponderRepository using the default implementation.
+func NewOCSPResponderRepository(db *sql.DB) OCSPResponderRepository {
+	return &ocspResponderRepo{
+		db: db,
+	}
+}
+
+type ocspResponderRepo struct {
+	db *sql.DB
+}
+
+const saveOCSPResponderQuery = `
+	INSERT INTO ocsp_responder(id, certificate_id, body, public_key, private_key, key_type, encryption_salt, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (r *ocspResponderRepo) Save(ctx context.Context, responder model.OCSPResponder) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ocsp_responder_repo_save")
+	defer span.Finish()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	key := responder.KeyPair
+	_, err = tx.ExecContext(ctx, saveOCSPResponderQuery,
+		responder.ID, responder.CertificateID, responder.Body, key.PublicKey, key.PrivateKey, key.Algorithm, key.EncryptionSalt, responder.CreatedAt, responder.ExpiresAt,
+	)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to insert %s: %w", responder, err)
+	}
+
+	for _, hash := range responder.IssuerHashes {
+		err = saveIssuerHashIfNew(ctx, tx, responder, hash)
+		if err != nil {
+			dbutil.Rollback(tx)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const countOCSPIssuerHashesQuery = `
+	SELECT COUNT(*) FROM ocsp_issuer_hash WHERE certificate_id = ? AND hash_algorithm = ?`
+
+const saveOCSPIssuerHashQuery = `
+	INSERT INTO ocsp_issuer_hash(certificate_id, hash_algorithm, name_hash, key_hash, created_at) VALUES (?, ?, ?, ?, ?)`
+
+// saveIssuerHashIfNew stores an issuer hash of a certificate authority unless it was stored with an earlier responder.
+func saveIssuerHashIfNew(ctx context.Context, tx *sql.Tx, responder model.OCSPResponder, hash model.OCSPIssuerHash) error {
+	var count int
+	err := tx.QueryRowContext(ctx, countOCSPIssuerHashesQuery, responder.CertificateID, hash.Algorithm).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to query ocsp_issuer_hash(certificate_id=%s): %w", responder.CertificateID, err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, saveOCSPIssuerHashQuery, responder.CertificateID, hash.Algorithm, hash.NameHash, hash.KeyHash, responder.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", hash, err)
+	}
+
+	return nil
+}
+
+const findLatestOCSPResponderQuery = `
+	SELECT 
+		id, 
+		certificate_id,
+		body,
+		public_key,
+		private_key,
+		key_type,
+		encryption_salt,
+		created_at,
+		expires_at
+	FROM 
+		ocsp_responder
+	WHERE
+		certificate_id = ?
+	ORDER BY expires_at DESC
+	LIMIT 1`
+
+func (r *ocspResponderRepo) FindLatest(ctx context.Context, certificateID string) (model.OCSPResponder, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ocsp_responder_repo_find_latest")
+	defer span.Finish()
+
+	var o model.OCSPResponder
+	err := r.db.QueryRowContext(ctx, findLatestOCSPResponderQuery, certificateID).Scan(
+		&o.ID, &o.CertificateID, &o.Body, &o.KeyPair.PublicKey, &o.KeyPair.PrivateKey, &o.KeyPair.Algorithm, &o.KeyPair.EncryptionSalt, &o.CreatedAt, &o.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.OCSPResponder{}, false, nil
+	}
+	if err != nil {
+		return model.OCSPResponder{}, false, fmt.Errorf("failed to query ocsp_responder(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return o, true, nil
+}
+
+const findCertificateIDsByOCSPIssuerHashQuery = `
+	SELECT
+		certificate_id
+	FROM
+		ocsp_issuer_hash
+	WHERE
+		hash_algorithm = ?
+		AND name_hash = ?
+		AND key_hash = ?
+	ORDER BY created_at DESC`
+
+func (r *ocspResponderRepo) FindCertificateIDsByIssuerHash(ctx context.Context, hash model.OCSPIssuerHash) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ocsp_responder_repo_find_certificate_ids_by_issuer_hash")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findCertificateIDsByOCSPIssuerHashQuery, hash.Algorithm, hash.NameHash, hash.KeyHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ocsp_issuer_hash by %s: %w", hash, err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row for ocsp_issuer_hash: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,350 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/CzarSimon/httputil/dbutil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// PendingCertificateRepository data access layer for certificates awaiting signature by an external signer.
+type PendingCertificateRepository interface {
+	Save(ctx context.Context, pending model.PendingCertificate) error
+	Activate(ctx context.Context, pending model.PendingCertificate, cert model.Certificate) (bool, error)
+	Find(ctx context.Context, id string) (model.PendingCertificate, bool, error)
+	FindByNameAndAccountID(ctx context.Context, name, accountID string) (model.PendingCertificate, bool, error)
+	FindByCertificateID(ctx context.Context, certificateID string) (model.PendingCertificate, bool, error)
+	FindByAccountID(ctx context.Context, accountID string) ([]model.PendingCertificate, error)
+}
+
+// NewPendingCertificateRepository creates a PendingCertificateRepository using the default implementation.
+func NewPendingCertificateRepository(db *sql.DB) PendingCertificateRepository {
+	return &pendingCertRepo{
+		db: db,
+	}
+}
+
+type pendingCertRepo struct {
+	db *sql.DB
+}
+
+const savePendingCertificateQuery = `
+	INSERT INTO pending_certificate(
+		id,
+		name,
+		csr,
+		status,
+		key_pair_id,
+		account_id,
+		created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+func (r *pendingCertRepo) Save(ctx context.Context, pending model.PendingCertificate) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "pending_certificate_repo_save")
+	defer span.Finish()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	err = saveKeyPairIfNew(ctx, tx, pending.KeyPair)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, savePendingCertificateQuery,
+		pending.ID,
+		pending.Name,
+		pending.CSR,
+		pending.Status,
+		pending.KeyPair.ID,
+		pending.AccountID,
+		pending.CreatedAt,
+	)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to save %s: %w", pending, err)
+	}
+
+	return tx.Commit()
+}
+
+const updatePendingCertificateQuery = `
+	UPDATE pending_certificate SET
+		status = ?,
+		certificate_id = ?,
+		issuer_chain = ?,
+		activated_at = ?
+	WHERE
+		id = ?
+		AND status = ?`
+
+// Activate saves the certificate of a pending certificate authority and marks it as activated in one transaction.
+// Nothing is saved and false is returned if the pending certificate no longer has the pending status.
+func (r *pendingCertRepo) Activate(ctx context.Context, pending model.PendingCertificate, cert model.Certificate) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "pending_certificate_repo_activate")
+	defer span.Finish()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	err = saveCertificate(ctx, tx, cert)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return false, err
+	}
+
+	certificateID := sql.NullString{
+		String: pending.CertificateID,
+		Valid:  pending.CertificateID != "",
+	}
+	res, err := tx.ExecContext(ctx, updatePendingCertificateQuery,
+		pending.Status,
+		certificateID,
+		pending.IssuerChain,
+		pending.ActivatedAt,
+		pending.ID,
+		model.PendingCertificatePending,
+	)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return false, fmt.Errorf("failed to update %s: %w", pending, err)
+	}
+
+	updated, err := rowsAffected(res)
+	if err != nil || !updated {
+		dbutil.Rollback(tx)
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+const findPendingCertificateQuery = `
+	SELECT
+		p.id,
+		p.name,
+		p.csr,
+		p.status,
+		p.account_id,
+		p.certificate_id,
+		p.issuer_chain,
+		p.created_at,
+		p.activated_at,
+		k.id,
+		k.public_key,
+		k.private_key,
+		k.format,
+		k.type,
+		k.encryption_salt,
+		k.password,
+		k.password_salt,
+		k.account_id,
+		k.created_at
+	FROM
+		pending_certificate p
+		INNER JOIN key_pair k ON k.id = p.key_pair_id
+	WHERE
+		p.id = ?`
+
+func (r *pendingCertRepo) Find(ctx context.Context, id string) (model.PendingCertificate, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "pending_certificate_repo_find")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findPendingCertificateQuery, id)
+	pending, err := scanPendingCertificate(row)
+	if err == sql.ErrNoRows {
+		return model.PendingCertificate{}, false, nil
+	}
+	if err != nil {
+		return model.PendingCertificate{}, false, fmt.Errorf("failed to query pending_certificate by id=%s: %w", id, err)
+	}
+
+	return pending, true, nil
+}
+
+const findPendingCertificateByNameAndAccountIDQuery = `
+	SELECT
+		p.id,
+		p.name,
+		p.csr,
+		p.status,
+		p.account_id,
+		p.certificate_id,
+		p.issuer_chain,
+		p.created_at,
+		p.activated_at,
+		k.id,
+		k.public_key,
+		k.private_key,
+		k.format,
+		k.type,
+		k.encryption_salt,
+		k.password,
+		k.password_salt,
+		k.account_id,
+		k.created_at
+	FROM
+		pending_certificate p
+		INNER JOIN key_pair k ON k.id = p.key_pair_id
+	WHERE
+		p.name = ?
+		AND p.account_id = ?`
+
+func (r *pendingCertRepo) FindByNameAndAccountID(ctx context.Context, name, accountID string) (model.PendingCertificate, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "pending_certificate_repo_find_by_name_and_account_id")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findPendingCertificateByNameAndAccountIDQuery, name, accountID)
+	pending, err := scanPendingCertificate(row)
+	if err == sql.ErrNoRows {
+		return model.PendingCertificate{}, false, nil
+	}
+	if err != nil {
+		return model.PendingCertificate{}, false, fmt.Errorf("failed to query pending_certificate by name=%s and accountId=%s: %w", name, accountID, err)
+	}
+
+	return pending, true, nil
+}
+
+const findPendingCertificateByCertificateIDQuery = `
+	SELECT
+		p.id,
+		p.name,
+		p.csr,
+		p.status,
+		p.account_id,
+		p.certificate_id,
+		p.issuer_chain,
+		p.created_at,
+		p.activated_at,
+		k.id,
+		k.public_key,
+		k.private_key,
+		k.format,
+		k.type,
+		k.encryption_salt,
+		k.password,
+		k.password_salt,
+		k.account_id,
+		k.created_at
+	FROM
+		pending_certificate p
+		INNER JOIN key_pair k ON k.id = p.key_pair_id
+	WHERE
+		p.certificate_id = ?`
+
+func (r *pendingCertRepo) FindByCertificateID(ctx context.Context, certificateID string) (model.PendingCertificate, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "pending_certificate_repo_find_by_certificate_id")
+	defer span.Finish()
+
+	row := r.db.QueryRowContext(ctx, findPendingCertificateByCertificateIDQuery, certificateID)
+	pending, err := scanPendingCertificate(row)
+	if err == sql.ErrNoRows {
+		return model.PendingCertificate{}, false, nil
+	}
+	if err != nil {
+		return model.PendingCertificate{}, false, fmt.Errorf("failed to query pending_certificate by certificateId=%s: %w", certificateID, err)
+	}
+
+	return pending, true, nil
+}
+
+const findPendingCertificatesByAccountIDQuery = `
+	SELECT
+		p.id,
+		p.name,
+		p.csr,
+		p.status,
+		p.account_id,
+		p.certificate_id,
+		p.issuer_chain,
+		p.created_at,
+		p.activated_at,
+		k.id,
+		k.public_key,
+		k.private_key,
+		k.format,
+		k.type,
+		k.encryption_salt,
+		k.password,
+		k.password_salt,
+		k.account_id,
+		k.created_at
+	FROM
+		pending_certificate p
+		INNER JOIN key_pair k ON k.id = p.key_pair_id
+	WHERE
+		p.account_id = ?
+	ORDER BY
+		p.created_at DESC`
+
+func (r *pendingCertRepo) FindByAccountID(ctx context.Context, accountID string) ([]model.PendingCertificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "pending_certificate_repo_find_by_account_id")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findPendingCertificatesByAccountIDQuery, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending_certificate by accountId=%s: %w", accountID, err)
+	}
+	defer rows.Close()
+
+	pendingCerts := make([]model.PendingCertificate, 0)
+	for rows.Next() {
+		pending, err := scanPendingCertificate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map row to model.PendingCertificate: %w", err)
+		}
+
+		pendingCerts = append(pendingCerts, pending)
+	}
+
+	return pendingCerts, nil
+}
+
+func scanPendingCertificate(row rowScanner) (model.PendingCertificate, error) {
+	var p model.PendingCertificate
+	var certificateID sql.NullString
+	var issuerChain sql.NullString
+	var activatedAt sql.NullTime
+	k := &p.KeyPair
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.CSR,
+		&p.Status,
+		&p.AccountID,
+		&certificateID,
+		&issuerChain,
+		&p.CreatedAt,
+		&activatedAt,
+		&k.ID,
+		&k.PublicKey,
+		&k.PrivateKey,
+		&k.Format,
+		&k.Algorithm,
+		&k.EncryptionSalt,
+		&k.Credentials.Password,
+		&k.Credentials.Salt,
+		&k.AccountID,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	p.CertificateID = certificateID.String
+	p.IssuerChain = issuerChain.String
+	if activatedAt.Valid {
+		p.ActivatedAt = &activatedAt.Time
+	}
+
+	return p, nil
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/opentracing/opentracing-go"
+)
+
+// ErrDuplicateRevocationListNumber returned when a revocation list is saved with a number already used by its certificate authority.
+var ErrDuplicateRevocationListNumber = errors.New("duplicate revocation list number")
+
+// RevocationListRepository data access layer for certificate revocation lists and their signing keys.
+type RevocationListRepository interface {
+	Save(ctx context.Context, crl model.RevocationList) error
+	FindLatest(ctx context.Context, certificateID string) (model.RevocationList, bool, error)
+	SaveSigningKey(ctx context.Context, key model.CRLSigningKey) error
+	FindSigningKey(ctx context.Context, certificateID string) (model.CRLSigningKey, bool, error)
+	DeleteSigningKey(ctx context.Context, certificateID string) (bool, error)
+	FindSigningKeyCertificateIDs(ctx context.Context) ([]string, error)
+}
+
+// NewRevocationListRepository creates a RevocationListRepository using the default implementation.
+func NewRevocationListRepository(db *sql.DB) RevocationListRepository {
+	return &crlRepo{
+		db: db,
+	}
+}
+
+type crlRepo struct {
+	db *sql.DB
+}
+
+const saveRevocationListQuery = `
+	INSERT INTO certificate_revocation_list(id, certificate_id, number, body, this_update, next_update, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+func (r *crlRepo) Save(ctx context.Context, crl model.RevocationList) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "crl_repo_save")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, saveRevocationListQuery,
+		crl.ID, crl.CertificateID, crl.Number, crl.Body, crl.ThisUpdate, crl.NextUpdate, crl.CreatedAt,
+	)
+	if isDuplicateKey(err, revocationListNumberKey) {
+		return fmt.Errorf("failed to insert %s: %w", crl, ErrDuplicateRevocationListNumber)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", crl, err)
+	}
+
+	return nil
+}
+
+const findLatestRevocationListQuery = `
+	SELECT 
+		id, 
+		certificate_id,
+		number,
+		body,
+		this_update,
+		next_update,
+		created_at
+	FROM 
+		certificate_revocation_list
+	WHERE
+		certificate_id = ?
+	ORDER BY number DESC
+	LIMIT 1`
+
+func (r *crlRepo) FindLatest(ctx context.Context, certificateID string) (model.RevocationList, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "crl_repo_find_latest")
+	defer span.Finish()
+
+	var l model.RevocationList
+	err := r.db.QueryRowContext(ctx, findLatestRevocationListQuery, certificateID).Scan(
+		&l.ID, &l.CertificateID, &l.Number, &l.Body, &l.ThisUpdate, &l.NextUpdate, &l.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.RevocationList{}, false, nil
+	}
+	if err != nil {
+		return model.RevocationList{}, false, fmt.Errorf("failed to query certificate_revocation_list(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return l, true, nil
+}
+
+const saveCRLSigningKeyQuery = `
+	INSERT INTO crl_signing_key(certificate_id, private_key, encryption_salt, created_at) VALUES (?, ?, ?, ?)`
+
+func (r *crlRepo) SaveSigningKey(ctx context.Context, key model.CRLSigningKey) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "crl_repo_save_signing_key")
+	defer span.Finish()
+
+	_, err := r.db.ExecContext(ctx, saveCRLSigningKeyQuery, key.CertificateID, key.PrivateKey, key.EncryptionSalt, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s: %w", key, err)
+	}
+
+	return nil
+}
+
+const findCRLSigningKeyQuery = `
+	SELECT 
+		certificate_id, 
+		private_key,
+		encryption_salt,
+		created_at
+	FROM 
+		crl_signing_key
+	WHERE
+		certificate_id = ?`
+
+func (r *crlRepo) FindSigningKey(ctx context.Context, certificateID string) (model.CRLSigningKey, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "crl_repo_find_signing_key")
+	defer span.Finish()
+
+	var k model.CRLSigningKey
+	err := r.db.QueryRowContext(ctx, findCRLSigningKeyQuery, certificateID).Scan(
+		&k.CertificateID, &k.PrivateKey, &k.EncryptionSalt, &k.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.CRLSigningKey{}, false, nil
+	}
+	if err != nil {
+		return model.CRLSigningKey{}, false, fmt.Errorf("failed to query crl_signing_key(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return k, true, nil
+}
+
+const deleteCRLSigningKeyQuery = `
+	DELETE FROM crl_signing_key WHERE certificate_id = ?`
+
+func (r *crlRepo) DeleteSigningKey(ctx context.Context, certificateID string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "crl_repo_delete_signing_key")
+	defer span.Finish()
+
+	res, err := r.db.ExecContext(ctx, deleteCRLSigningKeyQuery, certificateID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete crl_signing_key(certificate_id=%s): %w", certificateID, err)
+	}
+
+	return rowsAffected(res)
+}
+
+const findCRLSigningKeyCertificateIDsQuery = `
+	SELECT 
+		k.certificate_id
+	FROM 
+		crl_signing_key k
+		INNER JOIN certificate c ON k.certificate_id = c.id
+	WHERE
+		c.status = ?`
+
+func (r *crlRepo) FindSigningKeyCertificateIDs(ctx context.Context) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "crl_repo_find_signing_key_certificate_ids")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findCRLSigningKeyCertificateIDsQuery, model.CertificateActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crl_signing_key certificate ids: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row for crl_signing_key: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
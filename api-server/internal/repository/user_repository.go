@@ -3,17 +3,28 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/CzarSimon/httputil/dbutil"
 	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/mattn/go-sqlite3"
 	"github.com/opentracing/opentracing-go"
 )
 
+// ErrUserNotFound returned when updating a user that does not exist.
+var ErrUserNotFound = errors.New("user does not exist")
+
+// ErrLastActiveAdmin returned when an update would leave the account of a user without an active admin.
+var ErrLastActiveAdmin = errors.New("account would be left without an active admin")
+
 // UserRepository data access layer for user accounts.
 type UserRepository interface {
 	Save(ctx context.Context, user model.User) error
 	Find(ctx context.Context, id string) (model.User, bool, error)
 	FindByAccountNameAndEmail(ctx context.Context, accountName, email string) (model.User, bool, error)
+	FindByAccountID(ctx context.Context, accountID string) ([]model.User, error)
+	Update(ctx context.Context, user model.User) error
 }
 
 // NewUserRepository creates an UserRepository using the default implementation.
@@ -27,19 +38,24 @@ type userRepo struct {
 	db *sql.DB
 }
 
-const findUserQuery = `
-	SELECT 
+const userColumns = `
 		u.id, 
 		u.email, 
 		u.role,
+		u.active,
 		u.password, 
 		u.salt,
 		u.created_at,
 		u.updated_at,
 		a.id,
 		a.name,
+		a.validity_policy,
+		a.invitation_lifetime_hours,
 		a.created_at,
-		a.updated_at
+		a.updated_at`
+
+const findUserQuery = `
+	SELECT` + userColumns + `
 	FROM 
 		user_account u 
 		INNER JOIN account a ON a.id = u.account_id
@@ -50,20 +66,7 @@ func (r *userRepo) Find(ctx context.Context, id string) (model.User, bool, error
 	span, ctx := opentracing.StartSpanFromContext(ctx, "user_repo_find")
 	defer span.Finish()
 
-	var u model.User
-	err := r.db.QueryRowContext(ctx, findUserQuery, id).Scan(
-		&u.ID,
-		&u.Email,
-		&u.Role,
-		&u.Credentials.Password,
-		&u.Credentials.Salt,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-		&u.Account.ID,
-		&u.Account.Name,
-		&u.Account.CreatedAt,
-		&u.Account.UpdatedAt,
-	)
+	u, err := scanUser(r.db.QueryRowContext(ctx, findUserQuery, id))
 	if err == sql.ErrNoRows {
 		return model.User{}, false, nil
 	}
@@ -75,18 +78,7 @@ func (r *userRepo) Find(ctx context.Context, id string) (model.User, bool, error
 }
 
 const findUserByAccountNameAndEmailQuery = `
-	SELECT 
-		u.id, 
-		u.email, 
-		u.Role,
-		u.password, 
-		u.salt,
-		u.created_at,
-		u.updated_at,
-		a.id,
-		a.name,
-		a.created_at,
-		a.updated_at
+	SELECT` + userColumns + `
 	FROM 
 		user_account u 
 		INNER JOIN account a ON a.id = u.account_id
@@ -98,20 +90,7 @@ func (r *userRepo) FindByAccountNameAndEmail(ctx context.Context, accountName, e
 	span, ctx := opentracing.StartSpanFromContext(ctx, "user_repo_find_by_email")
 	defer span.Finish()
 
-	var u model.User
-	err := r.db.QueryRowContext(ctx, findUserByAccountNameAndEmailQuery, email, accountName).Scan(
-		&u.ID,
-		&u.Email,
-		&u.Role,
-		&u.Credentials.Password,
-		&u.Credentials.Salt,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-		&u.Account.ID,
-		&u.Account.Name,
-		&u.Account.CreatedAt,
-		&u.Account.UpdatedAt,
-	)
+	u, err := scanUser(r.db.QueryRowContext(ctx, findUserByAccountNameAndEmailQuery, email, accountName))
 	if err == sql.ErrNoRows {
 		return model.User{}, false, nil
 	}
@@ -122,15 +101,48 @@ func (r *userRepo) FindByAccountNameAndEmail(ctx context.Context, accountName, e
 	return u, true, nil
 }
 
+const findUsersByAccountIDQuery = `
+	SELECT` + userColumns + `
+	FROM 
+		user_account u 
+		INNER JOIN account a ON a.id = u.account_id
+	WHERE 
+		u.account_id = ?
+	ORDER BY
+		u.created_at`
+
+func (r *userRepo) FindByAccountID(ctx context.Context, accountID string) ([]model.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "user_repo_find_by_account_id")
+	defer span.Finish()
+
+	rows, err := r.db.QueryContext(ctx, findUsersByAccountIDQuery, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users by accountId=%s: %w", accountID, err)
+	}
+	defer rows.Close()
+
+	users := make([]model.User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map row to model.User: %w", err)
+		}
+
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
 const saveUserQuery = `
-	INSERT INTO user_account(id, email, role, account_id, created_at, updated_at, password, salt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	INSERT INTO user_account(id, email, role, active, account_id, created_at, updated_at, password, salt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 func (r *userRepo) Save(ctx context.Context, user model.User) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "user_repo_save")
 	defer span.Finish()
 
 	_, err := r.db.ExecContext(ctx, saveUserQuery,
-		user.ID, user.Email, user.Role, user.Account.ID, user.CreatedAt, user.UpdatedAt,
+		user.ID, user.Email, user.Role, user.Active, user.Account.ID, user.CreatedAt, user.UpdatedAt,
 		user.Credentials.Password, user.Credentials.Salt,
 	)
 	if err != nil {
@@ -139,3 +151,137 @@ func (r *userRepo) Save(ctx context.Context, user model.User) error {
 
 	return nil
 }
+
+const updateUserQuery = `
+	UPDATE user_account SET
+		role = ?,
+		active = ?,
+		updated_at = ?
+	WHERE
+		id = ?`
+
+// Update updates the role and active status of a user unless it would leave the users account without an active admin.
+// Returns ErrUserNotFound if the user does not exist and ErrLastActiveAdmin if the account would be left without an active admin.
+func (r *userRepo) Update(ctx context.Context, user model.User) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "user_repo_update")
+	defer span.Finish()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transtaction: %w", err)
+	}
+
+	otherAdmins := 0
+	remainsAdmin := user.Role == model.AdminRole && user.Active
+	if !remainsAdmin {
+		otherAdmins, err = r.lockOtherAdmins(ctx, tx, user)
+		if err != nil {
+			dbutil.Rollback(tx)
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, updateUserQuery, user.Role, user.Active, user.UpdatedAt, user.ID)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to update %s: %w", user, err)
+	}
+
+	updated, err := rowsAffected(res)
+	if err != nil {
+		dbutil.Rollback(tx)
+		return err
+	}
+
+	if !updated {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to update %s: %w", user, ErrUserNotFound)
+	}
+
+	if !remainsAdmin && otherAdmins == 0 {
+		dbutil.Rollback(tx)
+		return fmt.Errorf("failed to update %s: %w", user, ErrLastActiveAdmin)
+	}
+
+	return tx.Commit()
+}
+
+const lockAccountAdminsQuery = `
+	SELECT
+		id
+	FROM
+		user_account
+	WHERE
+		account_id = ?
+		AND role = ?
+		AND active = ?`
+
+const lockSqliteAccountAdminsQuery = `
+	UPDATE user_account SET
+		active = active
+	WHERE
+		account_id = ?
+		AND role = ?
+		AND active = ?`
+
+// lockOtherAdmins locks the active admins of the users account until the transaction ends and counts those other than the user,
+// so that concurrent updates cannot demote or deactivate every admin of an account. Sqlite does not support SELECT ... FOR UPDATE,
+// instead the admins are written to before they are read. This takes the write lock of the database, which waits for other writers,
+// rather than a read lock that fails with SQLITE_BUSY when it is upgraded while another transaction is writing.
+func (r *userRepo) lockOtherAdmins(ctx context.Context, tx *sql.Tx, user model.User) (int, error) {
+	query := lockAccountAdminsQuery + " FOR UPDATE"
+	if _, ok := r.db.Driver().(*sqlite3.SQLiteDriver); ok {
+		_, err := tx.ExecContext(ctx, lockSqliteAccountAdminsQuery, user.Account.ID, model.AdminRole, true)
+		if err != nil {
+			return 0, fmt.Errorf("failed to lock admins of accountId=%s: %w", user.Account.ID, err)
+		}
+
+		query = lockAccountAdminsQuery
+	}
+
+	rows, err := tx.QueryContext(ctx, query, user.Account.ID, model.AdminRole, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock admins of accountId=%s: %w", user.Account.ID, err)
+	}
+	defer rows.Close()
+
+	otherAdmins := 0
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to map row to admin id: %w", err)
+		}
+
+		if id != user.ID {
+			otherAdmins++
+		}
+	}
+
+	return otherAdmins, rows.Err()
+}
+
+func scanUser(row rowScanner) (model.User, error) {
+	var u model.User
+	err := row.Scan(
+		&u.ID,
+		&u.Email,
+		&u.Role,
+		&u.Active,
+		&u.Credentials.Password,
+		&u.Credentials.Salt,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.Account.ID,
+		&u.Account.Name,
+		&u.Account.ValidityPolicy,
+		&u.Account.InvitationLifetimeHours,
+		&u.Account.CreatedAt,
+		&u.Account.UpdatedAt,
+	)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return u, nil
+}
@@ -123,6 +123,18 @@ func GenerateKeys(req model.KeyRequest) (KeyPair, error) {
 	}, nil
 }
 
+// FromPrivateKey creates an RSA KeyPair from an existing private key.
+func FromPrivateKey(key *rsa.PrivateKey) KeyPair {
+	return KeyPair{
+		publicKey:  &key.PublicKey,
+		privateKey: key,
+		options: options{
+			keySize:   key.N.BitLen(),
+			algorithm: Algorithm,
+		},
+	}
+}
+
 func parseOptions(req model.KeyRequest) (options, error) {
 	if req.Algorithm != Algorithm {
 		return options{}, fmt.Errorf("rsautil: unsupported KeyRequest.Algorithm=%s", req.Algorithm)
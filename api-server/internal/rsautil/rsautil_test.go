@@ -1,6 +1,8 @@
 package rsautil
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"strings"
 	"testing"
@@ -129,6 +131,23 @@ func TestDecode(t *testing.T) {
 	assert.Equal(original.PrivateKey, copy.PrivateKey)
 }
 
+func TestFromPrivateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+
+	rsaKey := FromPrivateKey(key)
+	assert.Equal(1024, rsaKey.keySize)
+	assert.Equal(Algorithm, rsaKey.algorithm)
+	assert.Equal(key, rsaKey.PrivateKey())
+	assert.Equal(&key.PublicKey, rsaKey.PublicKey())
+
+	decoded, err := Decode(rsaKey.Encode())
+	assert.NoError(err)
+	assert.True(key.Equal(decoded.privateKey))
+}
+
 func TestDecode_WrongTypes(t *testing.T) {
 	assert := assert.New(t)
 
@@ -8,9 +8,11 @@ import (
 	"github.com/CzarSimon/httputil"
 	"github.com/CzarSimon/httputil/jwt"
 	"github.com/CzarSimon/webca/api-server/internal/audit"
+	"github.com/CzarSimon/webca/api-server/internal/authorization"
 	"github.com/CzarSimon/webca/api-server/internal/model"
 	"github.com/CzarSimon/webca/api-server/internal/password"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
 	"github.com/opentracing/opentracing-go"
 )
 
@@ -23,6 +25,7 @@ type AccountService struct {
 	AccountRepo     repository.AccountRepository
 	UserRepo        repository.UserRepository
 	PasswordService *password.Service
+	AuthService     *authorization.Service
 }
 
 // Signup signs up a user if not present.
@@ -66,6 +69,11 @@ func (a *AccountService) Login(ctx context.Context, req model.AuthenticationRequ
 		return model.AuthenticationResponse{}, err
 	}
 
+	if !user.Active {
+		err = fmt.Errorf("%s has been deactivated", user)
+		return model.AuthenticationResponse{}, httputil.UnauthorizedError(err)
+	}
+
 	token, err := a.JwtIssuer.Issue(user.JWTUser(), tokenLifetime)
 	if err != nil {
 		return model.AuthenticationResponse{}, err
@@ -104,6 +112,63 @@ func (a *AccountService) createUser(ctx context.Context, req model.Authenticatio
 	return user, nil
 }
 
+// GetAccount retrieves an account if it exists and is accessible by the principal.
+func (a *AccountService) GetAccount(ctx context.Context, principal jwt.User, id string) (model.Account, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "account_service_get_account")
+	defer span.Finish()
+
+	account, err := a.findAccount(ctx, principal, id)
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	a.AuditLog.Read(ctx, principal.ID, "account:%s", account.ID)
+	return account, nil
+}
+
+// UpdatePolicy updates the issuance policies of an account.
+func (a *AccountService) UpdatePolicy(ctx context.Context, principal jwt.User, req model.AccountPolicyRequest) (model.Account, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "account_service_update_policy")
+	defer span.Finish()
+
+	account, err := a.findAccount(ctx, principal, req.AccountID)
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	account.ValidityPolicy = req.ValidityPolicy
+	if req.InvitationLifetimeHours > 0 {
+		account.InvitationLifetimeHours = req.InvitationLifetimeHours
+	}
+	account.UpdatedAt = timeutil.Now()
+	err = a.AccountRepo.Update(ctx, account)
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	a.AuditLog.Update(ctx, principal.ID, "account:%s", account.ID)
+	return account, nil
+}
+
+func (a *AccountService) findAccount(ctx context.Context, principal jwt.User, id string) (model.Account, error) {
+	err := a.AuthService.AssertAccountAccess(ctx, principal, id)
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	account, exists, err := a.AccountRepo.Find(ctx, id)
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	if !exists {
+		err = fmt.Errorf("could not find account with id=%s", id)
+		return model.Account{}, httputil.NotFoundError(err)
+	}
+
+	return account, nil
+}
+
 func (a *AccountService) getOrCreateAccount(ctx context.Context, name string) (model.Account, bool, error) {
 	account, exists, err := a.AccountRepo.FindByName(ctx, name)
 	if err != nil {
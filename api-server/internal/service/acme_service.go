@@ -0,0 +1,1162 @@
+package service
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/httputil/crypto"
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/audit"
+	"github.com/CzarSimon/webca/api-server/internal/jwsutil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/ratelimit"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	acmeNonceValidity           = time.Hour
+	acmeOrderValidity           = 7 * 24 * time.Hour
+	acmeValidationTimeout       = 10 * time.Second
+	acmeCertificateValidityDays = 90
+	acmeTokenLength             = 32
+	acmeNonceLength             = 16
+	acmeMaxChallengeResponse    = 1024
+)
+
+// nonPublicNetworks address ranges that http-01 validation does not connect to unless allowed by the
+// enrollment: loopback, private, shared, link-local (including cloud metadata endpoints), multicast and
+// otherwise reserved addresses.
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24",
+	"224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// newACMEHTTPClient creates the client used for http-01 validation. Names are resolved when
+// dialing and connections to addresses that are neither public nor within the allowed networks
+// are refused, so that ACME clients cannot use the server to reach internal services.
+// Proxies from the environment are ignored and redirects are not followed, a redirect to an
+// internal address would otherwise bypass the identifier checks.
+func newACMEHTTPClient(allowedNetworks []string) *http.Client {
+	allowed := mustParseCIDRs(allowedNetworks...)
+	dialer := &net.Dialer{
+		Timeout: acmeValidationTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkValidationAddress(address, allowed)
+		},
+	}
+
+	return &http.Client{
+		Timeout: acmeValidationTimeout,
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkValidationAddress is called with the resolved address of every connection attempt of the http-01 client.
+func checkValidationAddress(address string, allowedNetworks []*net.IPNet) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("connections to invalid address %s are not allowed", host)
+	}
+
+	for _, network := range allowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+
+	if !isPublicIP(ip) {
+		return fmt.Errorf("connections to non public address %s are not allowed", host)
+	}
+
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// TXTResolver looks up DNS TXT records, satisfied by *net.Resolver.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// ACMEService ACME (RFC 8555) server for certificate authorities whose account admin has enabled ACME.
+//
+// Each certificate authority has its own directory and accounts. Certificates for validated orders
+// are issued through CertificateService with the server held issuing key of the certificate authority,
+// so only authorities enrolled for unattended issuance can be enabled. New accounts and orders are rate
+// limited per certificate authority.
+//
+// Orders must be permitted by the name constraints of the certificate authority and its issuers. Names
+// that are not publicly resolvable and http-01 validation of private addresses must be allowed in the
+// enrollment, domains permitted by the name constraints of the certificate authority are allowed as well.
+type ACMEService struct {
+	AuditLog           audit.Logger
+	ACMERepo           repository.ACMERepository
+	CertRepo           repository.CertificateRepository
+	IssuingKeyRepo     repository.IssuingKeyRepository
+	CertificateService *CertificateService
+	ExternalBaseURL    string
+	HTTPPort           string
+	Resolver           TXTResolver
+	AccountLimiter     *ratelimit.Limiter
+	OrderLimiter       *ratelimit.Limiter
+}
+
+// acmeMessage verified JWS request, the account or key that signed it and the enrollment of the certificate authority.
+type acmeMessage struct {
+	payload    []byte
+	account    model.ACMEAccount
+	key        gocrypto.PublicKey
+	ca         model.Certificate
+	enrollment model.ACMEEnrollment
+}
+
+// Enable opens the ACME directory of a certificate authority, the certificate authority must be enrolled for unattended issuance.
+func (s *ACMEService) Enable(ctx context.Context, principal jwt.User, req model.ACMEEnrollmentRequest) (model.ACMEEnrollment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_enable")
+	defer span.Finish()
+
+	cert, err := s.CertificateService.findCertificate(ctx, principal, req.CertificateID)
+	if err != nil {
+		return model.ACMEEnrollment{}, err
+	}
+
+	if (cert.Type != model.RootCAType && cert.Type != model.IntermediateCAType) || cert.Revoked() {
+		err = fmt.Errorf("acme can only be enabled for certificate authorities that are not revoked: %s", cert)
+		return model.ACMEEnrollment{}, httputil.BadRequestError(err)
+	}
+
+	_, found, err := s.ACMERepo.FindEnrollment(ctx, cert.ID)
+	if err != nil {
+		return model.ACMEEnrollment{}, err
+	}
+
+	if found {
+		err = fmt.Errorf("acme is already enabled for %s", cert)
+		return model.ACMEEnrollment{}, httputil.ConflictError(err)
+	}
+
+	_, enrolled, err := s.IssuingKeyRepo.Find(ctx, cert.ID)
+	if err != nil {
+		return model.ACMEEnrollment{}, err
+	}
+
+	if !enrolled {
+		err = fmt.Errorf("%s must be enrolled for unattended issuance before acme can be enabled", cert)
+		return model.ACMEEnrollment{}, httputil.PreconditionRequiredError(err)
+	}
+
+	enrollment := model.ACMEEnrollment{
+		CertificateID:   cert.ID,
+		AllowedDomains:  req.AllowedDomains,
+		AllowedNetworks: req.AllowedNetworks,
+		CreatedBy:       principal.ID,
+		CreatedAt:       timeutil.Now(),
+	}
+
+	err = s.ACMERepo.SaveEnrollment(ctx, enrollment)
+	if err != nil {
+		return model.ACMEEnrollment{}, err
+	}
+
+	s.AuditLog.Create(ctx, principal.ID, "certificate:%s:acme", cert.ID)
+	enrollment.DirectoryURL = s.URL(cert.ID, "directory")
+	return enrollment, nil
+}
+
+// Disable closes the ACME directory of a certificate authority. Existing accounts are kept but can no longer be used.
+func (s *ACMEService) Disable(ctx context.Context, principal jwt.User, certificateID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_disable")
+	defer span.Finish()
+
+	cert, err := s.CertificateService.findCertificate(ctx, principal, certificateID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.ACMERepo.DeleteEnrollment(ctx, cert.ID)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		err = fmt.Errorf("acme is not enabled for %s", cert)
+		return httputil.NotFoundError(err)
+	}
+
+	s.AuditLog.Delete(ctx, principal.ID, "certificate:%s:acme", cert.ID)
+	return nil
+}
+
+// Directory returns the ACME directory of a certificate authority.
+func (s *ACMEService) Directory(ctx context.Context, certificateID string) (model.ACMEDirectory, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_directory")
+	defer span.Finish()
+
+	_, _, err := s.findCA(ctx, certificateID)
+	if err != nil {
+		return model.ACMEDirectory{}, err
+	}
+
+	return model.ACMEDirectory{
+		NewNonce:   s.URL(certificateID, "new-nonce"),
+		NewAccount: s.URL(certificateID, "new-account"),
+		NewOrder:   s.URL(certificateID, "new-order"),
+	}, nil
+}
+
+// NewNonce creates and stores a nonce that can be used once in a request to any ACME directory.
+func (s *ACMEService) NewNonce(ctx context.Context) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_new_nonce")
+	defer span.Finish()
+
+	nonce, err := randomToken(acmeNonceLength)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.ACMERepo.SaveNonce(ctx, nonce, timeutil.Now().Add(acmeNonceValidity))
+	if err != nil {
+		return "", err
+	}
+
+	return nonce, nil
+}
+
+// DeleteExpiredNonces removes nonces that were issued but never used.
+func (s *ACMEService) DeleteExpiredNonces(ctx context.Context) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_delete_expired_nonces")
+	defer span.Finish()
+
+	return s.ACMERepo.DeleteExpiredNonces(ctx, timeutil.Now())
+}
+
+// NewAccount registers an account for the key that signed the request or finds the existing account of the key.
+// The returned bool is true if a new account was created.
+func (s *ACMEService) NewAccount(ctx context.Context, req model.ACMERequest) (model.ACMEAccount, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_new_account")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, true)
+	if err != nil {
+		return model.ACMEAccount{}, false, err
+	}
+
+	var accountReq model.ACMEAccountRequest
+	err = decodePayload(msg.payload, &accountReq)
+	if err != nil {
+		return model.ACMEAccount{}, false, err
+	}
+
+	err = accountReq.Validate()
+	if err != nil {
+		return model.ACMEAccount{}, false, err
+	}
+
+	thumbprint, err := jwsutil.Thumbprint(msg.key)
+	if err != nil {
+		return model.ACMEAccount{}, false, model.NewACMEProblem(model.ACMEMalformed, "unsupported account key: %v", err)
+	}
+
+	account, found, err := s.ACMERepo.FindAccountByThumbprint(ctx, req.CertificateID, thumbprint)
+	if err != nil {
+		return model.ACMEAccount{}, false, err
+	}
+
+	if found {
+		return account, false, nil
+	}
+
+	if accountReq.OnlyReturnExisting {
+		return model.ACMEAccount{}, false, model.NewACMEProblem(model.ACMEAccountDoesNotExist, "no account exists for the provided key")
+	}
+
+	if !s.AccountLimiter.Allow(req.CertificateID) {
+		return model.ACMEAccount{}, false, model.NewACMEProblem(model.ACMERateLimited, "too many new accounts for certificate authority %s", req.CertificateID)
+	}
+
+	publicKey, err := jwsutil.EncodeJWK(msg.key)
+	if err != nil {
+		return model.ACMEAccount{}, false, model.NewACMEProblem(model.ACMEMalformed, "unsupported account key: %v", err)
+	}
+
+	account = model.ACMEAccount{
+		ID:            id.New(),
+		CertificateID: req.CertificateID,
+		Status:        model.ACMEStatusValid,
+		Contact:       accountReq.Contact,
+		PublicKey:     publicKey,
+		KeyThumbprint: thumbprint,
+		CreatedAt:     timeutil.Now(),
+	}
+	if account.Contact == nil {
+		account.Contact = []string{}
+	}
+
+	err = s.ACMERepo.SaveAccount(ctx, account)
+	if err != nil {
+		return model.ACMEAccount{}, false, err
+	}
+
+	s.AuditLog.Create(ctx, account.ID, "acme-account:%s", account.ID)
+	return account, true, nil
+}
+
+// UpdateAccount returns, updates the contacts of or deactivates the account that signed the request.
+func (s *ACMEService) UpdateAccount(ctx context.Context, req model.ACMERequest) (model.ACMEAccount, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_update_account")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return model.ACMEAccount{}, err
+	}
+
+	account := msg.account
+	if account.ID != req.ResourceID {
+		return model.ACMEAccount{}, model.NewACMEProblem(model.ACMEUnauthorized, "accounts can only be accessed by their own key")
+	}
+
+	if len(msg.payload) == 0 {
+		return account, nil
+	}
+
+	var accountReq model.ACMEAccountRequest
+	err = decodePayload(msg.payload, &accountReq)
+	if err != nil {
+		return model.ACMEAccount{}, err
+	}
+
+	err = accountReq.Validate()
+	if err != nil {
+		return model.ACMEAccount{}, err
+	}
+
+	if accountReq.Contact != nil {
+		account.Contact = accountReq.Contact
+	}
+	if accountReq.Status != "" {
+		account.Status = accountReq.Status
+	}
+
+	err = s.ACMERepo.UpdateAccount(ctx, account)
+	if err != nil {
+		return model.ACMEAccount{}, err
+	}
+
+	s.AuditLog.Update(ctx, account.ID, "acme-account:%s", account.ID)
+	return account, nil
+}
+
+// NewOrder creates an order with pending authorizations for the requested identifiers.
+func (s *ACMEService) NewOrder(ctx context.Context, req model.ACMERequest) (model.ACMEOrder, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_new_order")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	if !s.OrderLimiter.Allow(req.CertificateID) {
+		return model.ACMEOrder{}, model.NewACMEProblem(model.ACMERateLimited, "too many new orders for certificate authority %s", req.CertificateID)
+	}
+
+	var orderReq model.ACMEOrderRequest
+	err = decodePayload(msg.payload, &orderReq)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	orderReq, err = s.checkIdentifiers(ctx, msg, orderReq)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	now := timeutil.Now()
+	order := model.ACMEOrder{
+		ID:          id.New(),
+		AccountID:   msg.account.ID,
+		Status:      model.ACMEStatusPending,
+		Identifiers: orderReq.Identifiers,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(acmeOrderValidity),
+	}
+
+	authorizations := make([]model.ACMEAuthorization, 0, len(order.Identifiers))
+	for _, identifier := range order.Identifiers {
+		authz, err := newAuthorization(order, identifier)
+		if err != nil {
+			return model.ACMEOrder{}, err
+		}
+		authorizations = append(authorizations, authz)
+	}
+
+	err = s.ACMERepo.SaveOrder(ctx, order, authorizations)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	return s.renderOrder(ctx, req.CertificateID, order)
+}
+
+// GetOrder returns an order of the account that signed the request.
+func (s *ACMEService) GetOrder(ctx context.Context, req model.ACMERequest) (model.ACMEOrder, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_get_order")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	order, err := s.findOrder(ctx, msg.account, req.ResourceID)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	return s.renderOrder(ctx, req.CertificateID, order)
+}
+
+// GetAuthorization returns an authorization belonging to an order of the account that signed the request.
+func (s *ACMEService) GetAuthorization(ctx context.Context, req model.ACMERequest) (model.ACMEAuthorization, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_get_authorization")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return model.ACMEAuthorization{}, err
+	}
+
+	authz, _, err := s.findAuthorization(ctx, msg.account, req.ResourceID)
+	if err != nil {
+		return model.ACMEAuthorization{}, err
+	}
+
+	return s.renderAuthorization(req.CertificateID, authz), nil
+}
+
+// RespondChallenge validates a challenge once the client has signalled that it is ready.
+// Validation is performed synchronously so the returned challenge has its final status.
+// Requests without a payload return the challenge without triggering validation.
+func (s *ACMEService) RespondChallenge(ctx context.Context, req model.ACMERequest) (model.ACMEChallenge, string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_respond_challenge")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return model.ACMEChallenge{}, "", err
+	}
+
+	chal, authz, order, err := s.findChallenge(ctx, msg.account, req.ResourceID)
+	if err != nil {
+		return model.ACMEChallenge{}, "", err
+	}
+
+	authzURL := s.URL(req.CertificateID, "authz", authz.ID)
+	if len(msg.payload) == 0 || chal.Status != model.ACMEStatusPending || authz.Status != model.ACMEStatusPending {
+		return s.renderChallenge(req.CertificateID, chal), authzURL, nil
+	}
+
+	if timeutil.Now().After(order.ExpiresAt) {
+		return model.ACMEChallenge{}, "", model.NewACMEProblem(model.ACMEMalformed, "order %s has expired", order.ID)
+	}
+
+	chal.Status = model.ACMEStatusProcessing
+	claimed, err := s.ACMERepo.UpdateChallenge(ctx, chal, model.ACMEStatusPending)
+	if err != nil {
+		return model.ACMEChallenge{}, "", err
+	}
+
+	if !claimed {
+		chal, _, _, err = s.findChallenge(ctx, msg.account, req.ResourceID)
+		return s.renderChallenge(req.CertificateID, chal), authzURL, err
+	}
+
+	problem := s.validateChallenge(ctx, msg, authz, chal)
+	if problem == nil {
+		validatedAt := timeutil.Now()
+		chal.Status = model.ACMEStatusValid
+		chal.ValidatedAt = &validatedAt
+		authz.Status = model.ACMEStatusValid
+	} else {
+		chal.Status = model.ACMEStatusInvalid
+		chal.Error = problem
+		authz.Status = model.ACMEStatusInvalid
+	}
+
+	err = s.ACMERepo.CompleteChallenge(ctx, chal, authz)
+	if err != nil {
+		return model.ACMEChallenge{}, "", err
+	}
+
+	err = s.updateOrderStatus(ctx, order)
+	if err != nil {
+		return model.ACMEChallenge{}, "", err
+	}
+
+	return s.renderChallenge(req.CertificateID, chal), authzURL, nil
+}
+
+// FinalizeOrder issues a certificate for the certificate signing request of a ready order.
+func (s *ACMEService) FinalizeOrder(ctx context.Context, req model.ACMERequest) (model.ACMEOrder, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_finalize_order")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	order, err := s.findOrder(ctx, msg.account, req.ResourceID)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	if order.Status != model.ACMEStatusReady {
+		return model.ACMEOrder{}, model.NewACMEProblem(model.ACMEOrderNotReady, "order has status %s", order.Status)
+	}
+
+	var finalizeReq model.ACMEFinalizeRequest
+	err = decodePayload(msg.payload, &finalizeReq)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	csr, names, err := parseOrderCSR(finalizeReq.CSR, order)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	order.Status = model.ACMEStatusProcessing
+	claimed, err := s.ACMERepo.UpdateOrder(ctx, order, model.ACMEStatusReady)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	if !claimed {
+		return model.ACMEOrder{}, model.NewACMEProblem(model.ACMEOrderNotReady, "order is already being finalized")
+	}
+
+	cert, err := s.CertificateService.SignUnattended(ctx, model.CertificateRequest{
+		Name:    fmt.Sprintf("acme-%s", order.ID),
+		Subject: model.CertificateSubject{CommonName: names[0]},
+		SubjectAlternativeNames: model.SubjectAlternativeNames{
+			DNSNames: names,
+		},
+		Type:          model.UserCertificateType,
+		Signatory:     model.Signatory{ID: req.CertificateID},
+		ExpiresInDays: acmeCertificateValidityDays,
+	}, csr.PublicKey, msg.account.ID)
+	if err != nil {
+		order.Status = model.ACMEStatusInvalid
+		order.Error = model.NewACMEProblem(model.ACMEServerInternal, "failed to issue certificate")
+		_, updateErr := s.ACMERepo.UpdateOrder(ctx, order, model.ACMEStatusProcessing)
+		if updateErr != nil {
+			return model.ACMEOrder{}, updateErr
+		}
+		return model.ACMEOrder{}, err
+	}
+
+	order.Status = model.ACMEStatusValid
+	order.CertificateID = cert.ID
+	_, err = s.ACMERepo.UpdateOrder(ctx, order, model.ACMEStatusProcessing)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	return s.renderOrder(ctx, req.CertificateID, order)
+}
+
+// GetCertificate returns the PEM encoded certificate chain issued for an order,
+// the chain contains the issued certificate and its issuers up to but excluding the root certificate authority.
+func (s *ACMEService) GetCertificate(ctx context.Context, req model.ACMERequest) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "acme_service_get_certificate")
+	defer span.Finish()
+
+	msg, err := s.verify(ctx, req, false)
+	if err != nil {
+		return "", err
+	}
+
+	order, err := s.findOrder(ctx, msg.account, req.ResourceID)
+	if err != nil {
+		return "", err
+	}
+
+	if order.Status != model.ACMEStatusValid || order.CertificateID == "" {
+		return "", acmeNotFound("no certificate has been issued for order %s", order.ID)
+	}
+
+	chain := ""
+	certID := order.CertificateID
+	for certID != "" {
+		cert, found, err := s.CertRepo.Find(ctx, certID)
+		if err != nil {
+			return "", err
+		}
+
+		if !found {
+			return "", fmt.Errorf("certificate with id %s in chain of %s does not exist", certID, order)
+		}
+
+		if cert.Type == model.RootCAType {
+			break
+		}
+
+		chain += cert.Body
+		if cert.SignatoryID == "" {
+			issuerChain, err := s.CertificateService.ExternalIssuerChain(ctx, cert)
+			if err != nil {
+				return "", err
+			}
+			chain += issuerChain
+		}
+
+		certID = cert.SignatoryID
+	}
+
+	return chain, nil
+}
+
+// URL returns the absolute url of an ACME resource of a certificate authority.
+func (s *ACMEService) URL(certificateID string, resource ...string) string {
+	base := fmt.Sprintf("%s/v1/acme/%s", strings.TrimSuffix(s.ExternalBaseURL, "/"), certificateID)
+	return strings.Join(append([]string{base}, resource...), "/")
+}
+
+// verify checks the signature, nonce and url of a JWS signed request.
+// New accounts are signed with a JWK, all other requests with the url of an existing account as key id.
+func (s *ACMEService) verify(ctx context.Context, req model.ACMERequest, withJWK bool) (acmeMessage, error) {
+	ca, enrollment, err := s.findCA(ctx, req.CertificateID)
+	if err != nil {
+		return acmeMessage{}, err
+	}
+
+	msg, header, err := jwsutil.Parse(req.Body)
+	if errors.Is(err, jwsutil.ErrUnsupportedAlgorithm) {
+		return acmeMessage{}, model.NewACMEProblem(model.ACMEBadSignatureAlgorithm, "%v", err)
+	}
+	if err != nil {
+		return acmeMessage{}, model.NewACMEProblem(model.ACMEMalformed, "%v", err)
+	}
+
+	if header.URL != req.URL {
+		return acmeMessage{}, model.NewACMEProblem(model.ACMEUnauthorized, "url %q in protected header does not match request url", header.URL)
+	}
+
+	used, err := s.ACMERepo.UseNonce(ctx, header.Nonce, timeutil.Now())
+	if err != nil {
+		return acmeMessage{}, err
+	}
+
+	if !used {
+		return acmeMessage{}, model.NewACMEProblem(model.ACMEBadNonce, "invalid or expired nonce")
+	}
+
+	if (len(header.JWK) > 0) == (header.KeyID != "") {
+		return acmeMessage{}, model.NewACMEProblem(model.ACMEMalformed, "exactly one of jwk and kid must be present in the protected header")
+	}
+
+	var account model.ACMEAccount
+	var key gocrypto.PublicKey
+	if withJWK {
+		if header.KeyID != "" {
+			return acmeMessage{}, model.NewACMEProblem(model.ACMEMalformed, "request must be signed with a jwk")
+		}
+
+		key, err = jwsutil.ParseJWK(header.JWK)
+		if err != nil {
+			return acmeMessage{}, model.NewACMEProblem(model.ACMEMalformed, "%v", err)
+		}
+	} else {
+		if header.KeyID == "" {
+			return acmeMessage{}, model.NewACMEProblem(model.ACMEMalformed, "request must be signed with the key of an account")
+		}
+
+		account, key, err = s.findAccountKey(ctx, req.CertificateID, header.KeyID)
+		if err != nil {
+			return acmeMessage{}, err
+		}
+	}
+
+	payload, err := msg.Verify(header.Algorithm, key)
+	if err != nil {
+		return acmeMessage{}, model.NewACMEProblem(model.ACMEMalformed, "%v", err)
+	}
+
+	return acmeMessage{
+		payload:    payload,
+		account:    account,
+		key:        key,
+		ca:         ca,
+		enrollment: enrollment,
+	}, nil
+}
+
+func (s *ACMEService) findAccountKey(ctx context.Context, certificateID, keyID string) (model.ACMEAccount, gocrypto.PublicKey, error) {
+	prefix := s.URL(certificateID, "account") + "/"
+	if !strings.HasPrefix(keyID, prefix) {
+		return model.ACMEAccount{}, nil, model.NewACMEProblem(model.ACMEAccountDoesNotExist, "unknown account: %s", keyID)
+	}
+
+	account, found, err := s.ACMERepo.FindAccount(ctx, strings.TrimPrefix(keyID, prefix))
+	if err != nil {
+		return model.ACMEAccount{}, nil, err
+	}
+
+	if !found || account.CertificateID != certificateID {
+		return model.ACMEAccount{}, nil, model.NewACMEProblem(model.ACMEAccountDoesNotExist, "unknown account: %s", keyID)
+	}
+
+	if account.Status != model.ACMEStatusValid {
+		return model.ACMEAccount{}, nil, model.NewACMEProblem(model.ACMEUnauthorized, "account has status %s", account.Status)
+	}
+
+	key, err := jwsutil.ParseJWK([]byte(account.PublicKey))
+	if err != nil {
+		return model.ACMEAccount{}, nil, err
+	}
+
+	return account, key, nil
+}
+
+// findCA finds a certificate authority that can issue certificates through ACME and its enrollment.
+func (s *ACMEService) findCA(ctx context.Context, certificateID string) (model.Certificate, model.ACMEEnrollment, error) {
+	if s.ExternalBaseURL == "" {
+		return model.Certificate{}, model.ACMEEnrollment{}, acmeNotFound("acme is not enabled")
+	}
+
+	ca, found, err := s.CertRepo.Find(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, model.ACMEEnrollment{}, err
+	}
+
+	if !found || (ca.Type != model.RootCAType && ca.Type != model.IntermediateCAType) || ca.Revoked() {
+		return model.Certificate{}, model.ACMEEnrollment{}, acmeNotFound("no acme directory exists for certificate authority %s", certificateID)
+	}
+
+	enrollment, found, err := s.ACMERepo.FindEnrollment(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, model.ACMEEnrollment{}, err
+	}
+
+	if !found {
+		return model.Certificate{}, model.ACMEEnrollment{}, acmeNotFound("acme is not enabled for certificate authority %s", certificateID)
+	}
+
+	_, found, err = s.IssuingKeyRepo.Find(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, model.ACMEEnrollment{}, err
+	}
+
+	if !found {
+		return model.Certificate{}, model.ACMEEnrollment{}, acmeNotFound("certificate authority %s is not enrolled for unattended issuance", certificateID)
+	}
+
+	return ca, enrollment, nil
+}
+
+// checkIdentifiers validates the identifiers of an order. Names that are not publicly resolvable must be within
+// the domains allowed by the enrollment or permitted by the name constraints of the certificate authority, and
+// all names must be permitted by the name constraints of the certificate authority and its issuers.
+func (s *ACMEService) checkIdentifiers(ctx context.Context, msg acmeMessage, req model.ACMEOrderRequest) (model.ACMEOrderRequest, error) {
+	x509Cert, err := parseCertificate(msg.ca.Body)
+	if err != nil {
+		return model.ACMEOrderRequest{}, err
+	}
+
+	allowedDomains := append(append([]string{}, msg.enrollment.AllowedDomains...), x509Cert.PermittedDNSDomains...)
+	req, err = req.Validate(allowedDomains)
+	if err != nil {
+		return model.ACMEOrderRequest{}, err
+	}
+
+	names := make([]string, 0, len(req.Identifiers))
+	for _, identifier := range req.Identifiers {
+		names = append(names, identifier.Value)
+	}
+
+	err = s.CertificateService.assertPermittedNames(ctx, model.Certificate{
+		SignatoryID:             msg.ca.ID,
+		SubjectAlternativeNames: model.SubjectAlternativeNames{DNSNames: names},
+	})
+	var httpErr *httputil.Error
+	if errors.As(err, &httpErr) && httpErr.Status == http.StatusBadRequest {
+		return model.ACMEOrderRequest{}, model.NewACMEProblem(model.ACMERejectedIdentifier, "%v", httpErr.Err)
+	}
+	if err != nil {
+		return model.ACMEOrderRequest{}, err
+	}
+
+	return req, nil
+}
+
+func (s *ACMEService) findOrder(ctx context.Context, account model.ACMEAccount, orderID string) (model.ACMEOrder, error) {
+	order, found, err := s.ACMERepo.FindOrder(ctx, orderID)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	if !found || order.AccountID != account.ID {
+		return model.ACMEOrder{}, acmeNotFound("order %s does not exist", orderID)
+	}
+
+	if order.Status != model.ACMEStatusPending && order.Status != model.ACMEStatusReady {
+		return order, nil
+	}
+
+	if timeutil.Now().Before(order.ExpiresAt) {
+		return order, nil
+	}
+
+	fromStatus := order.Status
+	order.Status = model.ACMEStatusInvalid
+	order.Error = model.NewACMEProblem(model.ACMEMalformed, "order expired")
+	_, err = s.ACMERepo.UpdateOrder(ctx, order, fromStatus)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	return order, nil
+}
+
+func (s *ACMEService) findAuthorization(ctx context.Context, account model.ACMEAccount, authzID string) (model.ACMEAuthorization, model.ACMEOrder, error) {
+	authz, found, err := s.ACMERepo.FindAuthorization(ctx, authzID)
+	if err != nil {
+		return model.ACMEAuthorization{}, model.ACMEOrder{}, err
+	}
+
+	if !found {
+		return model.ACMEAuthorization{}, model.ACMEOrder{}, acmeNotFound("authorization %s does not exist", authzID)
+	}
+
+	order, err := s.findOrder(ctx, account, authz.OrderID)
+	if err != nil {
+		return model.ACMEAuthorization{}, model.ACMEOrder{}, acmeNotFound("authorization %s does not exist", authzID)
+	}
+
+	return authz, order, nil
+}
+
+func (s *ACMEService) findChallenge(ctx context.Context, account model.ACMEAccount, challengeID string) (model.ACMEChallenge, model.ACMEAuthorization, model.ACMEOrder, error) {
+	chal, found, err := s.ACMERepo.FindChallenge(ctx, challengeID)
+	if err != nil {
+		return model.ACMEChallenge{}, model.ACMEAuthorization{}, model.ACMEOrder{}, err
+	}
+
+	if !found {
+		return model.ACMEChallenge{}, model.ACMEAuthorization{}, model.ACMEOrder{}, acmeNotFound("challenge %s does not exist", challengeID)
+	}
+
+	authz, order, err := s.findAuthorization(ctx, account, chal.AuthorizationID)
+	if err != nil {
+		return model.ACMEChallenge{}, model.ACMEAuthorization{}, model.ACMEOrder{}, acmeNotFound("challenge %s does not exist", challengeID)
+	}
+
+	return chal, authz, order, nil
+}
+
+// updateOrderStatus marks a pending order as ready once all of its authorizations are valid
+// or as invalid if any authorization failed.
+func (s *ACMEService) updateOrderStatus(ctx context.Context, order model.ACMEOrder) error {
+	authorizations, err := s.ACMERepo.FindAuthorizationsByOrderID(ctx, order.ID)
+	if err != nil {
+		return err
+	}
+
+	status := model.ACMEStatusReady
+	for _, authz := range authorizations {
+		if authz.Status == model.ACMEStatusInvalid {
+			status = model.ACMEStatusInvalid
+			break
+		}
+		if authz.Status != model.ACMEStatusValid {
+			status = model.ACMEStatusPending
+		}
+	}
+
+	if status == model.ACMEStatusPending {
+		return nil
+	}
+
+	order.Status = status
+	if status == model.ACMEStatusInvalid {
+		order.Error = model.NewACMEProblem(model.ACMEUnauthorized, "an authorization of the order failed")
+	}
+
+	_, err = s.ACMERepo.UpdateOrder(ctx, order, model.ACMEStatusPending)
+	return err
+}
+
+func (s *ACMEService) validateChallenge(ctx context.Context, msg acmeMessage, authz model.ACMEAuthorization, chal model.ACMEChallenge) *model.ACMEProblem {
+	ctx, cancel := context.WithTimeout(ctx, acmeValidationTimeout)
+	defer cancel()
+
+	keyAuthorization := chal.Token + "." + msg.account.KeyThumbprint
+	switch chal.Type {
+	case model.HTTP01Challenge:
+		return s.validateHTTP01(ctx, msg.enrollment, authz.Identifier.Value, chal.Token, keyAuthorization)
+	case model.DNS01Challenge:
+		return s.validateDNS01(ctx, authz.Identifier.Value, keyAuthorization)
+	default:
+		return model.NewACMEProblem(model.ACMEMalformed, "unsupported challenge type: %s", chal.Type)
+	}
+}
+
+func (s *ACMEService) validateHTTP01(ctx context.Context, enrollment model.ACMEEnrollment, domain, token, keyAuthorization string) *model.ACMEProblem {
+	host := domain
+	if s.HTTPPort != "" {
+		host = net.JoinHostPort(domain, s.HTTPPort)
+	}
+
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return model.NewACMEProblem(model.ACMEMalformed, "invalid challenge url: %s", url)
+	}
+
+	res, err := newACMEHTTPClient(enrollment.AllowedNetworks).Do(req)
+	if err != nil {
+		return model.NewACMEProblem(model.ACMEConnection, "failed to fetch %s: %v", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return model.NewACMEProblem(model.ACMEIncorrectResponse, "unexpected status fetching %s: %d", url, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, acmeMaxChallengeResponse))
+	if err != nil {
+		return model.NewACMEProblem(model.ACMEConnection, "failed to read response from %s: %v", url, err)
+	}
+
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return model.NewACMEProblem(model.ACMEIncorrectResponse, "key authorization served at %s does not match", url)
+	}
+
+	return nil
+}
+
+func (s *ACMEService) validateDNS01(ctx context.Context, domain, keyAuthorization string) *model.ACMEProblem {
+	name := "_acme-challenge." + domain
+	records, err := s.Resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return model.NewACMEProblem(model.ACMEDNS, "failed to look up TXT records for %s: %v", name, err)
+	}
+
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+	for _, record := range records {
+		if record == expected {
+			return nil
+		}
+	}
+
+	return model.NewACMEProblem(model.ACMEIncorrectResponse, "no TXT record for %s matches the key authorization", name)
+}
+
+func (s *ACMEService) renderOrder(ctx context.Context, certificateID string, order model.ACMEOrder) (model.ACMEOrder, error) {
+	authorizations, err := s.ACMERepo.FindAuthorizationsByOrderID(ctx, order.ID)
+	if err != nil {
+		return model.ACMEOrder{}, err
+	}
+
+	order.Authorizations = make([]string, 0, len(authorizations))
+	for _, authz := range authorizations {
+		order.Authorizations = append(order.Authorizations, s.URL(certificateID, "authz", authz.ID))
+	}
+
+	order.FinalizeURL = s.URL(certificateID, "order", order.ID, "finalize")
+	if order.Status == model.ACMEStatusValid {
+		order.CertificateURL = s.URL(certificateID, "certificate", order.ID)
+	}
+
+	return order, nil
+}
+
+func (s *ACMEService) renderAuthorization(certificateID string, authz model.ACMEAuthorization) model.ACMEAuthorization {
+	for i, chal := range authz.Challenges {
+		authz.Challenges[i] = s.renderChallenge(certificateID, chal)
+	}
+
+	return authz
+}
+
+func (s *ACMEService) renderChallenge(certificateID string, chal model.ACMEChallenge) model.ACMEChallenge {
+	chal.URL = s.URL(certificateID, "challenge", chal.ID)
+	return chal
+}
+
+func newAuthorization(order model.ACMEOrder, identifier model.ACMEIdentifier) (model.ACMEAuthorization, error) {
+	authz := model.ACMEAuthorization{
+		ID:         id.New(),
+		OrderID:    order.ID,
+		Identifier: identifier,
+		Status:     model.ACMEStatusPending,
+		ExpiresAt:  order.ExpiresAt,
+	}
+
+	// Wildcard names can only be validated through DNS as described in RFC 8555 section 7.1.3.
+	challengeTypes := []string{model.HTTP01Challenge, model.DNS01Challenge}
+	if strings.HasPrefix(identifier.Value, "*.") {
+		authz.Identifier.Value = strings.TrimPrefix(identifier.Value, "*.")
+		authz.Wildcard = true
+		challengeTypes = []string{model.DNS01Challenge}
+	}
+
+	for _, challengeType := range challengeTypes {
+		token, err := randomToken(acmeTokenLength)
+		if err != nil {
+			return model.ACMEAuthorization{}, err
+		}
+
+		authz.Challenges = append(authz.Challenges, model.ACMEChallenge{
+			ID:              id.New(),
+			AuthorizationID: authz.ID,
+			Type:            challengeType,
+			Token:           token,
+			Status:          model.ACMEStatusPending,
+		})
+	}
+
+	return authz, nil
+}
+
+// parseOrderCSR parses a base64url encoded DER certificate signing request and checks that it requests
+// exactly the identifiers of an order. The identifiers are returned in the order they were requested.
+func parseOrderCSR(encoded string, order model.ACMEOrder) (*x509.CertificateRequest, []string, error) {
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, model.NewACMEProblem(model.ACMEBadCSR, "failed to decode csr: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, model.NewACMEProblem(model.ACMEBadCSR, "failed to parse csr: %v", err)
+	}
+
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, nil, model.NewACMEProblem(model.ACMEBadCSR, "invalid csr signature: %v", err)
+	}
+
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, nil, model.NewACMEProblem(model.ACMEBadCSR, "csr may only contain dns names")
+	}
+
+	requested := csr.DNSNames
+	if csr.Subject.CommonName != "" {
+		requested = append(requested, csr.Subject.CommonName)
+	}
+
+	normalized, err := model.SubjectAlternativeNames{DNSNames: requested}.Normalize()
+	if err != nil {
+		return nil, nil, model.NewACMEProblem(model.ACMEBadCSR, "%v", err)
+	}
+
+	names := make([]string, 0, len(order.Identifiers))
+	for _, identifier := range order.Identifiers {
+		names = append(names, identifier.Value)
+	}
+
+	csrNames := uniqueSorted(normalized.DNSNames)
+	if strings.Join(csrNames, ",") != strings.Join(uniqueSorted(names), ",") {
+		return nil, nil, model.NewACMEProblem(model.ACMEBadCSR, "csr names %v do not match the identifiers of the order", csrNames)
+	}
+
+	return csr, names, nil
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+
+	sort.Strings(unique)
+	return unique
+}
+
+func decodePayload(payload []byte, v interface{}) error {
+	err := json.Unmarshal(payload, v)
+	if err != nil {
+		return model.NewACMEProblem(model.ACMEMalformed, "failed to parse payload: %v", err)
+	}
+
+	return nil
+}
+
+func acmeNotFound(detailPattern string, args ...interface{}) *model.ACMEProblem {
+	problem := model.NewACMEProblem(model.ACMEMalformed, detailPattern, args...)
+	problem.Status = http.StatusNotFound
+	return problem
+}
+
+func randomToken(length int) (string, error) {
+	b, err := crypto.RandomBytes(length)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/audit"
+	"github.com/CzarSimon/webca/api-server/internal/authorization"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/opentracing/opentracing-go"
+)
+
+// CertificateProfileService service responsible for management of certificate profiles.
+type CertificateProfileService struct {
+	AuditLog    audit.Logger
+	ProfileRepo repository.CertificateProfileRepository
+	UserRepo    repository.UserRepository
+	AuthService *authorization.Service
+}
+
+// Create creates a certificate profile in the account of the principal.
+func (p *CertificateProfileService) Create(ctx context.Context, principal jwt.User, req model.CertificateProfileRequest) (model.CertificateProfile, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_service_create")
+	defer span.Finish()
+
+	user, err := p.findUser(ctx, principal.ID)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	err = p.assertNameAvailable(ctx, req.Name, user.Account.ID, "")
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	profile := model.NewCertificateProfile(req, user.Account.ID)
+	err = p.ProfileRepo.Save(ctx, profile)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	p.AuditLog.Create(ctx, principal.ID, "certificate-profile:%s", profile.ID)
+	return profile, nil
+}
+
+// Get retrieves a certificate profile if it exists and is accessible by the principal.
+func (p *CertificateProfileService) Get(ctx context.Context, principal jwt.User, id string) (model.CertificateProfile, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_service_get")
+	defer span.Finish()
+
+	profile, err := p.findProfile(ctx, principal, id)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	p.AuditLog.Read(ctx, principal.ID, "certificate-profile:%s", profile.ID)
+	return profile, nil
+}
+
+// List retrieves the certificate profiles of the account of the principal.
+func (p *CertificateProfileService) List(ctx context.Context, principal jwt.User) ([]model.CertificateProfile, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_service_list")
+	defer span.Finish()
+
+	user, err := p.findUser(ctx, principal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := p.ProfileRepo.FindByAccountID(ctx, user.Account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, profile := range profiles {
+		p.AuditLog.Read(ctx, principal.ID, "certificate-profile:%s", profile.ID)
+	}
+
+	return profiles, nil
+}
+
+// Update replaces the name and policy of a certificate profile.
+// Certificates already issued with the profile are not affected.
+func (p *CertificateProfileService) Update(ctx context.Context, principal jwt.User, req model.CertificateProfileRequest) (model.CertificateProfile, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_service_update")
+	defer span.Finish()
+
+	profile, err := p.findProfile(ctx, principal, req.ProfileID)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	err = p.assertNameAvailable(ctx, req.Name, profile.AccountID, profile.ID)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	profile = profile.Apply(req, timeutil.Now())
+	err = p.ProfileRepo.Update(ctx, profile)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	p.AuditLog.Update(ctx, principal.ID, "certificate-profile:%s", profile.ID)
+	return profile, nil
+}
+
+// Delete removes a certificate profile, certificates already issued with the profile are not affected.
+func (p *CertificateProfileService) Delete(ctx context.Context, principal jwt.User, id string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_profile_service_delete")
+	defer span.Finish()
+
+	profile, err := p.findProfile(ctx, principal, id)
+	if err != nil {
+		return err
+	}
+
+	err = p.ProfileRepo.Delete(ctx, profile.ID)
+	if err != nil {
+		return err
+	}
+
+	p.AuditLog.Delete(ctx, principal.ID, "certificate-profile:%s", profile.ID)
+	return nil
+}
+
+func (p *CertificateProfileService) assertNameAvailable(ctx context.Context, name, accountID, profileID string) error {
+	existing, exists, err := p.ProfileRepo.FindByNameAndAccountID(ctx, name, accountID)
+	if err != nil {
+		return err
+	}
+
+	if exists && existing.ID != profileID {
+		err = fmt.Errorf("certificate profile with name=%s already exists in account(id=%s)", name, accountID)
+		return httputil.ConflictError(err)
+	}
+
+	return nil
+}
+
+func (p *CertificateProfileService) findProfile(ctx context.Context, principal jwt.User, id string) (model.CertificateProfile, error) {
+	profile, exists, err := p.ProfileRepo.Find(ctx, id)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	if !exists {
+		err = fmt.Errorf("could not find certificate profile with id=%s", id)
+		return model.CertificateProfile{}, httputil.NotFoundError(err)
+	}
+
+	err = p.AuthService.AssertAccountAccess(ctx, principal, profile.AccountID)
+	if err != nil {
+		return model.CertificateProfile{}, err
+	}
+
+	return profile, nil
+}
+
+func (p *CertificateProfileService) findUser(ctx context.Context, userID string) (model.User, error) {
+	user, exists, err := p.UserRepo.Find(ctx, userID)
+	if err != nil {
+		return model.User{}, httputil.InternalServerError(err)
+	}
+
+	if !exists {
+		err := fmt.Errorf("unable to find User(id=%s) even though an authenticated user id was provided", userID)
+		return model.User{}, httputil.UnauthorizedError(err)
+	}
+
+	if !user.Active {
+		err := fmt.Errorf("%s has been deactivated", user)
+		return model.User{}, httputil.UnauthorizedError(err)
+	}
+
+	return user, nil
+}
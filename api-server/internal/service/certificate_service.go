@@ -1,15 +1,26 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
-	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -17,33 +28,79 @@ import (
 	"github.com/CzarSimon/httputil/crypto"
 	"github.com/CzarSimon/httputil/id"
 	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/httputil/logger"
 	"github.com/CzarSimon/webca/api-server/internal/audit"
 	"github.com/CzarSimon/webca/api-server/internal/authorization"
+	"github.com/CzarSimon/webca/api-server/internal/ecdsautil"
+	"github.com/CzarSimon/webca/api-server/internal/ed25519util"
 	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
 	"github.com/CzarSimon/webca/api-server/internal/password"
+	"github.com/CzarSimon/webca/api-server/internal/pkcs12util"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
 	"github.com/CzarSimon/webca/api-server/internal/rsautil"
 	"github.com/CzarSimon/webca/api-server/internal/timeutil"
 	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/pkcs12"
 )
 
+var log = logger.GetDefaultLogger("api-server/service")
+
 const (
-	textPlainType   = "text/plain"
-	maxSerialNumber = 9007199254740991
+	textPlainType                = "text/plain"
+	pkcs12Type                   = "application/pkcs12"
+	pkcs12Format                 = "P12"
+	pkixCertType                 = "application/pkix-cert"
+	pkcs8Type                    = "application/pkcs8"
+	pkcs8Extension               = "P8"
+	pemFormat                    = "PEM"
+	csrBlockType                 = "CERTIFICATE REQUEST"
+	privateKeyBlockType          = "PRIVATE KEY"
+	encryptedPrivateKeyBlockType = "ENCRYPTED PRIVATE KEY"
+	serialNumberBits             = 159
+	maxSerialNumberAttempts      = 3
 )
 
-func init() {
-	mathrand.Seed(time.Now().UnixNano())
+var x509KeyUsages = map[string]x509.KeyUsage{
+	model.DigitalSignatureUsage:  x509.KeyUsageDigitalSignature,
+	model.ContentCommitmentUsage: x509.KeyUsageContentCommitment,
+	model.KeyEnciphermentUsage:   x509.KeyUsageKeyEncipherment,
+	model.DataEnciphermentUsage:  x509.KeyUsageDataEncipherment,
+	model.KeyAgreementUsage:      x509.KeyUsageKeyAgreement,
+}
+
+var x509ExtKeyUsages = map[string]x509.ExtKeyUsage{
+	model.ServerAuthUsage:      x509.ExtKeyUsageServerAuth,
+	model.ClientAuthUsage:      x509.ExtKeyUsageClientAuth,
+	model.CodeSigningUsage:     x509.ExtKeyUsageCodeSigning,
+	model.EmailProtectionUsage: x509.ExtKeyUsageEmailProtection,
+	model.TimeStampingUsage:    x509.ExtKeyUsageTimeStamping,
+	model.OCSPSigningUsage:     x509.ExtKeyUsageOCSPSigning,
 }
 
 // CertificateService service responsible for certificate createion and management.
+//
+// Certificate authorities that their owner explicitly enrolls for unattended issuance have their private key stored a second time,
+// wrapped with a key derived from KeyWrappingKey, so that leaf certificates can be issued through ACME without the password.
+//
+// The admins of an account are warned through Notifier once an active certificate that has not been renewed expires within ExpiryWarningPeriod.
 type CertificateService struct {
-	AuditLog        audit.Logger
-	CertRepo        repository.CertificateRepository
-	KeyPairRepo     repository.KeyPairRepository
-	UserRepo        repository.UserRepository
-	PasswordService *password.Service
-	AuthService     *authorization.Service
+	AuditLog            audit.Logger
+	CertRepo            repository.CertificateRepository
+	KeyPairRepo         repository.KeyPairRepository
+	UserRepo            repository.UserRepository
+	AccountRepo         repository.AccountRepository
+	ProfileRepo         repository.CertificateProfileRepository
+	PendingRepo         repository.PendingCertificateRepository
+	IssuingKeyRepo      repository.IssuingKeyRepository
+	KeyWrappingKey      []byte
+	PasswordService     *password.Service
+	AuthService         *authorization.Service
+	CRLService          *RevocationListService
+	OCSPService         *OCSPService
+	Notifier            Notifier
+	ExpiryWarningPeriod time.Duration
 }
 
 // GetCertificate retrieves certificate if it exists.
@@ -94,41 +151,77 @@ func (c *CertificateService) getCertificatesByFilter(ctx context.Context, filter
 	return c.CertRepo.FindByAccountIDAndTypes(ctx, filter.AccountID, filter.Types)
 }
 
-// GetOptions fetches certificate creation options.
-func (c *CertificateService) GetOptions(ctx context.Context) (model.CertificateOptions, error) {
+// GetOptions fetches certificate creation options, including the certificate profiles of the account of the principal.
+func (c *CertificateService) GetOptions(ctx context.Context, principal jwt.User) (model.CertificateOptions, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_get_options")
 	defer span.Finish()
 
+	user, err := c.findUser(ctx, principal.ID)
+	if err != nil {
+		return model.CertificateOptions{}, err
+	}
+
 	types, err := c.CertRepo.FindTypes(ctx)
 	if err != nil {
 		return model.CertificateOptions{}, err
 	}
 
+	profiles, err := c.ProfileRepo.FindByAccountID(ctx, user.Account.ID)
+	if err != nil {
+		return model.CertificateOptions{}, err
+	}
+
 	opts := model.CertificateOptions{
-		Types:      types,
-		Algorithms: []string{rsautil.Algorithm},
-		Formats:    []string{"PEM"},
+		Types:        types,
+		Algorithms:   []string{rsautil.Algorithm, ecdsautil.Algorithm, ed25519util.Algorithm},
+		Formats:      model.CertificateFormats,
+		KeyFormats:   model.KeyFormats,
+		Profiles:     profiles,
+		KeyUsages:    model.KeyUsages,
+		ExtKeyUsages: model.ExtKeyUsages,
 	}
 	return opts, nil
 }
 
-// GetCertificateBody retrieves certificate body as an attachment.
-func (c *CertificateService) GetCertificateBody(ctx context.Context, principal jwt.User, id string, fullchain bool) (model.Attachment, error) {
+// GetCertificateBody retrieves certificate body as an attachment in the requested format.
+func (c *CertificateService) GetCertificateBody(ctx context.Context, principal jwt.User, id string, fullchain bool, format string) (model.Attachment, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_get_certificate_body")
 	defer span.Finish()
 
+	err := model.ValidateCertificateFormat(format)
+	if err != nil {
+		return model.Attachment{}, httputil.BadRequestError(err)
+	}
+
 	if fullchain {
+		if format != model.FormatPEM {
+			err = fmt.Errorf("fullchain is only available in format %s", model.FormatPEM)
+			return model.Attachment{}, httputil.BadRequestError(err)
+		}
 		return c.getCertificateChainBody(ctx, principal, id)
 	}
 
-	return c.getCertificateBody(ctx, principal, id)
+	return c.getCertificateBody(ctx, principal, id, format)
 }
 
-// GetCertificatePrivateKey retrieves certificate private key as an attachment.
-func (c *CertificateService) GetCertificatePrivateKey(ctx context.Context, principal jwt.User, id, password string) (model.Attachment, error) {
+// GetCertificatePrivateKey retrieves certificate private key as an attachment in the requested format,
+// the export password is only used to encrypt keys in the encrypted PKCS#8 format.
+func (c *CertificateService) GetCertificatePrivateKey(ctx context.Context, principal jwt.User, id, format, password, exportPassword string) (model.Attachment, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_get_certificate_private_key")
 	defer span.Finish()
 
+	err := model.ValidateKeyFormat(format)
+	if err != nil {
+		return model.Attachment{}, httputil.BadRequestError(err)
+	}
+
+	if format == model.FormatEncryptedPKCS8 {
+		err = c.assertExportPassword(password, exportPassword)
+		if err != nil {
+			return model.Attachment{}, err
+		}
+	}
+
 	cert, err := c.findCertificate(ctx, principal, id)
 	if err != nil {
 		return model.Attachment{}, err
@@ -154,382 +247,2148 @@ func (c *CertificateService) GetCertificatePrivateKey(ctx context.Context, princ
 		return model.Attachment{}, err
 	}
 
+	attachment, err := encodePrivateKey(cert.Name, keyPair, format, exportPassword)
+	if err != nil {
+		return model.Attachment{}, httputil.InternalServerError(err)
+	}
+
 	c.logPrivateKeyReading(ctx, keyPair, principal.ID)
-	return model.Attachment{
-		Body:        keyPair.PrivateKey,
-		ContentType: textPlainType,
-		Filename:    attachmentFilename(cert.Name, "private-key", keyPair.Format),
-	}, err
+	return attachment, nil
 }
 
-// Create creates and stores a certificate and private key.
-func (c *CertificateService) Create(ctx context.Context, req model.CertificateRequest) (model.Certificate, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_create")
+// GetCertificatePKCS12 creates a PKCS#12 bundle of a certificate, its chain and private key which is protected by an export password.
+func (c *CertificateService) GetCertificatePKCS12(ctx context.Context, principal jwt.User, id, password, exportPassword string) (model.Attachment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_get_certificate_pkcs12")
 	defer span.Finish()
 
-	err := c.PasswordService.Allowed(req.Password)
+	err := c.assertExportPassword(password, exportPassword)
 	if err != nil {
-		return model.Certificate{}, httputil.BadRequestError(err)
+		return model.Attachment{}, err
 	}
 
-	user, err := c.findUser(ctx, req.UserID)
+	cert, err := c.findCertificate(ctx, principal, id)
 	if err != nil {
-		return model.Certificate{}, err
+		return model.Attachment{}, err
 	}
 
-	keys, err := c.createKeys(ctx, req.KeyRequest())
+	encryptedKeyPair, found, err := c.findCertificateKeyPair(ctx, principal, id)
 	if err != nil {
-		return model.Certificate{}, err
+		return model.Attachment{}, err
 	}
 
-	cert, err := c.createCertificate(ctx, req, keys, user)
-	if err != nil {
-		return model.Certificate{}, err
+	if !found {
+		err = fmt.Errorf("KeyPair does not exist for certificate with id = %s", id)
+		return model.Attachment{}, httputil.NotFoundError(err)
 	}
 
-	return cert, nil
-}
+	err = c.PasswordService.Verify(ctx, encryptedKeyPair.Credentials, password)
+	if err != nil {
+		return model.Attachment{}, err
+	}
 
-func (c *CertificateService) createCertificate(ctx context.Context, req model.CertificateRequest, keys model.KeyEncoder, user model.User) (model.Certificate, error) {
-	keyPair, err := c.encryptKeys(ctx, keys.Encode(), req.Password, user)
+	keyPair, err := c.decryptKeys(ctx, encryptedKeyPair, password)
 	if err != nil {
-		return model.Certificate{}, err
+		return model.Attachment{}, err
 	}
 
-	signingKeys, err := c.getSigningKeys(ctx, req, keys, user)
+	keys, err := decodeKeys(keyPair)
 	if err != nil {
-		return model.Certificate{}, err
+		return model.Attachment{}, httputil.InternalServerError(err)
 	}
 
-	cert, err := signCertificate(assembleCertificate(req, keyPair, user), keys.PublicKey(), signingKeys.PrivateKey())
+	bundle, err := c.pkcs12Bundle(ctx, principal, cert, keys)
 	if err != nil {
-		return model.Certificate{}, err
+		return model.Attachment{}, err
 	}
 
-	err = c.CertRepo.Save(ctx, cert)
+	body, err := pkcs12util.Encode(bundle, exportPassword)
 	if err != nil {
-		return model.Certificate{}, err
+		return model.Attachment{}, httputil.InternalServerError(err)
 	}
 
-	c.logNewCertificate(ctx, cert, user.ID)
-	return cert, nil
+	c.logPrivateKeyReading(ctx, keyPair, principal.ID)
+	return model.NewBinaryAttachment(body, pkcs12Type, attachmentFilename(cert.Name, "bundle", pkcs12Format)), nil
 }
 
-func (c *CertificateService) createKeys(ctx context.Context, req model.KeyRequest) (model.KeyEncoder, error) {
-	switch req.Algorithm {
-	case rsautil.Algorithm:
-		return rsautil.GenerateKeys(req)
-	default:
-		err := fmt.Errorf("CertificateService: unsupported KeyRequest.Algorithm=%s", req.Algorithm)
-		return nil, httputil.BadRequestError(err)
-	}
-}
+// Create creates and stores a certificate and private key.
+func (c *CertificateService) Create(ctx context.Context, req model.CertificateRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_create")
+	defer span.Finish()
 
-func (c *CertificateService) getCertificateBody(ctx context.Context, principal jwt.User, id string) (model.Attachment, error) {
-	cert, err := c.findCertificate(ctx, principal, id)
+	err := c.PasswordService.Allowed(req.Password)
 	if err != nil {
-		return model.Attachment{}, err
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	c.logCertificateBodyReading(ctx, cert, principal.ID)
-	return model.Attachment{
-		Body:        cert.Body,
-		ContentType: textPlainType,
-		Filename:    attachmentFilename(cert.Name, cert.Type, cert.Format),
-	}, err
-}
-
-func (c *CertificateService) getCertificateChainBody(ctx context.Context, principal jwt.User, id string) (model.Attachment, error) {
-	chain, err := c.getCertificateChain(ctx, principal, id)
+	user, err := c.findUser(ctx, req.UserID)
 	if err != nil {
-		return model.Attachment{}, err
+		return model.Certificate{}, err
 	}
 
-	if len(chain) < 1 {
-		err = fmt.Errorf("no certificate chain exits for certificate(id=%s)", id)
-		return model.Attachment{}, httputil.BadRequestError(err)
+	err = c.assertNameAvailable(ctx, req.Name, user.Account.ID)
+	if err != nil {
+		return model.Certificate{}, err
 	}
 
-	body := ""
-	for _, cert := range chain {
-		body += cert.Body
-		c.logCertificateBodyReading(ctx, cert, principal.ID)
+	req, profile, err := c.applyProfile(ctx, req, user)
+	if err != nil {
+		return model.Certificate{}, err
 	}
 
-	return model.Attachment{
-		Body:        body,
-		ContentType: textPlainType,
-		Filename:    attachmentFilename(chain[0].Name, "fullchain", chain[0].Format),
-	}, err
-}
-
-func (c *CertificateService) getCertificateChain(ctx context.Context, principal jwt.User, id string) ([]model.Certificate, error) {
-	certificates := make([]model.Certificate, 0)
-	certID := id
-	for certID != "" {
-		cert, err := c.findCertificate(ctx, principal, certID)
-		if err != nil {
-			return nil, err
-		}
+	keys, err := c.createKeys(ctx, req.KeyRequest())
+	if err != nil {
+		return model.Certificate{}, err
+	}
 
-		if cert.Type == model.RootCAType {
-			break
-		}
+	err = checkProfileKey(profile, keys.PublicKey())
+	if err != nil {
+		return model.Certificate{}, err
+	}
 
-		certificates = append(certificates, cert)
-		certID = cert.SignatoryID
+	cert, err := c.createCertificate(ctx, req, keys, user)
+	if err != nil {
+		return model.Certificate{}, err
 	}
 
-	return certificates, nil
+	return cert, nil
 }
 
-func (c *CertificateService) getSigningKeys(ctx context.Context, req model.CertificateRequest, certKeys model.KeyEncoder, user model.User) (model.KeyEncoder, error) {
-	if req.Type == model.RootCAType {
-		return certKeys, nil
-	}
+// Sign issues and stores a certificate for an externally generated certificate signing request.
+// No private key is created or stored for the certificate.
+func (c *CertificateService) Sign(ctx context.Context, signingReq model.SigningRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_sign")
+	defer span.Finish()
 
-	encryptedKeys, err := c.findSigningKeyPair(ctx, user.JWTUser(), req.Signatory.ID)
+	csr, err := parseCertificateRequest(signingReq.CSR)
 	if err != nil {
-		return nil, err
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	keyPair, err := c.decryptKeys(ctx, encryptedKeys, req.Signatory.Password)
+	req, err := newCertificateRequest(signingReq, csr)
 	if err != nil {
-		return nil, err
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	keys, err := rsautil.Decode(keyPair)
+	user, err := c.findUser(ctx, req.UserID)
 	if err != nil {
-		return nil, err
+		return model.Certificate{}, err
 	}
 
-	return keys, nil
-}
-
-func (c *CertificateService) findSigningKeyPair(ctx context.Context, principal jwt.User, certificateID string) (model.KeyPair, error) {
-	cert, found, err := c.CertRepo.Find(ctx, certificateID)
+	err = c.assertNameAvailable(ctx, req.Name, user.Account.ID)
 	if err != nil {
-		return model.KeyPair{}, err
-	}
-
-	if !found {
-		err = fmt.Errorf("certificate with id %s does not exist", certificateID)
-		return model.KeyPair{}, httputil.PreconditionRequiredError(err)
+		return model.Certificate{}, err
 	}
 
-	err = c.AuthService.AssertAccountAccess(ctx, principal, cert.AccountID)
+	req, profile, err := c.applyProfile(ctx, req, user)
 	if err != nil {
-		return model.KeyPair{}, err
+		return model.Certificate{}, err
 	}
 
-	if cert.Type != model.RootCAType && cert.Type != model.IntermediateCAType {
-		err := fmt.Errorf("invalid signing certificate: %s", cert)
-		return model.KeyPair{}, httputil.BadRequestError(err)
+	err = checkProfileKey(profile, csr.PublicKey)
+	if err != nil {
+		return model.Certificate{}, err
 	}
 
-	keyPair, found, err := c.findCertificateKeyPair(ctx, principal, certificateID)
+	issuer, err := c.getIssuer(ctx, req, nil, user)
 	if err != nil {
-		return model.KeyPair{}, err
+		return model.Certificate{}, err
 	}
 
-	if !found {
-		err = fmt.Errorf("KeyPair does not exist for certificate with id = %s", certificateID)
-		return model.KeyPair{}, httputil.PreconditionRequiredError(err)
+	cert := assembleCertificate(req, model.KeyPair{}, user)
+	cert.Format = pemFormat
+	cert, err = c.issueCertificate(ctx, cert, csr.PublicKey, issuer)
+	if errors.Is(err, repository.ErrDuplicateGeneration) {
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+	if err != nil {
+		return model.Certificate{}, err
 	}
 
-	return keyPair, nil
+	c.logNewCertificate(ctx, cert, user.ID)
+	return cert, nil
 }
 
-func (c *CertificateService) encryptKeys(ctx context.Context, keyPair model.KeyPair, pwd string, user model.User) (model.KeyPair, error) {
-	creds, err := c.PasswordService.Hash(ctx, pwd)
+// SignUnattended issues and stores a certificate for a public key without the password of the signatory.
+// The certificate is signed with the server held key of a certificate authority enrolled for unattended issuance,
+// actorID identifies who requested the certificate in the audit log.
+func (c *CertificateService) SignUnattended(ctx context.Context, req model.CertificateRequest, pub interface{}, actorID string) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_sign_unattended")
+	defer span.Finish()
+
+	signatory, signer, err := c.findUnattendedIssuer(ctx, req.Signatory.ID)
 	if err != nil {
-		return model.KeyPair{}, err
+		return model.Certificate{}, err
 	}
 
-	salt, err := c.PasswordService.GenerateSalt()
-	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("salt generation failed: %w", err)
+	if signatory.Revoked() {
+		err := fmt.Errorf("revoked certificate cannot be used as signatory: %s", signatory)
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	encryptionKey, err := crypto.Hmac([]byte(pwd), salt)
+	signer, err = c.withRevocationURLs(ctx, signatory.ID, signer)
 	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("failed to generate encryption key: %w", err)
+		return model.Certificate{}, err
 	}
 
-	ciphertext, err := crypto.NewAESCipher(encryptionKey).Encrypt([]byte(keyPair.PrivateKey))
+	owner := model.User{Account: model.Account{ID: signatory.AccountID}}
+	cert := assembleCertificate(req, model.KeyPair{}, owner)
+	cert.Format = pemFormat
+	cert, err = c.issueCertificate(ctx, cert, pub, signer)
 	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("failed to encrypt private key: %w", err)
+		return model.Certificate{}, err
 	}
 
-	keyPair.Credentials = creds
-	keyPair.EncryptionSalt = base64.StdEncoding.EncodeToString(salt)
-	keyPair.PrivateKey = base64.StdEncoding.EncodeToString(ciphertext)
-	keyPair.AccountID = user.Account.ID
-
-	return keyPair, nil
+	c.logNewCertificate(ctx, cert, actorID)
+	return cert, nil
 }
 
-func (c *CertificateService) decryptKeys(ctx context.Context, keyPair model.KeyPair, pwd string) (model.KeyPair, error) {
-	salt, err := base64.StdEncoding.DecodeString(keyPair.EncryptionSalt)
+// Import stores an existing certificate authority and its private key, e.g. when migrating from another CA.
+// The serial number of the certificate is preserved, its type is inferred from whether it is self-signed and
+// intermediates are linked to the certificate authority in the account that issued them, if it has been imported.
+func (c *CertificateService) Import(ctx context.Context, req model.ImportRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_import")
+	defer span.Finish()
+
+	err := c.PasswordService.Allowed(req.Password)
 	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("failed to decode salt: %w", err)
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	ciphertext, err := base64.StdEncoding.DecodeString(keyPair.PrivateKey)
+	user, err := c.findUser(ctx, req.UserID)
 	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("failed to decode encrypted private key: %w", err)
+		return model.Certificate{}, err
 	}
 
-	encryptionKey, err := crypto.Hmac([]byte(pwd), salt)
+	err = c.assertNameAvailable(ctx, req.Name, user.Account.ID)
 	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("failed to generate encryption key: %w", err)
+		return model.Certificate{}, err
 	}
 
-	plaintext, err := crypto.NewAESCipher(encryptionKey).Decrypt(ciphertext)
+	x509Cert, keys, err := decodeImport(req)
 	if err != nil {
-		return model.KeyPair{}, fmt.Errorf("failed to decrypt private key: %w", err)
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	keyPair.PrivateKey = string(plaintext)
-	return keyPair, nil
-}
-
-func (c *CertificateService) findCertificate(ctx context.Context, principal jwt.User, id string) (model.Certificate, error) {
-	cert, found, err := c.CertRepo.Find(ctx, id)
+	cert, err := c.importedCertificate(ctx, req.Name, x509Cert, user)
 	if err != nil {
 		return model.Certificate{}, err
 	}
 
-	if !found {
-		err = fmt.Errorf("certificate with id %s does not exist", id)
-		return model.Certificate{}, httputil.NotFoundError(err)
+	cert.KeyPair, err = c.encryptKeys(ctx, keys.Encode(), req.Password, user)
+	if err != nil {
+		return model.Certificate{}, err
 	}
 
-	err = c.AuthService.AssertAccountAccess(ctx, principal, cert.AccountID)
+	err = c.CertRepo.Save(ctx, cert)
+	if errors.Is(err, repository.ErrDuplicateKeyPair) {
+		return model.Certificate{}, httputil.NewError("Certificate already imported", http.StatusConflict, err)
+	}
+	if errors.Is(err, repository.ErrDuplicateSerialNumber) || errors.Is(err, repository.ErrDuplicateGeneration) {
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
 	if err != nil {
 		return model.Certificate{}, err
 	}
 
+	c.logNewCertificate(ctx, cert, user.ID)
 	return cert, nil
 }
 
-func (c *CertificateService) findCertificateKeyPair(ctx context.Context, principal jwt.User, id string) (model.KeyPair, bool, error) {
-	keyPair, found, err := c.KeyPairRepo.FindByCertificateID(ctx, id)
-	if err != nil || !found {
-		return model.KeyPair{}, found, err
+// importedCertificate creates the certificate of an imported certificate authority.
+func (c *CertificateService) importedCertificate(ctx context.Context, name string, x509Cert *x509.Certificate, user model.User) (model.Certificate, error) {
+	if !x509Cert.BasicConstraintsValid || !x509Cert.IsCA {
+		err := fmt.Errorf("certificate %s is not a certificate authority", x509Cert.Subject.CommonName)
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	err = c.AuthService.AssertAccountAccess(ctx, principal, keyPair.AccountID)
-	if err != nil {
-		return model.KeyPair{}, true, err
+	if x509Cert.SerialNumber.Sign() <= 0 {
+		err := fmt.Errorf("certificate %s has a non-positive serial number", x509Cert.Subject.CommonName)
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	return keyPair, true, nil
-}
-
-func (c *CertificateService) findUser(ctx context.Context, userID string) (model.User, error) {
-	user, exists, err := c.UserRepo.Find(ctx, userID)
+	names, err := alternativeNames(x509Cert.DNSNames, x509Cert.IPAddresses, x509Cert.EmailAddresses, x509Cert.URIs)
 	if err != nil {
-		return model.User{}, httputil.InternalServerError(err)
+		return model.Certificate{}, httputil.BadRequestError(err)
 	}
 
-	if !exists {
-		err := fmt.Errorf("unable to find User(id=%s) even though an authenticated user id was provided", userID)
-		return model.User{}, httputil.UnauthorizedError(err)
+	cert := model.Certificate{
+		ID:                      id.New(),
+		Name:                    name,
+		SerialNumber:            encodeSerialNumber(x509Cert.SerialNumber),
+		Subject:                 subjectOf(x509Cert.Subject),
+		SubjectAlternativeNames: names,
+		Body:                    encodeCertificate(x509Cert),
+		Format:                  pemFormat,
+		Type:                    model.RootCAType,
+		AccountID:               user.Account.ID,
+		Status:                  model.CertificateActive,
+		MaxPathLen:              pathLenConstraint(x509Cert),
+		NameConstraints:         nameConstraintsOf(x509Cert),
+		CreatedAt:               timeutil.Now(),
+		ExpiresAt:               x509Cert.NotAfter.UTC(),
 	}
 
-	return user, nil
+	if !selfSigned(x509Cert) {
+		cert.Type = model.IntermediateCAType
+		cert.SignatoryID, err = c.findImportedSignatory(ctx, x509Cert, user.Account.ID)
+		if err != nil {
+			return model.Certificate{}, err
+		}
+	}
+
+	cert.IssuerID = issuerID(cert, x509Cert)
+	return cert, nil
+}
+
+// findImportedSignatory finds the certificate authority in an account that issued a certificate by matching
+// its subject against the issuer and its subject key id against the authority key id of the certificate.
+// An empty id is returned if the issuer is not present in the account.
+func (c *CertificateService) findImportedSignatory(ctx context.Context, x509Cert *x509.Certificate, accountID string) (string, error) {
+	candidates, err := c.CertRepo.FindByAccountIDAndTypes(ctx, accountID, []string{model.RootCAType, model.IntermediateCAType})
+	if err != nil {
+		return "", err
+	}
+
+	for _, candidate := range candidates {
+		issuer, err := parseCertificate(candidate.Body)
+		if err != nil {
+			return "", err
+		}
+
+		if !bytes.Equal(issuer.RawSubject, x509Cert.RawIssuer) {
+			continue
+		}
+
+		if len(x509Cert.AuthorityKeyId) > 0 && !bytes.Equal(issuer.SubjectKeyId, x509Cert.AuthorityKeyId) {
+			continue
+		}
+
+		if x509Cert.CheckSignatureFrom(issuer) == nil {
+			return candidate.ID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Renew issues a successor of a certificate with the same name, subject, alternative names, type and signatory.
+// The successor either reuses the key pair of the renewed certificate, which requires its password, or gets a new key pair.
+func (c *CertificateService) Renew(ctx context.Context, principal jwt.User, req model.RenewalRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_renew")
+	defer span.Finish()
+
+	predecessor, err := c.findCertificate(ctx, principal, req.CertificateID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if predecessor.Renewed() {
+		err = fmt.Errorf("%s has already been renewed by certificate %s", predecessor, predecessor.SuccessorID)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+
+	if req.Signatory.ID != "" && req.Signatory.ID != predecessor.SignatoryID {
+		err = fmt.Errorf("signatory of %s cannot be changed on renewal", predecessor)
+		return model.Certificate{}, httputil.BadRequestError(err)
+	}
+
+	if predecessor.Revoked() && !req.Rekey {
+		err = fmt.Errorf("revoked %s can only be renewed with a new key", predecessor)
+		return model.Certificate{}, httputil.BadRequestError(err)
+	}
+
+	user, err := c.findUser(ctx, req.UserID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	certReq, err := newRenewalCertificateRequest(predecessor, req)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keys, keyPair, err := c.getRenewalKeys(ctx, principal, predecessor, certReq, req.Rekey, user)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	issuer, err := c.getIssuer(ctx, certReq, keys, user)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	successor := assembleCertificate(certReq, keyPair, user)
+	successor.PredecessorID = predecessor.ID
+	successor.Generation = predecessor.Generation + 1
+	successor, err = c.issueCertificate(ctx, successor, keys.PublicKey(), issuer)
+	if errors.Is(err, repository.ErrDuplicateGeneration) {
+		err = fmt.Errorf("%s was renewed concurrently: %w", predecessor, err)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	c.logNewCertificate(ctx, successor, user.ID)
+	c.logCertificateRenewal(ctx, predecessor, user.ID)
+	return successor, nil
+}
+
+// getRenewalKeys generates and encrypts a new key pair when rekeying,
+// otherwise it unlocks the stored key pair of the certificate being renewed.
+func (c *CertificateService) getRenewalKeys(ctx context.Context, principal jwt.User, predecessor model.Certificate, req model.CertificateRequest, rekey bool, user model.User) (model.KeyEncoder, model.KeyPair, error) {
+	if rekey {
+		err := c.PasswordService.Allowed(req.Password)
+		if err != nil {
+			return nil, model.KeyPair{}, httputil.BadRequestError(err)
+		}
+
+		keys, err := c.createKeys(ctx, req.KeyRequest())
+		if err != nil {
+			return nil, model.KeyPair{}, err
+		}
+
+		keyPair, err := c.encryptKeys(ctx, keys.Encode(), req.Password, user)
+		if err != nil {
+			return nil, model.KeyPair{}, err
+		}
+
+		return keys, keyPair, nil
+	}
+
+	encryptedKeyPair, found, err := c.findCertificateKeyPair(ctx, principal, predecessor.ID)
+	if err != nil {
+		return nil, model.KeyPair{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("KeyPair does not exist for %s, it can only be renewed with a new key", predecessor)
+		return nil, model.KeyPair{}, httputil.PreconditionRequiredError(err)
+	}
+
+	err = c.PasswordService.Verify(ctx, encryptedKeyPair.Credentials, req.Password)
+	if err != nil {
+		return nil, model.KeyPair{}, err
+	}
+
+	keyPair, err := c.decryptKeys(ctx, encryptedKeyPair, req.Password)
+	if err != nil {
+		return nil, model.KeyPair{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return nil, model.KeyPair{}, err
+	}
+
+	return keys, encryptedKeyPair, nil
+}
+
+// Revoke permanently revokes an issued certificate.
+func (c *CertificateService) Revoke(ctx context.Context, principal jwt.User, req model.RevocationRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_revoke")
+	defer span.Finish()
+
+	cert, err := c.findCertificate(ctx, principal, req.CertificateID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if cert.Revoked() {
+		err = fmt.Errorf("%s has already been revoked", cert)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+
+	cert.Status = model.CertificateRevoked
+	cert.Revocation = &model.Revocation{
+		Reason:         req.Reason,
+		RevokedAt:      timeutil.Now(),
+		InvalidityDate: req.InvalidityDate,
+	}
+
+	err = c.CertRepo.Revoke(ctx, cert)
+	if errors.Is(err, repository.ErrCertificateNotActive) {
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	c.logCertificateRevocation(ctx, cert, principal.ID)
+	c.updateRevocationList(ctx, cert)
+	return cert, nil
+}
+
+// EnableRevocationList enrolls a certificate authority for unattended signing of revocation lists.
+// The owner must unlock the private key with its password so that the server can store it wrapped with its key wrapping key.
+func (c *CertificateService) EnableRevocationList(ctx context.Context, principal jwt.User, req model.CRLSigningRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_enable_revocation_list")
+	defer span.Finish()
+
+	cert, encryptedKeyPair, err := c.findSignatory(ctx, principal, req.CertificateID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	enrolled, err := c.CRLService.Enrolled(ctx, cert.ID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if enrolled {
+		err = fmt.Errorf("%s is already enrolled for revocation lists", cert)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+
+	err = c.PasswordService.Verify(ctx, encryptedKeyPair.Credentials, req.Password)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keyPair, err := c.decryptKeys(ctx, encryptedKeyPair, req.Password)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	err = c.CRLService.Enroll(ctx, cert, keys)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	c.AuditLog.Create(ctx, principal.ID, "certificate:%s:crl-signing-key", cert.ID)
+	return cert, nil
+}
+
+// DisableRevocationList deletes the server held signing key of a certificate authority.
+// Revocation lists that have already been published remain available but are no longer updated.
+func (c *CertificateService) DisableRevocationList(ctx context.Context, principal jwt.User, certificateID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_disable_revocation_list")
+	defer span.Finish()
+
+	cert, err := c.findCertificate(ctx, principal, certificateID)
+	if err != nil {
+		return err
+	}
+
+	err = c.CRLService.Unenroll(ctx, cert.ID)
+	if err != nil {
+		return err
+	}
+
+	c.AuditLog.Delete(ctx, principal.ID, "certificate:%s:crl-signing-key", cert.ID)
+	return nil
+}
+
+// EnableUnattendedIssuance enrolls a certificate authority for unattended issuance of leaf certificates, which is required to enable ACME.
+// The owner must unlock the private key with its password so that the server can store it wrapped with its key wrapping key.
+func (c *CertificateService) EnableUnattendedIssuance(ctx context.Context, principal jwt.User, req model.UnattendedIssuanceRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_enable_unattended_issuance")
+	defer span.Finish()
+
+	if len(c.KeyWrappingKey) == 0 {
+		err := fmt.Errorf("unattended issuance is not enabled, no key wrapping key has been configured")
+		return model.Certificate{}, httputil.PreconditionRequiredError(err)
+	}
+
+	cert, encryptedKeyPair, err := c.findSignatory(ctx, principal, req.CertificateID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	_, enrolled, err := c.IssuingKeyRepo.Find(ctx, cert.ID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if enrolled {
+		err = fmt.Errorf("%s is already enrolled for unattended issuance", cert)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+
+	err = c.PasswordService.Verify(ctx, encryptedKeyPair.Credentials, req.Password)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keyPair, err := c.decryptKeys(ctx, encryptedKeyPair, req.Password)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	ciphertext, salt, err := encryptWithServerKey(c.KeyWrappingKey, keys.Encode().PrivateKey)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	err = c.IssuingKeyRepo.Save(ctx, model.IssuingKey{
+		CertificateID:  cert.ID,
+		PrivateKey:     ciphertext,
+		EncryptionSalt: salt,
+		CreatedBy:      principal.ID,
+		CreatedAt:      timeutil.Now(),
+	})
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	c.AuditLog.Create(ctx, principal.ID, "certificate:%s:issuing-key", cert.ID)
+	return cert, nil
+}
+
+// DisableUnattendedIssuance deletes the server held issuing key of a certificate authority,
+// which stops the issuance of certificates through its ACME directory.
+func (c *CertificateService) DisableUnattendedIssuance(ctx context.Context, principal jwt.User, certificateID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_disable_unattended_issuance")
+	defer span.Finish()
+
+	cert, err := c.findCertificate(ctx, principal, certificateID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := c.IssuingKeyRepo.Delete(ctx, cert.ID)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		err = fmt.Errorf("%s is not enrolled for unattended issuance", cert)
+		return httputil.NotFoundError(err)
+	}
+
+	c.AuditLog.Delete(ctx, principal.ID, "certificate:%s:issuing-key", cert.ID)
+	return nil
+}
+
+// IssueOCSPResponder issues a delegated OCSP responder certificate for a certificate authority.
+// The owner must unlock the private key with its password, only the key of the responder is stored by the server for unattended signing.
+func (c *CertificateService) IssueOCSPResponder(ctx context.Context, principal jwt.User, req model.OCSPResponderRequest) (model.OCSPResponder, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_issue_ocsp_responder")
+	defer span.Finish()
+
+	cert, encryptedKeyPair, err := c.findSignatory(ctx, principal, req.CertificateID)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	err = c.PasswordService.Verify(ctx, encryptedKeyPair.Credentials, req.Password)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	keyPair, err := c.decryptKeys(ctx, encryptedKeyPair, req.Password)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	x509Cert, err := parseCertificate(cert.Body)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	responder, err := c.OCSPService.issueResponder(ctx, cert, issuer{cert: x509Cert, keys: keys})
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	c.AuditLog.Create(ctx, principal.ID, "ocsp-responder:%s", responder.ID)
+	return responder, nil
+}
+
+// WarnExpiring notifies the active admins of an account about certificates that expire within the expiry warning period.
+// A warning is saved before the admins are notified, so each certificate is only warned about once even if several replicas run the job.
+func (c *CertificateService) WarnExpiring(ctx context.Context) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_warn_expiring")
+	defer span.Finish()
+
+	now := timeutil.Now()
+	certs, err := c.CertRepo.FindExpiringWithoutWarning(ctx, now, now.Add(c.ExpiryWarningPeriod))
+	if err != nil {
+		log.Error("failed to find expiring certificates", zap.Error(err))
+		return
+	}
+
+	for _, cert := range certs {
+		err = c.warnExpiring(ctx, cert)
+		if err != nil {
+			log.Error("failed to warn about expiring certificate", zap.String("certificateId", cert.ID), zap.Error(err))
+		}
+	}
+}
+
+func (c *CertificateService) warnExpiring(ctx context.Context, cert model.Certificate) error {
+	saved, err := c.CertRepo.SaveExpiryWarning(ctx, cert, timeutil.Now())
+	if err != nil || !saved {
+		return err
+	}
+
+	account, found, err := c.AccountRepo.Find(ctx, cert.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("account(id=%s) of %s does not exist", cert.AccountID, cert)
+	}
+
+	users, err := c.UserRepo.FindByAccountID(ctx, cert.AccountID)
+	if err != nil {
+		return err
+	}
+
+	data := notification.ExpiryWarningData{
+		AccountName:     account.Name,
+		CertificateName: cert.Name,
+		SerialNumber:    cert.SerialNumber,
+		ExpiresAt:       cert.ExpiresAt,
+	}
+
+	for _, user := range users {
+		if user.Role != model.AdminRole || !user.Active {
+			continue
+		}
+
+		err = c.Notifier.Notify(ctx, notification.ExpiryWarningTemplate, user.Email, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CertificateService) updateRevocationList(ctx context.Context, cert model.Certificate) {
+	if cert.SignatoryID == "" {
+		return
+	}
+
+	enrolled, err := c.CRLService.Enrolled(ctx, cert.SignatoryID)
+	if err != nil || !enrolled {
+		return
+	}
+
+	_, err = c.CRLService.Generate(ctx, cert.SignatoryID)
+	if err != nil {
+		log.Warn("failed to update revocation list after revocation", zap.String("signatoryId", cert.SignatoryID), zap.Error(err))
+	}
+}
+
+// applyProfile resolves the certificate profile selected in a request within the account of the user.
+// The usages of the profile are set on the request and its default validity is used unless the request specifies one.
+func (c *CertificateService) applyProfile(ctx context.Context, req model.CertificateRequest, user model.User) (model.CertificateRequest, model.CertificateProfile, error) {
+	if req.Profile == "" {
+		return req, model.CertificateProfile{}, nil
+	}
+
+	profile, exists, err := c.ProfileRepo.FindByNameAndAccountID(ctx, req.Profile, user.Account.ID)
+	if err != nil {
+		return model.CertificateRequest{}, model.CertificateProfile{}, err
+	}
+
+	if !exists {
+		err = fmt.Errorf("certificate profile %s does not exist in account(id=%s)", req.Profile, user.Account.ID)
+		return model.CertificateRequest{}, model.CertificateProfile{}, httputil.BadRequestError(err)
+	}
+
+	if req.ExpiresInDays <= 0 {
+		req.ExpiresInDays = profile.DefaultValidityDays
+	}
+
+	if profile.MaxValidityDays > 0 && req.ExpiresInDays > profile.MaxValidityDays {
+		err = fmt.Errorf("expiresInDays=%d exceeds the maximum validity of %d days allowed by %s", req.ExpiresInDays, profile.MaxValidityDays, profile)
+		return model.CertificateRequest{}, model.CertificateProfile{}, httputil.BadRequestError(err)
+	}
+
+	if req.Algorithm != "" && !profile.AllowsAlgorithm(req.Algorithm) {
+		err = fmt.Errorf("algorithm %s is not allowed by %s", req.Algorithm, profile)
+		return model.CertificateRequest{}, model.CertificateProfile{}, httputil.BadRequestError(err)
+	}
+
+	err = profile.AssertRequiredNames(req.SubjectAlternativeNames)
+	if err != nil {
+		return model.CertificateRequest{}, model.CertificateProfile{}, httputil.BadRequestError(err)
+	}
+
+	req.KeyUsage = profile.KeyUsage
+	req.ExtKeyUsage = profile.ExtKeyUsage
+	return req, profile, nil
+}
+
+func (c *CertificateService) createCertificate(ctx context.Context, req model.CertificateRequest, keys model.KeyEncoder, user model.User) (model.Certificate, error) {
+	keyPair, err := c.encryptKeys(ctx, keys.Encode(), req.Password, user)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	issuer, err := c.getIssuer(ctx, req, keys, user)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	cert, err := c.issueCertificate(ctx, assembleCertificate(req, keyPair, user), keys.PublicKey(), issuer)
+	if errors.Is(err, repository.ErrDuplicateGeneration) {
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	c.logNewCertificate(ctx, cert, user.ID)
+	return cert, nil
+}
+
+// issueCertificate assigns a random serial number to a certificate, signs and stores it.
+// Serial number collisions are retried with a new serial number, which requires the certificate to be signed again.
+func (c *CertificateService) issueCertificate(ctx context.Context, cert model.Certificate, pub interface{}, signer issuer) (model.Certificate, error) {
+	err := c.assertPermittedNames(ctx, cert)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	cert, err = c.constrainValidity(ctx, cert, signer)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		serialNumber, err := newSerialNumber()
+		if err != nil {
+			return model.Certificate{}, err
+		}
+
+		cert.SerialNumber = encodeSerialNumber(serialNumber)
+		signed, err := signCertificate(cert, pub, signer)
+		if err != nil {
+			return model.Certificate{}, err
+		}
+
+		err = c.CertRepo.Save(ctx, signed)
+		if err == nil {
+			return signed, nil
+		}
+
+		if !errors.Is(err, repository.ErrDuplicateSerialNumber) || attempt == maxSerialNumberAttempts {
+			return model.Certificate{}, err
+		}
+
+		log.Warn("certificate serial number collision, retrying", zap.String("serialNumber", cert.SerialNumber), zap.Int("attempt", attempt))
+	}
+}
+
+// constrainValidity fits the validity of a certificate within the validity of its issuer. Depending on the validity policy
+// of the account the certificate is either clamped to the validity window of the issuer or rejected if it falls outside of it.
+func (c *CertificateService) constrainValidity(ctx context.Context, cert model.Certificate, signer issuer) (model.Certificate, error) {
+	if signer.cert == nil {
+		return cert, nil
+	}
+
+	notBefore := cert.NotBefore
+	if notBefore.IsZero() {
+		notBefore = cert.CreatedAt
+	}
+
+	if !notBefore.Before(signer.cert.NotBefore) && !cert.ExpiresAt.After(signer.cert.NotAfter) {
+		return cert, nil
+	}
+
+	account, exists, err := c.AccountRepo.Find(ctx, cert.AccountID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if !exists {
+		return model.Certificate{}, fmt.Errorf("account(id=%s) of %s does not exist", cert.AccountID, cert)
+	}
+
+	if account.ValidityPolicy == model.ValidityPolicyReject {
+		err = fmt.Errorf(
+			"validity of %s (notBefore=%v, notAfter=%v) is outside of the validity of issuer %s (notBefore=%v, notAfter=%v)",
+			cert, notBefore, cert.ExpiresAt, signer.cert.Subject.CommonName, signer.cert.NotBefore, signer.cert.NotAfter,
+		)
+		return model.Certificate{}, httputil.BadRequestError(err)
+	}
+
+	if notBefore.Before(signer.cert.NotBefore) {
+		cert.NotBefore = signer.cert.NotBefore.UTC()
+	}
+
+	if cert.ExpiresAt.After(signer.cert.NotAfter) {
+		cert.ExpiresAt = signer.cert.NotAfter.UTC()
+	}
+
+	return cert, nil
+}
+
+// assertPermittedNames checks the alternative names of a certificate against the name constraints
+// of every certificate authority in the chain of its signatory.
+func (c *CertificateService) assertPermittedNames(ctx context.Context, cert model.Certificate) error {
+	for signatoryID := cert.SignatoryID; signatoryID != ""; {
+		signatory, found, err := c.CertRepo.Find(ctx, signatoryID)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("signatory(id=%s) in the chain of %s does not exist", signatoryID, cert)
+		}
+
+		x509Cert, err := parseCertificate(signatory.Body)
+		if err != nil {
+			return err
+		}
+
+		err = nameConstraintsOf(x509Cert).Permits(cert.SubjectAlternativeNames)
+		if err != nil {
+			return httputil.BadRequestError(fmt.Errorf("%s does not permit the requested names: %w", signatory, err))
+		}
+
+		signatoryID = signatory.SignatoryID
+	}
+
+	return nil
+}
+
+func (c *CertificateService) createKeys(ctx context.Context, req model.KeyRequest) (model.KeyEncoder, error) {
+	keys, err := generateKeys(req)
+	if err != nil {
+		return nil, httputil.BadRequestError(err)
+	}
+
+	return keys, nil
+}
+
+func (c *CertificateService) getCertificateBody(ctx context.Context, principal jwt.User, id, format string) (model.Attachment, error) {
+	cert, err := c.findCertificate(ctx, principal, id)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+
+	c.logCertificateBodyReading(ctx, cert, principal.ID)
+	if format == model.FormatDER {
+		x509Cert, err := parseCertificate(cert.Body)
+		if err != nil {
+			return model.Attachment{}, httputil.InternalServerError(err)
+		}
+		return model.NewBinaryAttachment(x509Cert.Raw, pkixCertType, attachmentFilename(cert.Name, cert.Type, model.FormatDER)), nil
+	}
+
+	return model.Attachment{
+		Body:        cert.Body,
+		ContentType: textPlainType,
+		Filename:    attachmentFilename(cert.Name, cert.Type, cert.Format),
+	}, err
+}
+
+func (c *CertificateService) getCertificateChainBody(ctx context.Context, principal jwt.User, id string) (model.Attachment, error) {
+	chain, err := c.getCertificateChain(ctx, principal, id)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+
+	if len(chain) < 1 {
+		err = fmt.Errorf("no certificate chain exits for certificate(id=%s)", id)
+		return model.Attachment{}, httputil.BadRequestError(err)
+	}
+
+	body := ""
+	for _, cert := range chain {
+		body += cert.Body
+		c.logCertificateBodyReading(ctx, cert, principal.ID)
+	}
+
+	issuerChain, err := c.ExternalIssuerChain(ctx, chain[len(chain)-1])
+	if err != nil {
+		return model.Attachment{}, err
+	}
+	body += issuerChain
+
+	return model.Attachment{
+		Body:        body,
+		ContentType: textPlainType,
+		Filename:    attachmentFilename(chain[0].Name, "fullchain", chain[0].Format),
+	}, err
+}
+
+func (c *CertificateService) getCertificateChain(ctx context.Context, principal jwt.User, id string) ([]model.Certificate, error) {
+	certificates := make([]model.Certificate, 0)
+	certID := id
+	for certID != "" {
+		cert, err := c.findCertificate(ctx, principal, certID)
+		if err != nil {
+			return nil, err
+		}
+
+		if cert.Type == model.RootCAType {
+			break
+		}
+
+		certificates = append(certificates, cert)
+		certID = cert.SignatoryID
+	}
+
+	return certificates, nil
+}
+
+// pkcs12Bundle assembles a certificate, its private key and the certificate chain up to, but not including, the root.
+func (c *CertificateService) pkcs12Bundle(ctx context.Context, principal jwt.User, cert model.Certificate, keys model.KeyEncoder) (pkcs12util.Bundle, error) {
+	chain, err := c.getCertificateChain(ctx, principal, cert.ID)
+	if err != nil {
+		return pkcs12util.Bundle{}, err
+	}
+
+	last := cert
+	caCerts := make([]*x509.Certificate, 0, len(chain))
+	for _, chainCert := range chain {
+		c.logCertificateBodyReading(ctx, chainCert, principal.ID)
+		last = chainCert
+		if chainCert.ID == cert.ID {
+			continue
+		}
+
+		x509Cert, err := parseCertificate(chainCert.Body)
+		if err != nil {
+			return pkcs12util.Bundle{}, httputil.InternalServerError(err)
+		}
+		caCerts = append(caCerts, x509Cert)
+	}
+
+	issuerChain, err := c.ExternalIssuerChain(ctx, last)
+	if err != nil {
+		return pkcs12util.Bundle{}, err
+	}
+
+	if issuerChain != "" {
+		externalCerts, err := parseCertificates(issuerChain)
+		if err != nil {
+			return pkcs12util.Bundle{}, httputil.InternalServerError(err)
+		}
+		caCerts = append(caCerts, externalCerts...)
+	}
+
+	x509Cert, err := parseCertificate(cert.Body)
+	if err != nil {
+		return pkcs12util.Bundle{}, httputil.InternalServerError(err)
+	}
+
+	return pkcs12util.Bundle{
+		FriendlyName:   cert.Name,
+		PrivateKey:     keys.PrivateKey(),
+		Certificate:    x509Cert,
+		CACertificates: caCerts,
+	}, nil
+}
+
+func (c *CertificateService) getIssuer(ctx context.Context, req model.CertificateRequest, certKeys model.KeyEncoder, user model.User) (issuer, error) {
+	if req.Type == model.RootCAType {
+		return issuer{keys: certKeys}, nil
+	}
+
+	signatory, encryptedKeys, err := c.findSignatory(ctx, user.JWTUser(), req.Signatory.ID)
+	if err != nil {
+		return issuer{}, err
+	}
+
+	keyPair, err := c.decryptKeys(ctx, encryptedKeys, req.Signatory.Password)
+	if err != nil {
+		return issuer{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return issuer{}, err
+	}
+
+	cert, err := parseCertificate(signatory.Body)
+	if err != nil {
+		return issuer{}, err
+	}
+
+	return c.withRevocationURLs(ctx, signatory.ID, issuer{
+		cert: cert,
+		keys: keys,
+	})
+}
+
+// withRevocationURLs adds the revocation list and OCSP responder urls of a signatory to an issuer.
+func (c *CertificateService) withRevocationURLs(ctx context.Context, signatoryID string, signer issuer) (issuer, error) {
+	crlURL, err := c.CRLService.DistributionPoint(ctx, signatoryID)
+	if err != nil {
+		return issuer{}, err
+	}
+
+	ocspURL, err := c.OCSPService.ResponderURL(ctx, signatoryID)
+	if err != nil {
+		return issuer{}, err
+	}
+
+	signer.crlURL = crlURL
+	signer.ocspURL = ocspURL
+	return signer, nil
+}
+
+// findUnattendedIssuer finds a certificate authority enrolled for unattended issuance and unwraps its server held issuing key.
+func (c *CertificateService) findUnattendedIssuer(ctx context.Context, certificateID string) (model.Certificate, issuer, error) {
+	ca, found, err := c.CertRepo.Find(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("certificate with id %s does not exist", certificateID)
+		return model.Certificate{}, issuer{}, httputil.NotFoundError(err)
+	}
+
+	if ca.Type != model.RootCAType && ca.Type != model.IntermediateCAType {
+		err = fmt.Errorf("invalid signing certificate: %s", ca)
+		return model.Certificate{}, issuer{}, httputil.BadRequestError(err)
+	}
+
+	issuingKey, found, err := c.IssuingKeyRepo.Find(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("%s is not enrolled for unattended issuance", ca)
+		return model.Certificate{}, issuer{}, httputil.PreconditionRequiredError(err)
+	}
+
+	keyPair, found, err := c.KeyPairRepo.FindByCertificateID(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("KeyPair does not exist for certificate with id = %s", certificateID)
+		return model.Certificate{}, issuer{}, httputil.PreconditionRequiredError(err)
+	}
+
+	keyPair.PrivateKey, err = decryptWithServerKey(c.KeyWrappingKey, issuingKey.PrivateKey, issuingKey.EncryptionSalt)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	cert, err := parseCertificate(ca.Body)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	return ca, issuer{
+		cert: cert,
+		keys: keys,
+	}, nil
+}
+
+func (c *CertificateService) findSignatory(ctx context.Context, principal jwt.User, certificateID string) (model.Certificate, model.KeyPair, error) {
+	cert, found, err := c.CertRepo.Find(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, model.KeyPair{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("certificate with id %s does not exist", certificateID)
+		return model.Certificate{}, model.KeyPair{}, httputil.PreconditionRequiredError(err)
+	}
+
+	err = c.AuthService.AssertAccountAccess(ctx, principal, cert.AccountID)
+	if err != nil {
+		return model.Certificate{}, model.KeyPair{}, err
+	}
+
+	if cert.Type != model.RootCAType && cert.Type != model.IntermediateCAType {
+		err := fmt.Errorf("invalid signing certificate: %s", cert)
+		return model.Certificate{}, model.KeyPair{}, httputil.BadRequestError(err)
+	}
+
+	if cert.Revoked() {
+		err := fmt.Errorf("revoked certificate cannot be used as signatory: %s", cert)
+		return model.Certificate{}, model.KeyPair{}, httputil.BadRequestError(err)
+	}
+
+	keyPair, found, err := c.findCertificateKeyPair(ctx, principal, certificateID)
+	if err != nil {
+		return model.Certificate{}, model.KeyPair{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("KeyPair does not exist for certificate with id = %s", certificateID)
+		return model.Certificate{}, model.KeyPair{}, httputil.PreconditionRequiredError(err)
+	}
+
+	return cert, keyPair, nil
+}
+
+func (c *CertificateService) encryptKeys(ctx context.Context, keyPair model.KeyPair, pwd string, user model.User) (model.KeyPair, error) {
+	creds, err := c.PasswordService.Hash(ctx, pwd)
+	if err != nil {
+		return model.KeyPair{}, err
+	}
+
+	salt, err := c.PasswordService.GenerateSalt()
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("salt generation failed: %w", err)
+	}
+
+	encryptionKey, err := crypto.Hmac([]byte(pwd), salt)
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	ciphertext, err := crypto.NewAESCipher(encryptionKey).Encrypt([]byte(keyPair.PrivateKey))
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	keyPair.Credentials = creds
+	keyPair.EncryptionSalt = base64.StdEncoding.EncodeToString(salt)
+	keyPair.PrivateKey = base64.StdEncoding.EncodeToString(ciphertext)
+	keyPair.AccountID = user.Account.ID
+
+	return keyPair, nil
+}
+
+func (c *CertificateService) decryptKeys(ctx context.Context, keyPair model.KeyPair, pwd string) (model.KeyPair, error) {
+	salt, err := base64.StdEncoding.DecodeString(keyPair.EncryptionSalt)
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(keyPair.PrivateKey)
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("failed to decode encrypted private key: %w", err)
+	}
+
+	encryptionKey, err := crypto.Hmac([]byte(pwd), salt)
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	plaintext, err := crypto.NewAESCipher(encryptionKey).Decrypt(ciphertext)
+	if err != nil {
+		return model.KeyPair{}, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+
+	keyPair.PrivateKey = string(plaintext)
+	return keyPair, nil
+}
+
+// assertNameAvailable checks that neither a certificate nor a pending certificate uses a name within an account.
+func (c *CertificateService) assertNameAvailable(ctx context.Context, name, accountID string) error {
+	_, exists, err := c.PendingRepo.FindByNameAndAccountID(ctx, name, accountID)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		_, exists, err = c.CertRepo.FindByNameAndAccountID(ctx, name, accountID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if exists {
+		err = fmt.Errorf("certificate with name=%s already exists in account(id=%s)", name, accountID)
+		return httputil.ConflictError(err)
+	}
+
+	return nil
+}
+
+// assertExportPassword checks that a password protecting exported private keys is allowed and differs from the password the key is stored with.
+func (c *CertificateService) assertExportPassword(password, exportPassword string) error {
+	if exportPassword == password {
+		err := fmt.Errorf("export password must differ from the private key password")
+		return httputil.BadRequestError(err)
+	}
+
+	err := c.PasswordService.Allowed(exportPassword)
+	if err != nil {
+		return httputil.BadRequestError(fmt.Errorf("invalid export password: %w", err))
+	}
+
+	return nil
+}
+
+func (c *CertificateService) findCertificate(ctx context.Context, principal jwt.User, id string) (model.Certificate, error) {
+	cert, found, err := c.CertRepo.Find(ctx, id)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("certificate with id %s does not exist", id)
+		return model.Certificate{}, httputil.NotFoundError(err)
+	}
+
+	err = c.AuthService.AssertAccountAccess(ctx, principal, cert.AccountID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	return cert, nil
+}
+
+func (c *CertificateService) findCertificateKeyPair(ctx context.Context, principal jwt.User, id string) (model.KeyPair, bool, error) {
+	keyPair, found, err := c.KeyPairRepo.FindByCertificateID(ctx, id)
+	if err != nil || !found {
+		return model.KeyPair{}, found, err
+	}
+
+	err = c.AuthService.AssertAccountAccess(ctx, principal, keyPair.AccountID)
+	if err != nil {
+		return model.KeyPair{}, true, err
+	}
+
+	return keyPair, true, nil
+}
+
+func (c *CertificateService) findUser(ctx context.Context, userID string) (model.User, error) {
+	user, exists, err := c.UserRepo.Find(ctx, userID)
+	if err != nil {
+		return model.User{}, httputil.InternalServerError(err)
+	}
+
+	if !exists {
+		err := fmt.Errorf("unable to find User(id=%s) even though an authenticated user id was provided", userID)
+		return model.User{}, httputil.UnauthorizedError(err)
+	}
+
+	if !user.Active {
+		err := fmt.Errorf("%s has been deactivated", user)
+		return model.User{}, httputil.UnauthorizedError(err)
+	}
+
+	return user, nil
+}
+
+func (c *CertificateService) logNewCertificate(ctx context.Context, cert model.Certificate, userID string) {
+	c.AuditLog.Create(ctx, userID, "certificate:%s", cert.ID)
+	if cert.KeyPair.ID != "" {
+		c.AuditLog.Create(ctx, userID, "key-pair:%s", cert.KeyPair.ID)
+	}
+}
+
+func (c *CertificateService) logCertificateReading(ctx context.Context, cert model.Certificate, userID string) {
+	c.AuditLog.Read(ctx, userID, "certificate:%s", cert.ID)
+}
+
+func (c *CertificateService) logCertificatesReading(ctx context.Context, certs []model.Certificate, userID string) {
+	for _, cert := range certs {
+		c.logCertificateReading(ctx, cert, userID)
+	}
+}
+
+func (c *CertificateService) logCertificateBodyReading(ctx context.Context, cert model.Certificate, userID string) {
+	c.AuditLog.Read(ctx, userID, "certificate:%s:body", cert.ID)
+}
+
+func (c *CertificateService) logCertificateRenewal(ctx context.Context, cert model.Certificate, userID string) {
+	c.AuditLog.Update(ctx, userID, "certificate:%s:renewal", cert.ID)
+}
+
+func (c *CertificateService) logCertificateRevocation(ctx context.Context, cert model.Certificate, userID string) {
+	c.AuditLog.Update(ctx, userID, "certificate:%s:revocation", cert.ID)
+}
+
+func (c *CertificateService) logPrivateKeyReading(ctx context.Context, keyPair model.KeyPair, userID string) {
+	c.AuditLog.Read(ctx, userID, "key-pair:%s:private-key", keyPair.ID)
+}
+
+// issuer certificate, signing keys, revocation list and OCSP responder urls of the issuer of a certificate,
+// cert is nil for self-signed certificates and the urls are empty if the issuer does not publish revocation status.
+type issuer struct {
+	cert    *x509.Certificate
+	keys    model.KeyEncoder
+	crlURL  string
+	ocspURL string
+}
+
+func signCertificate(cert model.Certificate, pub interface{}, signer issuer) (model.Certificate, error) {
+	template, err := x509Template(cert)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	template.SubjectKeyId, err = subjectKeyID(pub)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	parent := template
+	template.AuthorityKeyId = template.SubjectKeyId
+	if signer.cert != nil {
+		parent = signer.cert
+		template.Issuer = signer.cert.Subject
+		template.AuthorityKeyId = signer.cert.SubjectKeyId
+	}
+
+	if template.IsCA && signer.cert != nil {
+		err = constrainPathLen(template, signer.cert)
+		if err != nil {
+			return model.Certificate{}, err
+		}
+	}
+
+	if signer.crlURL != "" {
+		template.CRLDistributionPoints = []string{signer.crlURL}
+	}
+
+	if signer.ocspURL != "" {
+		template.OCSPServer = []string{signer.ocspURL}
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer.keys.PrivateKey())
+	if err != nil {
+		return model.Certificate{}, fmt.Errorf("failed to create x509 certificate: %w", err)
+	}
+
+	x509Cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return model.Certificate{}, fmt.Errorf("failed to parse x509 certificate: %w", err)
+	}
+
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: b}
+	cert.Body = string(pem.EncodeToMemory(block))
+	cert.IssuerID = issuerID(cert, x509Cert)
+	return cert, nil
+}
+
+// constrainPathLen enforces the path length constraint of an issuer on a certificate authority it issues.
+// A CA certificate without its own constraint gets the longest path length the issuer allows.
+func constrainPathLen(template, parent *x509.Certificate) error {
+	if !parent.BasicConstraintsValid || (parent.MaxPathLen <= 0 && !parent.MaxPathLenZero) {
+		return nil
+	}
+
+	if parent.MaxPathLen == 0 {
+		err := fmt.Errorf("issuer %s does not allow certificate authorities to be issued (pathLenConstraint=0)", parent.Subject.CommonName)
+		return httputil.BadRequestError(err)
+	}
+
+	if template.MaxPathLen < 0 {
+		template.MaxPathLen = parent.MaxPathLen - 1
+		template.MaxPathLenZero = template.MaxPathLen == 0
+		return nil
+	}
+
+	if template.MaxPathLen >= parent.MaxPathLen {
+		err := fmt.Errorf("maxPathLen must be less than %d, the pathLenConstraint of issuer %s", parent.MaxPathLen, parent.Subject.CommonName)
+		return httputil.BadRequestError(err)
+	}
+
+	return nil
+}
+
+// subjectKeyID computes a key identifier as the SHA-1 hash of the subject public key (RFC 5280 section 4.2.1.2).
+func subjectKeyID(pub interface{}) ([]byte, error) {
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(b, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	sum := sha1.Sum(spki.PublicKey.RightAlign())
+	return sum[:], nil
+}
+
+func assembleCertificate(req model.CertificateRequest, keyPair model.KeyPair, user model.User) model.Certificate {
+	now := timeutil.Now()
+
+	return model.Certificate{
+		ID:                      id.New(),
+		Name:                    req.Name,
+		Subject:                 req.Subject,
+		SubjectAlternativeNames: req.SubjectAlternativeNames,
+		KeyPair:                 keyPair,
+		Format:                  keyPair.Format,
+		Type:                    req.Type,
+		SignatoryID:             req.Signatory.ID,
+		AccountID:               user.Account.ID,
+		Status:                  model.CertificateActive,
+		MaxPathLen:              req.MaxPathLen,
+		NameConstraints:         req.NameConstraints,
+		KeyUsage:                req.KeyUsage,
+		ExtKeyUsage:             req.ExtKeyUsage,
+		NotBefore:               notBefore(req.NotBefore),
+		CreatedAt:               now,
+		ExpiresAt:               now.AddDate(0, 0, req.ExpiresInDays),
+	}
+}
+
+func notBefore(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.UTC()
+}
+
+func x509Template(cert model.Certificate) (*x509.Certificate, error) {
+	serialNumber, err := parseSerialNumber(cert.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkixName(cert.Subject),
+		NotBefore:    cert.CreatedAt,
+		NotAfter:     cert.ExpiresAt,
+	}
+
+	if !cert.NotBefore.IsZero() {
+		c.NotBefore = cert.NotBefore
+	}
+
+	err = addAlternativeNames(c, cert.SubjectAlternativeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cert.Type {
+	case model.RootCAType:
+		c.IsCA = true
+		c.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		c.BasicConstraintsValid = true
+		setMaxPathLen(c, cert.MaxPathLen)
+	case model.IntermediateCAType:
+		c.IsCA = true
+		c.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		c.BasicConstraintsValid = true
+		setMaxPathLen(c, cert.MaxPathLen)
+		err = setNameConstraints(c, cert.NameConstraints)
+		if err != nil {
+			return nil, err
+		}
+	case model.UserCertificateType:
+		c.IsCA = false
+		err = setKeyUsages(c, cert.KeyUsage, cert.ExtKeyUsage)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err := fmt.Errorf("unsupported certificate type: %s", cert.Type)
+		return nil, httputil.BadRequestError(err)
+	}
+
+	return c, nil
 }
 
-func (c *CertificateService) logNewCertificate(ctx context.Context, cert model.Certificate, userID string) {
-	c.AuditLog.Create(ctx, userID, "certificate:%s", cert.ID)
-	c.AuditLog.Create(ctx, userID, "key-pair:%s", cert.KeyPair.ID)
+// setKeyUsages sets the key usages of a user certificate, digital signatures for
+// client and server authentication are allowed unless the usages are given by a certificate profile.
+func setKeyUsages(c *x509.Certificate, keyUsages, extKeyUsages []string) error {
+	if len(keyUsages) == 0 {
+		c.KeyUsage = x509.KeyUsageDigitalSignature
+		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+		return nil
+	}
+
+	for _, name := range keyUsages {
+		usage, ok := x509KeyUsages[name]
+		if !ok {
+			return fmt.Errorf("unsupported key usage: %s", name)
+		}
+		c.KeyUsage |= usage
+	}
+
+	c.ExtKeyUsage = make([]x509.ExtKeyUsage, 0, len(extKeyUsages))
+	for _, name := range extKeyUsages {
+		usage, ok := x509ExtKeyUsages[name]
+		if !ok {
+			return fmt.Errorf("unsupported extended key usage: %s", name)
+		}
+		c.ExtKeyUsage = append(c.ExtKeyUsage, usage)
+	}
+
+	return nil
 }
 
-func (c *CertificateService) logCertificateReading(ctx context.Context, cert model.Certificate, userID string) {
-	c.AuditLog.Read(ctx, userID, "certificate:%s", cert.ID)
+// keyUsagesOf returns the names of the key usages and extended key usages of a certificate.
+func keyUsagesOf(cert *x509.Certificate) ([]string, []string) {
+	keyUsages := make([]string, 0)
+	for _, name := range model.KeyUsages {
+		if cert.KeyUsage&x509KeyUsages[name] != 0 {
+			keyUsages = append(keyUsages, name)
+		}
+	}
+
+	extKeyUsages := make([]string, 0, len(cert.ExtKeyUsage))
+	for _, name := range model.ExtKeyUsages {
+		for _, usage := range cert.ExtKeyUsage {
+			if usage == x509ExtKeyUsages[name] {
+				extKeyUsages = append(extKeyUsages, name)
+			}
+		}
+	}
+
+	return keyUsages, extKeyUsages
 }
 
-func (c *CertificateService) logCertificatesReading(ctx context.Context, certs []model.Certificate, userID string) {
-	for _, cert := range certs {
-		c.logCertificateReading(ctx, cert, userID)
+// checkProfileKey checks that the algorithm and size of a public key are allowed by a certificate profile.
+func checkProfileKey(profile model.CertificateProfile, pub interface{}) error {
+	if profile.ID == "" {
+		return nil
+	}
+
+	keyReq, err := newKeyRequestForPublicKey(pub)
+	if err != nil {
+		return httputil.BadRequestError(err)
+	}
+
+	if !profile.AllowsAlgorithm(keyReq.Algorithm) {
+		err = fmt.Errorf("algorithm %s is not allowed by %s", keyReq.Algorithm, profile)
+		return httputil.BadRequestError(err)
+	}
+
+	bits := publicKeySize(pub)
+	if !profile.AllowsKeySize(bits) {
+		err = fmt.Errorf("%s key size %d is not allowed by %s", keyReq.Algorithm, bits, profile)
+		return httputil.BadRequestError(err)
 	}
+
+	return nil
 }
 
-func (c *CertificateService) logCertificateBodyReading(ctx context.Context, cert model.Certificate, userID string) {
-	c.AuditLog.Read(ctx, userID, "certificate:%s:body", cert.ID)
+// publicKeySize returns the size of a public key in bits, for ECDSA keys this is the size of the curve.
+func publicKeySize(pub interface{}) int {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
 }
 
-func (c *CertificateService) logPrivateKeyReading(ctx context.Context, keyPair model.KeyPair, userID string) {
-	c.AuditLog.Read(ctx, userID, "key-pair:%s:private-key", keyPair.ID)
+// setMaxPathLen sets the pathLenConstraint of a CA certificate, a nil maxPathLen leaves the path length unconstrained.
+func setMaxPathLen(c *x509.Certificate, maxPathLen *int) {
+	if maxPathLen == nil {
+		c.MaxPathLen = -1
+		return
+	}
+
+	c.MaxPathLen = *maxPathLen
+	c.MaxPathLenZero = *maxPathLen == 0
 }
 
-func signCertificate(cert model.Certificate, pub, priv interface{}) (model.Certificate, error) {
-	template, err := x509Template(cert)
+// setNameConstraints encodes name constraints in a CA certificate, the extension is marked critical as required by RFC 5280.
+func setNameConstraints(c *x509.Certificate, constraints model.NameConstraints) error {
+	if constraints.Empty() {
+		return nil
+	}
+
+	permittedIPRanges, err := parseIPRanges(constraints.Permitted.IPRanges)
 	if err != nil {
-		return model.Certificate{}, err
+		return err
 	}
 
-	b, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	excludedIPRanges, err := parseIPRanges(constraints.Excluded.IPRanges)
 	if err != nil {
-		return model.Certificate{}, fmt.Errorf("failed to create x509 certificate: %w", err)
+		return err
 	}
 
-	block := &pem.Block{Type: "CERTIFICATE", Bytes: b}
-	cert.Body = string(pem.EncodeToMemory(block))
-	return cert, nil
+	c.PermittedDNSDomainsCritical = true
+	c.PermittedDNSDomains = constraints.Permitted.DNSDomains
+	c.ExcludedDNSDomains = constraints.Excluded.DNSDomains
+	c.PermittedIPRanges = permittedIPRanges
+	c.ExcludedIPRanges = excludedIPRanges
+	c.PermittedEmailAddresses = constraints.Permitted.EmailAddresses
+	c.ExcludedEmailAddresses = constraints.Excluded.EmailAddresses
+	c.PermittedURIDomains = constraints.Permitted.URIDomains
+	c.ExcludedURIDomains = constraints.Excluded.URIDomains
+	return nil
 }
 
-func assembleCertificate(req model.CertificateRequest, keyPair model.KeyPair, user model.User) model.Certificate {
-	now := timeutil.Now()
+// nameConstraintsOf returns the name constraints encoded in a certificate.
+func nameConstraintsOf(cert *x509.Certificate) model.NameConstraints {
+	return model.NameConstraints{
+		Permitted: model.NameSubtrees{
+			DNSDomains:     cert.PermittedDNSDomains,
+			EmailAddresses: cert.PermittedEmailAddresses,
+			IPRanges:       formatIPRanges(cert.PermittedIPRanges),
+			URIDomains:     cert.PermittedURIDomains,
+		},
+		Excluded: model.NameSubtrees{
+			DNSDomains:     cert.ExcludedDNSDomains,
+			EmailAddresses: cert.ExcludedEmailAddresses,
+			IPRanges:       formatIPRanges(cert.ExcludedIPRanges),
+			URIDomains:     cert.ExcludedURIDomains,
+		},
+	}
+}
 
-	return model.Certificate{
-		ID:           id.New(),
-		Name:         req.Name,
-		SerialNumber: randomSerialNumber(),
-		Subject:      req.Subject,
-		KeyPair:      keyPair,
-		Format:       keyPair.Format,
-		Type:         req.Type,
-		SignatoryID:  req.Signatory.ID,
-		AccountID:    user.Account.ID,
-		CreatedAt:    now,
-		ExpiresAt:    now.AddDate(0, 0, req.ExpiresInDays),
+func parseIPRanges(ipRanges []string) ([]*net.IPNet, error) {
+	parsed := make([]*net.IPNet, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return nil, httputil.BadRequestError(fmt.Errorf("invalid ip range: %s", ipRange))
+		}
+		parsed = append(parsed, ipNet)
 	}
+
+	return parsed, nil
 }
 
-func x509Template(cert model.Certificate) (*x509.Certificate, error) {
-	c := &x509.Certificate{
-		SerialNumber: big.NewInt(cert.SerialNumber),
-		Subject: pkix.Name{
-			CommonName:         cert.Subject.CommonName,
-			Country:            []string{cert.Subject.Country},
-			Province:           []string{cert.Subject.State},
-			Locality:           []string{cert.Subject.Locality},
-			Organization:       []string{cert.Subject.Organization},
-			OrganizationalUnit: []string{cert.Subject.OrganizationalUnit},
+func formatIPRanges(ipRanges []*net.IPNet) []string {
+	formatted := make([]string, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		formatted = append(formatted, ipRange.String())
+	}
+
+	return formatted
+}
+
+func addAlternativeNames(c *x509.Certificate, names model.SubjectAlternativeNames) error {
+	c.DNSNames = names.DNSNames
+	c.EmailAddresses = names.EmailAddresses
+
+	for _, addr := range names.IPAddresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			err := fmt.Errorf("invalid ip address: %s", addr)
+			return httputil.BadRequestError(err)
+		}
+		c.IPAddresses = append(c.IPAddresses, ip)
+	}
+
+	for _, uri := range names.URIs {
+		u, err := url.Parse(uri)
+		if err != nil {
+			err = fmt.Errorf("invalid uri: %s. %w", uri, err)
+			return httputil.BadRequestError(err)
+		}
+		c.URIs = append(c.URIs, u)
+	}
+
+	return nil
+}
+
+func generateKeys(req model.KeyRequest) (model.KeyEncoder, error) {
+	switch req.Algorithm {
+	case rsautil.Algorithm:
+		return rsautil.GenerateKeys(req)
+	case ecdsautil.Algorithm:
+		return ecdsautil.GenerateKeys(req)
+	case ed25519util.Algorithm:
+		return ed25519util.GenerateKeys(req)
+	default:
+		return nil, fmt.Errorf("CertificateService: unsupported KeyRequest.Algorithm=%s", req.Algorithm)
+	}
+}
+
+func decodeKeys(keyPair model.KeyPair) (model.KeyEncoder, error) {
+	switch keyPair.Algorithm {
+	case rsautil.Algorithm:
+		return rsautil.Decode(keyPair)
+	case ecdsautil.Algorithm:
+		return ecdsautil.Decode(keyPair)
+	case ed25519util.Algorithm:
+		return ed25519util.Decode(keyPair)
+	default:
+		return nil, fmt.Errorf("CertificateService: unsupported KeyPair.Algorithm=%s", keyPair.Algorithm)
+	}
+}
+
+func parseCertificateRequest(body string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(body))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode certificate request pem block")
+	}
+
+	if block.Type != csrBlockType && block.Type != "NEW "+csrBlockType {
+		return nil, fmt.Errorf("expected blocktype '%s' but got '%s'", csrBlockType, block.Type)
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+
+	return csr, nil
+}
+
+func newCertificateRequest(req model.SigningRequest, csr *x509.CertificateRequest) (model.CertificateRequest, error) {
+	names, err := alternativeNames(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+	if err != nil {
+		return model.CertificateRequest{}, err
+	}
+
+	return model.CertificateRequest{
+		Name:                    req.Name,
+		Subject:                 subjectOf(csr.Subject),
+		SubjectAlternativeNames: names,
+		Type:                    req.Type,
+		Signatory:               req.Signatory,
+		ExpiresInDays:           req.ExpiresInDays,
+		MaxPathLen:              req.MaxPathLen,
+		NameConstraints:         req.NameConstraints,
+		Profile:                 req.Profile,
+		NotBefore:               req.NotBefore,
+		UserID:                  req.UserID,
+	}, nil
+}
+
+// alternativeNames creates normalized subject alternative names from the names of a certificate or certificate request.
+func alternativeNames(dnsNames []string, ipAddresses []net.IP, emailAddresses []string, uris []*url.URL) (model.SubjectAlternativeNames, error) {
+	ips := make([]string, 0, len(ipAddresses))
+	for _, ip := range ipAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	uriStrings := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		uriStrings = append(uriStrings, uri.String())
+	}
+
+	return model.SubjectAlternativeNames{
+		DNSNames:       dnsNames,
+		IPAddresses:    ips,
+		EmailAddresses: emailAddresses,
+		URIs:           uriStrings,
+	}.Normalize()
+}
+
+func subjectOf(name pkix.Name) model.CertificateSubject {
+	return model.CertificateSubject{
+		CommonName:         name.CommonName,
+		Country:            firstOrEmpty(name.Country),
+		State:              firstOrEmpty(name.Province),
+		Locality:           firstOrEmpty(name.Locality),
+		Organization:       firstOrEmpty(name.Organization),
+		OrganizationalUnit: firstOrEmpty(name.OrganizationalUnit),
+	}
+}
+
+func pkixName(subject model.CertificateSubject) pkix.Name {
+	return pkix.Name{
+		CommonName:         subject.CommonName,
+		Country:            []string{subject.Country},
+		Province:           []string{subject.State},
+		Locality:           []string{subject.Locality},
+		Organization:       []string{subject.Organization},
+		OrganizationalUnit: []string{subject.OrganizationalUnit},
+	}
+}
+
+// newRenewalCertificateRequest creates a request for the successor of a certificate,
+// the subject is taken from the issued certificate since it is not stored in a structured form.
+func newRenewalCertificateRequest(predecessor model.Certificate, req model.RenewalRequest) (model.CertificateRequest, error) {
+	cert, err := parseCertificate(predecessor.Body)
+	if err != nil {
+		return model.CertificateRequest{}, err
+	}
+
+	keyReq := model.KeyRequest{
+		Algorithm: req.Algorithm,
+		Options:   req.Options,
+	}
+	if req.Rekey && keyReq.Algorithm == "" {
+		keyReq, err = newKeyRequestForPublicKey(cert.PublicKey)
+		if err != nil {
+			return model.CertificateRequest{}, err
+		}
+	}
+
+	expiresInDays := req.ExpiresInDays
+	if expiresInDays == 0 {
+		expiresInDays = int(predecessor.ExpiresAt.Sub(predecessor.CreatedAt).Hours() / 24)
+	}
+
+	certReq := model.CertificateRequest{
+		Name:                    predecessor.Name,
+		Subject:                 subjectOf(cert.Subject),
+		SubjectAlternativeNames: predecessor.SubjectAlternativeNames,
+		Type:                    predecessor.Type,
+		Algorithm:               keyReq.Algorithm,
+		Options:                 keyReq.Options,
+		Signatory: model.Signatory{
+			ID:       predecessor.SignatoryID,
+			Password: req.Signatory.Password,
 		},
-		NotBefore: cert.CreatedAt,
-		NotAfter:  cert.ExpiresAt,
+		Password:        req.Password,
+		ExpiresInDays:   expiresInDays,
+		MaxPathLen:      pathLenConstraint(cert),
+		NameConstraints: nameConstraintsOf(cert),
+		UserID:          req.UserID,
 	}
 
-	switch cert.Type {
-	case model.RootCAType:
-	case model.IntermediateCAType:
-		c.IsCA = true
-		c.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign
-		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
-		c.BasicConstraintsValid = true
-	case model.UserCertificateType:
-		c.IsCA = false
-		c.KeyUsage = x509.KeyUsageDigitalSignature
-		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	if certReq.Type == model.UserCertificateType {
+		certReq.KeyUsage, certReq.ExtKeyUsage = keyUsagesOf(cert)
+	}
+
+	err = certReq.Validate()
+	if err != nil {
+		return model.CertificateRequest{}, httputil.BadRequestError(err)
+	}
+
+	return certReq, nil
+}
+
+// pathLenConstraint returns the path length constraint of a CA certificate or nil if it is unconstrained.
+func pathLenConstraint(cert *x509.Certificate) *int {
+	if !cert.IsCA || (cert.MaxPathLen <= 0 && !cert.MaxPathLenZero) {
+		return nil
+	}
+
+	maxPathLen := cert.MaxPathLen
+	return &maxPathLen
+}
+
+// newKeyRequestForPublicKey creates a request for a key with the same algorithm and parameters as a public key.
+func newKeyRequestForPublicKey(pub interface{}) (model.KeyRequest, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return model.KeyRequest{
+			Algorithm: rsautil.Algorithm,
+			Options:   map[string]interface{}{"keySize": key.N.BitLen()},
+		}, nil
+	case *ecdsa.PublicKey:
+		return model.KeyRequest{
+			Algorithm: ecdsautil.Algorithm,
+			Options:   map[string]interface{}{"curve": key.Params().Name},
+		}, nil
+	case ed25519.PublicKey:
+		return model.KeyRequest{
+			Algorithm: ed25519util.Algorithm,
+			Options:   map[string]interface{}{},
+		}, nil
 	default:
-		err := fmt.Errorf("unsupported certificate type: %s", cert.Type)
-		return nil, httputil.BadRequestError(err)
+		return model.KeyRequest{}, fmt.Errorf("unsupported public key type: %T", pub)
 	}
+}
 
-	return c, nil
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func parseCertificate(body string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(body))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode certificate pem block")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse x509 certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// decodeImport decodes the certificate and private key of an import request and checks that they belong together.
+// A PKCS#12 bundle may contain the chain of the certificate, in which case the certificate matching the key is imported.
+func decodeImport(req model.ImportRequest) (*x509.Certificate, model.KeyEncoder, error) {
+	var certs []*x509.Certificate
+	var key interface{}
+	var err error
+	if req.Format == model.ImportFormatPKCS12 {
+		certs, key, err = decodePKCS12(req.Bundle, req.BundlePassword)
+	} else {
+		certs, err = parseCertificates(req.Certificate)
+		if err == nil {
+			key, err = parsePrivateKey(req.PrivateKey)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := privateKeyEncoder(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, cert := range certs {
+		if assertSamePublicKey(cert.PublicKey, keys.PublicKey()) == nil {
+			return cert, keys, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("private key does not match the certificate")
+}
+
+// decodePKCS12 decodes the certificates and private key of a base64 encoded PKCS#12 bundle.
+func decodePKCS12(bundle, password string) ([]*x509.Certificate, interface{}, error) {
+	b, err := base64.StdEncoding.DecodeString(bundle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode bundle: %w", err)
+	}
+
+	blocks, err := pkcs12.ToPEM(b, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode pkcs12 bundle: %w", err)
+	}
+
+	certs := make([]*x509.Certificate, 0, len(blocks))
+	var key interface{}
+	for _, block := range blocks {
+		if block.Type == certificateBlockType {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse x509 certificate: %w", err)
+			}
+			certs = append(certs, cert)
+			continue
+		}
+
+		// Keys are converted to PKCS#1 or SEC1 by pkcs12.ToPEM regardless of the block type.
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse private key of pkcs12 bundle: %w", err)
+		}
+	}
+
+	if key == nil {
+		return nil, nil, fmt.Errorf("pkcs12 bundle does not contain a private key")
+	}
+
+	return certs, key, nil
+}
+
+// parsePrivateKey parses an unencrypted PKCS#1, SEC1 or PKCS#8 private key.
+func parsePrivateKey(body string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(body))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key pem block")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case privateKeyBlockType:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key blocktype '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return key, nil
+}
+
+func privateKeyEncoder(key interface{}) (model.KeyEncoder, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return rsautil.FromPrivateKey(k), nil
+	case *ecdsa.PrivateKey:
+		return ecdsautil.FromPrivateKey(k)
+	case ed25519.PrivateKey:
+		return ed25519util.FromPrivateKey(k), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// issuerID identifies the issuer that a serial number must be unique for. Within webca the issuer is the signatory,
+// a self-signed certificate is its own issuer and the issuer of any other certificate is identified by the hash of its issuer name.
+func issuerID(cert model.Certificate, x509Cert *x509.Certificate) string {
+	if cert.SignatoryID != "" {
+		return cert.SignatoryID
+	}
+
+	if selfSigned(x509Cert) {
+		return cert.ID
+	}
+
+	hash := sha256.Sum256(x509Cert.RawIssuer)
+	return hex.EncodeToString(hash[:])
+}
+
+// encodePrivateKey encodes a decrypted private key in a download format. PEM keys are returned as stored, the other formats are PKCS#8.
+func encodePrivateKey(name string, keyPair model.KeyPair, format, exportPassword string) (model.Attachment, error) {
+	if format == model.FormatPEM {
+		return model.Attachment{
+			Body:        keyPair.PrivateKey,
+			ContentType: textPlainType,
+			Filename:    attachmentFilename(name, "private-key", keyPair.Format),
+		}, nil
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+
+	if format == model.FormatEncryptedPKCS8 {
+		der, err := pkcs12util.EncryptPrivateKey(keys.PrivateKey(), exportPassword)
+		if err != nil {
+			return model.Attachment{}, err
+		}
+
+		return model.Attachment{
+			Body:        string(pem.EncodeToMemory(&pem.Block{Type: encryptedPrivateKeyBlockType, Bytes: der})),
+			ContentType: textPlainType,
+			Filename:    attachmentFilename(name, "encrypted-private-key", pkcs8Extension),
+		}, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(keys.PrivateKey())
+	if err != nil {
+		return model.Attachment{}, fmt.Errorf("failed to marshal private key as PKCS#8: %w", err)
+	}
+
+	if format == model.FormatDER {
+		return model.NewBinaryAttachment(der, pkcs8Type, attachmentFilename(name, "private-key", model.FormatDER)), nil
+	}
+
+	return model.Attachment{
+		Body:        string(pem.EncodeToMemory(&pem.Block{Type: privateKeyBlockType, Bytes: der})),
+		ContentType: textPlainType,
+		Filename:    attachmentFilename(name, "private-key", pkcs8Extension),
+	}, nil
 }
 
 func attachmentFilename(name, category, format string) string {
@@ -541,11 +2400,33 @@ func attachmentFilename(name, category, format string) string {
 	return filename
 }
 
-func randomSerialNumber() int64 {
-	number := mathrand.Int63n(maxSerialNumber-1) + 1
-	if number < 0 {
-		return number * -1
+// newSerialNumber creates a positive serial number from serialNumberBits bits of cryptographically secure randomness,
+// which keeps the DER encoding within the 20 octets allowed by RFC 5280.
+func newSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), serialNumberBits)
+	for {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate serial number: %w", err)
+		}
+
+		if n.Sign() > 0 {
+			return n, nil
+		}
+	}
+}
+
+// encodeSerialNumber encodes a serial number as the lowercase hex string it is stored as.
+func encodeSerialNumber(n *big.Int) string {
+	return n.Text(16)
+}
+
+// parseSerialNumber parses a stored hex serial number, which must be positive as required by RFC 5280.
+func parseSerialNumber(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok || n.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid serial number: %q", s)
 	}
 
-	return number
+	return n, nil
 }
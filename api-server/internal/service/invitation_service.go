@@ -2,32 +2,50 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/CzarSimon/httputil"
 	"github.com/CzarSimon/httputil/id"
 	"github.com/CzarSimon/httputil/jwt"
 	"github.com/CzarSimon/webca/api-server/internal/audit"
+	"github.com/CzarSimon/webca/api-server/internal/authorization"
 	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
+	"github.com/CzarSimon/webca/api-server/internal/password"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
 	"github.com/CzarSimon/webca/api-server/internal/timeutil"
 	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
 )
 
+// invitationTokenLength is the number of random bytes in an invitation token.
+const invitationTokenLength = 32
+
 // InvitationService service responsible for invitation business logic.
 type InvitationService struct {
-	AuditLog       audit.Logger
-	InvitationRepo repository.InvitationRepository
-	UserRepo       repository.UserRepository
+	JwtIssuer       jwt.Issuer
+	AuditLog        audit.Logger
+	InvitationRepo  repository.InvitationRepository
+	UserRepo        repository.UserRepository
+	PasswordService *password.Service
+	AuthService     *authorization.Service
+	Notifier        Notifier
+	AppBaseURL      string
 }
 
-// GetInvitation retrieves an invitation if it exits.
-func (i *InvitationService) GetInvitation(ctx context.Context, id string) (model.Invitation, error) {
+// GetInvitation retrieves a pending invitation by its secret token.
+func (i *InvitationService) GetInvitation(ctx context.Context, token string) (model.Invitation, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_service_get_invitation")
 	defer span.Finish()
 
-	invite, err := i.findInvitation(ctx, id)
+	invite, err := i.findInvitationByToken(ctx, token)
 	if err != nil {
 		return model.Invitation{}, err
 	}
@@ -36,6 +54,113 @@ func (i *InvitationService) GetInvitation(ctx context.Context, id string) (model
 	return invite, nil
 }
 
+// GetInvitations lists the invitations of an account, optionally filtered by status.
+func (i *InvitationService) GetInvitations(ctx context.Context, principal jwt.User, filter model.InvitationFilter) ([]model.Invitation, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_service_get_invitations")
+	defer span.Finish()
+
+	err := i.AuthService.AssertAccountAccess(ctx, principal, filter.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := i.getInvitationsByFilter(ctx, filter)
+	if err != nil {
+		return nil, httputil.InternalServerError(err)
+	}
+
+	i.logInvitationsRead(ctx, invites, principal.ID)
+	return invites, nil
+}
+
+func (i *InvitationService) getInvitationsByFilter(ctx context.Context, filter model.InvitationFilter) ([]model.Invitation, error) {
+	if len(filter.Statuses) == 0 {
+		return i.InvitationRepo.FindByAccountID(ctx, filter.AccountID)
+	}
+
+	return i.InvitationRepo.FindByAccountIDAndStatuses(ctx, filter.AccountID, filter.Statuses)
+}
+
+// Revoke revokes a pending invitation so that it can no longer be accepted.
+func (i *InvitationService) Revoke(ctx context.Context, principal jwt.User, id string) (model.Invitation, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_service_revoke")
+	defer span.Finish()
+
+	invite, err := i.findPendingInvitation(ctx, principal, id)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	invite.Status = model.InvitationRevoked
+	invite.TokenHash = ""
+	err = i.updatePendingInvitation(ctx, invite)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	i.AuditLog.Delete(ctx, principal.ID, "invitation:%s", invite.ID)
+	return invite, nil
+}
+
+// Resend replaces the token of a pending invitation and extends its validity by the invitation lifetime of its account.
+func (i *InvitationService) Resend(ctx context.Context, principal jwt.User, id string) (model.Invitation, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_service_resend")
+	defer span.Finish()
+
+	invite, err := i.findPendingInvitation(ctx, principal, id)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	invite, err = withNewToken(invite)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	invite.ValidTo = timeutil.Now().Add(invite.Account.InvitationLifetime())
+	err = i.updatePendingInvitation(ctx, invite)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	i.AuditLog.Update(ctx, principal.ID, "invitation:%s", invite.ID)
+	i.notifyInvitee(ctx, invite, principal.ID)
+	return invite, nil
+}
+
+func (i *InvitationService) findPendingInvitation(ctx context.Context, principal jwt.User, id string) (model.Invitation, error) {
+	invite, err := i.findInvitation(ctx, id)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	err = i.AuthService.AssertAccountAccess(ctx, principal, invite.Account.ID)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	if invite.Status != model.InvitationCreated {
+		err = fmt.Errorf("%s is no longer pending", invite)
+		return model.Invitation{}, httputil.ConflictError(err)
+	}
+
+	return invite, nil
+}
+
+func (i *InvitationService) updatePendingInvitation(ctx context.Context, invite model.Invitation) error {
+	updated, err := i.InvitationRepo.Update(ctx, invite, model.InvitationCreated)
+	if err != nil {
+		return httputil.InternalServerError(err)
+	}
+
+	if !updated {
+		err = fmt.Errorf("%s was accepted or revoked while being updated", invite)
+		return httputil.ConflictError(err)
+	}
+
+	return nil
+}
+
 // Create creates an invitation.
 func (i *InvitationService) Create(ctx context.Context, principal jwt.User, req model.InvitationCreationRequest) (model.Invitation, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_service_create")
@@ -52,17 +177,81 @@ func (i *InvitationService) Create(ctx context.Context, principal jwt.User, req
 	}
 
 	i.logNewInvitation(ctx, invite, principal.ID)
+	i.notifyInvitee(ctx, invite, principal.ID)
 	return invite, nil
 }
 
+// Accept accepts a pending invitation by creating the invited user in the inviting account.
+func (i *InvitationService) Accept(ctx context.Context, req model.InvitationAcceptanceRequest) (model.AuthenticationResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "invitation_service_accept")
+	defer span.Finish()
+
+	invite, err := i.findInvitationByToken(ctx, req.Token)
+	if err != nil {
+		return model.AuthenticationResponse{}, err
+	}
+
+	err = i.assertNewUser(ctx, invite)
+	if err != nil {
+		return model.AuthenticationResponse{}, err
+	}
+
+	credentials, err := i.PasswordService.Hash(ctx, req.Password)
+	if err != nil {
+		return model.AuthenticationResponse{}, err
+	}
+
+	user := model.NewUser(invite.Email, invite.Role, credentials, invite.Account)
+	invite.Status = model.InvitationAccepted
+	invite.AcceptedAt = user.CreatedAt
+
+	err = i.InvitationRepo.Accept(ctx, invite, user)
+	if errors.Is(err, repository.ErrInvitationNotPending) {
+		return model.AuthenticationResponse{}, httputil.ConflictError(fmt.Errorf("%s has already been used: %w", invite, err))
+	}
+	if err != nil {
+		return model.AuthenticationResponse{}, httputil.InternalServerError(err)
+	}
+
+	token, err := i.JwtIssuer.Issue(user.JWTUser(), tokenLifetime)
+	if err != nil {
+		return model.AuthenticationResponse{}, err
+	}
+
+	i.logInvitationAccepted(ctx, invite, user)
+	return model.AuthenticationResponse{
+		Token: token,
+		User:  user,
+	}, nil
+}
+
+func (i *InvitationService) assertNewUser(ctx context.Context, invite model.Invitation) error {
+	user, found, err := i.UserRepo.FindByAccountNameAndEmail(ctx, invite.Account.Name, invite.Email)
+	if err != nil {
+		return httputil.InternalServerError(err)
+	}
+
+	if found {
+		err = fmt.Errorf("%s already exists", user)
+		return httputil.ConflictError(err)
+	}
+
+	return nil
+}
+
 func (i *InvitationService) createNewInviation(ctx context.Context, req model.InvitationCreationRequest, userID string) (model.Invitation, error) {
 	user, err := i.findUser(ctx, userID)
 	if err != nil {
 		return model.Invitation{}, err
 	}
 
+	lifetime := user.Account.InvitationLifetime()
+	if req.LifetimeHours > 0 {
+		lifetime = time.Duration(req.LifetimeHours) * time.Hour
+	}
+
 	now := timeutil.Now()
-	return model.Invitation{
+	return withNewToken(model.Invitation{
 		ID:          id.New(),
 		Email:       req.Email,
 		Role:        req.Role,
@@ -70,8 +259,31 @@ func (i *InvitationService) createNewInviation(ctx context.Context, req model.In
 		CreatedByID: user.ID,
 		Account:     user.Account,
 		CreatedAt:   now,
-		ValidTo:     now.Add(24 * time.Hour),
-	}, nil
+		ValidTo:     now.Add(lifetime),
+	})
+}
+
+// notifyInvitee emails the invitation link to the invited user. Failures are only logged since
+// the invitation has been stored and its token is returned to the inviting admin.
+func (i *InvitationService) notifyInvitee(ctx context.Context, invite model.Invitation, userID string) {
+	inviter, err := i.findUser(ctx, userID)
+	if err != nil {
+		log.Error("failed to find inviting user", zap.String("invitationId", invite.ID), zap.Error(err))
+		return
+	}
+
+	data := notification.InvitationData{
+		AccountName: invite.Account.Name,
+		Role:        invite.Role,
+		InvitedBy:   inviter.Email,
+		Link:        fmt.Sprintf("%s/invitations/%s", strings.TrimSuffix(i.AppBaseURL, "/"), invite.Token),
+		ValidTo:     invite.ValidTo,
+	}
+
+	err = i.Notifier.Notify(ctx, notification.InvitationTemplate, invite.Email, data)
+	if err != nil {
+		log.Error("failed to send invitation", zap.String("invitationId", invite.ID), zap.Error(err))
+	}
 }
 
 func (i *InvitationService) findUser(ctx context.Context, userID string) (model.User, error) {
@@ -85,6 +297,11 @@ func (i *InvitationService) findUser(ctx context.Context, userID string) (model.
 		return model.User{}, httputil.UnauthorizedError(err)
 	}
 
+	if !user.Active {
+		err := fmt.Errorf("%s has been deactivated", user)
+		return model.User{}, httputil.UnauthorizedError(err)
+	}
+
 	return user, nil
 }
 
@@ -102,10 +319,59 @@ func (i *InvitationService) findInvitation(ctx context.Context, id string) (mode
 	return invite, nil
 }
 
+// findInvitationByToken looks up an invitation by the hash of its token. Tokens are cleared when
+// an invitation is accepted or revoked and are rejected once the invitation has expired.
+func (i *InvitationService) findInvitationByToken(ctx context.Context, token string) (model.Invitation, error) {
+	hash := hashInvitationToken(token)
+	invite, exists, err := i.InvitationRepo.FindByTokenHash(ctx, hash)
+	if err != nil {
+		return model.Invitation{}, httputil.InternalServerError(err)
+	}
+
+	if !exists || subtle.ConstantTimeCompare([]byte(invite.TokenHash), []byte(hash)) != 1 || invite.Status != model.InvitationCreated {
+		err = fmt.Errorf("could not find a pending invitation matching the provided token")
+		return model.Invitation{}, httputil.NotFoundError(err)
+	}
+
+	if timeutil.Now().After(invite.ValidTo) {
+		err = fmt.Errorf("%s expired at %v", invite, invite.ValidTo)
+		return model.Invitation{}, httputil.NewError(http.StatusText(http.StatusGone), http.StatusGone, err)
+	}
+
+	return invite, nil
+}
+
+func withNewToken(invite model.Invitation) (model.Invitation, error) {
+	token, err := randomToken(invitationTokenLength)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	invite.Token = token
+	invite.TokenHash = hashInvitationToken(token)
+	return invite, nil
+}
+
+func hashInvitationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
 func (i *InvitationService) logNewInvitation(ctx context.Context, invite model.Invitation, userID string) {
 	i.AuditLog.Create(ctx, userID, "invitation:%s", invite.ID)
 }
 
+func (i *InvitationService) logInvitationAccepted(ctx context.Context, invite model.Invitation, user model.User) {
+	i.AuditLog.Update(ctx, user.ID, "invitation:%s", invite.ID)
+	i.AuditLog.Create(ctx, user.ID, "user:%s", user.ID)
+}
+
+func (i *InvitationService) logInvitationsRead(ctx context.Context, invites []model.Invitation, userID string) {
+	for _, invite := range invites {
+		i.AuditLog.Read(ctx, userID, "invitation:%s", invite.ID)
+	}
+}
+
 func (i *InvitationService) logInvitationRead(ctx context.Context, invite model.Invitation) {
 	i.AuditLog.Read(ctx, "ANONYMOUS", "invitation:%s", invite.ID)
 }
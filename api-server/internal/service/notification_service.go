@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/notification"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+// Default delivery settings of the notification outbox.
+const (
+	DefaultNotificationAttempts   = 5
+	DefaultNotificationRetryDelay = time.Minute
+	notificationBatchSize         = 100
+	notificationClaimTimeout      = 5 * time.Minute
+)
+
+// Notifier sends templated notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, template, recipient string, data interface{}) error
+}
+
+// NotificationService renders notifications into a persistent outbox and delivers them with a notification.Sender.
+// Notifications are only delivered from the outbox, so sending never blocks the request that caused it.
+// Bodies are encrypted with EncryptionKey while in the outbox and each delivery attempt claims its notification first,
+// so that concurrent workers do not send it twice. Failed deliveries are retried with an exponential backoff,
+// starting at RetryDelay, until MaxAttempts have been made.
+type NotificationService struct {
+	Sender           notification.Sender
+	NotificationRepo repository.NotificationRepository
+	EncryptionKey    []byte
+	MaxAttempts      int
+	RetryDelay       time.Duration
+}
+
+// Notify renders a notification and stores it in the outbox, it is sent by the next call to DeliverPending.
+func (n *NotificationService) Notify(ctx context.Context, template, recipient string, data interface{}) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_service_notify")
+	defer span.Finish()
+
+	msg, err := notification.Render(template, recipient, data)
+	if err != nil {
+		return err
+	}
+
+	body, salt, err := encryptWithServerKey(n.EncryptionKey, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt notification body: %w", err)
+	}
+
+	notif := model.NewNotification(template, msg.To, msg.Subject, body)
+	notif.EncryptionSalt = salt
+	return n.NotificationRepo.Save(ctx, notif)
+}
+
+// DeliverPending attempts to deliver the notifications in the outbox that are due.
+func (n *NotificationService) DeliverPending(ctx context.Context) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "notification_service_deliver_pending")
+	defer span.Finish()
+
+	notifications, err := n.NotificationRepo.FindDue(ctx, timeutil.Now(), notificationBatchSize)
+	if err != nil {
+		log.Error("failed to find notifications to deliver", zap.Error(err))
+		return
+	}
+
+	for _, notif := range notifications {
+		claimed, err := n.NotificationRepo.Claim(ctx, notif, timeutil.Now(), timeutil.Now().Add(notificationClaimTimeout))
+		if err != nil {
+			log.Error("failed to claim notification", zap.String("notificationId", notif.ID), zap.Error(err))
+			continue
+		}
+
+		if claimed {
+			n.deliver(ctx, notif)
+		}
+	}
+}
+
+// deliver sends a claimed notification and records the outcome, which releases the claim.
+func (n *NotificationService) deliver(ctx context.Context, notif model.Notification) {
+	err := n.send(ctx, notif)
+	if err != nil {
+		log.Warn("failed to deliver notification", zap.String("notificationId", notif.ID), zap.Int("attempt", notif.Attempts+1), zap.Error(err))
+		notif = n.recordFailure(notif, err)
+	} else {
+		notif = recordDelivery(notif)
+	}
+
+	err = n.NotificationRepo.Update(ctx, notif)
+	if err != nil {
+		log.Error("failed to update notification after delivery attempt", zap.String("notificationId", notif.ID), zap.Error(err))
+	}
+}
+
+func (n *NotificationService) send(ctx context.Context, notif model.Notification) error {
+	body, err := decryptWithServerKey(n.EncryptionKey, notif.Body, notif.EncryptionSalt)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt notification body: %w", err)
+	}
+
+	return n.Sender.Send(ctx, notification.Message{
+		To:      notif.Recipient,
+		Subject: notif.Subject,
+		Body:    body,
+	})
+}
+
+func (n *NotificationService) recordFailure(notif model.Notification, err error) model.Notification {
+	notif.Attempts++
+	notif.LastError = err.Error()
+	notif.ClaimedUntil = time.Time{}
+	if notif.Attempts >= n.maxAttempts() {
+		notif.Status = model.NotificationFailed
+		notif.Body = ""
+		notif.EncryptionSalt = ""
+		return notif
+	}
+
+	backoff := n.retryDelay() << uint(notif.Attempts-1)
+	notif.Status = model.NotificationPending
+	notif.NextAttemptAt = timeutil.Now().Add(backoff)
+	return notif
+}
+
+func recordDelivery(notif model.Notification) model.Notification {
+	notif.Attempts++
+	notif.Status = model.NotificationSent
+	notif.SentAt = timeutil.Now()
+	notif.ClaimedUntil = time.Time{}
+	notif.Body = ""
+	notif.EncryptionSalt = ""
+	return notif
+}
+
+func (n *NotificationService) maxAttempts() int {
+	if n.MaxAttempts <= 0 {
+		return DefaultNotificationAttempts
+	}
+
+	return n.MaxAttempts
+}
+
+func (n *NotificationService) retryDelay() time.Duration {
+	if n.RetryDelay <= 0 {
+		return DefaultNotificationRetryDelay
+	}
+
+	return n.RetryDelay
+}
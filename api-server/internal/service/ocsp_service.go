@@ -0,0 +1,339 @@
+package service
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/webca/api-server/internal/ecdsautil"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspResponderValidity = 365 * 24 * time.Hour
+
+// Pre-encoded OCSP error responses with the statuses defined in RFC 6960 section 4.2.1.
+var (
+	ocspInternalErrorResponse = []byte{0x30, 0x03, 0x0A, 0x01, 0x02}
+	ocspUnauthorizedResponse  = []byte{0x30, 0x03, 0x0A, 0x01, 0x06}
+)
+
+// Object identifier of the id-pkix-ocsp-nocheck extension defined in RFC 6960 section 4.2.2.2.1.
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// Hash algorithms accepted for the issuer name and key hashes of OCSP requests.
+var ocspHashAlgorithms = map[gocrypto.Hash]string{
+	gocrypto.SHA1:   "SHA1",
+	gocrypto.SHA256: "SHA256",
+	gocrypto.SHA384: "SHA384",
+	gocrypto.SHA512: "SHA512",
+}
+
+// OCSPService RFC 6960 responder for certificates issued by certificate authorities that have a delegated responder.
+//
+// Responses are signed by a delegated responder certificate per certificate authority. The responder certificate is issued
+// when the owner of the certificate authority requests it with its password, only the key of the responder is held by the server
+// wrapped with KeyWrappingKey. Certificate authorities are found by the issuer hashes stored when their responder was issued.
+type OCSPService struct {
+	CertRepo        repository.CertificateRepository
+	ResponderRepo   repository.OCSPResponderRepository
+	KeyWrappingKey  []byte
+	ExternalBaseURL string
+	Validity        time.Duration
+}
+
+// Respond creates a signed response to a DER encoded OCSP request.
+// Failures are reported as OCSP error responses rather than as errors.
+func (s *OCSPService) Respond(ctx context.Context, der []byte) model.OCSPResponse {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ocsp_service_respond")
+	defer span.Finish()
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return model.OCSPResponse{Body: ocsp.MalformedRequestErrorResponse}
+	}
+
+	res, err := s.respond(ctx, req)
+	if err != nil {
+		log.Error("failed to create ocsp response", zap.String("serialNumber", req.SerialNumber.String()), zap.Error(err))
+		return model.OCSPResponse{Body: ocspInternalErrorResponse}
+	}
+
+	return res
+}
+
+// ResponderURL returns the url of the OCSP responder for certificates issued by a certificate authority.
+// An empty string is returned if no external base url is configured or if the certificate authority has no responder.
+func (s *OCSPService) ResponderURL(ctx context.Context, certificateID string) (string, error) {
+	if s.ExternalBaseURL == "" {
+		return "", nil
+	}
+
+	_, found, err := s.ResponderRepo.FindLatest(ctx, certificateID)
+	if err != nil || !found {
+		return "", err
+	}
+
+	return strings.TrimSuffix(s.ExternalBaseURL, "/") + "/v1/ocsp", nil
+}
+
+func (s *OCSPService) respond(ctx context.Context, req *ocsp.Request) (model.OCSPResponse, error) {
+	ca, caCert, found, err := s.findIssuer(ctx, req)
+	if err != nil {
+		return model.OCSPResponse{}, err
+	}
+
+	if !found {
+		return model.OCSPResponse{Body: ocspUnauthorizedResponse}, nil
+	}
+
+	responderCert, responderKeys, found, err := s.getResponder(ctx, ca)
+	if err != nil {
+		return model.OCSPResponse{}, err
+	}
+
+	if !found {
+		return model.OCSPResponse{Body: ocspUnauthorizedResponse}, nil
+	}
+
+	now := timeutil.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(s.Validity),
+		Certificate:  responderCert,
+		IssuerHash:   req.HashAlgorithm,
+	}
+
+	err = s.addCertificateStatus(ctx, &template, ca)
+	if err != nil {
+		return model.OCSPResponse{}, err
+	}
+
+	signer, ok := responderKeys.PrivateKey().(gocrypto.Signer)
+	if !ok {
+		return model.OCSPResponse{}, fmt.Errorf("private key of type %T cannot sign ocsp responses", responderKeys.PrivateKey())
+	}
+
+	b, err := ocsp.CreateResponse(caCert, responderCert, template, signer)
+	if err != nil {
+		return model.OCSPResponse{}, fmt.Errorf("failed to create ocsp response: %w", err)
+	}
+
+	return model.OCSPResponse{
+		Body:       b,
+		NextUpdate: template.NextUpdate,
+	}, nil
+}
+
+func (s *OCSPService) addCertificateStatus(ctx context.Context, template *ocsp.Response, ca model.Certificate) error {
+	cert, found, err := s.CertRepo.FindBySignatoryIDAndSerialNumber(ctx, ca.ID, encodeSerialNumber(template.SerialNumber))
+	if err != nil || !found {
+		return err
+	}
+
+	if !cert.Revoked() {
+		template.Status = ocsp.Good
+		return nil
+	}
+
+	template.Status = ocsp.Revoked
+	template.RevokedAt = cert.Revocation.RevokedAt
+	template.RevocationReason = cert.Revocation.Reason
+	return nil
+}
+
+// findIssuer finds the certificate authority identified by the issuer name and key hashes of a request.
+// Certificate authorities that share subject and key, e.g. when renewed without rekeying, are told apart by the requested serial number.
+func (s *OCSPService) findIssuer(ctx context.Context, req *ocsp.Request) (model.Certificate, *x509.Certificate, bool, error) {
+	algorithm, ok := ocspHashAlgorithms[req.HashAlgorithm]
+	if !ok {
+		return model.Certificate{}, nil, false, nil
+	}
+
+	ids, err := s.ResponderRepo.FindCertificateIDsByIssuerHash(ctx, model.OCSPIssuerHash{
+		Algorithm: algorithm,
+		NameHash:  hex.EncodeToString(req.IssuerNameHash),
+		KeyHash:   hex.EncodeToString(req.IssuerKeyHash),
+	})
+	if err != nil || len(ids) == 0 {
+		return model.Certificate{}, nil, false, err
+	}
+
+	certificateID := ids[0]
+	for _, id := range ids[1:] {
+		_, found, err := s.CertRepo.FindBySignatoryIDAndSerialNumber(ctx, id, encodeSerialNumber(req.SerialNumber))
+		if err != nil {
+			return model.Certificate{}, nil, false, err
+		}
+		if found {
+			certificateID = id
+			break
+		}
+	}
+
+	ca, found, err := s.CertRepo.Find(ctx, certificateID)
+	if err != nil || !found {
+		return model.Certificate{}, nil, false, err
+	}
+
+	caCert, err := parseCertificate(ca.Body)
+	if err != nil {
+		return model.Certificate{}, nil, false, err
+	}
+
+	return ca, caCert, true, nil
+}
+
+// getResponder finds and unwraps the latest responder of a certificate authority,
+// responders must be reissued by the owner of the certificate authority once they have expired.
+func (s *OCSPService) getResponder(ctx context.Context, ca model.Certificate) (*x509.Certificate, model.KeyEncoder, bool, error) {
+	responder, found, err := s.ResponderRepo.FindLatest(ctx, ca.ID)
+	if err != nil || !found {
+		return nil, nil, false, err
+	}
+
+	if timeutil.Now().After(responder.ExpiresAt) {
+		log.Warn("ocsp responder has expired", zap.String("responderId", responder.ID), zap.String("certificateId", ca.ID))
+		return nil, nil, false, nil
+	}
+
+	cert, err := parseCertificate(responder.Body)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	keyPair := responder.KeyPair
+	keyPair.PrivateKey, err = decryptWithServerKey(s.KeyWrappingKey, keyPair.PrivateKey, keyPair.EncryptionSalt)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return cert, keys, true, nil
+}
+
+// issueResponder issues and stores a delegated responder certificate signed by the unlocked key of a certificate authority,
+// along with the issuer hashes by which OCSP requests identify the certificate authority.
+func (s *OCSPService) issueResponder(ctx context.Context, ca model.Certificate, signer issuer) (model.OCSPResponder, error) {
+	if len(s.KeyWrappingKey) == 0 {
+		err := fmt.Errorf("unattended signing is not enabled, no key wrapping key has been configured")
+		return model.OCSPResponder{}, httputil.PreconditionRequiredError(err)
+	}
+
+	hashes, err := issuerHashes(signer.cert)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	keys, err := ecdsautil.GenerateKeys(model.KeyRequest{
+		Algorithm: ecdsautil.Algorithm,
+		Options: map[string]interface{}{
+			"curve": ecdsautil.P256,
+		},
+	})
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	now := timeutil.Now()
+	expiresAt := now.Add(ocspResponderValidity)
+	if expiresAt.After(ca.ExpiresAt) {
+		expiresAt = ca.ExpiresAt
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: fmt.Sprintf("%s OCSP Responder", signer.cert.Subject.CommonName),
+		},
+		NotBefore:       now,
+		NotAfter:        expiresAt,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{{Id: oidOCSPNoCheck, Value: asn1.NullBytes}},
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, template, signer.cert, keys.PublicKey(), signer.keys.PrivateKey())
+	if err != nil {
+		return model.OCSPResponder{}, fmt.Errorf("failed to create ocsp responder certificate: %w", err)
+	}
+
+	keyPair := keys.Encode()
+	keyPair.PrivateKey, keyPair.EncryptionSalt, err = encryptWithServerKey(s.KeyWrappingKey, keyPair.PrivateKey)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	responder := model.OCSPResponder{
+		ID:            id.New(),
+		CertificateID: ca.ID,
+		Body:          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})),
+		KeyPair:       keyPair,
+		IssuerHashes:  hashes,
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+	}
+
+	err = s.ResponderRepo.Save(ctx, responder)
+	if err != nil {
+		return model.OCSPResponder{}, err
+	}
+
+	return responder, nil
+}
+
+// issuerHashes computes the issuer name and key hashes of a certificate authority with each accepted hash algorithm.
+func issuerHashes(ca *x509.Certificate) ([]model.OCSPIssuerHash, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key of certificate authority: %w", err)
+	}
+
+	hashes := make([]model.OCSPIssuerHash, 0, len(ocspHashAlgorithms))
+	for hash, algorithm := range ocspHashAlgorithms {
+		h := hash.New()
+		h.Write(spki.PublicKey.RightAlign())
+		keyHash := h.Sum(nil)
+
+		h.Reset()
+		h.Write(ca.RawSubject)
+		nameHash := h.Sum(nil)
+
+		hashes = append(hashes, model.OCSPIssuerHash{
+			Algorithm: algorithm,
+			NameHash:  hex.EncodeToString(nameHash),
+			KeyHash:   hex.EncodeToString(keyHash),
+		})
+	}
+
+	return hashes, nil
+}
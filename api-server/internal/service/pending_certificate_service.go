@@ -0,0 +1,389 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/httputil/jwt"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/opentracing/opentracing-go"
+)
+
+const certificateBlockType = "CERTIFICATE"
+
+var basicConstraintsOID = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// CreatePending generates and stores the key pair of an intermediate certificate authority
+// and returns a certificate signing request for it to be signed by an external signer.
+func (c *CertificateService) CreatePending(ctx context.Context, req model.PendingCertificateRequest) (model.PendingCertificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_create_pending")
+	defer span.Finish()
+
+	err := c.PasswordService.Allowed(req.Password)
+	if err != nil {
+		return model.PendingCertificate{}, httputil.BadRequestError(err)
+	}
+
+	user, err := c.findUser(ctx, req.UserID)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	err = c.assertNameAvailable(ctx, req.Name, user.Account.ID)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	keys, err := c.createKeys(ctx, req.KeyRequest())
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	csr, err := createCertificateRequest(req, keys)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	keyPair, err := c.encryptKeys(ctx, keys.Encode(), req.Password, user)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	pending := model.PendingCertificate{
+		ID:        id.New(),
+		Name:      req.Name,
+		CSR:       csr,
+		Status:    model.PendingCertificatePending,
+		KeyPair:   keyPair,
+		AccountID: user.Account.ID,
+		CreatedAt: timeutil.Now(),
+	}
+
+	err = c.PendingRepo.Save(ctx, pending)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	c.AuditLog.Create(ctx, user.ID, "pending-certificate:%s", pending.ID)
+	c.AuditLog.Create(ctx, user.ID, "key-pair:%s", keyPair.ID)
+	return pending, nil
+}
+
+// GetPending retrieves a pending certificate if it exists and is accessible by the principal.
+func (c *CertificateService) GetPending(ctx context.Context, principal jwt.User, id string) (model.PendingCertificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_get_pending")
+	defer span.Finish()
+
+	pending, err := c.findPending(ctx, principal, id)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	c.AuditLog.Read(ctx, principal.ID, "pending-certificate:%s", pending.ID)
+	return pending, nil
+}
+
+// ListPending retrieves the pending certificates of the account of the principal.
+func (c *CertificateService) ListPending(ctx context.Context, principal jwt.User) ([]model.PendingCertificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_list_pending")
+	defer span.Finish()
+
+	user, err := c.findUser(ctx, principal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingCerts, err := c.PendingRepo.FindByAccountID(ctx, user.Account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pending := range pendingCerts {
+		c.AuditLog.Read(ctx, principal.ID, "pending-certificate:%s", pending.ID)
+	}
+
+	return pendingCerts, nil
+}
+
+// Activate imports the externally signed certificate of a pending certificate authority.
+// The certificate must be issued for the pending key pair and verify against the provided chain,
+// once activated the certificate authority can be used as signatory like any other intermediate.
+func (c *CertificateService) Activate(ctx context.Context, principal jwt.User, req model.ActivationRequest) (model.Certificate, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "certificate_service_activate")
+	defer span.Finish()
+
+	pending, err := c.findPending(ctx, principal, req.PendingCertificateID)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if pending.Status != model.PendingCertificatePending {
+		err = fmt.Errorf("%s has already been activated", pending)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+
+	err = c.PasswordService.Verify(ctx, pending.KeyPair.Credentials, req.Password)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keyPair, err := c.decryptKeys(ctx, pending.KeyPair, req.Password)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	keys, err := decodeKeys(keyPair)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	x509Cert, issuerChain, err := verifyExternalCertificate(req, keys)
+	if err != nil {
+		return model.Certificate{}, httputil.BadRequestError(err)
+	}
+
+	cert, err := activatedCertificate(pending, x509Cert)
+	if err != nil {
+		return model.Certificate{}, httputil.BadRequestError(err)
+	}
+
+	activatedAt := timeutil.Now()
+	pending.Status = model.PendingCertificateActivated
+	pending.CertificateID = cert.ID
+	pending.IssuerChain = issuerChain
+	pending.ActivatedAt = &activatedAt
+	updated, err := c.PendingRepo.Activate(ctx, pending, cert)
+	if errors.Is(err, repository.ErrDuplicateSerialNumber) {
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	if !updated {
+		err = fmt.Errorf("%s was activated concurrently", pending)
+		return model.Certificate{}, httputil.ConflictError(err)
+	}
+
+	c.AuditLog.Update(ctx, principal.ID, "pending-certificate:%s:activation", pending.ID)
+	c.AuditLog.Create(ctx, principal.ID, "certificate:%s", cert.ID)
+	return cert, nil
+}
+
+// ExternalIssuerChain returns the certificates between an externally signed certificate authority and its external root,
+// the chain is empty for certificates signed within webca.
+func (c *CertificateService) ExternalIssuerChain(ctx context.Context, cert model.Certificate) (string, error) {
+	if cert.Type != model.IntermediateCAType || cert.SignatoryID != "" {
+		return "", nil
+	}
+
+	pending, found, err := c.PendingRepo.FindByCertificateID(ctx, cert.ID)
+	if err != nil || !found {
+		return "", err
+	}
+
+	return pending.IssuerChain, nil
+}
+
+func (c *CertificateService) findPending(ctx context.Context, principal jwt.User, id string) (model.PendingCertificate, error) {
+	pending, found, err := c.PendingRepo.Find(ctx, id)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("pending certificate with id %s does not exist", id)
+		return model.PendingCertificate{}, httputil.NotFoundError(err)
+	}
+
+	err = c.AuthService.AssertAccountAccess(ctx, principal, pending.AccountID)
+	if err != nil {
+		return model.PendingCertificate{}, err
+	}
+
+	return pending, nil
+}
+
+// createCertificateRequest creates a PEM encoded certificate signing request for a certificate authority.
+func createCertificateRequest(req model.PendingCertificateRequest, keys model.KeyEncoder) (string, error) {
+	names, err := req.SubjectAlternativeNames.Normalize()
+	if err != nil {
+		return "", httputil.BadRequestError(err)
+	}
+
+	c := &x509.Certificate{}
+	err = addAlternativeNames(c, names)
+	if err != nil {
+		return "", err
+	}
+
+	constraints, err := asn1.Marshal(struct {
+		IsCA bool
+	}{IsCA: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode basic constraints: %w", err)
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:        pkixName(req.Subject),
+		DNSNames:       c.DNSNames,
+		EmailAddresses: c.EmailAddresses,
+		IPAddresses:    c.IPAddresses,
+		URIs:           c.URIs,
+		ExtraExtensions: []pkix.Extension{
+			{Id: basicConstraintsOID, Critical: true, Value: constraints},
+		},
+	}
+
+	b, err := x509.CreateCertificateRequest(rand.Reader, template, keys.PrivateKey())
+	if err != nil {
+		return "", fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: csrBlockType, Bytes: b})), nil
+}
+
+// verifyExternalCertificate checks that an externally signed certificate is a certificate authority for the pending keys
+// that verifies against the provided chain. The certificates of the chain below its root are returned as the issuer chain.
+func verifyExternalCertificate(req model.ActivationRequest, keys model.KeyEncoder) (*x509.Certificate, string, error) {
+	cert, err := parseCertificate(req.Certificate)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, "", fmt.Errorf("certificate %s is not a certificate authority", cert.Subject.CommonName)
+	}
+
+	err = assertSamePublicKey(cert.PublicKey, keys.PublicKey())
+	if err != nil {
+		return nil, "", err
+	}
+
+	chain, err := parseCertificates(req.Chain)
+	if err != nil {
+		return nil, "", err
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	hasRoot := false
+	issuerChain := ""
+	for _, chainCert := range chain {
+		if bytes.Equal(chainCert.RawIssuer, chainCert.RawSubject) && chainCert.CheckSignatureFrom(chainCert) == nil {
+			roots.AddCert(chainCert)
+			hasRoot = true
+			continue
+		}
+
+		intermediates.AddCert(chainCert)
+		issuerChain += encodeCertificate(chainCert)
+	}
+
+	if !hasRoot {
+		return nil, "", fmt.Errorf("chain must include the root certificate of the external signer")
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("certificate does not verify against the provided chain: %w", err)
+	}
+
+	return cert, issuerChain, nil
+}
+
+func assertSamePublicKey(certKey, pendingKey interface{}) error {
+	certDER, err := x509.MarshalPKIXPublicKey(certKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate public key: %w", err)
+	}
+
+	pendingDER, err := x509.MarshalPKIXPublicKey(pendingKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode pending public key: %w", err)
+	}
+
+	if !bytes.Equal(certDER, pendingDER) {
+		return fmt.Errorf("certificate is not issued for the key pair of the pending certificate")
+	}
+
+	return nil
+}
+
+// activatedCertificate creates the certificate of an activated pending certificate authority.
+// Having no signatory the certificate is the top of its chain within webca.
+func activatedCertificate(pending model.PendingCertificate, x509Cert *x509.Certificate) (model.Certificate, error) {
+	names, err := alternativeNames(x509Cert.DNSNames, x509Cert.IPAddresses, x509Cert.EmailAddresses, x509Cert.URIs)
+	if err != nil {
+		return model.Certificate{}, err
+	}
+
+	cert := model.Certificate{
+		ID:                      id.New(),
+		Name:                    pending.Name,
+		SerialNumber:            encodeSerialNumber(x509Cert.SerialNumber),
+		Subject:                 subjectOf(x509Cert.Subject),
+		SubjectAlternativeNames: names,
+		Body:                    encodeCertificate(x509Cert),
+		Format:                  pemFormat,
+		Type:                    model.IntermediateCAType,
+		KeyPair:                 pending.KeyPair,
+		AccountID:               pending.AccountID,
+		Status:                  model.CertificateActive,
+		MaxPathLen:              pathLenConstraint(x509Cert),
+		NameConstraints:         nameConstraintsOf(x509Cert),
+		CreatedAt:               timeutil.Now(),
+		ExpiresAt:               x509Cert.NotAfter.UTC(),
+	}
+
+	cert.IssuerID = issuerID(cert, x509Cert)
+	return cert, nil
+}
+
+func encodeCertificate(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: certificateBlockType, Bytes: cert.Raw}))
+}
+
+func parseCertificates(body string) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+	rest := []byte(body)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != certificateBlockType {
+			return nil, fmt.Errorf("expected blocktype '%s' but got '%s'", certificateBlockType, block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse x509 certificate: %w", err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to decode certificate pem block")
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,413 @@
+package service
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/CzarSimon/httputil"
+	"github.com/CzarSimon/httputil/crypto"
+	"github.com/CzarSimon/httputil/id"
+	"github.com/CzarSimon/webca/api-server/internal/model"
+	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+const (
+	crlBlockType              = "X509 CRL"
+	signingKeySaltLength      = 32
+	maxRevocationListAttempts = 3
+)
+
+// Object identifiers of the CRL entry extensions defined in RFC 5280 section 5.3.
+var (
+	oidReasonCode     = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidInvalidityDate = asn1.ObjectIdentifier{2, 5, 29, 24}
+)
+
+// RevocationListService service responsible for generation and publication of certificate revocation lists.
+//
+// Revocation lists must be re-signed without a user present, so certificate authorities that are explicitly enrolled by their owner
+// have their private key stored a second time, wrapped with a key derived from KeyWrappingKey instead of the user password.
+// KeyWrappingKey is a dedicated secret, unattended signing is disabled when it is not configured.
+type RevocationListService struct {
+	CertRepo        repository.CertificateRepository
+	KeyPairRepo     repository.KeyPairRepository
+	CRLRepo         repository.RevocationListRepository
+	KeyWrappingKey  []byte
+	ExternalBaseURL string
+	Validity        time.Duration
+}
+
+// GetRevocationList retrieves the latest revocation list published by a certificate authority.
+func (s *RevocationListService) GetRevocationList(ctx context.Context, certificateID string) (model.RevocationList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "revocation_list_service_get_revocation_list")
+	defer span.Finish()
+
+	crl, found, err := s.CRLRepo.FindLatest(ctx, certificateID)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("no revocation list published for certificate with id %s", certificateID)
+		return model.RevocationList{}, httputil.NotFoundError(err)
+	}
+
+	return crl, nil
+}
+
+// Enroll stores the signing key of a certificate authority and publishes its first revocation list.
+func (s *RevocationListService) Enroll(ctx context.Context, cert model.Certificate, keys model.KeyEncoder) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "revocation_list_service_enroll")
+	defer span.Finish()
+
+	if len(s.KeyWrappingKey) == 0 {
+		err := fmt.Errorf("unattended signing is not enabled, no key wrapping key has been configured")
+		return httputil.PreconditionRequiredError(err)
+	}
+
+	signingKey, err := s.encryptSigningKey(cert, keys.Encode())
+	if err != nil {
+		return err
+	}
+
+	err = s.CRLRepo.SaveSigningKey(ctx, signingKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Generate(ctx, cert.ID)
+	return err
+}
+
+// Unenroll deletes the stored signing key of a certificate authority, which stops the publication of new revocation lists.
+func (s *RevocationListService) Unenroll(ctx context.Context, certificateID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "revocation_list_service_unenroll")
+	defer span.Finish()
+
+	deleted, err := s.CRLRepo.DeleteSigningKey(ctx, certificateID)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		err = fmt.Errorf("no revocation list signing key stored for certificate with id %s", certificateID)
+		return httputil.NotFoundError(err)
+	}
+
+	return nil
+}
+
+// Enrolled checks if a certificate authority has a stored signing key.
+func (s *RevocationListService) Enrolled(ctx context.Context, certificateID string) (bool, error) {
+	_, found, err := s.CRLRepo.FindSigningKey(ctx, certificateID)
+	return found, err
+}
+
+// Generate creates, signs and stores a new revocation list for a certificate authority.
+func (s *RevocationListService) Generate(ctx context.Context, certificateID string) (model.RevocationList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "revocation_list_service_generate")
+	defer span.Finish()
+
+	ca, issuer, err := s.findIssuer(ctx, certificateID)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	revoked, err := s.CertRepo.FindRevokedBySignatoryID(ctx, ca.ID)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	entries, err := revokedCertificateEntries(revoked)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	// Concurrent generation for the same certificate authority races for the next number, which is unique per certificate authority.
+	for attempt := 1; ; attempt++ {
+		crl, err := s.generate(ctx, ca, issuer, entries)
+		if err == nil {
+			return crl, nil
+		}
+
+		if !errors.Is(err, repository.ErrDuplicateRevocationListNumber) || attempt == maxRevocationListAttempts {
+			return model.RevocationList{}, err
+		}
+
+		log.Warn("revocation list number taken, retrying", zap.String("certificateId", ca.ID), zap.Int("attempt", attempt))
+	}
+}
+
+func (s *RevocationListService) generate(ctx context.Context, ca model.Certificate, signer issuer, entries []pkix.RevokedCertificate) (model.RevocationList, error) {
+	latest, _, err := s.CRLRepo.FindLatest(ctx, ca.ID)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	now := timeutil.Now()
+	crl := model.RevocationList{
+		ID:            id.New(),
+		CertificateID: ca.ID,
+		Number:        latest.Number + 1,
+		ThisUpdate:    now,
+		NextUpdate:    now.Add(s.Validity),
+		CreatedAt:     now,
+	}
+
+	crl, err = signRevocationList(crl, entries, signer)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	err = s.CRLRepo.Save(ctx, crl)
+	if err != nil {
+		return model.RevocationList{}, err
+	}
+
+	return crl, nil
+}
+
+// GenerateAll regenerates the revocation lists of all active certificate authorities that have a stored signing key.
+func (s *RevocationListService) GenerateAll(ctx context.Context) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "revocation_list_service_generate_all")
+	defer span.Finish()
+
+	ids, err := s.CRLRepo.FindSigningKeyCertificateIDs(ctx)
+	if err != nil {
+		log.Error("failed to find certificate authorities to generate revocation lists for", zap.Error(err))
+		return
+	}
+
+	for _, certificateID := range ids {
+		_, err = s.Generate(ctx, certificateID)
+		if err != nil {
+			log.Error("failed to generate revocation list", zap.String("certificateId", certificateID), zap.Error(err))
+		}
+	}
+}
+
+// DistributionPoint returns the URL where the revocation list of a certificate authority is published.
+// An empty string is returned if no external base url is configured or if the certificate authority does not publish revocation lists.
+func (s *RevocationListService) DistributionPoint(ctx context.Context, certificateID string) (string, error) {
+	if s.ExternalBaseURL == "" {
+		return "", nil
+	}
+
+	_, found, err := s.CRLRepo.FindSigningKey(ctx, certificateID)
+	if err != nil || !found {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/v1/ca/%s/crl", strings.TrimSuffix(s.ExternalBaseURL, "/"), certificateID), nil
+}
+
+func (s *RevocationListService) findIssuer(ctx context.Context, certificateID string) (model.Certificate, issuer, error) {
+	ca, found, err := s.CertRepo.Find(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	if !found {
+		err = fmt.Errorf("certificate with id %s does not exist", certificateID)
+		return model.Certificate{}, issuer{}, httputil.NotFoundError(err)
+	}
+
+	if ca.Type != model.RootCAType && ca.Type != model.IntermediateCAType {
+		err = fmt.Errorf("revocation lists can only be issued by certificate authorities: %s", ca)
+		return model.Certificate{}, issuer{}, httputil.BadRequestError(err)
+	}
+
+	keys, err := s.findSigningKeys(ctx, certificateID)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	cert, err := parseCertificate(ca.Body)
+	if err != nil {
+		return model.Certificate{}, issuer{}, err
+	}
+
+	return ca, issuer{
+		cert: cert,
+		keys: keys,
+	}, nil
+}
+
+func (s *RevocationListService) findSigningKeys(ctx context.Context, certificateID string) (model.KeyEncoder, error) {
+	signingKey, found, err := s.CRLRepo.FindSigningKey(ctx, certificateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		err = fmt.Errorf("no revocation list signing key stored for certificate with id %s", certificateID)
+		return nil, httputil.PreconditionRequiredError(err)
+	}
+
+	keyPair, found, err := s.KeyPairRepo.FindByCertificateID(ctx, certificateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		err = fmt.Errorf("KeyPair does not exist for certificate with id = %s", certificateID)
+		return nil, httputil.PreconditionRequiredError(err)
+	}
+
+	keyPair.PrivateKey, err = s.decryptSigningKey(signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeKeys(keyPair)
+}
+
+func (s *RevocationListService) encryptSigningKey(cert model.Certificate, keyPair model.KeyPair) (model.CRLSigningKey, error) {
+	ciphertext, salt, err := encryptWithServerKey(s.KeyWrappingKey, keyPair.PrivateKey)
+	if err != nil {
+		return model.CRLSigningKey{}, err
+	}
+
+	return model.CRLSigningKey{
+		CertificateID:  cert.ID,
+		PrivateKey:     ciphertext,
+		EncryptionSalt: salt,
+		CreatedAt:      timeutil.Now(),
+	}, nil
+}
+
+func (s *RevocationListService) decryptSigningKey(signingKey model.CRLSigningKey) (string, error) {
+	return decryptWithServerKey(s.KeyWrappingKey, signingKey.PrivateKey, signingKey.EncryptionSalt)
+}
+
+// encryptWithServerKey encrypts a secret held by the server, such as a private key for unattended use, with a key derived
+// from a server key and a random salt, returning the base64 encoded ciphertext and salt.
+func encryptWithServerKey(serverKey []byte, plaintext string) (string, string, error) {
+	salt, err := crypto.RandomBytes(signingKeySaltLength)
+	if err != nil {
+		return "", "", fmt.Errorf("salt generation failed: %w", err)
+	}
+
+	encryptionKey, err := crypto.Hmac(serverKey, salt)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	ciphertext, err := crypto.NewAESCipher(encryptionKey).Encrypt([]byte(plaintext))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(ciphertext), base64.StdEncoding.EncodeToString(salt), nil
+}
+
+func decryptWithServerKey(serverKey []byte, encodedCiphertext, encodedSalt string) (string, error) {
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode encrypted secret: %w", err)
+	}
+
+	encryptionKey, err := crypto.Hmac(serverKey, salt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	plaintext, err := crypto.NewAESCipher(encryptionKey).Decrypt(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func signRevocationList(crl model.RevocationList, entries []pkix.RevokedCertificate, signer issuer) (model.RevocationList, error) {
+	key, ok := signer.keys.PrivateKey().(gocrypto.Signer)
+	if !ok {
+		return model.RevocationList{}, fmt.Errorf("private key of type %T cannot sign revocation lists", signer.keys.PrivateKey())
+	}
+
+	template := &x509.RevocationList{
+		Number:              big.NewInt(crl.Number),
+		ThisUpdate:          crl.ThisUpdate,
+		NextUpdate:          crl.NextUpdate,
+		RevokedCertificates: entries,
+	}
+
+	b, err := x509.CreateRevocationList(rand.Reader, template, signer.cert, key)
+	if err != nil {
+		return model.RevocationList{}, fmt.Errorf("failed to create x509 revocation list: %w", err)
+	}
+
+	block := &pem.Block{Type: crlBlockType, Bytes: b}
+	crl.Body = string(pem.EncodeToMemory(block))
+	return crl, nil
+}
+
+func revokedCertificateEntries(certs []model.Certificate) ([]pkix.RevokedCertificate, error) {
+	entries := make([]pkix.RevokedCertificate, 0, len(certs))
+	for _, cert := range certs {
+		if cert.Revocation == nil {
+			continue
+		}
+
+		serialNumber, err := parseSerialNumber(cert.SerialNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		extensions, err := revocationExtensions(*cert.Revocation)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, pkix.RevokedCertificate{
+			SerialNumber:   serialNumber,
+			RevocationTime: cert.Revocation.RevokedAt,
+			Extensions:     extensions,
+		})
+	}
+
+	return entries, nil
+}
+
+// revocationExtensions encodes the reasonCode and invalidityDate CRL entry extensions,
+// the reason code is left out when unspecified as recommended by RFC 5280.
+func revocationExtensions(rev model.Revocation) ([]pkix.Extension, error) {
+	extensions := make([]pkix.Extension, 0, 2)
+	if rev.Reason != model.ReasonUnspecified {
+		b, err := asn1.Marshal(asn1.Enumerated(rev.Reason))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode revocation reason %d: %w", rev.Reason, err)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidReasonCode, Value: b})
+	}
+
+	if rev.InvalidityDate != nil {
+		b, err := asn1.MarshalWithParams(rev.InvalidityDate.UTC(), "generalized")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode invalidity date %v: %w", rev.InvalidityDate, err)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidInvalidityDate, Value: b})
+	}
+
+	return extensions, nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/CzarSimon/httputil"
@@ -10,6 +11,7 @@ import (
 	"github.com/CzarSimon/webca/api-server/internal/authorization"
 	"github.com/CzarSimon/webca/api-server/internal/model"
 	"github.com/CzarSimon/webca/api-server/internal/repository"
+	"github.com/CzarSimon/webca/api-server/internal/timeutil"
 	"github.com/opentracing/opentracing-go"
 )
 
@@ -43,3 +45,95 @@ func (u *UserService) GetUser(ctx context.Context, principal jwt.User, id string
 	u.AuditLog.Read(ctx, principal.ID, "user:%s", id)
 	return user, nil
 }
+
+// GetAccountUsers retrieves the users of an account that the principal has access to.
+func (u *UserService) GetAccountUsers(ctx context.Context, principal jwt.User, accountID string) ([]model.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "user_service_get_account_users")
+	defer span.Finish()
+
+	err := u.AuthService.AssertAccountAccess(ctx, principal, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := u.UserRepo.FindByAccountID(ctx, accountID)
+	if err != nil {
+		return nil, httputil.InternalServerError(err)
+	}
+
+	for _, user := range users {
+		u.AuditLog.Read(ctx, principal.ID, "user:%s", user.ID)
+	}
+
+	return users, nil
+}
+
+// UpdateRole changes the role of a user. The last active admin of an account cannot be demoted.
+func (u *UserService) UpdateRole(ctx context.Context, principal jwt.User, req model.UserRoleRequest) (model.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "user_service_update_role")
+	defer span.Finish()
+
+	user, err := u.findAccessibleUser(ctx, principal, req.UserID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user.Role = req.Role
+	return u.updateUser(ctx, principal, user)
+}
+
+// Deactivate deactivates a user, which prevents it from logging in or using tokens it has already been issued.
+// The last active admin of an account cannot be deactivated.
+func (u *UserService) Deactivate(ctx context.Context, principal jwt.User, id string) (model.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "user_service_deactivate")
+	defer span.Finish()
+
+	user, err := u.findAccessibleUser(ctx, principal, id)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if !user.Active {
+		err = fmt.Errorf("%s is already deactivated", user)
+		return model.User{}, httputil.ConflictError(err)
+	}
+
+	user.Active = false
+	return u.updateUser(ctx, principal, user)
+}
+
+func (u *UserService) findAccessibleUser(ctx context.Context, principal jwt.User, id string) (model.User, error) {
+	err := u.AuthService.AssertUserAccess(ctx, principal, id)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, found, err := u.UserRepo.Find(ctx, id)
+	if err != nil {
+		return model.User{}, httputil.InternalServerError(err)
+	}
+
+	if !found {
+		err = fmt.Errorf("user with id %s does not exist", id)
+		return model.User{}, httputil.NotFoundError(err)
+	}
+
+	return user, nil
+}
+
+func (u *UserService) updateUser(ctx context.Context, principal jwt.User, user model.User) (model.User, error) {
+	user.UpdatedAt = timeutil.Now()
+	err := u.UserRepo.Update(ctx, user)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return model.User{}, httputil.NotFoundError(err)
+	}
+	if errors.Is(err, repository.ErrLastActiveAdmin) {
+		return model.User{}, httputil.ConflictError(err)
+	}
+	if err != nil {
+		return model.User{}, httputil.InternalServerError(err)
+	}
+
+	u.AuditLog.Update(ctx, principal.ID, "user:%s", user.ID)
+	return user, nil
+}
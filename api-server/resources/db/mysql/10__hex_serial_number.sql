@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE `certificate` MODIFY `serial_number` VARCHAR(40) NOT NULL;
+UPDATE `certificate` SET `serial_number` = LOWER(CONV(`serial_number`, 10, 16));
+-- The issuer of a certificate is identified by its signatory, by the certificate itself when it is self-signed and otherwise
+-- by the hex SHA-256 of its issuer name. Existing certificates were all issued by webca, so they are identified by signatory or by themselves.
+ALTER TABLE `certificate` ADD COLUMN `issuer_id` VARCHAR(64);
+UPDATE `certificate` SET `issuer_id` = COALESCE(`signatory_id`, `id`);
+ALTER TABLE `certificate` MODIFY `issuer_id` VARCHAR(64) NOT NULL,
+  DROP INDEX `serial_number`,
+  ADD CONSTRAINT `uq_certificate_issuer_serial_number` UNIQUE (`issuer_id`, `serial_number`);
+-- +migrate Down
+-- Hex serial numbers are up to 159 bits and do not fit in the previous integer column, so this migration
+-- cannot be reverted without losing data. The query below fails on purpose to stop the downgrade.
+SELECT `serial_number` FROM `hex_serial_number_migration_is_irreversible`;
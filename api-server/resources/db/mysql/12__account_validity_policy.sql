@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE `account`
+ADD COLUMN `validity_policy` VARCHAR(50) NOT NULL DEFAULT 'CLAMP';
+-- +migrate Down
+ALTER TABLE `account` DROP COLUMN `validity_policy`;
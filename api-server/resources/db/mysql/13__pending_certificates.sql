@@ -0,0 +1,31 @@
+-- +migrate Up
+CREATE TABLE `pending_certificate_status` (
+  `name` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `pending_certificate` (
+  `id` VARCHAR(50) NOT NULL,
+  `name` VARCHAR(100) NOT NULL,
+  `csr` TEXT NOT NULL,
+  `status` VARCHAR(50) NOT NULL,
+  `key_pair_id` VARCHAR(50) NOT NULL,
+  `account_id` VARCHAR(50) NOT NULL,
+  `certificate_id` VARCHAR(50),
+  `issuer_chain` TEXT,
+  `created_at` DATETIME NOT NULL,
+  `activated_at` DATETIME,
+  PRIMARY KEY (`id`),
+  UNIQUE(`name`, `account_id`),
+  UNIQUE(`certificate_id`),
+  FOREIGN KEY (`status`) REFERENCES `pending_certificate_status` (`name`),
+  FOREIGN KEY (`key_pair_id`) REFERENCES `key_pair` (`id`),
+  FOREIGN KEY (`account_id`) REFERENCES `account` (`id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+INSERT INTO `pending_certificate_status`(`name`, `created_at`)
+VALUES ('PENDING', CURRENT_TIMESTAMP),
+  ('ACTIVATED', CURRENT_TIMESTAMP);
+-- +migrate Down
+DROP TABLE IF EXISTS `pending_certificate`;
+DROP TABLE IF EXISTS `pending_certificate_status`;
@@ -0,0 +1,8 @@
+-- +migrate Up
+INSERT INTO `invitation_status`(`name`, `created_at`)
+VALUES ('REVOKED', NOW());
+ALTER TABLE `account`
+ADD COLUMN `invitation_lifetime_hours` INT NOT NULL DEFAULT 24;
+-- +migrate Down
+ALTER TABLE `account` DROP COLUMN `invitation_lifetime_hours`;
+DELETE FROM `invitation_status` WHERE `name` = 'REVOKED';
@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE `invitation`
+ADD COLUMN `token_hash` VARCHAR(100),
+ADD CONSTRAINT `uq_invitation_token_hash` UNIQUE (`token_hash`);
+-- +migrate Down
+ALTER TABLE `invitation` DROP INDEX `uq_invitation_token_hash`,
+  DROP COLUMN `token_hash`;
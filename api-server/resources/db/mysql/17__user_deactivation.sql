@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE `user_account`
+ADD COLUMN `active` BOOLEAN NOT NULL DEFAULT TRUE;
+-- +migrate Down
+ALTER TABLE `user_account` DROP COLUMN `active`;
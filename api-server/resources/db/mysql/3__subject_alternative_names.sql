@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE `certificate`
+ADD COLUMN `subject_alternative_names` TEXT;
+-- +migrate Down
+ALTER TABLE `certificate` DROP COLUMN `subject_alternative_names`;
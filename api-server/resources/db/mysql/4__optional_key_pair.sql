@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE `certificate`
+MODIFY `key_pair_id` VARCHAR(50);
+-- +migrate Down
+ALTER TABLE `certificate`
+MODIFY `key_pair_id` VARCHAR(50) NOT NULL;
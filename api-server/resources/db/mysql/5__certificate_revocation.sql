@@ -0,0 +1,22 @@
+-- +migrate Up
+CREATE TABLE `certificate_status` (
+  `name` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+INSERT INTO `certificate_status`(`name`, `created_at`)
+VALUES ('ACTIVE', NOW()),
+  ('REVOKED', NOW());
+ALTER TABLE `certificate`
+ADD COLUMN `status` VARCHAR(50) NOT NULL DEFAULT 'ACTIVE',
+ADD COLUMN `revoked_at` DATETIME,
+ADD COLUMN `revocation_reason` INTEGER,
+ADD COLUMN `invalidity_date` DATETIME,
+ADD CONSTRAINT `fk_certificate_status` FOREIGN KEY (`status`) REFERENCES `certificate_status` (`name`);
+-- +migrate Down
+ALTER TABLE `certificate` DROP FOREIGN KEY `fk_certificate_status`;
+ALTER TABLE `certificate` DROP COLUMN `status`,
+  DROP COLUMN `revoked_at`,
+  DROP COLUMN `revocation_reason`,
+  DROP COLUMN `invalidity_date`;
+DROP TABLE IF EXISTS `certificate_status`;
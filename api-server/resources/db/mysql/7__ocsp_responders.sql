@@ -0,0 +1,27 @@
+-- +migrate Up
+CREATE TABLE `ocsp_responder` (
+  `id` VARCHAR(50) NOT NULL,
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `body` TEXT NOT NULL,
+  `public_key` TEXT NOT NULL,
+  `private_key` TEXT NOT NULL,
+  `key_type` VARCHAR(50) NOT NULL,
+  `encryption_salt` VARCHAR(64) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `ocsp_issuer_hash` (
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `hash_algorithm` VARCHAR(50) NOT NULL,
+  `name_hash` VARCHAR(128) NOT NULL,
+  `key_hash` VARCHAR(128) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`certificate_id`, `hash_algorithm`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE INDEX `idx_ocsp_issuer_hash_lookup` ON `ocsp_issuer_hash` (`hash_algorithm`, `name_hash`, `key_hash`);
+-- +migrate Down
+DROP TABLE IF EXISTS `ocsp_issuer_hash`;
+DROP TABLE IF EXISTS `ocsp_responder`;
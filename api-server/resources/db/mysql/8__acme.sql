@@ -0,0 +1,79 @@
+-- +migrate Up
+CREATE TABLE `issuing_key` (
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `private_key` TEXT NOT NULL,
+  `encryption_salt` VARCHAR(64) NOT NULL,
+  `created_by` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`certificate_id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `acme_enrollment` (
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `allowed_domains` TEXT NOT NULL,
+  `allowed_networks` TEXT NOT NULL,
+  `created_by` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`certificate_id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `acme_nonce` (
+  `id` VARCHAR(64) NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `acme_account` (
+  `id` VARCHAR(50) NOT NULL,
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `status` VARCHAR(50) NOT NULL,
+  `contact` TEXT NOT NULL,
+  `public_key` TEXT NOT NULL,
+  `key_thumbprint` VARCHAR(64) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE(`certificate_id`, `key_thumbprint`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `acme_order` (
+  `id` VARCHAR(50) NOT NULL,
+  `account_id` VARCHAR(50) NOT NULL,
+  `status` VARCHAR(50) NOT NULL,
+  `identifiers` TEXT NOT NULL,
+  `error` TEXT,
+  `certificate_id` VARCHAR(50),
+  `created_at` DATETIME NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`account_id`) REFERENCES `acme_account` (`id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `acme_authorization` (
+  `id` VARCHAR(50) NOT NULL,
+  `order_id` VARCHAR(50) NOT NULL,
+  `identifier_type` VARCHAR(50) NOT NULL,
+  `identifier_value` VARCHAR(255) NOT NULL,
+  `wildcard` BOOLEAN NOT NULL,
+  `status` VARCHAR(50) NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`order_id`) REFERENCES `acme_order` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+CREATE TABLE `acme_challenge` (
+  `id` VARCHAR(50) NOT NULL,
+  `authorization_id` VARCHAR(50) NOT NULL,
+  `type` VARCHAR(50) NOT NULL,
+  `token` VARCHAR(64) NOT NULL,
+  `status` VARCHAR(50) NOT NULL,
+  `validated_at` DATETIME,
+  `error` TEXT,
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`authorization_id`) REFERENCES `acme_authorization` (`id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+-- +migrate Down
+DROP TABLE IF EXISTS `acme_challenge`;
+DROP TABLE IF EXISTS `acme_authorization`;
+DROP TABLE IF EXISTS `acme_order`;
+DROP TABLE IF EXISTS `acme_account`;
+DROP TABLE IF EXISTS `acme_nonce`;
+DROP TABLE IF EXISTS `acme_enrollment`;
+DROP TABLE IF EXISTS `issuing_key`;
@@ -0,0 +1,15 @@
+-- +migrate Up
+ALTER TABLE `certificate`
+ADD COLUMN `predecessor_id` VARCHAR(50),
+ADD COLUMN `generation` INTEGER NOT NULL DEFAULT 1,
+ADD CONSTRAINT `fk_certificate_predecessor` FOREIGN KEY (`predecessor_id`) REFERENCES `certificate` (`id`),
+ADD CONSTRAINT `uq_certificate_predecessor` UNIQUE (`predecessor_id`),
+ADD CONSTRAINT `uq_certificate_name_generation` UNIQUE (`name`, `account_id`, `generation`),
+DROP INDEX `name`;
+-- +migrate Down
+ALTER TABLE `certificate` DROP FOREIGN KEY `fk_certificate_predecessor`;
+ALTER TABLE `certificate` DROP INDEX `uq_certificate_name_generation`,
+  DROP INDEX `uq_certificate_predecessor`,
+  ADD CONSTRAINT `name` UNIQUE (`name`, `account_id`),
+  DROP COLUMN `predecessor_id`,
+  DROP COLUMN `generation`;
@@ -0,0 +1,86 @@
+-- +migrate Up
+-- The issuer of a certificate is identified by its signatory, by the certificate itself when it is self-signed and otherwise
+-- by the hex SHA-256 of its issuer name. Existing certificates were all issued by webca, so they are identified by signatory or by themselves.
+CREATE TABLE `certificate_new` (
+  `id` VARCHAR(50) NOT NULL,
+  `name` VARCHAR(100) NOT NULL,
+  `serial_number` VARCHAR(40) NOT NULL,
+  `subject` TEXT NOT NULL,
+  `body` TEXT NOT NULL,
+  `format` VARCHAR(50) NOT NULL,
+  `type` VARCHAR(50) NOT NULL,
+  `key_pair_id` VARCHAR(50),
+  `signatory_id` VARCHAR(50),
+  `account_id` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  `subject_alternative_names` TEXT,
+  `status` VARCHAR(50) NOT NULL DEFAULT 'ACTIVE',
+  `revoked_at` DATETIME,
+  `revocation_reason` INTEGER,
+  `invalidity_date` DATETIME,
+  `predecessor_id` VARCHAR(50),
+  `generation` INTEGER NOT NULL DEFAULT 1,
+  `issuer_id` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE(`body`),
+  UNIQUE(`name`, `account_id`, `generation`),
+  UNIQUE(`issuer_id`, `serial_number`),
+  UNIQUE(`predecessor_id`),
+  FOREIGN KEY (`key_pair_id`) REFERENCES `key_pair` (`id`),
+  FOREIGN KEY (`type`) REFERENCES `certificate_type` (`name`),
+  FOREIGN KEY (`status`) REFERENCES `certificate_status` (`name`),
+  FOREIGN KEY (`signatory_id`) REFERENCES `certificate` (`id`),
+  FOREIGN KEY (`predecessor_id`) REFERENCES `certificate` (`id`),
+  FOREIGN KEY (`account_id`) REFERENCES `account` (`id`)
+);
+INSERT INTO `certificate_new`(
+    `id`,
+    `name`,
+    `serial_number`,
+    `subject`,
+    `body`,
+    `format`,
+    `type`,
+    `key_pair_id`,
+    `signatory_id`,
+    `account_id`,
+    `created_at`,
+    `expires_at`,
+    `subject_alternative_names`,
+    `status`,
+    `revoked_at`,
+    `revocation_reason`,
+    `invalidity_date`,
+    `predecessor_id`,
+    `generation`,
+    `issuer_id`
+  )
+SELECT `id`,
+  `name`,
+  lower(printf('%x', `serial_number`)),
+  `subject`,
+  `body`,
+  `format`,
+  `type`,
+  `key_pair_id`,
+  `signatory_id`,
+  `account_id`,
+  `created_at`,
+  `expires_at`,
+  `subject_alternative_names`,
+  `status`,
+  `revoked_at`,
+  `revocation_reason`,
+  `invalidity_date`,
+  `predecessor_id`,
+  `generation`,
+  COALESCE(`signatory_id`, `id`)
+FROM `certificate`;
+DROP TABLE `certificate`;
+ALTER TABLE `certificate_new`
+  RENAME TO `certificate`;
+-- +migrate Down
+-- Hex serial numbers are up to 159 bits and do not fit in the previous integer column, so this migration
+-- cannot be reverted without losing data. The query below fails on purpose to stop the downgrade.
+SELECT `serial_number` FROM `hex_serial_number_migration_is_irreversible`;
@@ -0,0 +1,20 @@
+-- +migrate Up
+CREATE TABLE `certificate_profile` (
+  `id` VARCHAR(50) NOT NULL,
+  `name` VARCHAR(100) NOT NULL,
+  `key_usage` TEXT NOT NULL,
+  `ext_key_usage` TEXT NOT NULL,
+  `default_validity_days` INTEGER NOT NULL,
+  `max_validity_days` INTEGER NOT NULL,
+  `algorithms` TEXT NOT NULL,
+  `key_sizes` TEXT NOT NULL,
+  `required_san_types` TEXT NOT NULL,
+  `account_id` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `updated_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE(`name`, `account_id`),
+  FOREIGN KEY (`account_id`) REFERENCES `account` (`id`)
+);
+-- +migrate Down
+DROP TABLE IF EXISTS `certificate_profile`;
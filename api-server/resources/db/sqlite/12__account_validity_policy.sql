@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE `account`
+ADD COLUMN `validity_policy` VARCHAR(50) NOT NULL DEFAULT 'CLAMP';
+-- +migrate Down
@@ -0,0 +1,7 @@
+-- +migrate Up
+INSERT INTO `invitation_status`(`name`, `created_at`)
+VALUES ('REVOKED', CURRENT_TIMESTAMP);
+ALTER TABLE `account`
+ADD COLUMN `invitation_lifetime_hours` INTEGER NOT NULL DEFAULT 24;
+-- +migrate Down
+DELETE FROM `invitation_status` WHERE `name` = 'REVOKED';
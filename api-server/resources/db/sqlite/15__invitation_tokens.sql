@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE `invitation`
+ADD COLUMN `token_hash` VARCHAR(100);
+CREATE UNIQUE INDEX `uq_invitation_token_hash` ON `invitation` (`token_hash`);
+-- +migrate Down
+DROP INDEX IF EXISTS `uq_invitation_token_hash`;
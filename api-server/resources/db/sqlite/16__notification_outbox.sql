@@ -0,0 +1,39 @@
+-- +migrate Up
+CREATE TABLE `notification_status` (
+  `name` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`name`)
+);
+CREATE TABLE `notification` (
+  `id` VARCHAR(50) NOT NULL,
+  `template` VARCHAR(50) NOT NULL,
+  `recipient` VARCHAR(255) NOT NULL,
+  `subject` VARCHAR(255) NOT NULL,
+  `body` TEXT NOT NULL,
+  `status` VARCHAR(50) NOT NULL,
+  `attempts` INTEGER NOT NULL DEFAULT 0,
+  `last_error` TEXT,
+  `created_at` DATETIME NOT NULL,
+  `next_attempt_at` DATETIME NOT NULL,
+  `sent_at` DATETIME,
+  `encryption_salt` VARCHAR(64),
+  `claimed_until` DATETIME,
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`status`) REFERENCES `notification_status` (`name`)
+);
+CREATE INDEX `idx_notification_status_next_attempt_at` ON `notification` (`status`, `next_attempt_at`);
+INSERT INTO `notification_status`(`name`, `created_at`)
+VALUES ('PENDING', CURRENT_TIMESTAMP),
+  ('SENDING', CURRENT_TIMESTAMP),
+  ('SENT', CURRENT_TIMESTAMP),
+  ('FAILED', CURRENT_TIMESTAMP);
+CREATE TABLE `certificate_expiry_warning` (
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`certificate_id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+);
+-- +migrate Down
+DROP TABLE IF EXISTS `certificate_expiry_warning`;
+DROP TABLE IF EXISTS `notification`;
+DROP TABLE IF EXISTS `notification_status`;
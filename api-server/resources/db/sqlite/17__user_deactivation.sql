@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE `user_account`
+ADD COLUMN `active` BOOLEAN NOT NULL DEFAULT TRUE;
+-- +migrate Down
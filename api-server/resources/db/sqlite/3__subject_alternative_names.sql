@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE `certificate`
+ADD COLUMN `subject_alternative_names` TEXT;
+-- +migrate Down
@@ -0,0 +1,57 @@
+-- +migrate Up
+CREATE TABLE `certificate_new` (
+  `id` VARCHAR(50) NOT NULL,
+  `name` VARCHAR(100) NOT NULL,
+  `serial_number` INTEGER NOT NULL,
+  `subject` TEXT NOT NULL,
+  `body` TEXT NOT NULL,
+  `format` VARCHAR(50) NOT NULL,
+  `type` VARCHAR(50) NOT NULL,
+  `key_pair_id` VARCHAR(50),
+  `signatory_id` VARCHAR(50),
+  `account_id` VARCHAR(50) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  `subject_alternative_names` TEXT,
+  PRIMARY KEY (`id`),
+  UNIQUE(`body`),
+  UNIQUE(`name`, `account_id`),
+  UNIQUE(`serial_number`),
+  FOREIGN KEY (`key_pair_id`) REFERENCES `key_pair` (`id`),
+  FOREIGN KEY (`type`) REFERENCES `certificate_type` (`name`),
+  FOREIGN KEY (`signatory_id`) REFERENCES `certificate` (`id`),
+  FOREIGN KEY (`account_id`) REFERENCES `account` (`id`)
+);
+INSERT INTO `certificate_new`(
+    `id`,
+    `name`,
+    `serial_number`,
+    `subject`,
+    `body`,
+    `format`,
+    `type`,
+    `key_pair_id`,
+    `signatory_id`,
+    `account_id`,
+    `created_at`,
+    `expires_at`,
+    `subject_alternative_names`
+  )
+SELECT `id`,
+  `name`,
+  `serial_number`,
+  `subject`,
+  `body`,
+  `format`,
+  `type`,
+  `key_pair_id`,
+  `signatory_id`,
+  `account_id`,
+  `created_at`,
+  `expires_at`,
+  `subject_alternative_names`
+FROM `certificate`;
+DROP TABLE `certificate`;
+ALTER TABLE `certificate_new`
+  RENAME TO `certificate`;
+-- +migrate Down
@@ -0,0 +1,24 @@
+-- +migrate Up
+CREATE TABLE `crl_signing_key` (
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `private_key` TEXT NOT NULL,
+  `encryption_salt` VARCHAR(64) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`certificate_id`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+);
+CREATE TABLE `certificate_revocation_list` (
+  `id` VARCHAR(50) NOT NULL,
+  `certificate_id` VARCHAR(50) NOT NULL,
+  `number` INTEGER NOT NULL,
+  `body` TEXT NOT NULL,
+  `this_update` DATETIME NOT NULL,
+  `next_update` DATETIME NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE(`certificate_id`, `number`),
+  FOREIGN KEY (`certificate_id`) REFERENCES `certificate` (`id`)
+);
+-- +migrate Down
+DROP TABLE IF EXISTS `certificate_revocation_list`;
+DROP TABLE IF EXISTS `crl_signing_key`;
@@ -11,6 +11,24 @@ export JWT_SECRET_FILE='./resources/testing/jwt-secret.key'
 export PASSWORD_SALT_LENGTH='32'
 export PASSWORD_MIN_LENGTH='16'
 export PASSWORD_ENCRYPTION_KEY_FILE='./resources/testing/password-encryption.key'
+export CA_KEY_WRAPPING_KEY_FILE='./resources/testing/ca-key-wrapping.key'
+
+export EXTERNAL_BASE_URL='http://localhost:8080'
+export CRL_VALIDITY_HOURS='24'
+export OCSP_VALIDITY_HOURS='1'
+export ACME_HTTP01_PORT='80'
+export ACME_NEW_ACCOUNT_RATE_LIMIT_PER_HOUR='20'
+export ACME_NEW_ORDER_RATE_LIMIT_PER_HOUR='300'
+export INVITATION_RATE_LIMIT_PER_MINUTE='10'
+
+export WEB_APP_BASE_URL='http://localhost:3000'
+export NOTIFICATION_RETRY_DELAY_SECONDS='60'
+export NOTIFICATION_MAX_ATTEMPTS='5'
+export EXPIRY_WARNING_DAYS='30'
+export NOTIFICATION_ENCRYPTION_KEY_FILE='./resources/testing/notification-encryption.key'
+# export SMTP_HOST='127.0.0.1'
+# export SMTP_PORT='1025'
+# export SMTP_FROM='WebCA <no-reply@webca.io>'
 
 export DB_TYPE='sqlite'
 export DB_FILENAME='./test.db'
@@ -42,7 +42,7 @@ beforeEach(() => {
 const rootCert: Certificate = {
   id: certificateId,
   name: 'cert-1',
-  serialNumber: 4526837442362845,
+  serialNumber: '10152279b53ddd',
   body: 'pem formated certificate body',
   subject: {
     commonName: 'test root ca',
@@ -69,7 +69,7 @@ const rootCertPrivateKey: Attachment = {
 const rootCA: Certificate = {
   id: '7807d79b-2ee3-43bf-aa28-87c7bc66acba',
   name: 'name:root-ca',
-  serialNumber: 6674323821678735,
+  serialNumber: '17b6431c81d08f',
   body: 'pem formated certificate body',
   subject: {
     commonName: 'root-ca',
@@ -84,7 +84,7 @@ const rootCA: Certificate = {
 const intermediateCA: Certificate = {
   id: certificateId,
   name: 'name:intermediate-ca',
-  serialNumber: 2800649869724387,
+  serialNumber: '9f32d1ca00ae3',
   body: 'pem formated certificate body',
   subject: {
     commonName: 'intermediate-ca',
@@ -100,7 +100,7 @@ const intermediateCA: Certificate = {
 const cert: Certificate = {
   id: certificateId,
   name: 'cert-2',
-  serialNumber: 1362162917301873,
+  serialNumber: '4d6e144cfde71',
   body: 'pem formated certificate body',
   subject: {
     commonName: 'test certificate',
@@ -25,6 +25,11 @@ function useColumns(): ColumnProps<Certificate>[] {
       dataIndex: 'type',
       render: (type) => formatedMessage(`certificate.type-${type}`),
     },
+    {
+      title: formatedMessage('certificateList.status-column'),
+      dataIndex: 'status',
+      render: (status) => (status ? formatedMessage(`certificate.status-${status}`) : null),
+    },
     {
       title: formatedMessage('certificateList.createdAt-column'),
       sorter: true,
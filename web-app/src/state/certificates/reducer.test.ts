@@ -137,7 +137,7 @@ test('certificate reducer: select and deselect certificate', () => {
   const cert: Certificate = {
     id: '14597ed1-281f-495a-8366-4f8a411a20bc',
     name: 'test root ca',
-    serialNumber: 1041018258857953,
+    serialNumber: '3b2ccf3861fe1',
     body: 'pem formated certificate body',
     subject: {
       commonName: 'test root ca',
@@ -187,7 +187,7 @@ test('certificate reducer: add and remove certificates', () => {
       {
         id: 'd1b9c1e9-ce8f-4296-8671-3411105ceb45',
         name: 'cert-1',
-        serialNumber: 2681780644171099,
+        serialNumber: '98710b6a94d5b',
         body: 'pem formated certificate body',
         subject: {
           commonName: 'test root ca',
@@ -201,7 +201,7 @@ test('certificate reducer: add and remove certificates', () => {
       {
         id: '26b679f0-ad89-4290-84a3-02f16ee23c09',
         name: 'cert-2',
-        serialNumber: 2546633804774058,
+        serialNumber: '90c26634e7aaa',
         body: 'pem formated certificate body',
         subject: {
           commonName: 'test root ca',
@@ -253,7 +253,7 @@ test('certificate reducer: add and remove signing certificates', () => {
       {
         id: 'd1b9c1e9-ce8f-4296-8671-3411105ceb45',
         name: 'cert-1',
-        serialNumber: 2681780644171099,
+        serialNumber: '98710b6a94d5b',
         body: 'pem formated certificate body',
         subject: {
           commonName: 'test root ca',
@@ -267,7 +267,7 @@ test('certificate reducer: add and remove signing certificates', () => {
       {
         id: '26b679f0-ad89-4290-84a3-02f16ee23c09',
         name: 'cert-2',
-        serialNumber: 2546633804774058,
+        serialNumber: '90c26634e7aaa',
         body: 'pem formated certificate body',
         subject: {
           commonName: 'test root ca',
@@ -334,7 +334,7 @@ test('certificate reducer: select and deselect certificate and signatory', () =>
   const intermediateCA: Certificate = {
     id: '14597ed1-281f-495a-8366-4f8a411a20bc',
     name: 'Intermediate CA',
-    serialNumber: 1041018258857953,
+    serialNumber: '3b2ccf3861fe1',
     body: 'pem formated certificate body',
     subject: {
       commonName: 'intermediate-ca',
@@ -350,7 +350,7 @@ test('certificate reducer: select and deselect certificate and signatory', () =>
   const rootCA: Certificate = {
     id: '2722c00f-7b10-4642-ba42-cc83a1727cb0',
     name: 'Root CA',
-    serialNumber: 3802855181619434,
+    serialNumber: 'd82ad383b90ea',
     body: 'pem formated certificate body',
     subject: {
       commonName: 'root-ca',
@@ -51,12 +51,15 @@ export const enUS: TextMap = {
   'certificate.type-ROOT_CA': 'Root CA',
   'certificate.type-INTERMEDIATE_CA': 'Intermediate CA',
   'certificate.type-CERTIFICATE': 'Certificate',
+  'certificate.status-ACTIVE': 'Active',
+  'certificate.status-REVOKED': 'Revoked',
   'certificate.algorithm-RSA': 'RSA',
 
   'certificateList.title': 'Certificates',
   'certificateList.newCertificate-button': 'New certificate',
   'certificateList.name-column': 'Name',
   'certificateList.type-column': 'Certificate type',
+  'certificateList.status-column': 'Status',
   'certificateList.createdAt-column': 'Created at',
   'certificateList.expiresAt-column': 'Expires at',
 
@@ -55,17 +55,25 @@ export interface Account {
 export interface Certificate {
   id: string;
   name: string;
-  serialNumber: number;
+  serialNumber: string;
   body: string;
   subject: CertificateSubject;
   format: string;
   type: string;
   signatoryId?: string;
   accountId: string;
+  status?: string;
+  revocation?: Revocation;
   createdAt: string;
   expiresAt: string;
 }
 
+export interface Revocation {
+  reason: number;
+  revokedAt: string;
+  invalidityDate?: string;
+}
+
 export interface CertificateSubject {
   commonName: string;
   country?: string;